@@ -0,0 +1,130 @@
+package drivesync
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The control api lets other tools, and one day a GUI, talk to a running sync. GET /api/status has the queues, the
+// progress of the running transfers, when the state was last verified and the recent errors, and POST /api/pause,
+// /api/resume and /api/sync do what the keys of the monitor command do. It's served next to the metrics when
+// control_api=true, only on localhost, and a request that comes from a web page is refused so a site open in the
+// browser can't pause the sync.
+
+type apiStatus struct {
+	Paused      bool      `json:"paused"`
+	Activity    string    `json:"activity"` // empty while waiting for the next cycle
+	Transfer    string    `json:"transfer"`
+	LastCycleAt time.Time `json:"lastCycleAt"`
+	VerifiedAt  time.Time `json:"verifiedAt"`
+
+	Active           []TransferProgress `json:"active"` // the uploads and downloads that are running
+	Queued           int                `json:"queued"` // the transfers of the running cycle that haven't started yet
+	PendingUploads   int                `json:"pendingUploads"`
+	PendingDownloads int                `json:"pendingDownloads"`
+	PendingDeletions []PendingDeletion  `json:"pendingDeletions"`
+
+	Errors    map[string]string `json:"errors"`    // key = local path, value = the last error for that file
+	Failures  []string          `json:"failures"`  // the most recent cycles that failed, newest last
+	Conflicts []string          `json:"conflicts"` // the most recent conflict copies, newest last
+	Transfers []string          `json:"transfers"` // the most recent uploads and downloads, newest last
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) controlApi() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", service.handleApiStatus)
+	mux.HandleFunc("/api/pause", handleApiControl(service, service.Pause))
+	mux.HandleFunc("/api/resume", handleApiControl(service, service.Resume))
+	mux.HandleFunc("/api/sync", handleApiControl(service, service.SyncNow))
+	return mux
+}
+
+//*********************************************************
+
+func (service *Service) handleApiStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowedApiRequest(w, r, http.MethodGet) {
+		return
+	}
+	writeApiStatus(w, service.MonitorSnapshot())
+}
+
+//*********************************************************
+
+// runs the action and answers with the status after it
+func handleApiControl(service *Service, action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !allowedApiRequest(w, r, http.MethodPost) {
+			return
+		}
+		action()
+		writeApiStatus(w, service.MonitorSnapshot())
+	}
+}
+
+//*********************************************************
+
+func writeApiStatus(w http.ResponseWriter, snapshot MonitorSnapshot) {
+	status := apiStatus{
+		Paused:           snapshot.Paused,
+		Activity:         snapshot.Activity,
+		Transfer:         snapshot.Transfer,
+		LastCycleAt:      snapshot.LastCycleAt,
+		VerifiedAt:       snapshot.VerifiedAt,
+		Active:           snapshot.Active,
+		Queued:           snapshot.Queued,
+		PendingUploads:   snapshot.PendingUploads,
+		PendingDownloads: snapshot.PendingDownloads,
+		PendingDeletions: snapshot.PendingDeletions,
+		Errors:           snapshot.Errors,
+		Failures:         snapshot.Failures,
+		Conflicts:        snapshot.Conflicts,
+		Transfers:        snapshot.Transfers,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// checks the method, and that the request was made to localhost by name and not from a web page, a browser sends
+// the Origin of the page and a page on a domain that resolves to 127.0.0.1 still has its own name in the Host
+func allowedApiRequest(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if !isLoopbackHost(r.Host) {
+		http.Error(w, "only requests to localhost are allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		parsed, err := url.Parse(origin)
+		if err != nil || !isLoopbackHost(parsed.Host) {
+			http.Error(w, "requests from web pages are not allowed", http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
+//*********************************************************
+
+// the host can have a port or not
+func isLoopbackHost(host string) bool {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "0")
+	}
+	return isLoopbackAddress(host)
+}
@@ -0,0 +1,346 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A Backend is one side of a BackendPair, a tree of files and folders that can be listed and changed. The
+// paths are relative to the top of the tree and always use / so the two sides can be compared.
+type Backend interface {
+	Name() string // for the messages, like drive:<folder id>
+
+	// everything in the tree, key = path, the backend remembers it for the changes that follow
+	List(ctx context.Context) (map[string]BackendItem, error)
+
+	CreateFolder(ctx context.Context, itemPath string, modTime time.Time) error
+
+	// copies an item of the from backend to itemPath, replacing what is there, from can be the backend itself
+	Copy(ctx context.Context, from Backend, item BackendItem, itemPath string) error
+
+	// removes a file or a folder with everything in it, the backends that have a trash use it
+	Remove(ctx context.Context, item BackendItem) error
+}
+
+type BackendItem struct {
+	Path         string
+	ID           string // what the backend uses to find the item, the id on Google Drive or the full local path
+	IsDir        bool
+	Size         int64
+	Md5          string // empty for folders and Google Docs
+	ModifiedTime time.Time
+}
+
+//*********************************************************
+
+// two items with the same fingerprint are the same, Google Docs and local files don't have an md5 so their size
+// and time are used, the time is only compared to 2 seconds since that's all a FAT drive keeps
+func (item BackendItem) fingerprint() string {
+	if item.IsDir {
+		return "folder"
+	}
+	if item.Md5 != "" {
+		return item.Md5
+	}
+	return fmt.Sprintf("%d %v", item.Size, item.ModifiedTime.UTC().Truncate(2*time.Second).Format(time.RFC3339))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a folder on Google Drive, the copies between two DriveBackends are done on the server so nothing is downloaded
+type DriveBackend struct {
+	conn     *Connection
+	folderId string
+	items    map[string]BackendItem // from the last List, key = path
+}
+
+func NewDriveBackend(conn *Connection, folderId string) *DriveBackend {
+	return &DriveBackend{conn: conn, folderId: folderId, items: make(map[string]BackendItem)}
+}
+
+func (backend *DriveBackend) Name() string {
+	return "drive:" + backend.folderId
+}
+
+//*********************************************************
+
+func (backend *DriveBackend) List(ctx context.Context) (map[string]BackendItem, error) {
+	files, err := backend.conn.listTree(ctx, backend.folderId, "", func(parentPath string, name string) string {
+		return path.Join(parentPath, strings.ReplaceAll(name, "/", "_"))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	items := make(map[string]BackendItem)
+	for itemPath, file := range files {
+		items[itemPath] = backendItemFromMetadata(itemPath, file)
+	}
+	backend.items = items
+	return items, nil
+}
+
+//*********************************************************
+
+// lists everything in a folder on Google Drive one level at a time, all the folders of a level are listed
+// together, childPath builds the path of an item from the path of its folder, the trashed items are left out
+func (conn *Connection) listTree(ctx context.Context, folderId string, folderPath string, childPath func(string, string) string) (map[string]FileMetaData, error) {
+	files := make(map[string]FileMetaData)
+	level := map[string]string{folderId: folderPath} // key = folder id, value = path
+
+	for len(level) > 0 {
+		data, err := conn.getItemsInFolders(ctx, folderPath, sortedStringKeys(level))
+		if err != nil {
+			return nil, err
+		}
+
+		// Drive allows two items with the same name in a folder, only one of them is synced
+		chosen := make(map[string]FileMetaData)
+		for _, file := range data.Files {
+			if file.Trashed {
+				continue
+			}
+			for _, parent := range file.Parents {
+				parentPath, found := level[parent]
+				if !found {
+					continue
+				}
+				itemPath := childPath(parentPath, file.Name)
+				if existing, found := chosen[itemPath]; found && !preferRemoteItem(existing, file) {
+					continue
+				}
+				chosen[itemPath] = file
+			}
+		}
+
+		level = make(map[string]string)
+		for itemPath, file := range chosen {
+			files[itemPath] = file
+			if file.MimeType == "application/vnd.google-apps.folder" {
+				level[file.ID] = itemPath
+			}
+		}
+	}
+
+	return files, nil
+}
+
+//*********************************************************
+
+func backendItemFromMetadata(itemPath string, file FileMetaData) BackendItem {
+	modTime, _ := time.Parse(time.RFC3339Nano, file.ModifiedTime)
+	return BackendItem{
+		Path:         itemPath,
+		ID:           file.ID,
+		IsDir:        file.MimeType == "application/vnd.google-apps.folder",
+		Size:         file.Size,
+		Md5:          file.Md5Checksum,
+		ModifiedTime: modTime,
+	}
+}
+
+//*********************************************************
+
+// the id of the folder the path goes in, the folder must have been listed or created already
+func (backend *DriveBackend) parentId(itemPath string) (string, error) {
+	parentPath := path.Dir(itemPath)
+	if parentPath == "." {
+		return backend.folderId, nil
+	}
+	parent, found := backend.items[parentPath]
+	if !found || !parent.IsDir {
+		return "", fmt.Errorf("the folder %v is not on %v", parentPath, backend.Name())
+	}
+	return parent.ID, nil
+}
+
+//*********************************************************
+
+func (backend *DriveBackend) CreateFolder(ctx context.Context, itemPath string, modTime time.Time) error {
+	parentId, err := backend.parentId(itemPath)
+	if err != nil {
+		return err
+	}
+	id, err := backend.conn.nextId(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to generate an id for %v: %v", itemPath, err)
+	}
+
+	request := CreateFolderRequest{ID: id, Name: path.Base(itemPath), MimeType: "application/vnd.google-apps.folder",
+		Parents: []string{parentId}, ModifiedTime: modTime.Format(time.RFC3339Nano)}
+	err = backend.conn.createRemoteFolder(ctx, request)
+	if err != nil {
+		return err
+	}
+	backend.items[itemPath] = BackendItem{Path: itemPath, ID: id, IsDir: true, ModifiedTime: modTime}
+	return nil
+}
+
+//*********************************************************
+
+// the copy is made on the server, and the file it replaces is moved to the trash afterwards
+func (backend *DriveBackend) Copy(ctx context.Context, from Backend, item BackendItem, itemPath string) error {
+	if _, isDrive := from.(*DriveBackend); !isDrive {
+		return fmt.Errorf("can't copy %v from %v to %v", item.Path, from.Name(), backend.Name())
+	}
+	parentId, err := backend.parentId(itemPath)
+	if err != nil {
+		return err
+	}
+
+	request := CopyFileRequest{Name: path.Base(itemPath), Parents: []string{parentId}, ModifiedTime: item.ModifiedTime.Format(time.RFC3339Nano)}
+	copied, err := backend.conn.copyFile(ctx, item.ID, request)
+	if err != nil {
+		return err
+	}
+
+	replaced, exists := backend.items[itemPath]
+	backend.items[itemPath] = backendItemFromMetadata(itemPath, copied)
+	if exists && replaced.ID != copied.ID {
+		return backend.conn.trashFile(ctx, replaced.ID)
+	}
+	return nil
+}
+
+//*********************************************************
+
+func (backend *DriveBackend) Remove(ctx context.Context, item BackendItem) error {
+	err := backend.conn.trashFile(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	delete(backend.items, item.Path)
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the suffix of the files a LocalBackend writes before renaming them into place
+const LOCAL_BACKEND_TEMP_SUFFIX = ".mirror.tmp"
+
+// a folder on a local disk, for example on a USB drive that keeps a copy of a synced folder, the copies between
+// two LocalBackends keep the modification times so the next sync sees they are the same
+type LocalBackend struct {
+	fileSystem FS
+	root       string
+}
+
+func NewLocalBackend(fileSystem FS, root string) *LocalBackend {
+	return &LocalBackend{fileSystem: fileSystem, root: filepath.Clean(root)}
+}
+
+func (backend *LocalBackend) Name() string {
+	return "local:" + backend.root
+}
+
+//*********************************************************
+
+// a missing root is an error instead of an empty tree, so a USB drive that isn't plugged in doesn't look like
+// everything was removed from it
+func (backend *LocalBackend) List(ctx context.Context) (map[string]BackendItem, error) {
+	rootInfo, err := backend.fileSystem.Stat(backend.root)
+	if err != nil {
+		return nil, err
+	}
+	if !rootInfo.IsDir() {
+		return nil, fmt.Errorf("%v is not a folder", backend.root)
+	}
+
+	items := make(map[string]BackendItem)
+	err = backend.fileSystem.Walk(backend.root, func(localPath string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if localPath == backend.root || isIgnoredFile(fileInfo.Name()) || strings.HasSuffix(localPath, LOCAL_BACKEND_TEMP_SUFFIX) {
+			return nil
+		}
+
+		relativePath, err := filepath.Rel(backend.root, localPath)
+		if err != nil {
+			return err
+		}
+		itemPath := filepath.ToSlash(relativePath)
+		item := BackendItem{Path: itemPath, ID: localPath, IsDir: fileInfo.IsDir(), ModifiedTime: fileInfo.ModTime()}
+		if !item.IsDir {
+			item.Size = fileInfo.Size()
+		}
+		items[itemPath] = item
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//*********************************************************
+
+func (backend *LocalBackend) localPath(itemPath string) string {
+	return filepath.Join(backend.root, filepath.FromSlash(itemPath))
+}
+
+//*********************************************************
+
+func (backend *LocalBackend) CreateFolder(ctx context.Context, itemPath string, modTime time.Time) error {
+	return backend.fileSystem.Mkdir(backend.localPath(itemPath), 0766)
+}
+
+//*********************************************************
+
+// the file is written next to where it goes and renamed into place, so a copy that is interrupted when the
+// drive is unplugged doesn't leave half a file in place of the old one
+func (backend *LocalBackend) Copy(ctx context.Context, from Backend, item BackendItem, itemPath string) error {
+	source, isLocal := from.(*LocalBackend)
+	if !isLocal {
+		return fmt.Errorf("can't copy %v from %v to %v", item.Path, from.Name(), backend.Name())
+	}
+
+	input, err := source.fileSystem.Open(item.ID)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	localPath := backend.localPath(itemPath)
+	tempPath := localPath + LOCAL_BACKEND_TEMP_SUFFIX
+	output, err := backend.fileSystem.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(output, input)
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = backend.fileSystem.Chtimes(tempPath, item.ModifiedTime, item.ModifiedTime)
+	}
+	if err == nil {
+		err = backend.fileSystem.Rename(tempPath, localPath)
+	}
+	if err != nil {
+		backend.fileSystem.Remove(tempPath)
+		return err
+	}
+	return nil
+}
+
+//*********************************************************
+
+func (backend *LocalBackend) Remove(ctx context.Context, item BackendItem) error {
+	return backend.fileSystem.RemoveAll(backend.localPath(item.Path))
+}
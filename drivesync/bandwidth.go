@@ -0,0 +1,151 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// upload_limit and download_limit cap how fast the file contents are sent and received, so a big sync doesn't
+// take up the whole connection at home. The limit is shared by all of the workers, 4 downloads at once still
+// add up to download_limit. Only the contents are limited, the small requests for the metadata are not.
+
+// the most bytes read or written at once, so the waits stay short and the transfer is smooth
+const BANDWIDTH_CHUNK_BYTES = 32 * 1024
+
+type bandwidthLimiter struct {
+	mutex          sync.Mutex
+	bytesPerSecond int64
+	next           time.Time // when the bytes allowed so far have all been sent
+}
+
+// nil means no limit, a nil limiter passes everything through
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+}
+
+//*********************************************************
+
+// the value is a number of bytes per second with an optional unit, like 2MB/s or 500KB, 0 or off means no limit
+func parseBandwidthSetting(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "OFF" {
+		return 0, nil
+	}
+	value = strings.TrimSpace(strings.TrimSuffix(value, "/S"))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"GB", 1024 * 1024 * 1024}, {"MB", 1024 * 1024}, {"KB", 1024}, {"G", 1024 * 1024 * 1024}, {"M", 1024 * 1024}, {"K", 1024}, {"B", 1}} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("expected a rate like 2MB/s, 500KB/s or off")
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// waits until n more bytes can be sent, the waits of the workers are lined up one after the other
+func (limiter *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	limiter.mutex.Lock()
+	now := time.Now()
+	if limiter.next.Before(now) {
+		limiter.next = now // the time spent idle is not saved up for a burst later
+	}
+	limiter.next = limiter.next.Add(time.Duration(int64(n) * int64(time.Second) / limiter.bytesPerSecond))
+	delay := limiter.next.Sub(now)
+	limiter.mutex.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//*********************************************************
+
+type limitedReader struct {
+	ctx     context.Context
+	limiter *bandwidthLimiter
+	reader  io.Reader
+}
+
+func (limiter *bandwidthLimiter) reader(ctx context.Context, reader io.Reader) io.Reader {
+	if limiter == nil {
+		return reader
+	}
+	return &limitedReader{ctx: ctx, limiter: limiter, reader: reader}
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > BANDWIDTH_CHUNK_BYTES {
+		p = p[:BANDWIDTH_CHUNK_BYTES]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+//*********************************************************
+
+type limitedWriter struct {
+	ctx     context.Context
+	limiter *bandwidthLimiter
+	writer  io.Writer
+}
+
+func (limiter *bandwidthLimiter) writer(ctx context.Context, writer io.Writer) io.Writer {
+	if limiter == nil {
+		return writer
+	}
+	return &limitedWriter{ctx: ctx, limiter: limiter, writer: writer}
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		end := written + BANDWIDTH_CHUNK_BYTES
+		if end > len(p) {
+			end = len(p)
+		}
+		err := w.limiter.wait(w.ctx, end-written)
+		if err != nil {
+			return written, err
+		}
+		n, err := w.writer.Write(p[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
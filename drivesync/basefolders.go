@@ -0,0 +1,93 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The base folders are checked before anything is synced, since a bad one does real damage. Two base folders that
+// overlap see the same files twice, uploading them to both places and deleting them from one when they move in the
+// other. A root, home or system folder would upload or delete far more than intended, and a base folder that holds
+// the config folder (or is inside it) keeps uploading the state files that every cycle changes. The same folder on
+// Google Drive can't be synced to two local folders either.
+
+// folders that can't be a base folder or be inside one, the home folder and the roots are checked separately
+func systemFolders() []string {
+	if runtime.GOOS == "windows" {
+		var folders []string
+		for _, name := range []string{"SystemRoot", "ProgramFiles", "ProgramFiles(x86)", "ProgramData"} {
+			if folder := os.Getenv(name); folder != "" {
+				folders = append(folders, folder)
+			}
+		}
+		return folders
+	}
+	return []string{"/bin", "/boot", "/dev", "/etc", "/lib", "/lib64", "/proc", "/sbin", "/sys", "/usr", "/System"}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns an error for the first base folder that is not safe to sync, configFolder is the folder with the state
+func validateBaseFolders(baseFolders map[string]string, configFolder string) error {
+	folderOfId := make(map[string]string)
+	config := comparablePath(configFolder)
+	home, _ := os.UserHomeDir()
+
+	folders := sortedStringKeys(baseFolders)
+	for index, folder := range folders {
+		path := comparablePath(folder)
+		switch {
+		case filepath.Dir(path) == path:
+			return fmt.Errorf("the base folder %v is the root of a drive", folder)
+		case home != "" && path == comparablePath(home):
+			return fmt.Errorf("the base folder %v is the home folder, use a folder inside it", folder)
+		case localPathIsInside(path, config):
+			return fmt.Errorf("the base folder %v contains the config folder %v", folder, configFolder)
+		case localPathIsInside(config, path):
+			return fmt.Errorf("the base folder %v is inside the config folder %v", folder, configFolder)
+		}
+		for _, systemFolder := range systemFolders() {
+			if localPathIsInside(comparablePath(systemFolder), path) {
+				return fmt.Errorf("the base folder %v is in the system folder %v", folder, systemFolder)
+			}
+		}
+
+		for _, other := range folders[index+1:] {
+			otherPath := comparablePath(other)
+			if localPathIsInside(path, otherPath) || localPathIsInside(otherPath, path) {
+				return fmt.Errorf("the base folders %v and %v overlap", folder, other)
+			}
+		}
+
+		id := baseFolders[folder]
+		if otherFolder, used := folderOfId[id]; used {
+			return fmt.Errorf("the base folders %v and %v are the same folder on Google Drive, %v", otherFolder, folder, id)
+		}
+		folderOfId[id] = folder
+	}
+	return nil
+}
+
+//*********************************************************
+
+// the absolute path with the links followed, so two names for the same folder compare equal, and in lower case
+// where the file names are not case sensitive. A folder that doesn't exist yet is only made absolute.
+func comparablePath(path string) string {
+	if absolutePath, err := filepath.Abs(path); err == nil {
+		path = absolutePath
+	}
+	if realPath, err := filepath.EvalSymlinks(path); err == nil {
+		path = realPath
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		path = strings.ToLower(path)
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,195 @@
+package drivesync
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// only the end of a long trace is included, that's where the bug usually is
+const BUNDLE_MAX_TRACE_LINES = 10000
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// writes a zip file for bug reports with the version, the settings, a summary of the saved state, the status,
+// the end of the API trace and any log files in the config folder, the secrets are removed from all of them
+func (service *Service) WriteSupportBundle(ctx context.Context, fileName string) error {
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	archive := zip.NewWriter(fh)
+	redact := service.secretRedactor()
+
+	addText := func(name string, text string) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		writer, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(writer, redact.Replace(text))
+		return err
+	}
+
+	err = addText("version.txt", fmt.Sprintf("%v %v\ngo: %v\nos: %v/%v\nnumApiCalls: %v\n",
+		APP_NAME, AppVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH, service.conn.getNumApiCalls()))
+	if err != nil {
+		return err
+	}
+
+	// the effective settings, including the defaults that were filled in
+	settings := service.settings
+	if settings.NotifyCommand != "" {
+		settings.NotifyCommand = "REDACTED" // might contain a webhook url or a token
+	}
+	if settings.NotifyWebhook != "" {
+		settings.NotifyWebhook = "REDACTED" // the url is the secret
+	}
+	settingsJson, _ := json.MarshalIndent(settings, "", "  ")
+	err = addText("settings.json", string(settingsJson))
+	if err != nil {
+		return err
+	}
+
+	err = addText("base-folders.txt", fmt.Sprintln(len(service.baseFolders), "base folders:", service.getBaseFolderSlice()))
+	if err != nil {
+		return err
+	}
+
+	err = addText("credentials.txt", describeCredentials(service.settings))
+	if err != nil {
+		return err
+	}
+
+	err = addText("state-summary.txt", summarizeState(service.configFile(STATE_FILE_NAME)))
+	if err != nil {
+		return err
+	}
+
+	if statusData, err := os.ReadFile(service.configFile(STATUS_FILE_NAME)); err == nil {
+		err = addText("status.json", string(statusData))
+		if err != nil {
+			return err
+		}
+	}
+
+	if service.settings.RecordTrace != "" {
+		trace, err := tailOfFile(service.settings.RecordTrace, BUNDLE_MAX_TRACE_LINES)
+		if err == nil {
+			err = addText("trace.jsonl", trace)
+		}
+		if err != nil {
+			service.log.Warn("not including the trace:", err)
+		}
+	}
+
+	logFiles, _ := filepath.Glob(service.configFile("config/*.log"))
+	for _, logFile := range logFiles {
+		logData, err := tailOfFile(logFile, BUNDLE_MAX_TRACE_LINES)
+		if err == nil {
+			err = addText("logs/"+filepath.Base(logFile), logData)
+		}
+		if err != nil {
+			service.log.Warn("not including the log", logFile, ":", err)
+		}
+	}
+
+	err = archive.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote the support bundle to", fileName)
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// replaces every secret we know about with REDACTED
+func (service *Service) secretRedactor() *strings.Replacer {
+	var secrets []string
+	if service.conn.api_key != "" && service.conn.api_key != "REDACTED" {
+		secrets = append(secrets, strings.TrimSpace(service.conn.api_key), "REDACTED")
+	}
+	if service.conn.conf != nil {
+		secrets = append(secrets, service.conn.conf.Email, "REDACTED@service-account")
+		if service.conn.conf.PrivateKeyID != "" {
+			secrets = append(secrets, service.conn.conf.PrivateKeyID, "REDACTED")
+		}
+	}
+	return strings.NewReplacer(secrets...)
+}
+
+//*********************************************************
+
+// says which credential files are there without including them
+func describeCredentials(settings Settings) string {
+	var description strings.Builder
+	fmt.Fprintln(&description, "auth:", settings.Auth)
+	for _, fileName := range []string{settings.CredentialsFile, settings.ApiKeyFile, settings.OAuthClientFile, configPath(OAUTH_TOKEN_FILE_NAME)} {
+		if _, err := os.Stat(fileName); err == nil {
+			fmt.Fprintln(&description, fileName, "is present (contents not included)")
+		} else {
+			fmt.Fprintln(&description, fileName, "is missing:", err)
+		}
+	}
+	return description.String()
+}
+
+//*********************************************************
+
+// the state file lists every local path, so only the counts are included
+func summarizeState(fileName string) string {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return fmt.Sprintln("no saved state:", err)
+	}
+
+	state, err := decodeState(data)
+	if err != nil {
+		return fmt.Sprintln("the saved state is damaged:", err)
+	}
+
+	return fmt.Sprintf("verifiedAt: %v\nhas changes page token: %v\nlocal files: %v\nstate file size: %v bytes\n",
+		state.VerifiedAt, state.ChangesPageToken != "", len(state.LocalFiles), len(data))
+}
+
+//*********************************************************
+
+func tailOfFile(fileName string, maxLines int) (string, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > maxLines {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,184 @@
+package drivesync
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const CHUNK_CACHE_FILE_NAME = "config/chunk-cache.json"
+
+// only files at least this big get their chunks remembered, the smaller files are cheap to upload again
+const CHUNKED_FILE_THRESHOLD_BYTES int64 = 100 * 1024 * 1024
+
+// the chunk boundaries depend on the contents instead of the offset, so inserting bytes near the
+// start of a file only changes the chunks around the insert instead of every chunk after it
+const (
+	MIN_CHUNK_BYTES = 256 * 1024
+	MAX_CHUNK_BYTES = 4 * 1024 * 1024
+	CHUNK_MASK      = 1<<20 - 1 // makes the average chunk about 1 MB
+)
+
+type fileChunk struct {
+	Length int64  `json:"length"`
+	Md5    string `json:"md5"`
+}
+
+// the chunks of the large files, as they were the last time each one was uploaded
+type chunkCache struct {
+	mutex    sync.Mutex
+	uploaded map[string][]fileChunk // key = local path
+	scanned  map[string][]fileChunk // key = local path, the chunks seen the last time the file was hashed
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the random values for the gear rolling hash, they only need to be the same every time the program runs
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	seed := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		seed += 0x9E3779B97F4A7C15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+		z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// splits a stream into content defined chunks as it is written
+type chunker struct {
+	chunks  []fileChunk
+	hash    uint64
+	current []byte
+}
+
+func (chunker *chunker) Write(data []byte) (int, error) {
+	for _, b := range data {
+		chunker.current = append(chunker.current, b)
+		chunker.hash = (chunker.hash << 1) + gearTable[b]
+
+		length := len(chunker.current)
+		if length >= MAX_CHUNK_BYTES || (length >= MIN_CHUNK_BYTES && chunker.hash&CHUNK_MASK == 0) {
+			chunker.cut()
+		}
+	}
+	return len(data), nil
+}
+
+func (chunker *chunker) cut() {
+	if len(chunker.current) == 0 {
+		return
+	}
+	chunker.chunks = append(chunker.chunks, fileChunk{
+		Length: int64(len(chunker.current)),
+		Md5:    fmt.Sprintf("%x", md5.Sum(chunker.current)),
+	})
+	chunker.current = chunker.current[:0]
+	chunker.hash = 0
+}
+
+// returns the chunks, including the last partial one
+func (chunker *chunker) finish() []fileChunk {
+	chunker.cut()
+	return chunker.chunks
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadChunkCache() {
+	service.chunks.uploaded = make(map[string][]fileChunk)
+	service.chunks.scanned = make(map[string][]fileChunk)
+
+	data, err := os.ReadFile(service.configFile(CHUNK_CACHE_FILE_NAME))
+	if err != nil {
+		return // no cache yet
+	}
+	err = json.Unmarshal(data, &service.chunks.uploaded)
+	if err != nil {
+		service.log.Warn("ignoring the chunk cache:", err)
+		service.chunks.uploaded = make(map[string][]fileChunk)
+	}
+}
+
+//*********************************************************
+
+func (service *Service) saveChunkCache() {
+	// held while writing too, the upload workers can save at the same time
+	service.chunks.mutex.Lock()
+	defer service.chunks.mutex.Unlock()
+	data, err := json.Marshal(service.chunks.uploaded)
+	if err != nil {
+		service.log.Warn("failed to save the chunk cache:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(CHUNK_CACHE_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the chunk cache:", err)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// called while hashing a large file
+func (service *Service) rememberScannedChunks(path string, chunks []fileChunk) {
+	service.chunks.mutex.Lock()
+	defer service.chunks.mutex.Unlock()
+	service.chunks.scanned[path] = chunks
+}
+
+//*********************************************************
+
+// the file is on the server now, so its chunks become the ones to compare against next time
+func (service *Service) commitUploadedChunks(path string) {
+	service.chunks.mutex.Lock()
+	chunks, scanned := service.chunks.scanned[path]
+	if scanned {
+		service.chunks.uploaded[path] = chunks
+		delete(service.chunks.scanned, path)
+	}
+	service.chunks.mutex.Unlock()
+
+	if scanned {
+		service.saveChunkCache()
+	}
+}
+
+//*********************************************************
+
+// returns how many bytes of the file are in chunks that were not in the uploaded version, and false if
+// there is nothing to compare against
+func (service *Service) changedChunkBytes(path string) (int64, bool) {
+	service.chunks.mutex.Lock()
+	defer service.chunks.mutex.Unlock()
+
+	uploaded, haveUploaded := service.chunks.uploaded[path]
+	scanned, haveScanned := service.chunks.scanned[path]
+	if !haveUploaded || !haveScanned {
+		return 0, false
+	}
+
+	known := make(map[string]bool)
+	for _, chunk := range uploaded {
+		known[chunk.Md5] = true
+	}
+
+	var changed int64
+	for _, chunk := range scanned {
+		if !known[chunk.Md5] {
+			changed += chunk.Length
+		}
+	}
+	return changed, true
+}
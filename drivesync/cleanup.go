@@ -0,0 +1,462 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// folders that were seen in the user's folders more recently than this are trusted without asking the server again
+const KNOWN_FOLDER_MAX_AGE = 24 * time.Hour
+
+// guards against a loop in the parent ids
+const MAX_FOLDER_DEPTH = 100
+
+// what the cleanup does with the orphans, see cleanup_mode
+const CLEANUP_TRASH = "trash"
+const CLEANUP_DELETE = "delete"
+
+// when the cleanup trashed each orphan, Google Drive only says when an item was trashed on a Shared Drive, so
+// empty-trash needs this to know how long an item has been in the trash
+const CLEANUP_TRASH_FILE_NAME = "config/cleanup-trash.json"
+
+// the nightly cleanup runs once a day, the first time the sync runs after this hour
+const CLEANUP_HOUR = 2
+
+// a cleanup that failed is tried again after this long, instead of every cycle
+const CLEANUP_RETRY_DELAY = time.Hour
+
+// the day of the last cleanup that finished, and the one that is running, so a restart doesn't run the cleanup
+// twice or skip a day
+const CLEANUP_SCHEDULE_FILE_NAME = "config/cleanup-schedule.json"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type CleanupSummary struct {
+	OrphansFound   int
+	Deleted        int64
+	Failed         int64
+	BytesReclaimed int64
+	Duration       time.Duration
+	Trashed        bool // the orphans were moved to the trash, they still use the storage until the trash is emptied
+}
+
+func (summary CleanupSummary) String() string {
+	if summary.Trashed {
+		return fmt.Sprintf("found %v orphaned files/folders, trashed %v, failed %v, %.1f MB will be reclaimed when the trash is emptied, in %v",
+			summary.OrphansFound, summary.Deleted, summary.Failed, float64(summary.BytesReclaimed)/(1024*1024), summary.Duration.Round(time.Second))
+	}
+	return fmt.Sprintf("found %v orphaned files/folders, deleted %v, failed %v, reclaimed %.1f MB in %v",
+		summary.OrphansFound, summary.Deleted, summary.Failed, float64(summary.BytesReclaimed)/(1024*1024), summary.Duration.Round(time.Second))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// finds the files owned by the service account that are no longer in one of the user's folders,
+// and plans a DeleteRemote for each one that is not inside another orphaned folder
+func (service *Service) planCleanup(ctx context.Context) (Plan, error) {
+	if err := service.cleanupIsOff("the cleanup"); err != nil {
+		return Plan{}, err
+	}
+
+	// all the pages are fetched before anything is deleted, deleting while paging could shift the pages and skip files
+	allServiceAcctFiles, err := service.conn.GetFilesOwnedByServiceAcct(ctx, false)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	filesById := make(map[string]FileMetaData)
+	for _, serviceFile := range allServiceAcctFiles {
+		filesById[serviceFile.ID] = serviceFile
+	}
+
+	inUserFolders := make(map[string]bool) // key = folder id, remembers the answer for each folder we have checked
+	orphanIds := make(map[string]bool)
+	var orphans []FileMetaData
+
+	for _, serviceFile := range allServiceAcctFiles {
+		// files without a parent are left alone
+		if len(serviceFile.Parents) == 0 {
+			continue
+		}
+		// what's in the trash already is left for empty-trash
+		if serviceFile.Trashed && service.settings.CleanupMode == CLEANUP_TRASH {
+			continue
+		}
+
+		// if there are any errors when checking the parents, then don't delete this file!!
+		found, err := service.folderIsInUserFolders(ctx, serviceFile.Parents[0], filesById, inUserFolders, 0)
+		if err != nil {
+			service.cleanupLog.Warn("not removing", serviceFile.Name, serviceFile.ID, "because:", err)
+			continue
+		}
+
+		if !found {
+			orphans = append(orphans, serviceFile)
+			orphanIds[serviceFile.ID] = true
+		}
+	}
+
+	// deleting a folder also deletes everything inside it, so there's no need to delete those files one by one
+	topLevelOrphans := make(map[string]*Action)
+	var result []*Action
+	for _, orphan := range orphans {
+		if !orphanIds[orphan.Parents[0]] {
+			item := &Action{Type: ACTION_DELETE_REMOTE, Remote: orphan, Reason: "no longer in the user's folders"}
+			topLevelOrphans[orphan.ID] = item
+			result = append(result, item)
+		}
+	}
+
+	// add up the sizes so each top level orphan knows how much will be reclaimed by deleting it
+	for _, orphan := range orphans {
+		topLevelId := orphan.ID
+		for depth := 0; depth < MAX_FOLDER_DEPTH && topLevelOrphans[topLevelId] == nil; depth++ {
+			topLevelId = filesById[topLevelId].Parents[0]
+		}
+		item, found := topLevelOrphans[topLevelId]
+		if found {
+			item.ItemCount++
+			item.Bytes += orphan.Size
+		}
+	}
+
+	var plan Plan
+	for _, item := range result {
+		plan.add(*item)
+	}
+	sortActionsByRemoteName(plan.Actions)
+
+	return plan, nil
+}
+
+//*********************************************************
+
+func (service *Service) folderIsInUserFolders(ctx context.Context, folderId string, filesById map[string]FileMetaData, inUserFolders map[string]bool, depth int) (bool, error) {
+	if depth > MAX_FOLDER_DEPTH {
+		return false, errors.New("too many parent folders for " + folderId)
+	}
+
+	answer, alreadyChecked := inUserFolders[folderId]
+	if alreadyChecked {
+		return answer, nil
+	}
+
+	for _, baseId := range service.baseFolders {
+		if folderId == baseId {
+			inUserFolders[folderId] = true
+			return true, nil
+		}
+	}
+
+	// reuse what the sync loop already learned instead of listing the whole tree again
+	seenAt, known := service.knownFolders[folderId]
+	if known && service.clock.Now().Sub(seenAt) < KNOWN_FOLDER_MAX_AGE {
+		inUserFolders[folderId] = true
+		return true, nil
+	}
+
+	// the folder might be owned by the service account, otherwise we have to ask the server
+	metadata, inList := filesById[folderId]
+	if !inList {
+		var err error
+		metadata, err = service.conn.getMetadataById(ctx, "?", folderId)
+		if errors.Is(err, ErrNotFound) {
+			inUserFolders[folderId] = false
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if len(metadata.Parents) == 0 {
+		inUserFolders[folderId] = false
+		return false, nil
+	}
+
+	answer, err := service.folderIsInUserFolders(ctx, metadata.Parents[0], filesById, inUserFolders, depth+1)
+	if err != nil {
+		return false, err
+	}
+	inUserFolders[folderId] = answer
+	if answer {
+		service.rememberFolder(folderId)
+	}
+
+	return answer, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// deletes or trashes the items using a bounded pool of workers, the deletes are spread out over time so
+// thousands of orphans don't use up the quota for the API
+func (service *Service) deleteRemoteItems(ctx context.Context, items []Action, summary *CleanupSummary) {
+	var numDeleted, numFailed, bytesReclaimed int64
+	var wg sync.WaitGroup
+	jobs := make(chan Action)
+
+	var trashedMutex sync.Mutex
+	var trashedIds []string
+
+	for i := 0; i < service.cleanupWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				var err error
+				if summary.Trashed {
+					err = service.conn.trashFile(ctx, item.Remote.ID)
+				} else {
+					err = service.conn.deleteFileOrFolder(ctx, item.Remote)
+					if errors.Is(err, ErrNotFound) {
+						err = nil // it went away with a folder that was deleted before it
+					}
+				}
+				if err != nil {
+					service.cleanupLog.Warn("failed to delete", item.Remote.Name, item.Remote.ID, err)
+					atomic.AddInt64(&numFailed, int64(item.ItemCount))
+				} else {
+					atomic.AddInt64(&numDeleted, int64(item.ItemCount))
+					atomic.AddInt64(&bytesReclaimed, item.Bytes)
+					if summary.Trashed {
+						trashedMutex.Lock()
+						trashedIds = append(trashedIds, item.Remote.ID)
+						trashedMutex.Unlock()
+					}
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / service.cleanupRate()))
+	defer ticker.Stop()
+
+	for _, item := range items {
+		<-ticker.C
+		if ctx.Err() != nil {
+			break // cancelled, the rest will be deleted by the next cleanup
+		}
+		jobs <- item
+	}
+	close(jobs)
+	wg.Wait()
+
+	if len(trashedIds) > 0 {
+		trashedAt := service.loadTrashedTimes()
+		for _, id := range trashedIds {
+			trashedAt[id] = service.clock.Now()
+		}
+		service.saveTrashedTimes(trashedAt)
+	}
+
+	summary.Deleted += numDeleted
+	summary.Failed += numFailed
+	summary.BytesReclaimed += bytesReclaimed
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the cleanup and empty-trash only delete the files of a service account, signed in as the user, or acting as one
+// with impersonate_user, the files listed are the user's own, so every file in the user's Drive would look orphaned
+// and the user's own trash would be emptied
+func (service *Service) cleanupIsOff(what string) error {
+	if service.settings.Auth == AUTH_USER {
+		return fmt.Errorf("%v only deletes the files of a service account, it's off with auth=user", what)
+	}
+	if service.settings.ImpersonateUser != "" {
+		return fmt.Errorf("%v only deletes the files of a service account, it's off with impersonate_user", what)
+	}
+	return nil
+}
+
+//*********************************************************
+
+type cleanupSchedule struct {
+	LastFinished string     `json:"lastFinished"`        // the local date of the last cleanup that ran to the end, 2006-01-02
+	StartedAt    *time.Time `json:"startedAt,omitempty"` // set while a cleanup is running, or until a failed one gets through
+	RetryAt      *time.Time `json:"retryAt,omitempty"`   // when a cleanup that failed is tried again
+}
+
+//*********************************************************
+
+// runs the nightly cleanup if it hasn't run today, a cleanup that was interrupted by a restart is started again
+// right away, it plans again from the start but the orphans it removed already are not found again, so it
+// carries on where it stopped, and one that failed is tried again after CLEANUP_RETRY_DELAY, it only counts for
+// the day once it gets through
+func (service *Service) runScheduledCleanup(ctx context.Context) {
+	now := service.clock.Now()
+	schedule := service.loadCleanupSchedule()
+
+	if schedule.RetryAt != nil && now.Before(*schedule.RetryAt) {
+		return
+	} else if schedule.StartedAt != nil {
+		service.cleanupLog.Info("resuming the cleanup that was started at", schedule.StartedAt.Local(), "at", now)
+	} else if now.Hour() < CLEANUP_HOUR || schedule.LastFinished == now.Format("2006-01-02") {
+		return
+	} else {
+		service.cleanupLog.Info("cleaning up at", now)
+		schedule.StartedAt = &now
+		service.saveCleanupSchedule(schedule)
+	}
+
+	service.setActivity("cleaning up")
+	err := service.RemoveDeletedFiles(ctx)
+	if ctx.Err() != nil {
+		return // stopped partway, it's resumed the next time
+	}
+	if err != nil {
+		// it doesn't count as done for the day, it's started again later
+		service.cleanupLog.Error(err)
+		retryAt := service.clock.Now().Add(CLEANUP_RETRY_DELAY)
+		schedule.RetryAt = &retryAt
+		service.saveCleanupSchedule(schedule)
+		service.cleanupLog.Info("the cleanup will be tried again at", retryAt.Local())
+		return
+	}
+
+	// a cleanup that is resumed after midnight still counts for the day it was started
+	schedule.LastFinished = schedule.StartedAt.In(now.Location()).Format("2006-01-02")
+	schedule.StartedAt = nil
+	schedule.RetryAt = nil
+	service.saveCleanupSchedule(schedule)
+}
+
+//*********************************************************
+
+func (service *Service) loadCleanupSchedule() cleanupSchedule {
+	var schedule cleanupSchedule
+	data, err := os.ReadFile(service.configFile(CLEANUP_SCHEDULE_FILE_NAME))
+	if err != nil {
+		return schedule // the cleanup never ran
+	}
+	err = json.Unmarshal(data, &schedule)
+	if err != nil {
+		service.cleanupLog.Warn("ignoring", service.configFile(CLEANUP_SCHEDULE_FILE_NAME), ":", err)
+		return cleanupSchedule{}
+	}
+	return schedule
+}
+
+//*********************************************************
+
+func (service *Service) saveCleanupSchedule(schedule cleanupSchedule) {
+	data, err := json.MarshalIndent(schedule, "", "  ")
+	if err != nil {
+		service.cleanupLog.Warn("failed to save the cleanup schedule:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(CLEANUP_SCHEDULE_FILE_NAME), data)
+	if err != nil {
+		service.cleanupLog.Warn("failed to save the cleanup schedule:", err)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// key = id, value = when the cleanup trashed it, or when empty-trash first saw it in the trash
+func (service *Service) loadTrashedTimes() map[string]time.Time {
+	trashedAt := make(map[string]time.Time)
+	data, err := os.ReadFile(service.configFile(CLEANUP_TRASH_FILE_NAME))
+	if err != nil {
+		return trashedAt
+	}
+	err = json.Unmarshal(data, &trashedAt)
+	if err != nil {
+		service.cleanupLog.Warn("ignoring", service.configFile(CLEANUP_TRASH_FILE_NAME), ":", err)
+		return make(map[string]time.Time)
+	}
+	return trashedAt
+}
+
+//*********************************************************
+
+func (service *Service) saveTrashedTimes(trashedAt map[string]time.Time) {
+	data, err := json.MarshalIndent(trashedAt, "", "  ")
+	if err != nil {
+		service.cleanupLog.Warn("failed to save the trashed items:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(CLEANUP_TRASH_FILE_NAME), data)
+	if err != nil {
+		service.cleanupLog.Warn("failed to save the trashed items:", err)
+	}
+}
+
+//*********************************************************
+
+// deletes for good the items of the service account that have been in the trash for longer than olderThan,
+// an item that is in the trash without the cleanup having put it there is counted from when it's first seen
+func (service *Service) EmptyTrash(ctx context.Context, olderThan time.Duration) error {
+	if err := service.cleanupIsOff("empty-trash"); err != nil {
+		return err
+	}
+
+	startTime := service.clock.Now()
+	allServiceAcctFiles, err := service.conn.GetFilesOwnedByServiceAcct(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	savedTrashedAt := service.loadTrashedTimes()
+	trashedAt := make(map[string]time.Time) // only the items that are still in the trash are kept
+	due := make(map[string]FileMetaData)
+	for _, file := range allServiceAcctFiles {
+		if !file.Trashed {
+			continue
+		}
+		at, found := savedTrashedAt[file.ID]
+		if !found {
+			at = startTime
+			if remoteAt, err := time.Parse(time.RFC3339Nano, file.TrashedTime); err == nil {
+				at = remoteAt
+			}
+		}
+		trashedAt[file.ID] = at
+		if startTime.Sub(at) >= olderThan {
+			due[file.ID] = file
+		}
+	}
+
+	// deleting a folder also deletes everything inside it
+	var plan Plan
+	for _, file := range due {
+		if len(file.Parents) > 0 {
+			if _, parentDue := due[file.Parents[0]]; parentDue {
+				continue
+			}
+		}
+		plan.add(Action{Type: ACTION_DELETE_REMOTE, Remote: file, Reason: "in the trash since " + trashedAt[file.ID].Local().Format(time.RFC1123),
+			ItemCount: 1, Bytes: file.Size})
+	}
+	sortActionsByRemoteName(plan.Actions)
+	if logEnabled(LOG_DEBUG) {
+		plan.Print()
+	}
+
+	summary := CleanupSummary{OrphansFound: len(plan.Actions)}
+	service.deleteRemoteItems(ctx, plan.Actions, &summary)
+	summary.Duration = service.clock.Now().Sub(startTime)
+
+	// the deleted items are left out the next time since they are no longer listed
+	service.saveTrashedTimes(trashedAt)
+
+	service.cleanupLog.Infof("emptied %v of %v items from the trash, failed %v, reclaimed %.1f MB in %v\n", summary.Deleted, len(trashedAt),
+		summary.Failed, float64(summary.BytesReclaimed)/(1024*1024), summary.Duration.Round(time.Second))
+	return ctx.Err()
+}
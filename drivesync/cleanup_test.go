@@ -0,0 +1,49 @@
+package drivesync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestFailedCleanupIsTriedAgain(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, CLEANUP_HOUR+1, 0, 0, 0, time.Local))
+	service := newTestService(t, newMemFS(clock), "sync")
+	service.SetClock(clock)
+	failing := true
+	service.conn.client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if failing {
+			return &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{},
+				Body: io.NopCloser(strings.NewReader(`{"error":{"errors":[{"reason":"backendError"}]}}`)), Request: req}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"files":[]}`)), Request: req}, nil
+	})}
+
+	service.runScheduledCleanup(context.Background())
+	schedule := service.loadCleanupSchedule()
+	if schedule.LastFinished != "" || schedule.RetryAt == nil {
+		t.Fatalf("a failed cleanup was recorded as %+v", schedule)
+	}
+
+	// Google Drive is back, but it waits for the retry time
+	failing = false
+	clock.Advance(CLEANUP_RETRY_DELAY / 2)
+	service.runScheduledCleanup(context.Background())
+	if schedule := service.loadCleanupSchedule(); schedule.LastFinished != "" {
+		t.Fatalf("tried again before the retry time: %+v", schedule)
+	}
+
+	clock.Advance(CLEANUP_RETRY_DELAY / 2)
+	service.runScheduledCleanup(context.Background())
+	schedule = service.loadCleanupSchedule()
+	if schedule.LastFinished != "2024-03-01" || schedule.StartedAt != nil || schedule.RetryAt != nil {
+		t.Errorf("expected the cleanup to be done for the day, got %+v", schedule)
+	}
+}
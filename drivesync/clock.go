@@ -0,0 +1,32 @@
+package drivesync
+
+import (
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// everything in the engine that needs the current time asks the Clock, so a fake clock can be used to check
+// the verified timestamps, the retry backoffs and the 2 AM cleanup without waiting for them
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// the Clock that is used unless SetClock is called
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+//*********************************************************
+
+func (service *Service) SetClock(clock Clock) {
+	service.clock = clock
+}
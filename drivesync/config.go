@@ -0,0 +1,374 @@
+package drivesync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// One structured file, config/config.json, can hold everything that is otherwise spread over folder-ids.txt,
+// settings.txt and the paths of the credential files. When it's there those two files are not read. It's
+// checked when the sync starts and every problem in it is reported at once, with the line it's on.
+
+const CONFIG_FILE_NAME = "config/config.json"
+
+type ConfigFile struct {
+	Auth            string            `json:"auth,omitempty"`            // service_account (the default) or user
+	Credentials     string            `json:"credentials,omitempty"`     // the service account key, defaults to config/service-account.json
+	ApiKeyFile      string            `json:"apiKeyFile,omitempty"`      // defaults to config/api-key.txt
+	OAuthClientFile string            `json:"oauthClientFile,omitempty"` // for auth=user, defaults to config/oauth-client.json
+	Folders         map[string]string `json:"folders"`                   // key = local folder, value = folder id on Google Drive
+
+	Intervals ConfigIntervals         `json:"intervals"`
+	Filters   map[string]ConfigFilter `json:"filters,omitempty"` // key = local folder, one of the folders
+	Log       ConfigLog               `json:"log"`
+
+	// any of the other settings.txt keys, the value is a string, number or true/false, or a list of them for the
+	// keys that can be repeated
+	Settings map[string]interface{} `json:"settings,omitempty"`
+}
+
+type ConfigIntervals struct {
+	LocalScanSeconds   int `json:"localScanSeconds,omitempty"`
+	RemoteCheckSeconds int `json:"remoteCheckSeconds,omitempty"`
+	FastPollSeconds    int `json:"fastPollSeconds,omitempty"`
+	FastPollMinutes    int `json:"fastPollMinutes,omitempty"`
+}
+
+type ConfigFilter struct {
+	Include   []string `json:"include,omitempty"`
+	Exclude   []string `json:"exclude,omitempty"`
+	MaxFileMb int      `json:"maxFileMb,omitempty"`
+}
+
+type ConfigLog struct {
+	Debug               bool   `json:"debug,omitempty"`
+	Level               string `json:"level,omitempty"`
+	Format              string `json:"format,omitempty"`
+	RecordTrace         string `json:"recordTrace,omitempty"`
+	RecordTraceContents bool   `json:"recordTraceContents,omitempty"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns nil without an error when there is no config file
+func readConfigFile(fileName string) (*ConfigFile, error) {
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config ConfigFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(&config)
+
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		return nil, fmt.Errorf("%v line %v: %v", fileName, lineOfOffset(data, syntaxError.Offset), syntaxError)
+	case errors.As(err, &typeError):
+		return nil, fmt.Errorf("%v line %v: %v should be %v, not %v", fileName, lineOfOffset(data, typeError.Offset),
+			typeError.Field, typeError.Type, typeError.Value)
+	case err != nil:
+		return nil, fmt.Errorf("%v: %v", fileName, err) // an unknown field says which one it is
+	}
+
+	problems := config.validate()
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%v needs to be fixed:\n  %v", fileName, strings.Join(problems, "\n  "))
+	}
+	return &config, nil
+}
+
+//*********************************************************
+
+func lineOfOffset(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+//*********************************************************
+
+// returns one message for each problem so they can all be fixed at once
+func (config *ConfigFile) validate() []string {
+	var problems []string
+
+	if len(config.Folders) == 0 {
+		problems = append(problems, `"folders" is empty, add the folders to sync like "folders": {"Shared": "<folder id>"}`)
+	}
+	for localName, folderId := range config.Folders {
+		if strings.TrimSpace(localName) == "" {
+			problems = append(problems, fmt.Sprintf(`the folder with id %q in "folders" has no local name`, folderId))
+		}
+		if strings.TrimSpace(folderId) == "" {
+			problems = append(problems, fmt.Sprintf(`the folder %q in "folders" has no folder id`, localName))
+		}
+	}
+
+	credentials := []string{config.Credentials, config.ApiKeyFile}
+	defaults := []string{"config/service-account.json", "config/api-key.txt"}
+	switch config.Auth {
+	case "", AUTH_SERVICE_ACCOUNT:
+	case AUTH_USER:
+		// signed in as the user the service account key isn't needed
+		credentials = []string{config.OAuthClientFile, config.ApiKeyFile}
+		defaults = []string{"config/oauth-client.json", "config/api-key.txt"}
+	default:
+		problems = append(problems, fmt.Sprintf(`"auth" should be %q or %q, not %q`, AUTH_SERVICE_ACCOUNT, AUTH_USER, config.Auth))
+	}
+	for i, fileName := range credentials {
+		if fileName == "" {
+			fileName = configPath(defaults[i])
+		}
+		if _, err := os.Stat(fileName); err != nil {
+			problems = append(problems, fmt.Sprintf("the credentials file %v can't be read: %v", fileName, err))
+		}
+	}
+
+	intervals := map[string]int{
+		"localScanSeconds":   config.Intervals.LocalScanSeconds,
+		"remoteCheckSeconds": config.Intervals.RemoteCheckSeconds,
+		"fastPollSeconds":    config.Intervals.FastPollSeconds,
+		"fastPollMinutes":    config.Intervals.FastPollMinutes,
+	}
+	for name, value := range intervals {
+		if value < 0 {
+			problems = append(problems, fmt.Sprintf(`"intervals" %v can't be negative`, name))
+		}
+	}
+
+	for localName, filter := range config.Filters {
+		if _, found := config.Folders[localName]; !found {
+			problems = append(problems, fmt.Sprintf(`the filter for %q is not for one of the "folders"`, localName))
+		}
+		var scratch Settings
+		scratch.FolderFilters = make(map[string]*FolderFilter)
+		for _, line := range config.filterLines(localName, filter) {
+			keyValue := strings.SplitN(line, "=", 2)
+			if err := parseFilterSetting(keyValue[0], keyValue[1], &scratch); err != nil {
+				problems = append(problems, fmt.Sprintf("the filter %v: %v", line, err))
+			}
+		}
+		if filter.MaxFileMb < 0 {
+			problems = append(problems, fmt.Sprintf(`the filter for %q has a negative maxFileMb`, localName))
+		}
+	}
+
+	for key, value := range config.Settings {
+		if _, err := settingValues(value); err != nil {
+			problems = append(problems, fmt.Sprintf(`"settings" %v: %v`, key, err))
+		}
+	}
+
+	sort.Strings(problems)
+	return problems
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the config as settings.txt lines, so they are parsed the same way
+func (config *ConfigFile) settingLines() []string {
+	var lines []string
+	add := func(key string, value interface{}) {
+		lines = append(lines, fmt.Sprintf("%v=%v", key, value))
+	}
+
+	if config.Auth != "" {
+		add("auth", config.Auth)
+	}
+	if config.Credentials != "" {
+		add("credentials_file", config.Credentials)
+	}
+	if config.ApiKeyFile != "" {
+		add("api_key_file", config.ApiKeyFile)
+	}
+	if config.OAuthClientFile != "" {
+		add("oauth_client_file", config.OAuthClientFile)
+	}
+
+	if config.Intervals.LocalScanSeconds > 0 {
+		add("local_scan_seconds", config.Intervals.LocalScanSeconds)
+	}
+	if config.Intervals.RemoteCheckSeconds > 0 {
+		add("remote_check_seconds", config.Intervals.RemoteCheckSeconds)
+	}
+	if config.Intervals.FastPollSeconds > 0 {
+		add("fast_poll_seconds", config.Intervals.FastPollSeconds)
+	}
+	if config.Intervals.FastPollMinutes > 0 {
+		add("fast_poll_minutes", config.Intervals.FastPollMinutes)
+	}
+
+	for _, localName := range sortedStringKeys(config.Folders) {
+		if filter, found := config.Filters[localName]; found {
+			lines = append(lines, config.filterLines(localName, filter)...)
+		}
+	}
+
+	if config.Log.RecordTrace != "" {
+		add("record_trace", config.Log.RecordTrace)
+	}
+	if config.Log.RecordTraceContents {
+		add("record_trace_contents", true)
+	}
+
+	keys := make([]string, 0, len(config.Settings))
+	for key := range config.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		values, _ := settingValues(config.Settings[key])
+		for _, value := range values {
+			add(key, value)
+		}
+	}
+	return lines
+}
+
+//*********************************************************
+
+func (config *ConfigFile) filterLines(localName string, filter ConfigFilter) []string {
+	var lines []string
+	for _, pattern := range filter.Include {
+		lines = append(lines, "include="+localName+"="+pattern)
+	}
+	for _, pattern := range filter.Exclude {
+		lines = append(lines, "exclude="+localName+"="+pattern)
+	}
+	if filter.MaxFileMb > 0 {
+		lines = append(lines, fmt.Sprintf("max_file_mb=%v=%v", localName, filter.MaxFileMb))
+	}
+	return lines
+}
+
+//*********************************************************
+
+// a setting is a string, number or true/false, or a list of them for the keys that can be repeated
+func settingValues(value interface{}) ([]string, error) {
+	switch typed := value.(type) {
+	case string:
+		return []string{typed}, nil
+	case float64, bool:
+		return []string{fmt.Sprint(typed)}, nil
+	case []interface{}:
+		var values []string
+		for _, item := range typed {
+			itemValues, err := settingValues(item)
+			if err != nil || len(itemValues) != 1 {
+				return nil, fmt.Errorf("a list can only have strings, numbers and true/false in it")
+			}
+			values = append(values, itemValues...)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("expected a string, number, true/false or a list of them")
+}
+
+//*********************************************************
+
+func (config *ConfigFile) settings() Settings {
+	return parseSettings(CONFIG_FILE_NAME, config.settingLines())
+}
+
+//*********************************************************
+
+func (config *ConfigFile) baseFolders() map[string]string {
+	baseFolders := make(map[string]string)
+	for localName, folderId := range config.Folders {
+		baseFolders[configNameToLocalPath(localName)] = strings.TrimSpace(folderId)
+	}
+	return baseFolders
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// reads config/config.json, or settings.txt when there is no config.json, in which case the config is nil
+func loadConfig(configFileName string, settingsFileName string) (Settings, *ConfigFile, error) {
+	config, err := readConfigFile(configFileName)
+	if err != nil {
+		return Settings{}, nil, err
+	}
+	if config == nil {
+		return loadSettings(settingsFileName), nil, nil
+	}
+
+	if config.Log.Level != "" {
+		level, err := ParseLogLevel(config.Log.Level)
+		if err != nil {
+			return Settings{}, nil, fmt.Errorf("%v: %w", configFileName, err)
+		}
+		SetLogLevel(level)
+	}
+	if config.Log.Format != "" {
+		err := SetLogFormat(config.Log.Format)
+		if err != nil {
+			return Settings{}, nil, fmt.Errorf("%v: %w", configFileName, err)
+		}
+	}
+	if config.Log.Debug {
+		SetDebug(true)
+	}
+	return config.settings(), config, nil
+}
+
+//*********************************************************
+
+// reads config/folder-ids.txt, one folder=id per line
+func readFolderIds(fileName string) (map[string]string, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	baseFolders := make(map[string]string)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := scanner.Text()
+		line_split := strings.SplitN(line, "=", 2)
+		if len(line_split) != 2 {
+			continue // skip blank lines
+		}
+		baseFolders[configNameToLocalPath(line_split[0])] = strings.TrimSpace(line_split[1])
+	}
+	return baseFolders, scanner.Err()
+}
+
+//*********************************************************
+
+// adds a folder to config/config.json, the file is written again so any formatting of it is lost
+func addFolderToConfigFile(fileName string, localName string, folderId string) error {
+	config, err := readConfigFile(fileName)
+	if err != nil {
+		return err
+	}
+	if config.Folders == nil {
+		config.Folders = make(map[string]string)
+	}
+	config.Folders[localName] = folderId
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(fileName, data)
+}
@@ -0,0 +1,91 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// When a file changed on both sides since the last verify, the newer version wins like before, but the
+// other version is no longer thrown away. It is kept next to the file as "name (conflict YYYY-MM-DD).ext"
+// on both sides, so the user can compare the two and merge them by hand.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns the name for the conflict copy of a file, count > 1 is added when the first name is already taken
+func conflictName(name string, date time.Time, count int) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		// a name like .bashrc has no extension
+		base, ext = name, ""
+	}
+
+	stamp := date.Format("2006-01-02")
+	if count > 1 {
+		stamp += fmt.Sprintf(" %d", count)
+	}
+	return base + " (conflict " + stamp + ")" + ext
+}
+
+//*********************************************************
+
+// returns a local path for the conflict copy that isn't used on either side yet
+func (service *Service) conflictPath(localPath string) string {
+	dir, name := filepath.Split(localPath)
+	now := service.clock.Now()
+	for count := 1; ; count++ {
+		candidate := dir + conflictName(name, now, count)
+		_, existsOnServer := service.uploadLookupMap[candidate]
+		_, existsOnDownload := service.downloadLookupMap[candidate]
+		if _, err := service.fileSystem.Stat(candidate); err != nil && !existsOnServer && !existsOnDownload {
+			return candidate
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the local version won, so copy the remote version to a conflict copy on Google Drive before it is
+// replaced, the copy is downloaded like any other new remote file
+func (service *Service) keepRemoteConflictCopy(ctx context.Context, localPath string, remote FileMetaData) error {
+	conflictPath := service.conflictPath(localPath)
+	request := CopyFileRequest{Name: filepath.Base(conflictPath), Parents: remote.Parents, ModifiedTime: remote.ModifiedTime}
+	copied, err := service.conn.copyFile(ctx, remote.ID, request)
+	if err != nil {
+		return err
+	}
+
+	service.log.with(logFields{Path: localPath, FileId: remote.ID}).Warn("conflict:", localPath, "changed on both sides, kept the remote version as", conflictPath)
+	service.uploadLookupMap[conflictPath] = copied
+	service.cycle.conflicts = append(service.cycle.conflicts, conflictPath)
+	return nil
+}
+
+//*********************************************************
+
+// the remote version won, so move the local version out of the way to a conflict copy and upload it as a
+// new file, then the download brings over the remote version to the original name
+func (service *Service) keepLocalConflictCopy(ctx context.Context, localPath string) error {
+	conflictPath := service.conflictPath(localPath)
+	err := service.fileSystem.Rename(localPath, conflictPath)
+	if err != nil {
+		return err
+	}
+	service.log.with(logFields{Path: localPath}).Warn("conflict:", localPath, "changed on both sides, kept the local version as", conflictPath)
+	service.cycle.conflicts = append(service.cycle.conflicts, conflictPath)
+
+	info, err := service.fileSystem.Stat(conflictPath)
+	if err != nil {
+		return err
+	}
+	service.queueLocalUpload(conflictPath, info.ModTime())
+	return service.handleCreate(ctx, conflictPath, info)
+}
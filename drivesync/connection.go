@@ -0,0 +1,1922 @@
+package drivesync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/drive/v2"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type Connection struct {
+	conf        *jwt.Config
+	client      *http.Client
+	api_key     string
+	numApiCalls int64
+	log         logger // connLog with the tenant and numApiCalls, set by the Service
+
+	rateLimited      int64 // the rate limited responses since the throttle was last adjusted
+	rateLimitedTotal int64 // the rate limited responses since startup
+
+	errorPrinter errorPrinter // prints the error responses without flooding the output
+
+	apiLimiter      *tokenBucket      // nil when there's no api_rate
+	uploadLimiter   *bandwidthLimiter // nil when there's no upload_limit
+	downloadLimiter *bandwidthLimiter // nil when there's no download_limit
+	idPool          idPool            // the ids for new files and folders, see nextId
+	pageSize        int               // page_size
+	errorBudget     errorBudget       // the failed requests, see checkErrorBudget
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// these structs match the data that is received from Google Drive API, the json decoder will fill in these structs
+type FileMetaData struct {
+	// NOTE!!** if updating this then be sure to update METADATA_FIELDS which is sent with the GET requests
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	MimeType     string   `json:"mimeType"`
+	ModifiedTime string   `json:"modifiedTime"` // "modifiedTime": "2022-01-22T18:32:04.223Z"
+	Md5Checksum  string   `json:"md5Checksum"`
+	Parents      []string `json:"parents"`
+	WebViewLink  string   `json:"webViewLink"` // the url for opening the file in a browser
+	Size         int64    `json:"size,string"` // folders and Google Docs don't have a size
+	Trashed      bool     `json:"trashed"`
+	TrashedTime  string   `json:"trashedTime"` // only set when trashed
+	Sha256       string   `json:"sha256Checksum"`
+
+	ShortcutDetails *ShortcutDetails `json:"shortcutDetails,omitempty"` // only set for a shortcut
+
+	Properties    map[string]string `json:"properties,omitempty"`    // the custom properties anyone with access can see
+	AppProperties map[string]string `json:"appProperties,omitempty"` // the custom properties of the app that set them
+
+	Owners []Owner `json:"owners,omitempty"` // empty on a Shared Drive
+	// NOTE!!** if updating this then be sure to update METADATA_FIELDS which is sent with the GET requests
+}
+
+type Owner struct {
+	EmailAddress string `json:"emailAddress"`
+}
+
+// the fields of FileMetaData that we request from the Drive API
+const METADATA_FIELDS = "id,name,mimeType,modifiedTime,md5Checksum,parents,webViewLink,size,trashed,trashedTime,sha256Checksum,shortcutDetails,properties,appProperties,owners(emailAddress)"
+
+type ListFilesResponse struct {
+	NextPageToken string         `json:"nextPageToken"`
+	Files         []FileMetaData `json:"files"`
+}
+
+type StartPageTokenResponse struct {
+	StartPageToken string `json:"startPageToken"`
+}
+
+type Change struct {
+	FileID  string       `json:"fileId"`
+	Removed bool         `json:"removed"`
+	File    FileMetaData `json:"file"`
+}
+
+type ListChangesResponse struct {
+	NextPageToken     string   `json:"nextPageToken"`
+	NewStartPageToken string   `json:"newStartPageToken"`
+	Changes           []Change `json:"changes"`
+}
+
+type SharedDrive struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type ListDrivesResponse struct {
+	NextPageToken string        `json:"nextPageToken"`
+	Drives        []SharedDrive `json:"drives"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type GenerateIdsResponse struct {
+	IDs []string `json:"ids"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type UploadRequest interface {
+	GetBytes() []byte
+	CreateFile() bool
+}
+
+//*********************************************************
+
+// satisfies the UploadRequest interface
+type UpdateFileRequest struct {
+	ModifiedTime string `json:"modifiedTime"`
+	Trashed      *bool  `json:"trashed,omitempty"` // set to false to take the file back out of the trash
+}
+
+func (req *UpdateFileRequest) GetBytes() []byte {
+	data, _ := json.Marshal(req)
+	return data
+}
+
+func (req *UpdateFileRequest) CreateFile() bool { return false }
+
+//*********************************************************
+
+// satisfies the UploadRequest interface
+type CreateFileRequest struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Parents      []string `json:"parents"`
+	ModifiedTime string   `json:"modifiedTime"`
+}
+
+func (req *CreateFileRequest) GetBytes() []byte {
+	data, _ := json.Marshal(req)
+	return data
+}
+
+func (req *CreateFileRequest) CreateFile() bool { return true }
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type CreateFolderRequest struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	MimeType     string   `json:"mimeType"`
+	Parents      []string `json:"parents"`
+	ModifiedTime string   `json:"modifiedTime"`
+}
+
+type CopyFileRequest struct {
+	Name         string   `json:"name"`
+	Parents      []string `json:"parents"`
+	ModifiedTime string   `json:"modifiedTime"`
+}
+
+// the parents can't be set in the body of an update, they are changed with the addParents and removeParents parameters
+type MoveFileRequest struct {
+	Name         string `json:"name"`
+	ModifiedTime string `json:"modifiedTime"`
+}
+
+type TrashFileRequest struct {
+	Trashed bool `json:"trashed"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the connection can be used from several goroutines at once, so the counter is updated atomically
+// every request takes the context of its caller, so an embedder can cancel it and two callers don't share one
+func (conn *Connection) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return conn.client.Do(req)
+}
+
+//*********************************************************
+
+func (conn *Connection) post(ctx context.Context, url string, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	return conn.client.Do(req)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) countApiCall() {
+	atomic.AddInt64(&conn.numApiCalls, 1)
+}
+
+func (conn *Connection) getNumApiCalls() int64 {
+	return atomic.LoadInt64(&conn.numApiCalls)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// adds the User-Agent header and quotaUser parameter to every request so that Workspace admins
+// can identify this client's traffic in their audit logs and API console dashboards
+type identifyingTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	quotaUser string
+}
+
+func (t *identifyingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// a RoundTripper should not modify the original request, so work on a copy
+	newReq := req.Clone(req.Context())
+	newReq.Header.Set("User-Agent", t.userAgent)
+	if len(t.quotaUser) > 0 && newReq.URL.Query().Get("quotaUser") == "" {
+		if len(newReq.URL.RawQuery) > 0 {
+			newReq.URL.RawQuery += "&"
+		}
+		newReq.URL.RawQuery += "quotaUser=" + url.QueryEscape(t.quotaUser)
+	}
+
+	return t.base.RoundTrip(newReq)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) initializeGoogleDrive(settings Settings) {
+	conn.apiLimiter = newTokenBucket(settings.ApiRatePerSecond)
+	conn.uploadLimiter = newBandwidthLimiter(settings.UploadLimit)
+	conn.downloadLimiter = newBandwidthLimiter(settings.DownloadLimit)
+	conn.pageSize = settings.PageSize
+
+	// a replay doesn't talk to Google Drive, so the credentials are not needed
+	if settings.ReplayTrace != "" {
+		transport, err := newReplayTransport(settings.ReplayTrace)
+		if err != nil {
+			log.Fatal("failed to read the trace file: ", err)
+		}
+		transport.log = conn.log
+		conn.log.Info("replaying", len(transport.interactions), "API calls from", settings.ReplayTrace)
+		conn.client = &http.Client{Transport: &retryingTransport{base: &throttleObservingTransport{base: transport, conn: conn}, maxRetries: settings.MaxRetries, log: conn.log}}
+		conn.api_key = "REDACTED"
+		return
+	}
+
+	if settings.Auth == AUTH_USER {
+		client, err := userClient(context.Background(), settings)
+		if err != nil {
+			log.Fatal("failed to sign in to Google Drive: ", err)
+		}
+		conn.client = client
+	} else {
+		// load the service account file
+		data, err := os.ReadFile(settings.CredentialsFile)
+		if err != nil {
+			log.Fatal("failed to read the service account key: ", err)
+		}
+
+		// parse the json for our service account
+		conf, err := google.JWTConfigFromJSON(data, drive.DriveScope)
+		if err != nil {
+			log.Fatal("failed to parse json file")
+		}
+		conf.Subject = settings.ImpersonateUser
+		conn.conf = conf
+		conn.client = conf.Client(context.Background())
+	}
+	conn.client.Transport = &identifyingTransport{base: conn.client.Transport, userAgent: settings.UserAgent, quotaUser: settings.QuotaUser}
+
+	if settings.RecordTrace != "" {
+		transport, err := newRecordingTransport(conn.client.Transport, settings.RecordTrace, settings.RecordTraceContents)
+		if err != nil {
+			log.Fatal("failed to open the trace file: ", err)
+		}
+		transport.log = conn.log
+		conn.log.Info("recording the API calls to", settings.RecordTrace)
+		conn.client.Transport = transport
+	}
+	conn.client.Transport = &rateLimitingTransport{base: conn.client.Transport, bucket: conn.apiLimiter}
+	conn.client.Transport = &throttleObservingTransport{base: conn.client.Transport, conn: conn}
+	conn.client.Transport = &retryingTransport{base: conn.client.Transport, maxRetries: settings.MaxRetries, log: conn.log}
+
+	// load the api key from a file
+	apiKeyBytes, err := os.ReadFile(settings.ApiKeyFile)
+	if err != nil {
+		log.Fatal("failed to read the API key: ", err)
+	}
+	conn.api_key = string(apiKeyBytes)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) GetItemsInSharedFolder(ctx context.Context, localFolderPath, folderId string) (ListFilesResponse, error) {
+	return conn.getItemsInFolders(ctx, localFolderPath, []string{folderId})
+}
+
+//*********************************************************
+
+// the maximum number of folder ids that are combined into one "in parents" query, this keeps the url a reasonable length
+const MAX_FOLDERS_PER_QUERY = 50
+
+// lists the items in all the given folders, sibling folders are combined into a single query
+// ('id1' in parents or 'id2' in parents ...) to reduce the number of API calls on wide trees
+func (conn *Connection) getItemsInFolders(ctx context.Context, localFolderPath string, folderIds []string) (ListFilesResponse, error) {
+	var allData ListFilesResponse
+
+	for start := 0; start < len(folderIds); start += MAX_FOLDERS_PER_QUERY {
+		end := start + MAX_FOLDERS_PER_QUERY
+		if end > len(folderIds) {
+			end = len(folderIds)
+		}
+		batch := folderIds[start:end]
+
+		data, err := conn.getPageInSharedFolder(ctx, localFolderPath, batch, "")
+		if err != nil {
+			return ListFilesResponse{}, err
+		}
+
+		for len(data.NextPageToken) > 0 {
+			newData, err := conn.getPageInSharedFolder(ctx, localFolderPath, batch, data.NextPageToken)
+			if err != nil {
+				return ListFilesResponse{}, err
+			}
+			data.Files = append(data.Files, newData.Files...)
+			data.NextPageToken = newData.NextPageToken
+		}
+
+		allData.Files = append(allData.Files, data.Files...)
+	}
+
+	return allData, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageInSharedFolder(ctx context.Context, localFolderPath string, folderIds []string, nextPageToken string) (ListFilesResponse, error) {
+	conn.countApiCall()
+
+	if logEnabled(LOG_DEBUG) {
+		if len(nextPageToken) == 0 {
+			conn.log.Debug("getting first page in shared folder", localFolderPath, "number of folders in query:", len(folderIds))
+		} else {
+			conn.log.Debug("getting next page for folder", localFolderPath)
+		}
+	}
+
+	query := ""
+	for i, folderId := range folderIds {
+		if i > 0 {
+			query += " or "
+		}
+		query += "'" + folderId + "' in parents"
+	}
+
+	parameters := "?fields=" + url.QueryEscape("nextPageToken,files("+METADATA_FIELDS+")")
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true&includeItemsFromAllDrives=true" // allows the base folders to be on Shared Drives
+	parameters += "&q=" + url.QueryEscape(query)
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListFilesResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListFilesResponse{}, responseError(response.StatusCode, bodyData, "unexpected response in getItemsInSharedFolder")
+	}
+
+	// decode the json data into our struct
+	var data ListFilesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) getMetadataById(ctx context.Context, name string, id string) (FileMetaData, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting metadata for", name, id)
+
+	parameters := "?fields=" + url.QueryEscape(METADATA_FIELDS)
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files/"+id+parameters)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode == 404 {
+		return FileMetaData{}, fmt.Errorf("failed to get metadata by ID %v: %w", id, ErrNotFound)
+	}
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return FileMetaData{}, responseError(response.StatusCode, bodyData, "failed to get metadata by ID")
+	}
+
+	var data FileMetaData
+	err = json.Unmarshal(bodyData, &data)
+	conn.log.Debug(data)
+
+	return data, err
+}
+
+//*********************************************************
+
+// the metadata of one item along with who changed it last, which the sync itself doesn't need
+type ItemDetails struct {
+	FileMetaData
+	LastModifyingUser struct {
+		DisplayName  string `json:"displayName"`
+		EmailAddress string `json:"emailAddress"`
+	} `json:"lastModifyingUser"`
+	Version string `json:"version"` // goes up with every change to the item
+}
+
+func (conn *Connection) getItemDetails(ctx context.Context, id string) (ItemDetails, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting the details of", id)
+
+	parameters := "?fields=" + url.QueryEscape(METADATA_FIELDS+",lastModifyingUser(displayName,emailAddress),version")
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files/"+id+parameters)
+	if err != nil {
+		return ItemDetails{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == 404 {
+		return ItemDetails{}, fmt.Errorf("failed to get the details of %v: %w", id, ErrNotFound)
+	}
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ItemDetails{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ItemDetails{}, responseError(response.StatusCode, bodyData, "failed to get the details by ID")
+	}
+
+	var data ItemDetails
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*********************************************************
+
+// the most requests Google Drive allows in one batch
+const MAX_BATCH_SIZE = 100
+
+// gets the metadata of many items at once with a batch request, the ids that were not found are left out
+func (conn *Connection) getMetadataByIds(ctx context.Context, ids []string) (map[string]FileMetaData, error) {
+	items := make(map[string]FileMetaData)
+
+	for start := 0; start < len(ids); start += MAX_BATCH_SIZE {
+		end := start + MAX_BATCH_SIZE
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		conn.countApiCall()
+		conn.log.Debug("getting metadata for", end-start, "items in one batch")
+
+		// each part is a whole GET request, the Content-ID ties the response to the id
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		for _, id := range ids[start:end] {
+			header := make(textproto.MIMEHeader)
+			header.Set("Content-Type", "application/http")
+			header.Set("Content-ID", "<"+id+">")
+			part, err := writer.CreatePart(header)
+			if err != nil {
+				return nil, err
+			}
+			parameters := "?fields=" + url.QueryEscape(METADATA_FIELDS)
+			parameters += "&supportsAllDrives=true"
+			fmt.Fprintf(part, "GET /drive/v3/files/%v%v HTTP/1.1\r\n\r\n", id, parameters)
+		}
+		writer.Close()
+
+		// each request in the batch counts against the quota, the transport takes the token for the batch itself
+		err := conn.apiLimiter.wait(ctx, end-start-1)
+		if err != nil {
+			return nil, err
+		}
+		parameters := "?key=" + conn.api_key
+		response, err := conn.post(ctx, "https://www.googleapis.com/batch/drive/v3"+parameters, "multipart/mixed; boundary="+writer.Boundary(), &body)
+		if err != nil {
+			return nil, err
+		}
+		conn.log.Debug("received StatusCode", response.StatusCode)
+
+		err = conn.readBatchResponse(response, items)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return items, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) readBatchResponse(response *http.Response, items map[string]FileMetaData) error {
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return responseError(response.StatusCode, bodyData, "failed to get metadata by IDs")
+	}
+
+	_, params, err := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	reader := multipart.NewReader(response.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<response-"), ">")
+		partResponse, err := http.ReadResponse(bufio.NewReader(part), nil)
+		if err != nil {
+			return err
+		}
+		bodyData, err := io.ReadAll(partResponse.Body)
+		partResponse.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		if partResponse.StatusCode == 404 {
+			continue
+		}
+		if partResponse.StatusCode >= 400 {
+			conn.printErrorBody(partResponse.StatusCode, bodyData)
+			return responseError(partResponse.StatusCode, bodyData, "failed to get metadata by ID "+id)
+		}
+		var data FileMetaData
+		err = json.Unmarshal(bodyData, &data)
+		if err != nil {
+			return err
+		}
+		items[data.ID] = data
+	}
+}
+
+//*********************************************************
+
+// the items in a folder with this name that are not in the trash
+func (conn *Connection) getItemsByName(ctx context.Context, parentId string, name string) ([]FileMetaData, error) {
+	conn.countApiCall()
+	conn.log.Debug("looking for", name, "in folder", parentId)
+
+	escapedName := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(name)
+	parameters := "?q=" + url.QueryEscape("'"+parentId+"' in parents and name = '"+escapedName+"' and trashed = false")
+	parameters += "&fields=" + url.QueryEscape("files("+METADATA_FIELDS+")")
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true&includeItemsFromAllDrives=true"
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+	if err != nil {
+		return []FileMetaData{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return []FileMetaData{}, responseError(response.StatusCode, bodyData, "unexpected response when looking for "+name)
+	}
+
+	// decode the json data into our struct
+	var data ListFilesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data.Files, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) generateIds(ctx context.Context, count int) ([]string, error) {
+	conn.countApiCall()
+	conn.log.Debug("generating ids with count:", count)
+
+	parameters := "?count=" + fmt.Sprintf("%v", count)
+	parameters += "&key=" + conn.api_key
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files/generateIds"+parameters)
+	if err != nil {
+		return []string{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return []string{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return []string{}, responseError(response.StatusCode, bodyData, "unexpected response in generateIds")
+	}
+
+	// decode the json data into our struct
+	var data GenerateIdsResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data.IDs, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) createRemoteFolder(ctx context.Context, folderRequest CreateFolderRequest) error {
+	conn.countApiCall()
+	conn.log.Debug("creating remote folder:", folderRequest)
+
+	data, _ := json.Marshal(folderRequest)
+	reader := bytes.NewReader(data)
+
+	parameters := "?key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	response, err := conn.post(ctx, "https://www.googleapis.com/drive/v3/files"+parameters, "application/json; charset=UTF-8", reader)
+	if err != nil {
+		return err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	conn.log.Debug(string(bodyData))
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return responseError(response.StatusCode, bodyData, "failed to create the remote folder")
+	}
+
+	return nil
+}
+
+//*********************************************************
+
+// makes a copy of a file on Google Drive without downloading it, returns the metadata of the copy
+func (conn *Connection) copyFile(ctx context.Context, id string, copyRequest CopyFileRequest) (FileMetaData, error) {
+	conn.countApiCall()
+	conn.log.Debug("copying remote file", id, "to", copyRequest.Name)
+
+	data, _ := json.Marshal(copyRequest)
+	reader := bytes.NewReader(data)
+
+	parameters := "?fields=" + url.QueryEscape(METADATA_FIELDS)
+	parameters += "&supportsAllDrives=true"
+	parameters += "&key=" + conn.api_key
+	response, err := conn.post(ctx, "https://www.googleapis.com/drive/v3/files/"+id+"/copy"+parameters, "application/json; charset=UTF-8", reader)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return FileMetaData{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return FileMetaData{}, responseError(response.StatusCode, bodyData, "failed to copy the remote file")
+	}
+
+	// decode the json data into our struct
+	var copied FileMetaData
+	err = json.NewDecoder(response.Body).Decode(&copied)
+	return copied, err
+}
+
+//*********************************************************
+
+// renames a file and/or moves it to another folder, only the metadata changes so nothing is uploaded
+func (conn *Connection) moveFile(ctx context.Context, id string, oldParentId string, newParentId string, moveRequest MoveFileRequest) (FileMetaData, error) {
+	conn.countApiCall()
+	conn.log.Debug("moving remote file", id, "to", moveRequest.Name, "in", newParentId)
+
+	data, _ := json.Marshal(moveRequest)
+	reader := bytes.NewReader(data)
+
+	parameters := "?fields=" + url.QueryEscape(METADATA_FIELDS)
+	if oldParentId != newParentId {
+		parameters += "&addParents=" + newParentId
+		parameters += "&removeParents=" + oldParentId
+	}
+	parameters += "&supportsAllDrives=true"
+	parameters += "&key=" + conn.api_key
+	req, err := http.NewRequestWithContext(ctx, "PATCH", "https://www.googleapis.com/drive/v3/files/"+id+parameters, reader)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return FileMetaData{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return FileMetaData{}, responseError(response.StatusCode, bodyData, "failed to move the remote file")
+	}
+
+	// decode the json data into our struct
+	var moved FileMetaData
+	err = json.NewDecoder(response.Body).Decode(&moved)
+	return moved, err
+}
+
+//*********************************************************
+
+// moves a file or folder to the trash on Google Drive, unlike deleteFileOrFolder it can be restored from there
+func (conn *Connection) trashFile(ctx context.Context, id string) error {
+	return conn.setTrashed(ctx, id, true)
+}
+
+//*********************************************************
+
+// moves the item to the trash or takes it back out
+func (conn *Connection) setTrashed(ctx context.Context, id string, trashed bool) error {
+	conn.countApiCall()
+	conn.log.Debug("setting trashed to", trashed, "for remote item", id)
+
+	data, _ := json.Marshal(TrashFileRequest{Trashed: trashed})
+	reader := bytes.NewReader(data)
+
+	parameters := "?supportsAllDrives=true"
+	parameters += "&key=" + conn.api_key
+	req, err := http.NewRequestWithContext(ctx, "PATCH", "https://www.googleapis.com/drive/v3/files/"+id+parameters, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return responseError(response.StatusCode, bodyData, fmt.Sprintf("failed to set trashed to %v for the remote item", trashed))
+	}
+
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the contents are read from fh as they are sent instead of being held in memory, returns the metadata from the
+// server along with the md5 of the bytes that were sent
+func (conn *Connection) uploadFile(ctx context.Context, id string, uploadRequest UploadRequest, fh File, fileSize int64) (FileMetaData, string, error) {
+	conn.countApiCall()
+	create := uploadRequest.CreateFile()
+
+	if logEnabled(LOG_DEBUG) {
+		if create {
+			conn.log.Debug("Creating remote file:", uploadRequest)
+		} else {
+			conn.log.Debug("Updating remote file:", uploadRequest)
+		}
+	}
+
+	// build the url
+	parameters := "?uploadType=multipart"
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	parameters += "&fields=" + url.QueryEscape(METADATA_FIELDS)
+	url := "https://www.googleapis.com/upload/drive/v3/files"
+	if !create {
+		url += "/" + id
+	}
+	url += parameters
+
+	// the multipart writer picks a random boundary so binary file data can't be mistaken for it
+	prefix, suffix, contentType, err := buildMultipartFrame(uploadRequest.GetBytes())
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	contentLength := int64(len(prefix)) + fileSize + int64(len(suffix))
+
+	// the body is put together again from the start of the file each time the request is sent, and the md5 is
+	// taken of what goes out, so it matches the contents even if the file is changed during the upload. Each body
+	// reads the file at its own offset and has its own md5 and count, so a copy that is made but not sent, or one
+	// still being read when the next is made, doesn't change another, the attempt that sent the whole file last
+	// is the one that counts.
+	type uploadAttempt struct {
+		hash hash.Hash
+		sent int64
+	}
+	var attemptMutex sync.Mutex
+	var lastSent *uploadAttempt
+	newBody := func() (io.ReadCloser, error) {
+		// seeking back to the start starts the progress over
+		_, err := fh.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+		attempt := &uploadAttempt{hash: md5.New()}
+		contents := &countingReader{reader: io.TeeReader(io.NewSectionReader(fh, 0, fileSize), attempt.hash), count: &attempt.sent}
+		sentAll := onEOFReader(func() {
+			attemptMutex.Lock()
+			lastSent = attempt
+			attemptMutex.Unlock()
+		})
+		reader := io.MultiReader(bytes.NewReader(prefix), contents, sentAll, bytes.NewReader(suffix))
+		return io.NopCloser(conn.uploadLimiter.reader(ctx, reader)), nil
+	}
+	body, err := newBody()
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+
+	// create a new request, then call the Do function
+	verb := "POST"
+	if !create {
+		verb = "PATCH"
+	}
+	req, err := http.NewRequestWithContext(ctx, verb, url, body)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	req.GetBody = newBody
+	req.ContentLength = contentLength // not known to the request when the body is streamed
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Content-Length", fmt.Sprintf("%v", contentLength))
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	conn.log.Debug(string(bodyData))
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return FileMetaData{}, "", responseError(response.StatusCode, bodyData, "failed to upload the file")
+	}
+	attemptMutex.Lock()
+	attempt := lastSent
+	attemptMutex.Unlock()
+	if attempt == nil || attempt.sent != fileSize {
+		var sent int64
+		if attempt != nil {
+			sent = attempt.sent
+		}
+		return FileMetaData{}, "", fmt.Errorf("the file changed size during the upload, sent %v of %v bytes", sent, fileSize)
+	}
+
+	// the response has the metadata of the uploaded file, including the md5 that the server calculated
+	var data FileMetaData
+	json.Unmarshal(bodyData, &data)
+	return data, fmt.Sprintf("%x", attempt.hash.Sum(nil)), nil
+}
+
+//*********************************************************
+
+// builds the parts of a multipart/related body around the file contents, the json metadata and the header of
+// the file part go before the contents and the closing boundary after them
+func buildMultipartFrame(jsonData []byte) ([]byte, []byte, string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	metadataHeader := textproto.MIMEHeader{}
+	metadataHeader.Set("Content-Type", "application/json; charset=UTF-8")
+	part, err := writer.CreatePart(metadataHeader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	_, err = part.Write(jsonData)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	fileHeader := textproto.MIMEHeader{}
+	fileHeader.Set("Content-Type", "application/octet-stream")
+	_, err = writer.CreatePart(fileHeader)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	prefix := append([]byte{}, body.Bytes()...)
+	body.Reset()
+
+	err = writer.Close()
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return prefix, body.Bytes(), "multipart/related; boundary=" + writer.Boundary(), nil
+}
+
+//*********************************************************
+
+// counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	*r.count += int64(n)
+	return n, err
+}
+
+// calls the function when it's reached, the end of what comes before it in a MultiReader
+type onEOFReader func()
+
+func (f onEOFReader) Read(p []byte) (int, error) {
+	f()
+	return 0, io.EOF
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) uploadLargeFile(ctx context.Context, id string, uploadRequest UploadRequest, fh File, fileSize int64) (FileMetaData, error) {
+	conn.countApiCall()
+	create := uploadRequest.CreateFile()
+
+	if logEnabled(LOG_DEBUG) {
+		if create {
+			conn.log.Debug("Creating large remote file:", uploadRequest)
+		} else {
+			conn.log.Debug("Updating large remote file:", uploadRequest)
+		}
+	}
+
+	// Step 1: get a session URI where we can upload the data to
+
+	// build the url
+	parameters := "?uploadType=resumable"
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	parameters += "&fields=" + url.QueryEscape(METADATA_FIELDS)
+	url := "https://www.googleapis.com/upload/drive/v3/files"
+	if !create {
+		url += "/" + id
+	}
+	url += parameters
+
+	// create a new request, then call the Do function
+	json_data := uploadRequest.GetBytes()
+	reader := bytes.NewReader(json_data)
+	verb := "POST"
+	if !create {
+		verb = "PATCH"
+	}
+	req, err := http.NewRequestWithContext(ctx, verb, url, reader)
+	req.Header.Add("Content-Type", "application/json; charset=UTF-8")
+	req.Header.Add("Content-Length", fmt.Sprintf("%v", len(json_data)))
+	if err != nil {
+		return FileMetaData{}, err
+	}
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	locationHeader, inHeader := response.Header["Location"]
+	if !inHeader || len(locationHeader) == 0 {
+		err := errors.New("header Location not available for createLargeRemoteFile")
+		return FileMetaData{}, err
+	}
+	conn.log.Debug("received locationHeader:", locationHeader)
+
+	bodyData, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return FileMetaData{}, err
+	}
+	conn.log.Debug(string(bodyData))
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return FileMetaData{}, responseError(response.StatusCode, bodyData, "failed to start the large file upload")
+	}
+
+	//*************************************************************************
+
+	// Step 2: upload data to the session URI
+
+	bytesUploaded := int64(0)
+	for try := 1; try <= 5; try++ {
+		conn.countApiCall()
+		parameters = ""
+		if strings.Contains(locationHeader[0], "&key=") {
+			conn.log.Debug("session URI already has the API key")
+		} else {
+			conn.log.Debug("session URI did not have the API key, adding it")
+			parameters += "&key=" + conn.api_key
+		}
+		url = locationHeader[0] + parameters
+		verb := "PUT"
+		if !create {
+			verb = "PATCH"
+		}
+		fh.Seek(bytesUploaded, 0)
+		req, err = http.NewRequestWithContext(ctx, verb, url, conn.uploadLimiter.reader(ctx, fh))
+		if err != nil {
+			conn.log.Warn(err)
+			continue // do a retry
+		}
+		req.ContentLength = fileSize - bytesUploaded
+		req.Header.Add("Content-Length", fmt.Sprintf("%v", fileSize-bytesUploaded))
+		if bytesUploaded > 0 {
+			req.Header.Add("Content-Range", fmt.Sprintf("bytes %v-%v/%v", bytesUploaded, fileSize-1, fileSize))
+		}
+
+		response, err = conn.client.Do(req)
+		if err != nil {
+			conn.log.Warn(err)
+			time.Sleep(time.Minute)
+			bytesUploaded, err := conn.getBytesUploaded(ctx, url, fileSize)
+			if err != nil {
+				return FileMetaData{}, err
+			}
+			if bytesUploaded < fileSize {
+				conn.log.Debug("trying again after", bytesUploaded, "bytes were uploaded")
+				continue // do a retry
+			}
+
+			// everything made it to the server but we never got the response, so the md5 is unknown
+			return FileMetaData{}, nil
+		}
+
+		conn.log.Debug("received StatusCode", response.StatusCode)
+		if response.StatusCode >= 400 {
+			err = errors.New("error uploading large file")
+			conn.log.Warn(err)
+			time.Sleep(time.Minute)
+			bytesUploaded, err := conn.getBytesUploaded(ctx, url, fileSize)
+			if err != nil {
+				return FileMetaData{}, err
+			}
+			if bytesUploaded < fileSize {
+				conn.log.Debug("trying again after", bytesUploaded, "bytes were uploaded")
+				continue // do a retry
+			}
+		}
+
+		bodyData, err = io.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			conn.log.Warn(err)
+			time.Sleep(time.Minute)
+			bytesUploaded, err := conn.getBytesUploaded(ctx, url, fileSize)
+			if err != nil {
+				return FileMetaData{}, err
+			}
+			if bytesUploaded < fileSize {
+				conn.log.Debug("trying again after", bytesUploaded, "bytes were uploaded")
+				continue // do a retry
+			}
+		}
+		conn.log.Debug(string(bodyData))
+
+		// if we got this far then it was successful, the response has the metadata of the uploaded file
+		var data FileMetaData
+		json.Unmarshal(bodyData, &data)
+		return data, nil
+	}
+
+	return FileMetaData{}, errors.New("ran out of retries in createLargeRemoteFile")
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) getBytesUploaded(ctx context.Context, url string, fileSize int64) (int64, error) {
+	conn.countApiCall()
+	conn.log.Debug("requesting the number of bytes uploaded")
+
+	req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
+	req.Header.Add("Content-Range", fmt.Sprintf("*/%v", fileSize))
+	if err != nil {
+		conn.log.Warn(err)
+		return 0, err
+	}
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return 0, err
+	}
+	conn.log.Debug(string(bodyData))
+
+	switch response.StatusCode {
+	case 200, 201:
+		return fileSize, nil
+	case 308:
+		rangeHeader, inHeaders := response.Header["Range"]
+		if !inHeaders || len(rangeHeader) == 0 {
+			return 0, nil
+		}
+		rangeSplit := strings.Split(rangeHeader[0], "-")
+		if len(rangeSplit) > 1 {
+			bytesUploaded, err := strconv.ParseInt(rangeSplit[1], 10, 0)
+			if err == nil {
+				return bytesUploaded + 1, nil
+			}
+		}
+	default:
+		return 0, errors.New("unknown number of bytes uploaded")
+	}
+
+	return 0, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a download is written next to the file with this added to the name and renamed into place once it's complete,
+// so a crash in the middle never leaves a truncated file behind that would be uploaded by the next loop
+const DOWNLOAD_TEMP_SUFFIX = ".gdrivetmp"
+
+//*********************************************************
+
+// returns the md5 of what was downloaded, which is also returned with an md5 mismatch. A Google Doc, Sheet or
+// Slides is exported as exportMimeType instead, it has no md5 or size to check
+// the progress can be nil when the download isn't reported
+func (conn *Connection) downloadFile(ctx context.Context, fileSystem FS, id string, localFileName string, expectedMd5 string, expectedSize int64, exportMimeType string,
+	progress *fileProgress) (string, error) {
+	conn.countApiCall()
+	conn.log.Debug("downloading", localFileName, id)
+
+	address := "https://www.googleapis.com/drive/v3/files/" + id
+	parameters := "?alt=media"
+	if exportMimeType != "" {
+		// the export is limited to 10 MB by Google Drive
+		address += "/export"
+		parameters = "?mimeType=" + url.QueryEscape(exportMimeType)
+	}
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	req, err := http.NewRequestWithContext(ctx, "GET", address+parameters, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// ask for the exact stored bytes, setting this header also stops the http client from transparently
+	// decompressing a gzip Content-Encoding which would change the bytes we write to disk
+	req.Header.Set("Accept-Encoding", "identity")
+
+	// a large file is written to a partial file first, and what's already in it from an earlier try isn't
+	// downloaded again
+	hash := md5.New()
+	writeName := localFileName + DOWNLOAD_TEMP_SUFFIX
+	resumable := resumableDownload(fileSystem, expectedMd5, expectedSize, exportMimeType)
+	var offset int64
+	var overlap []byte
+	if resumable {
+		writeName = localFileName + PARTIAL_DOWNLOAD_SUFFIX
+		offset, overlap = readPartialDownload(fileSystem, writeName, expectedSize, hash)
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		}
+	}
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode, "Content-Type", response.Header.Get("Content-Type"))
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return "", err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		if response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			fileSystem.Remove(writeName) // the file got smaller, the next try starts over
+		}
+		return "", responseError(response.StatusCode, bodyData, "failed to download")
+	}
+
+	var fh io.WriteCloser
+	if offset > 0 && response.StatusCode == http.StatusPartialContent {
+		matches, err := overlapMatches(response.Body, overlap)
+		if err != nil {
+			return "", err
+		}
+		if !matches {
+			fileSystem.Remove(writeName)
+			return "", fmt.Errorf("the partial download of %v is from another version of the file, starting over", localFileName)
+		}
+		conn.log.Debug("resuming the download of", localFileName, "after", offset+int64(len(overlap)), "bytes")
+		hash.Write(overlap)
+		progress.set(offset + int64(len(overlap)))
+		fh, err = fileSystem.(appendFS).Append(writeName)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		// the whole file is coming, whatever was in the partial file is replaced
+		hash.Reset()
+		fh, err = fileSystem.Create(writeName)
+		if err != nil {
+			return "", err
+		}
+
+		// reserve the space for a large file up front, so it isn't fragmented and a full disk fails right away
+		// instead of after most of the file was downloaded
+		if file, isOsFile := fh.(*os.File); isOsFile && expectedSize > LARGE_FILE_THRESHOLD_BYTES {
+			err = preallocate(file, expectedSize)
+			if err != nil {
+				fh.Close()
+				fileSystem.Remove(writeName)
+				return "", err
+			}
+		}
+	}
+
+	// calculate the md5 while writing the file so we don't have to read it back again
+	n, err := io.Copy(conn.downloadLimiter.writer(ctx, progress.writer(io.MultiWriter(fh, hash))), response.Body)
+	conn.log.Debugf("Wrote %v bytes to file\n", n)
+	if err != nil {
+		// a partial file is kept so the next try can pick up from there
+		fh.Close()
+		if !resumable {
+			fileSystem.Remove(writeName)
+		}
+
+		return "", err
+	}
+
+	fh.Close()
+
+	// if the bytes were changed along the way then the local file is left as it was
+	localMd5 := fmt.Sprintf("%x", hash.Sum(nil))
+	if len(expectedMd5) > 0 && localMd5 != expectedMd5 {
+		fileSystem.Remove(writeName)
+		return localMd5, fmt.Errorf("md5 mismatch after downloading %v, expected %v but got %v", localFileName, expectedMd5, localMd5)
+	}
+
+	err = fileSystem.Rename(writeName, localFileName)
+	if err != nil {
+		fileSystem.Remove(writeName)
+		return "", err
+	}
+	return localMd5, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) getModifiedItems(ctx context.Context, timestamp string) ([]FileMetaData, error) {
+	data, err := conn.getPageOfModifiedItems(ctx, timestamp, "")
+	if err != nil {
+		return []FileMetaData{}, err
+	}
+
+	for len(data.NextPageToken) > 0 {
+		newData, err := conn.getPageOfModifiedItems(ctx, timestamp, data.NextPageToken)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+		data.Files = append(data.Files, newData.Files...)
+		data.NextPageToken = newData.NextPageToken
+	}
+
+	return data.Files, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfModifiedItems(ctx context.Context, timestamp, nextPageToken string) (ListFilesResponse, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting page of modified items for timestamp >", timestamp)
+
+	parameters := "?q=" + url.QueryEscape("modifiedTime > '"+timestamp+"'")
+	parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&fields=" + url.QueryEscape("nextPageToken,files("+METADATA_FIELDS+")")
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true&includeItemsFromAllDrives=true" // allows the base folders to be on Shared Drives
+
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListFilesResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListFilesResponse{}, responseError(response.StatusCode, bodyData, "unexpected response when getting modified items")
+	}
+
+	// decode the json data into our struct
+	var data ListFilesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+
+	return data, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the files whose contents match the text, from everything the account can see, up to maxResults of them
+func (conn *Connection) searchFullText(ctx context.Context, text string, maxResults int) ([]FileMetaData, error) {
+	var files []FileMetaData
+	nextPageToken := ""
+	for {
+		conn.countApiCall()
+		parameters := "?q=" + url.QueryEscape(fullTextQuery(text))
+		parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+		if len(nextPageToken) > 0 {
+			parameters += "&pageToken=" + nextPageToken
+		}
+		parameters += "&fields=" + url.QueryEscape("nextPageToken,files("+METADATA_FIELDS+")")
+		parameters += "&key=" + conn.api_key
+		parameters += "&supportsAllDrives=true&includeItemsFromAllDrives=true"
+
+		response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode >= 400 {
+			bodyData, err := readErrorBody(response.Body)
+			response.Body.Close()
+			if err != nil {
+				return nil, err
+			}
+			conn.printErrorBody(response.StatusCode, bodyData)
+			return nil, responseError(response.StatusCode, bodyData, "failed to search")
+		}
+
+		var data ListFilesResponse
+		err = json.NewDecoder(response.Body).Decode(&data)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, data.Files...)
+		if len(files) >= maxResults {
+			return files[:maxResults], nil
+		}
+		if data.NextPageToken == "" {
+			return files, nil
+		}
+		nextPageToken = data.NextPageToken
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// gets the page token for the current position in the list of changes, any changes after this will be returned by getChanges
+func (conn *Connection) getStartPageToken(ctx context.Context) (string, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting the start page token for changes")
+
+	parameters := "?key=" + conn.api_key
+	parameters += "&supportsAllDrives=true"
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/changes/startPageToken"+parameters)
+	if err != nil {
+		return "", err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return "", err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return "", responseError(response.StatusCode, bodyData, "unexpected response when getting the start page token")
+	}
+
+	// decode the json data into our struct
+	var data StartPageTokenResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data.StartPageToken, err
+}
+
+//*********************************************************
+
+// returns the files that changed since the page token, the ids of the ones that were removed from the user's view,
+// and the page token to use next time
+func (conn *Connection) getChanges(ctx context.Context, pageToken string) ([]FileMetaData, []string, string, error) {
+	var files []FileMetaData
+	var removedIds []string
+
+	for {
+		data, err := conn.getPageOfChanges(ctx, pageToken)
+		if err != nil {
+			return []FileMetaData{}, nil, "", err
+		}
+
+		for _, change := range data.Changes {
+			// removed files and changes to the drives themselves don't have any file metadata
+			if change.Removed && change.FileID != "" {
+				removedIds = append(removedIds, change.FileID)
+			} else if change.File.ID != "" {
+				files = append(files, change.File)
+			}
+		}
+
+		if len(data.NextPageToken) == 0 {
+			return files, removedIds, data.NewStartPageToken, nil
+		}
+		pageToken = data.NextPageToken
+	}
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfChanges(ctx context.Context, pageToken string) (ListChangesResponse, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting page of changes for page token", pageToken)
+
+	parameters := "?pageToken=" + url.QueryEscape(pageToken)
+	parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+	parameters += "&fields=" + url.QueryEscape("nextPageToken,newStartPageToken,changes(fileId,removed,file("+METADATA_FIELDS+"))")
+	parameters += "&key=" + conn.api_key
+	parameters += "&supportsAllDrives=true&includeItemsFromAllDrives=true" // allows the base folders to be on Shared Drives
+
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/changes"+parameters)
+	if err != nil {
+		return ListChangesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListChangesResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListChangesResponse{}, responseError(response.StatusCode, bodyData, "unexpected response when getting changes")
+	}
+
+	// decode the json data into our struct
+	var data ListChangesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) GetFilesOwnedByServiceAcct(ctx context.Context, verbose bool) ([]FileMetaData, error) {
+	data, err := conn.getPageOfFilesOwnedByServiceAcct(ctx, verbose, "")
+	if err != nil {
+		return []FileMetaData{}, err
+	}
+
+	for len(data.NextPageToken) > 0 {
+		newData, err := conn.getPageOfFilesOwnedByServiceAcct(ctx, verbose, data.NextPageToken)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+		data.Files = append(data.Files, newData.Files...)
+		data.NextPageToken = newData.NextPageToken
+	}
+
+	return data.Files, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfFilesOwnedByServiceAcct(ctx context.Context, verbose bool, nextPageToken string) (ListFilesResponse, error) {
+	conn.countApiCall()
+
+	if logEnabled(LOG_DEBUG) {
+		if len(nextPageToken) == 0 {
+			conn.log.Debug("getting first page of files owned by service acct")
+		} else {
+			conn.log.Debug("getting another page of files owned by service acct")
+		}
+	}
+
+	parameters := "?fields=" + url.QueryEscape("nextPageToken,files("+METADATA_FIELDS+")")
+	parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&key=" + conn.api_key
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// read the data
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListFilesResponse{}, responseError(response.StatusCode, bodyData, "received unexpected response when getting page of files owned by service acct")
+	}
+
+	if verbose {
+		fmt.Println(string(bodyData))
+	}
+
+	// decode the json data into our struct
+	var data ListFilesResponse
+	err = json.Unmarshal(bodyData, &data)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+
+	conn.log.Debug(data.Files)
+	return data, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) deleteFileOrFolder(ctx context.Context, item FileMetaData) error {
+	conn.countApiCall()
+	conn.log.Debug("deleting", item.Name, item.ID)
+
+	url := "https://www.googleapis.com/drive/v3/files/" + item.ID + "?supportsAllDrives=true"
+	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := conn.client.Do(req)
+	if err != nil {
+		return err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+	bodyData, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	conn.log.Debug(string(bodyData))
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return responseError(response.StatusCode, bodyData, "failed to delete")
+	}
+
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) GetSharedDrives(ctx context.Context) ([]SharedDrive, error) {
+	data, err := conn.getPageOfSharedDrives(ctx, "")
+	if err != nil {
+		return []SharedDrive{}, err
+	}
+
+	for len(data.NextPageToken) > 0 {
+		newData, err := conn.getPageOfSharedDrives(ctx, data.NextPageToken)
+		if err != nil {
+			return []SharedDrive{}, err
+		}
+		data.Drives = append(data.Drives, newData.Drives...)
+		data.NextPageToken = newData.NextPageToken
+	}
+
+	return data.Drives, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfSharedDrives(ctx context.Context, nextPageToken string) (ListDrivesResponse, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting page of shared drives")
+
+	parameters := "?fields=" + url.QueryEscape("nextPageToken,drives(id,name)")
+	parameters += "&pageSize=100"
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&key=" + conn.api_key
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/drives"+parameters)
+	if err != nil {
+		return ListDrivesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListDrivesResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListDrivesResponse{}, responseError(response.StatusCode, bodyData, "unexpected response when getting shared drives")
+	}
+
+	// decode the json data into our struct
+	var data ListDrivesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// gets the folders that were shared directly with the credential, these are the candidates for base folders
+func (conn *Connection) GetSharedFolders(ctx context.Context) ([]FileMetaData, error) {
+	data, err := conn.getPageOfSharedFolders(ctx, "")
+	if err != nil {
+		return []FileMetaData{}, err
+	}
+
+	for len(data.NextPageToken) > 0 {
+		newData, err := conn.getPageOfSharedFolders(ctx, data.NextPageToken)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+		data.Files = append(data.Files, newData.Files...)
+		data.NextPageToken = newData.NextPageToken
+	}
+
+	return data.Files, nil
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfSharedFolders(ctx context.Context, nextPageToken string) (ListFilesResponse, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting page of shared folders")
+
+	parameters := "?q=" + url.QueryEscape("mimeType = 'application/vnd.google-apps.folder' and sharedWithMe = true and trashed = false")
+	parameters += "&pageSize=" + strconv.Itoa(conn.pageSize)
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&fields=" + url.QueryEscape("nextPageToken,files("+METADATA_FIELDS+")")
+	parameters += "&key=" + conn.api_key
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files"+parameters)
+	if err != nil {
+		return ListFilesResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListFilesResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListFilesResponse{}, responseError(response.StatusCode, bodyData, "unexpected response when getting shared folders")
+	}
+
+	// decode the json data into our struct
+	var data ListFilesResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a sharing permission on a file or folder
+type Permission struct {
+	ID                 string `json:"id,omitempty"`
+	Type               string `json:"type"` // user, group, domain or anyone
+	Role               string `json:"role"` // owner, organizer, fileOrganizer, writer, commenter or reader
+	EmailAddress       string `json:"emailAddress,omitempty"`
+	Domain             string `json:"domain,omitempty"`
+	AllowFileDiscovery bool   `json:"allowFileDiscovery,omitempty"`
+
+	// on a shared drive this says if the permission comes from a parent folder
+	PermissionDetails []PermissionDetail `json:"permissionDetails,omitempty"`
+}
+
+type PermissionDetail struct {
+	Inherited bool `json:"inherited"`
+}
+
+type ListPermissionsResponse struct {
+	NextPageToken string       `json:"nextPageToken"`
+	Permissions   []Permission `json:"permissions"`
+}
+
+const PERMISSION_FIELDS = "id,type,role,emailAddress,domain,allowFileDiscovery,permissionDetails(inherited)"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (conn *Connection) listPermissions(ctx context.Context, id string) ([]Permission, error) {
+	var permissions []Permission
+	nextPageToken := ""
+
+	for {
+		data, err := conn.getPageOfPermissions(ctx, id, nextPageToken)
+		if err != nil {
+			return []Permission{}, err
+		}
+		permissions = append(permissions, data.Permissions...)
+
+		nextPageToken = data.NextPageToken
+		if len(nextPageToken) == 0 {
+			return permissions, nil
+		}
+	}
+}
+
+//*********************************************************
+
+func (conn *Connection) getPageOfPermissions(ctx context.Context, id string, nextPageToken string) (ListPermissionsResponse, error) {
+	conn.countApiCall()
+	conn.log.Debug("getting page of permissions for", id)
+
+	parameters := "?pageSize=100"
+	if len(nextPageToken) > 0 {
+		parameters += "&pageToken=" + nextPageToken
+	}
+	parameters += "&fields=" + url.QueryEscape("nextPageToken,permissions("+PERMISSION_FIELDS+")")
+	parameters += "&supportsAllDrives=true"
+	parameters += "&key=" + conn.api_key
+	response, err := conn.get(ctx, "https://www.googleapis.com/drive/v3/files/"+id+"/permissions"+parameters)
+	if err != nil {
+		return ListPermissionsResponse{}, err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	// if we didn't get what we were expecting, print out the response
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return ListPermissionsResponse{}, err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return ListPermissionsResponse{}, responseError(response.StatusCode, bodyData, "unexpected response when getting permissions")
+	}
+
+	// decode the json data into our struct
+	var data ListPermissionsResponse
+	err = json.NewDecoder(response.Body).Decode(&data)
+	return data, err
+}
+
+//*********************************************************
+
+func (conn *Connection) createPermission(ctx context.Context, id string, permission Permission) error {
+	conn.countApiCall()
+	conn.log.Debug("creating permission on", id, permission.Type, permission.Role, permission.EmailAddress, permission.Domain)
+
+	// only the fields that can be set, the id and the details are filled in by the server
+	request := Permission{
+		Type:               permission.Type,
+		Role:               permission.Role,
+		EmailAddress:       permission.EmailAddress,
+		Domain:             permission.Domain,
+		AllowFileDiscovery: permission.AllowFileDiscovery,
+	}
+	data, _ := json.Marshal(request)
+
+	// the people already had access to the old copy, so don't email them about the new one
+	parameters := "?sendNotificationEmail=false"
+	parameters += "&supportsAllDrives=true"
+	parameters += "&key=" + conn.api_key
+	response, err := conn.post(ctx, "https://www.googleapis.com/drive/v3/files/"+id+"/permissions"+parameters, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	conn.log.Debug("received StatusCode", response.StatusCode)
+
+	defer response.Body.Close()
+
+	if response.StatusCode >= 400 {
+		bodyData, err := readErrorBody(response.Body)
+		if err != nil {
+			return err
+		}
+		conn.printErrorBody(response.StatusCode, bodyData)
+		return responseError(response.StatusCode, bodyData, "unexpected response when creating a permission")
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package drivesync
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the contents of a file are sent and received as they are, these are the bytes most likely to get changed
+var binaryPayloads = []struct {
+	name     string
+	contents []byte
+}{
+	{"empty", []byte{}},
+	{"text", []byte("hello world")},
+	{"crlf", []byte("line one\r\nline two\r\n\r\n")},
+	{"lone cr and lf", []byte("\r\n\n\r\r\n")},
+	{"boundary-like", []byte("\r\n--boundary\r\nContent-Type: application/json\r\n\r\n{}\r\n--boundary--\r\n")},
+	{"dashes", []byte("----------------------------------------")},
+	{"nul and high bytes", []byte{0x00, 0xff, 0xfe, 0x0d, 0x0a, 0x2d, 0x2d, 0x00, 0x80, 0x7f}},
+	{"all bytes", allBytes()},
+}
+
+func allBytes() []byte {
+	contents := make([]byte, 0, 256*4)
+	for i := 0; i < 4; i++ {
+		for b := 0; b < 256; b++ {
+			contents = append(contents, byte(b))
+		}
+	}
+	return contents
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestMultipartFrameKeepsContents(t *testing.T) {
+	jsonData := []byte(`{"name":"file.bin","parents":["abc"]}`)
+	for _, test := range binaryPayloads {
+		t.Run(test.name, func(t *testing.T) {
+			prefix, suffix, contentType, err := buildMultipartFrame(jsonData)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the frame around a boundary from the contents has to end up with a boundary that isn't in them
+			mediaType, params, err := mime.ParseMediaType(contentType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mediaType != "multipart/related" {
+				t.Fatalf("got media type %v", mediaType)
+			}
+			body := append(append(append([]byte{}, prefix...), test.contents...), suffix...)
+			reader := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+
+			metadata := readPart(t, reader, "application/json; charset=UTF-8")
+			if !bytes.Equal(metadata, jsonData) {
+				t.Errorf("metadata part is %q, expected %q", metadata, jsonData)
+			}
+			contents := readPart(t, reader, "application/octet-stream")
+			if !bytes.Equal(contents, test.contents) {
+				t.Errorf("contents part is %q, expected %q", contents, test.contents)
+			}
+			if _, err := reader.NextPart(); err != io.EOF {
+				t.Errorf("expected only 2 parts, got %v", err)
+			}
+		})
+	}
+}
+
+func readPart(t *testing.T, reader *multipart.Reader, contentType string) []byte {
+	t.Helper()
+	part, err := reader.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := part.Header.Get("Content-Type"); got != contentType {
+		t.Errorf("part has Content-Type %v, expected %v", got, contentType)
+	}
+	data, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// a connection that answers every request with the handler instead of going to Google Drive
+func testConnection(handler roundTripFunc) *Connection {
+	return &Connection{client: &http.Client{Transport: handler}, log: connLog}
+}
+
+//*********************************************************
+
+func TestDownloadFileKeepsContents(t *testing.T) {
+	for _, test := range binaryPayloads {
+		t.Run(test.name, func(t *testing.T) {
+			conn := testConnection(func(req *http.Request) (*http.Response, error) {
+				if req.Header.Get("Accept-Encoding") != "identity" {
+					t.Errorf("the download asked for Accept-Encoding %q", req.Header.Get("Accept-Encoding"))
+				}
+				return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/octet-stream"}},
+					Body: io.NopCloser(bytes.NewReader(test.contents)), Request: req}, nil
+			})
+
+			localPath := filepath.Join(t.TempDir(), "file.bin")
+			expectedMd5 := fmt.Sprintf("%x", md5.Sum(test.contents))
+			gotMd5, err := conn.downloadFile(context.Background(), osFS{}, "id", localPath, expectedMd5, int64(len(test.contents)), "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotMd5 != expectedMd5 {
+				t.Errorf("got md5 %v, expected %v", gotMd5, expectedMd5)
+			}
+			contents, err := os.ReadFile(localPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(contents, test.contents) {
+				t.Errorf("downloaded %q, expected %q", contents, test.contents)
+			}
+		})
+	}
+}
+
+//*********************************************************
+
+func TestDownloadFileMd5Mismatch(t *testing.T) {
+	conn := testConnection(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{},
+			Body: io.NopCloser(strings.NewReader("changed on the way\r\n")), Request: req}, nil
+	})
+
+	localPath := filepath.Join(t.TempDir(), "file.bin")
+	if err := os.WriteFile(localPath, []byte("the old contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expectedMd5 := fmt.Sprintf("%x", md5.Sum([]byte("what was uploaded\r\n")))
+	_, err := conn.downloadFile(context.Background(), osFS{}, "id", localPath, expectedMd5, 19, "", nil)
+	if err == nil {
+		t.Fatal("expected an md5 mismatch")
+	}
+
+	// the local file is left as it was and nothing is left behind
+	contents, _ := os.ReadFile(localPath)
+	if string(contents) != "the old contents" {
+		t.Errorf("the local file was changed to %q", contents)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(localPath))
+	if len(entries) != 1 {
+		t.Errorf("expected only the local file, found %v entries", len(entries))
+	}
+}
+
+//*********************************************************
+
+// a transport that makes extra copies of the body, like a recorder or a retry would, mustn't change the md5 or
+// the count of what was sent
+func TestUploadFileWithExtraCopiesOfTheBody(t *testing.T) {
+	contents := []byte("the contents of the uploaded file")
+	conn := testConnection(func(req *http.Request) (*http.Response, error) {
+		// one copy is read halfway, one is made and never read, and the one that was sent last is read to the end
+		partial, _ := req.GetBody()
+		io.ReadFull(partial, make([]byte, 40))
+		io.ReadAll(req.Body)
+		req.GetBody()
+		sent, _ := req.GetBody()
+		io.ReadAll(sent)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"id":"a"}`)), Request: req}, nil
+	})
+
+	fh := memReader{bytes.NewReader(contents)}
+	metadata, uploadedMd5, err := conn.uploadFile(context.Background(), "a", &UpdateFileRequest{}, fh, int64(len(contents)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metadata.ID != "a" || uploadedMd5 != fmt.Sprintf("%x", md5.Sum(contents)) {
+		t.Errorf("uploaded %v with md5 %v, expected %x", metadata.ID, uploadedMd5, md5.Sum(contents))
+	}
+}
@@ -0,0 +1,156 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Every new file or folder gets its id before it's created, and the id is saved here until the create has
+// finished. If the sync crashed or lost its connection in between, the item might be on Google Drive already
+// without the sync knowing it. The next time the item is created it's first looked for by name and md5 in
+// its folder, and if it's not there the same id is used again, so it's never created twice.
+
+const PENDING_CREATES_FILE_NAME = "config/pending-creates.json"
+
+type pendingCreate struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadPendingCreates() {
+	service.pendingCreates = make(map[string]pendingCreate)
+
+	data, err := os.ReadFile(service.configFile(PENDING_CREATES_FILE_NAME))
+	if err != nil {
+		return // nothing was left over
+	}
+	err = json.Unmarshal(data, &service.pendingCreates)
+	if err != nil {
+		service.log.Warn("ignoring the pending creates:", err)
+		service.pendingCreates = make(map[string]pendingCreate)
+	}
+}
+
+//*********************************************************
+
+func (service *Service) savePendingCreates() {
+	data, err := json.MarshalIndent(service.pendingCreates, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to save the pending creates:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(PENDING_CREATES_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the pending creates:", err)
+	}
+}
+
+//*********************************************************
+
+// saved before the create is sent, so the id is known even if the sync never sees the response
+func (service *Service) rememberPendingCreate(localPath string, id string) {
+	service.uploadMutex.Lock()
+	defer service.uploadMutex.Unlock()
+	service.pendingCreates[localPath] = pendingCreate{ID: id, StartedAt: service.clock.Now()}
+	service.savePendingCreates()
+}
+
+//*********************************************************
+
+func (service *Service) pendingCreate(localPath string) (pendingCreate, bool) {
+	service.uploadMutex.Lock()
+	defer service.uploadMutex.Unlock()
+	pending, found := service.pendingCreates[localPath]
+	return pending, found
+}
+
+//*********************************************************
+
+func (service *Service) forgetPendingCreate(localPath string) {
+	service.uploadMutex.Lock()
+	defer service.uploadMutex.Unlock()
+	if _, found := service.pendingCreates[localPath]; found {
+		delete(service.pendingCreates, localPath)
+		service.savePendingCreates()
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// looks for a copy of the item that an earlier create left in the parent folder, a file only counts if it
+// has the same md5 as the local file
+func (service *Service) findCreatedCopy(ctx context.Context, localPath string, localFileInfo fs.FileInfo, parentId string) (FileMetaData, bool, error) {
+	items, err := service.conn.getItemsByName(ctx, parentId, localFileInfo.Name())
+	if err != nil {
+		return FileMetaData{}, false, err
+	}
+
+	localMd5 := ""
+	for _, item := range items {
+		isFolder := item.MimeType == "application/vnd.google-apps.folder"
+		if isFolder != localFileInfo.IsDir() {
+			continue
+		}
+		if !isFolder {
+			if localMd5 == "" {
+				localMd5 = service.getMd5OfFile(localPath)
+			}
+			if item.Md5Checksum != localMd5 {
+				continue
+			}
+		}
+		return item, true, nil
+	}
+	return FileMetaData{}, false, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// creates the folders above localPath that are not on Google Drive yet, from the top down, so a file deep in a
+// new tree is uploaded in the same cycle instead of waiting a cycle for each level of folders, returns the parent
+func (service *Service) createMissingParents(ctx context.Context, localPath string) (FileMetaData, error) {
+	var missing []string
+	parentPath := filepath.Dir(localPath)
+	for {
+		if _, found := service.uploadedItem(parentPath); found {
+			break
+		}
+		if baseId, isBaseFolder := service.baseFolders[parentPath]; isBaseFolder {
+			service.setUploadedItem(parentPath, FileMetaData{ID: baseId})
+			break
+		}
+		if _, _, inside := service.splitLocalPath(parentPath); !inside || service.isNotSynced(parentPath) {
+			return FileMetaData{}, errors.New("parent not in map yet") // we'll try again next time
+		}
+		missing = append(missing, parentPath)
+		parentPath = filepath.Dir(parentPath)
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		folderInfo, err := service.fileSystem.Stat(missing[i])
+		if err != nil {
+			return FileMetaData{}, err
+		}
+		service.log.Debug("creating the missing folder", missing[i])
+		err = service.handleCreate(ctx, missing[i], folderInfo)
+		if err != nil {
+			return FileMetaData{}, err
+		}
+	}
+	parent, _ := service.uploadedItem(filepath.Dir(localPath))
+	return parent, nil
+}
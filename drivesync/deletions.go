@@ -0,0 +1,279 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the deletions waiting for the deletion_delay_hours grace period, kept in a file so they survive a restart
+// and can be cancelled from another process while the sync is running, every change re-reads the file so a
+// cancel that was saved in the meantime isn't overwritten
+const PENDING_DELETIONS_FILE_NAME = "config/pending-deletions.json"
+
+// an item that was trashed on Google Drive, its local copy is removed at DeleteAt unless it's cancelled, or an
+// orphan that the cleanup removes from Google Drive at DeleteAt
+type PendingDeletion struct {
+	LocalPath     string       `json:"path,omitempty"` // empty for an orphan
+	Remote        FileMetaData `json:"remote"`
+	DetectedAt    time.Time    `json:"detectedAt"`
+	DeleteAt      time.Time    `json:"deleteAt"`
+	OnGoogleDrive bool         `json:"onGoogleDrive,omitempty"` // an orphan, it's deleted by the cleanup
+}
+
+type pendingDeletionsFile struct {
+	Pending   []PendingDeletion `json:"pending"`
+	Cancelled []string          `json:"cancelled"` // remote ids, these are left alone until they are restored and trashed again
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadPendingDeletions() pendingDeletionsFile {
+	var deletions pendingDeletionsFile
+
+	data, err := os.ReadFile(service.configFile(PENDING_DELETIONS_FILE_NAME))
+	if err != nil {
+		return deletions
+	}
+	err = json.Unmarshal(data, &deletions)
+	if err != nil {
+		service.log.Warn("ignoring the pending deletions:", err)
+		return pendingDeletionsFile{}
+	}
+	return deletions
+}
+
+//*********************************************************
+
+func (service *Service) savePendingDeletions(deletions pendingDeletionsFile) {
+	data, err := json.MarshalIndent(deletions, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to save the pending deletions:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(PENDING_DELETIONS_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the pending deletions:", err)
+	}
+}
+
+//*********************************************************
+
+// loads the pending deletions, lets update change them and saves them if it returns true, all while holding
+// the lock, so the cycle, the cleanup and a cancel from the api don't overwrite each other's changes
+func (service *Service) updatePendingDeletions(update func(deletions *pendingDeletionsFile) bool) {
+	service.deletionsMutex.Lock()
+	defer service.deletionsMutex.Unlock()
+
+	deletions := service.loadPendingDeletions()
+	if update(&deletions) {
+		service.savePendingDeletions(deletions)
+	}
+}
+
+//*********************************************************
+
+// the local path, or the name and id on Google Drive of an orphan
+func (deletion PendingDeletion) Target() string {
+	if deletion.OnGoogleDrive {
+		return fmt.Sprintf("Google Drive: %v (%v)", deletion.Remote.Name, deletion.Remote.ID)
+	}
+	return deletion.LocalPath
+}
+
+//*********************************************************
+
+func (deletions *pendingDeletionsFile) indexOf(localPath string) int {
+	for i, pending := range deletions.Pending {
+		if !pending.OnGoogleDrive && pending.LocalPath == localPath {
+			return i
+		}
+	}
+	return -1
+}
+
+func (deletions *pendingDeletionsFile) indexOfOrphan(id string) int {
+	for i, pending := range deletions.Pending {
+		if pending.OnGoogleDrive && pending.Remote.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (deletions *pendingDeletionsFile) isCancelled(id string) bool {
+	for _, cancelledId := range deletions.Cancelled {
+		if cancelledId == id {
+			return true
+		}
+	}
+	return false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the item was trashed on Google Drive, so its local copy is removed once the grace period is over, that way an
+// accidental mass delete can still be cancelled before it reaches this computer
+func (service *Service) scheduleDeletion(deletions *pendingDeletionsFile, localPath string, remoteFileInfo FileMetaData) bool {
+	if service.settings.DeletionDelay == 0 {
+		service.applyRemoteTrash(localPath, remoteFileInfo)
+		return false
+	}
+	if service.deletionPolicy(localPath) == DELETION_KEEP || deletions.isCancelled(remoteFileInfo.ID) || deletions.indexOf(localPath) >= 0 {
+		return false
+	}
+	if _, err := service.fileSystem.Stat(localPath); err != nil {
+		return false // nothing to delete
+	}
+
+	now := service.clock.Now()
+	pending := PendingDeletion{LocalPath: localPath, Remote: remoteFileInfo, DetectedAt: now, DeleteAt: now.Add(service.settings.DeletionDelay)}
+	deletions.Pending = append(deletions.Pending, pending)
+	service.log.Info(localPath, "was trashed on Google Drive, the local copy will be removed at", pending.DeleteAt.Local().Format(time.RFC1123),
+		"unless the deletion is cancelled")
+	return true
+}
+
+//*********************************************************
+
+// the item is back out of the trash, so forget about deleting it
+func (service *Service) unscheduleDeletion(deletions *pendingDeletionsFile, localPath string, remoteFileInfo FileMetaData) bool {
+	changed := false
+	if i := deletions.indexOf(localPath); i >= 0 {
+		service.log.Info(localPath, "was restored on Google Drive, it won't be removed")
+		deletions.Pending = append(deletions.Pending[:i], deletions.Pending[i+1:]...)
+		changed = true
+	}
+	for i, cancelledId := range deletions.Cancelled {
+		if cancelledId == remoteFileInfo.ID {
+			deletions.Cancelled = append(deletions.Cancelled[:i], deletions.Cancelled[i+1:]...)
+			changed = true
+			break
+		}
+	}
+	return changed
+}
+
+//*********************************************************
+
+// removes the local copies whose grace period is over, the orphans are left for the cleanup
+func (service *Service) applyDueDeletions() {
+	service.updatePendingDeletions(func(deletions *pendingDeletionsFile) bool {
+		now := service.clock.Now()
+
+		var stillPending []PendingDeletion
+		for _, pending := range deletions.Pending {
+			if pending.OnGoogleDrive || now.Before(pending.DeleteAt) {
+				stillPending = append(stillPending, pending)
+				continue
+			}
+			service.applyRemoteTrash(pending.LocalPath, pending.Remote)
+		}
+
+		changed := len(stillPending) != len(deletions.Pending)
+		deletions.Pending = stillPending
+		return changed
+	})
+}
+
+//*********************************************************
+
+// the orphans wait for the grace period too, so a mass delete on Google Drive can be seen with the deletions
+// command and cancelled before the cleanup removes the files for good, returns the actions of the orphans whose
+// grace period is over. An orphan stays in the list until the cleanup no longer finds it, when save is false
+// nothing is scheduled, for the dry run.
+func (service *Service) dueCleanupActions(plan Plan, save bool) Plan {
+	if service.settings.DeletionDelay == 0 {
+		return plan
+	}
+
+	var due Plan
+	update := func(deletions *pendingDeletionsFile) bool {
+		changed := false
+		now := service.clock.Now()
+		orphanIds := make(map[string]bool)
+		for _, action := range plan.Actions {
+			orphanIds[action.Remote.ID] = true
+			if deletions.isCancelled(action.Remote.ID) {
+				continue
+			}
+			i := deletions.indexOfOrphan(action.Remote.ID)
+			if i < 0 {
+				pending := PendingDeletion{Remote: action.Remote, DetectedAt: now, DeleteAt: now.Add(service.settings.DeletionDelay), OnGoogleDrive: true}
+				deletions.Pending = append(deletions.Pending, pending)
+				service.cleanupLog.Info(action.Remote.Name, action.Remote.ID, "is no longer in the user's folders, it will be removed from Google Drive at",
+					pending.DeleteAt.Local().Format(time.RFC1123), "unless the deletion is cancelled")
+				changed = true
+				continue
+			}
+			if !now.Before(deletions.Pending[i].DeleteAt) {
+				due.add(action)
+			}
+		}
+
+		// the orphans that are gone, or are back in the user's folders
+		var stillPending []PendingDeletion
+		for _, pending := range deletions.Pending {
+			if !pending.OnGoogleDrive || orphanIds[pending.Remote.ID] {
+				stillPending = append(stillPending, pending)
+			}
+		}
+		changed = changed || len(stillPending) != len(deletions.Pending)
+		deletions.Pending = stillPending
+		return changed
+	}
+
+	if save {
+		service.updatePendingDeletions(update)
+	} else {
+		deletions := service.loadPendingDeletions()
+		update(&deletions)
+	}
+	return due
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns the local copies that will be removed once their grace period is over
+func (service *Service) PendingDeletions() []PendingDeletion {
+	return service.loadPendingDeletions().Pending
+}
+
+//*********************************************************
+
+// keeps the local copy of an item that was trashed on Google Drive, it won't be scheduled for deletion again
+// unless it's restored and trashed again, an orphan is given by its id and is kept on Google Drive
+func (service *Service) CancelDeletion(ctx context.Context, localPathOrId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	found := false
+	service.updatePendingDeletions(func(deletions *pendingDeletionsFile) bool {
+		i := deletions.indexOf(filepath.Clean(localPathOrId))
+		if i < 0 {
+			i = deletions.indexOfOrphan(localPathOrId)
+		}
+		if i < 0 {
+			return false
+		}
+		found = true
+		deletions.Cancelled = append(deletions.Cancelled, deletions.Pending[i].Remote.ID)
+		deletions.Pending = append(deletions.Pending[:i], deletions.Pending[i+1:]...)
+		return true
+	})
+	if !found {
+		return fmt.Errorf("no deletion is pending for %v: %w", localPathOrId, ErrNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package drivesync
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func cleanupTestPlan(ids ...string) Plan {
+	var plan Plan
+	for _, id := range ids {
+		plan.add(Action{Type: ACTION_DELETE_REMOTE, Remote: FileMetaData{ID: id, Name: id + ".txt"}, ItemCount: 1})
+	}
+	return plan
+}
+
+func remoteIds(plan Plan) []string {
+	var ids []string
+	for _, action := range plan.Actions {
+		ids = append(ids, action.Remote.ID)
+	}
+	return ids
+}
+
+//*********************************************************
+
+func TestCleanupWaitsForTheGracePeriod(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	service := newTestService(t, newMemFS(clock), filepath.FromSlash("/sync"))
+	service.SetClock(clock)
+
+	if due := service.dueCleanupActions(cleanupTestPlan("a", "b"), false); len(due.Actions) != 0 {
+		t.Errorf("the dry run would delete %v right away", remoteIds(due))
+	}
+	if pending := service.PendingDeletions(); len(pending) != 0 {
+		t.Errorf("the dry run scheduled %v deletions", len(pending))
+	}
+
+	if due := service.dueCleanupActions(cleanupTestPlan("a", "b"), true); len(due.Actions) != 0 {
+		t.Errorf("deleting %v before the grace period is over", remoteIds(due))
+	}
+	if err := service.CancelDeletion(context.Background(), "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(service.settings.DeletionDelay)
+	due := service.dueCleanupActions(cleanupTestPlan("a", "b"), true)
+	if ids := remoteIds(due); !equalStrings(ids, []string{"a"}) {
+		t.Errorf("deleting %q, expected only the one that wasn't cancelled", ids)
+	}
+
+	// once it's gone it's no longer pending
+	service.dueCleanupActions(cleanupTestPlan(), true)
+	if pending := service.PendingDeletions(); len(pending) != 0 {
+		t.Errorf("still pending after the orphan is gone: %+v", pending)
+	}
+}
+
+//*********************************************************
+
+// calls onStat before each Stat, so something can happen in the middle of a cycle
+type statHookFS struct {
+	*memFS
+	onStat func(name string)
+}
+
+func (fileSystem statHookFS) Stat(name string) (fs.FileInfo, error) {
+	fileSystem.onStat(name)
+	return fileSystem.memFS.Stat(name)
+}
+
+func TestCancelDuringTheCycleIsKept(t *testing.T) {
+	a, b, c := filepath.FromSlash("/sync/a.txt"), filepath.FromSlash("/sync/b.txt"), filepath.FromSlash("/sync/c.txt")
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	fileSystem := statHookFS{memFS: newMemFS(clock), onStat: func(name string) {}}
+	hourAgo := clock.Now().Add(-time.Hour)
+	fileSystem.add("/sync/a.txt", "a", hourAgo)
+	fileSystem.add("/sync/b.txt", "b", hourAgo)
+	fileSystem.add("/sync/c.txt", "c", hourAgo)
+	service := newTestService(t, fileSystem, filepath.FromSlash("/sync"))
+	service.SetClock(clock)
+
+	service.downloadLookupMap[a] = FileMetaData{ID: "a", Name: "a.txt", Trashed: true}
+	service.checkForDownloads()
+	if pending := service.PendingDeletions(); len(pending) != 1 {
+		t.Fatalf("expected a.txt to be pending, got %+v", pending)
+	}
+
+	// the deletion of a.txt is cancelled from the api while the next cycle is looking at c.txt
+	fileSystem.onStat = func(name string) {
+		if name == c {
+			if err := service.CancelDeletion(context.Background(), a); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	service.fileSystem = fileSystem
+	service.downloadLookupMap[b] = FileMetaData{ID: "b", Name: "b.txt", Trashed: true}
+	service.downloadLookupMap[c] = FileMetaData{ID: "c", Name: "c.txt", ModifiedTime: driveTime(hourAgo)}
+	service.checkForDownloads()
+
+	pending := service.PendingDeletions()
+	if len(pending) != 1 || pending[0].LocalPath != b {
+		t.Errorf("expected only b.txt to be pending, got %+v", pending)
+	}
+}
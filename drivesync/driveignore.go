@@ -0,0 +1,211 @@
+package drivesync
+
+import (
+	"bufio"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A .driveignore file at the top of a base folder lists what is not synced in that base folder, with the
+// same patterns as a .gitignore: a pattern without a / matches a name in any folder, a pattern with a / is
+// relative to the base folder, a trailing / only matches folders, ** matches any number of folders, and a
+// pattern starting with ! brings back something an earlier pattern excluded. Like with git, nothing inside
+// an excluded folder can be brought back. The .driveignore file itself is synced.
+
+const DRIVEIGNORE_FILE_NAME = ".driveignore"
+
+type ignoreRule struct {
+	segments []string // the pattern split on /
+	negate   bool
+	dirOnly  bool
+}
+
+// the rules from the .driveignore of one base folder
+type ignoreList struct {
+	modTime time.Time
+	rules   []ignoreRule
+}
+
+// the watcher looks up the ignored paths from its own goroutine, so the lists are behind a mutex
+type driveIgnores struct {
+	mutex        sync.Mutex
+	byBaseFolder map[string]ignoreList
+
+	notSynced        map[string]bool // the folders from config/not-synced.txt, see selective.go
+	notSyncedModTime time.Time
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func parseIgnoreRules(contents string) []ignoreRule {
+	var rules []ignoreRule
+
+	scanner := bufio.NewScanner(strings.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:] // \# and \! start a pattern with a # or !
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+
+		// a pattern without a / can match at any depth
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		rule.segments = strings.Split(line, "/")
+		if !anchored {
+			rule.segments = append([]string{"**"}, rule.segments...)
+		}
+		rules = append(rules, rule)
+	}
+
+	return rules
+}
+
+//*********************************************************
+
+// names are the components of the path inside the base folder
+func (rule ignoreRule) matches(names []string, isDir bool) bool {
+	if rule.dirOnly && !isDir {
+		return false
+	}
+	return matchSegments(rule.segments, names)
+}
+
+//*********************************************************
+
+func matchSegments(segments []string, names []string) bool {
+	if len(segments) == 0 {
+		return len(names) == 0
+	}
+
+	if segments[0] == "**" {
+		for i := 0; i <= len(names); i++ {
+			if matchSegments(segments[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+	matched, err := path.Match(segments[0], names[0])
+	if err != nil || !matched {
+		return false
+	}
+	return matchSegments(segments[1:], names[1:])
+}
+
+//*********************************************************
+
+// the last rule that matches decides, so a ! rule can undo an earlier one
+func (list ignoreList) ignores(names []string, isDir bool) bool {
+	ignored := false
+	for _, rule := range list.rules {
+		if rule.matches(names, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// reads the .driveignore of each base folder again if it changed, returns true if any of them changed
+// since the files it excluded before might need to be uploaded now
+func (service *Service) loadIgnoreFiles() bool {
+	changed := false
+	lists := make(map[string]ignoreList)
+
+	service.ignores.mutex.Lock()
+	previous := service.ignores.byBaseFolder
+	service.ignores.mutex.Unlock()
+
+	for _, baseFolder := range service.getBaseFolderSlice() {
+		fileName := filepath.Join(baseFolder, DRIVEIGNORE_FILE_NAME)
+		oldList, hadList := previous[baseFolder]
+
+		fileInfo, err := service.fileSystem.Stat(fileName)
+		if err != nil {
+			changed = changed || hadList
+			continue
+		}
+		if hadList && fileInfo.ModTime().Equal(oldList.modTime) {
+			lists[baseFolder] = oldList
+			continue
+		}
+
+		contents, err := service.fileSystem.ReadFile(fileName)
+		if err != nil {
+			service.log.Warn("failed to read", fileName, err)
+			if hadList {
+				lists[baseFolder] = oldList
+			}
+			continue
+		}
+		lists[baseFolder] = ignoreList{modTime: fileInfo.ModTime(), rules: parseIgnoreRules(string(contents))}
+		changed = true
+		service.log.Debug("read", len(lists[baseFolder].rules), "patterns from", fileName)
+	}
+
+	service.ignores.mutex.Lock()
+	service.ignores.byBaseFolder = lists
+	service.ignores.mutex.Unlock()
+	return changed
+}
+
+//*********************************************************
+
+// returns true if the .driveignore of the base folder, or its filters in the settings, exclude the path or
+// a folder the path is in, or if it's in a folder that is not synced
+func (service *Service) isIgnoredPath(localPath string, isDir bool) bool {
+	service.ignores.mutex.Lock()
+	defer service.ignores.mutex.Unlock()
+	if service.inNotSyncedFolder(localPath) {
+		return true
+	}
+	if len(service.ignores.byBaseFolder) == 0 && len(service.settings.FolderFilters) == 0 {
+		return false
+	}
+
+	baseFolder, names, found := service.splitLocalPath(localPath)
+	if !found || len(names) == 0 {
+		return false
+	}
+	list, hasList := service.ignores.byBaseFolder[baseFolder]
+	filter, hasFilter := service.settings.FolderFilters[baseFolder]
+
+	for i := 1; i <= len(names); i++ {
+		nameIsDir := i < len(names) || isDir
+		if hasList && list.ignores(names[:i], nameIsDir) {
+			return true
+		}
+		if hasFilter && filter.excludes(names[:i], nameIsDir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A dry run goes through one whole sync cycle, the uploads, downloads, local deletions and the cleanup, and
+// prints what it would do without changing anything on either side. Nothing is saved either, so the next real
+// sync starts from the same place. It's meant for seeing what the first sync on a new machine will do.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// while planning the items trashed on Google Drive are planned here instead of being scheduled for deletion
+func (service *Service) planLocalDeletion(localPath string, remoteFileInfo FileMetaData, reason string) {
+	if _, err := service.fileSystem.Stat(localPath); err != nil {
+		return // nothing to delete
+	}
+	policy := service.deletionPolicy(localPath)
+	if policy == DELETION_KEEP {
+		return
+	}
+	service.plannedDeletions.add(Action{Type: ACTION_DELETE_LOCAL, LocalPath: localPath, Remote: remoteFileInfo,
+		Reason: fmt.Sprintf("%v, the deletion policy is %v", reason, policy)})
+}
+
+//*********************************************************
+
+// prints the plan of one sync cycle, nothing is uploaded, downloaded or deleted
+func (service *Service) DryRun(ctx context.Context, fullRescan bool) error {
+	service.dryRun = true
+	service.fileSystem = ReadOnlyFS{service.fileSystem} // in case anything slips through
+
+	service.loadIgnoreFiles()
+	service.loadNotSyncedFolders()
+	verified := !fullRescan && service.loadState()
+	if !verified {
+		service.fillLocalMap()
+		service.resetVerifiedTime()
+	}
+
+	planner := service.Planner()
+	uploads, err := planner.PlanUploads(ctx)
+	if err != nil {
+		return err
+	}
+	downloads, err := planner.PlanDownloads(ctx)
+	if err != nil {
+		return err
+	}
+	var transfers Plan
+	for _, action := range downloads.Actions {
+		if action.Type == ACTION_DELETE_LOCAL {
+			service.plannedDeletions.add(action)
+		} else {
+			transfers.add(action)
+		}
+	}
+
+	// the deletions that are already waiting would happen in this cycle if their grace period is over
+	for _, pending := range service.loadPendingDeletions().Pending {
+		if !pending.OnGoogleDrive && !service.clock.Now().Before(pending.DeleteAt) {
+			service.planLocalDeletion(pending.LocalPath, pending.Remote, "the grace period for the deletion is over")
+		}
+	}
+
+	var cleanup Plan
+	if service.cleanupIsOff("the cleanup") == nil {
+		cleanup, err = planner.PlanCleanup(ctx)
+		if err != nil {
+			return err
+		}
+		// the other orphans would only be scheduled for deletion
+		cleanup = service.dueCleanupActions(cleanup, false)
+	}
+
+	sections := []struct {
+		name string
+		plan Plan
+	}{
+		{"uploads", uploads},
+		{"downloads", transfers},
+		{"local deletions", service.plannedDeletions},
+		{"cleanup of orphaned files on Google Drive", cleanup},
+	}
+	for _, section := range sections {
+		fmt.Println()
+		fmt.Println(len(section.plan.Actions), section.name)
+		section.plan.Print()
+	}
+	fmt.Printf("\n%.1f MB would be uploaded, nothing was changed\n", float64(uploads.UploadBytes())/(1024*1024))
+	return nil
+}
@@ -0,0 +1,125 @@
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Each request to Google Drive is counted along with whether it failed, in one minute buckets that cover the last
+// ERROR_BUDGET_WINDOW. When more than error_budget of them failed a notification is sent, once, and another one
+// when the failures are back under the budget. A few failures are normal and are retried, a growing share of
+// them is how an expired credential, a lost permission or a quota that is running out shows up before the sync
+// stops altogether. A missing file is an answer and not a failure, so only the network errors, the 5xx's and the
+// 401, 403 and 429 responses count.
+
+const ERROR_BUDGET_WINDOW = 15 * time.Minute
+const ERROR_BUDGET_BUCKET = time.Minute
+
+// with fewer requests than this in the window the share of failures doesn't say much
+const ERROR_BUDGET_MIN_REQUESTS = 20
+
+type errorBudget struct {
+	mutex    sync.Mutex
+	buckets  []budgetBucket // oldest first
+	alerting bool           // the budget was exceeded and it hasn't recovered yet
+}
+
+type budgetBucket struct {
+	start  time.Time
+	total  int64
+	failed int64
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (budget *errorBudget) record(now time.Time, failed bool) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	start := now.Truncate(ERROR_BUDGET_BUCKET)
+	if len(budget.buckets) == 0 || budget.buckets[len(budget.buckets)-1].start.Before(start) {
+		budget.buckets = append(budget.buckets, budgetBucket{start: start})
+	}
+	bucket := &budget.buckets[len(budget.buckets)-1]
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+	budget.dropOldBuckets(now)
+}
+
+//*********************************************************
+
+// the requests and the failed ones in the window
+func (budget *errorBudget) counts(now time.Time) (int64, int64) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+
+	budget.dropOldBuckets(now)
+	var total, failed int64
+	for _, bucket := range budget.buckets {
+		total += bucket.total
+		failed += bucket.failed
+	}
+	return total, failed
+}
+
+//*********************************************************
+
+// the mutex is held by the caller
+func (budget *errorBudget) dropOldBuckets(now time.Time) {
+	keep := 0
+	for keep < len(budget.buckets) && now.Sub(budget.buckets[keep].start) >= ERROR_BUDGET_WINDOW {
+		keep++
+	}
+	budget.buckets = budget.buckets[keep:]
+}
+
+//*********************************************************
+
+// true if the request counts against the error budget
+func isFailedRequest(response *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+	switch response.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusTooManyRequests:
+		return true
+	}
+	return response.StatusCode >= 500
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// called after each cycle, notifies when the share of failed requests goes over error_budget and when it's back
+func (service *Service) checkErrorBudget() {
+	budget := &service.conn.errorBudget
+	total, failed := budget.counts(time.Now())
+	if total < ERROR_BUDGET_MIN_REQUESTS {
+		return
+	}
+	ratio := float64(failed) / float64(total)
+	window := fmt.Sprintf("%v of the %v requests to Google Drive in the last %v failed", failed, total, ERROR_BUDGET_WINDOW)
+
+	budget.mutex.Lock()
+	wasAlerting := budget.alerting
+	budget.alerting = ratio > service.settings.ErrorBudget
+	budget.mutex.Unlock()
+
+	if budget.alerting && !wasAlerting {
+		service.conn.log.Warn("over the error budget:", window)
+		service.alert("Requests to Google Drive are failing", window+", check the credentials, the sharing and the quota")
+	} else if !budget.alerting && wasAlerting {
+		service.conn.log.Info("back under the error budget:", window)
+		service.notify("Requests to Google Drive have recovered", window)
+	}
+}
@@ -0,0 +1,135 @@
+package drivesync
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the errors returned by the package wrap one of these when the cause is known, check them with errors.Is
+var (
+	ErrNotFound      = errors.New("not found")             // the file or folder does not exist on Google Drive
+	ErrQuotaExceeded = errors.New("quota exceeded")        // a rate limit or the storage quota was hit, try again later
+	ErrConflict      = errors.New("conflict")              // the item was changed by someone else in the meantime
+	ErrNoSpace       = errors.New("not enough disk space") // a download would not fit on the local disk
+	ErrFileTooLarge  = errors.New("file too large")        // the local filesystem can't hold a file this big
+	ErrNotVerified   = errors.New("not verified")          // some files were not synced, the next sync tries them again
+	ErrExpired       = errors.New("expired")               // the saved changes page token is too old, a full reconciliation is needed
+	ErrUnauthorized  = errors.New("unauthorized")          // the credentials were refused, sign in again or check the service account
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// turns an error response from the API into an error that wraps the matching typed error
+func responseError(statusCode int, bodyData []byte, message string) error {
+	body := string(bodyData)
+
+	switch {
+	case statusCode == http.StatusNotFound:
+		return fmt.Errorf("%v: %w", message, ErrNotFound)
+	case statusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%v: %w", message, ErrQuotaExceeded)
+	case statusCode == http.StatusForbidden && (strings.Contains(body, "RateLimitExceeded") ||
+		strings.Contains(body, "rateLimitExceeded") || strings.Contains(body, "QuotaExceeded") || strings.Contains(body, "quotaExceeded")):
+		return fmt.Errorf("%v: %w", message, ErrQuotaExceeded)
+	case statusCode == http.StatusConflict || statusCode == http.StatusPreconditionFailed:
+		return fmt.Errorf("%v: %w", message, ErrConflict)
+	case statusCode == http.StatusGone:
+		return fmt.Errorf("%v: %w", message, ErrExpired)
+	case statusCode == http.StatusUnauthorized:
+		return fmt.Errorf("%v: %w", message, ErrUnauthorized)
+	}
+
+	if reasons := errorReasons(bodyData); len(reasons) > 0 {
+		return fmt.Errorf("%v: status %v %v", message, statusCode, strings.Join(reasons, ", "))
+	}
+	return fmt.Errorf("%v: status %v", message, statusCode)
+}
+
+//*********************************************************
+
+// true if the credentials were refused, by the API or when the access token was refreshed
+func isAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	return errors.Is(err, ErrUnauthorized) || errors.As(err, &retrieveErr)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Some error responses are whole html pages, so only the start of an error body is read and only the start of
+// that is printed. The same error is printed once a minute at most, with a count of how many times it happened
+// in between. The whole body is still saved in the trace file when record_trace is on.
+
+const MAX_ERROR_BODY_BYTES = 64 * 1024  // the most that is read of an error response
+const MAX_PRINTED_ERROR_BYTES = 1024    // the most that is printed of it
+const ERROR_REPEAT_WINDOW = time.Minute // the same error is printed once in this long
+
+type errorPrinter struct {
+	mutex   sync.Mutex
+	printed map[string]*printedError // key = the status code and the md5 of the body
+}
+
+type printedError struct {
+	printedAt time.Time
+	repeats   int // how many times it happened since it was printed
+}
+
+//*********************************************************
+
+// reads the start of an error response, enough to tell what the error is
+func readErrorBody(body io.Reader) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(body, MAX_ERROR_BODY_BYTES))
+}
+
+//*********************************************************
+
+func (conn *Connection) printErrorBody(statusCode int, bodyData []byte) {
+	key := fmt.Sprintf("%v %x", statusCode, md5.Sum(bodyData))
+	now := time.Now()
+
+	conn.errorPrinter.mutex.Lock()
+	if conn.errorPrinter.printed == nil {
+		conn.errorPrinter.printed = make(map[string]*printedError)
+	}
+	last, found := conn.errorPrinter.printed[key]
+	if found && now.Sub(last.printedAt) < ERROR_REPEAT_WINDOW {
+		last.repeats++
+		conn.errorPrinter.mutex.Unlock()
+		return
+	}
+	repeats := 0
+	if found {
+		repeats = last.repeats
+	}
+	conn.errorPrinter.printed[key] = &printedError{printedAt: now}
+
+	// the errors that stopped happening are forgotten so the map doesn't keep growing
+	for otherKey, other := range conn.errorPrinter.printed {
+		if now.Sub(other.printedAt) > 10*ERROR_REPEAT_WINDOW {
+			delete(conn.errorPrinter.printed, otherKey)
+		}
+	}
+	conn.errorPrinter.mutex.Unlock()
+
+	body := strings.TrimSpace(string(bodyData))
+	if len(body) > MAX_PRINTED_ERROR_BYTES {
+		body = strings.ToValidUTF8(body[:MAX_PRINTED_ERROR_BYTES], "") + " ...(cut)"
+	}
+	if repeats > 0 {
+		conn.log.Warn("status", statusCode, body, "(the same error happened", repeats, "more times since it was last printed)")
+	} else {
+		conn.log.Warn("status", statusCode, body)
+	}
+}
@@ -0,0 +1,167 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The export lists every file in the base folders with its checksums and Drive id, so audit and dedupe tools
+// can use them without hashing everything again. The checksums come from Google Drive, so they are only
+// filled in for the files that are synced, a file that changed locally since the last sync has no checksums.
+
+const (
+	EXPORT_SYNCED      = "synced"
+	EXPORT_MODIFIED    = "modified"    // the local file is different from the one on Google Drive
+	EXPORT_LOCAL_ONLY  = "local-only"  // not uploaded yet
+	EXPORT_REMOTE_ONLY = "remote-only" // not downloaded yet, or a Google Doc which is never downloaded
+)
+
+type ExportedFile struct {
+	Path         string    `json:"path"`
+	Size         int64     `json:"size"`
+	Md5          string    `json:"md5"`
+	Sha256       string    `json:"sha256"`
+	DriveId      string    `json:"driveId"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+	Status       string    `json:"status"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// lists the files of every base folder on both sides, sorted by path
+func (service *Service) ExportedFiles(ctx context.Context) ([]ExportedFile, error) {
+	service.loadIgnoreFiles()
+	service.loadNotSyncedFolders()
+
+	var exported []ExportedFile
+	for _, baseFolder := range service.getBaseFolderSlice() {
+		remoteFiles, err := service.conn.listTree(ctx, service.baseFolders[baseFolder], baseFolder, localChildPath)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[string]bool)
+		err = service.fileSystem.Walk(baseFolder, func(localPath string, fileInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if service.isIgnoredPath(localPath, fileInfo.IsDir()) {
+				if fileInfo.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fileInfo.IsDir() || isIgnoredFile(fileInfo.Name()) {
+				return nil
+			}
+
+			seen[localPath] = true
+			file := ExportedFile{Path: localPath, Size: fileInfo.Size(), ModifiedTime: fileInfo.ModTime(), Status: EXPORT_LOCAL_ONLY}
+			if remote, found := remoteFiles[localPath]; found {
+				file.DriveId = remote.ID
+				file.Status = EXPORT_MODIFIED
+				remoteModTime, _ := time.Parse(time.RFC3339Nano, remote.ModifiedTime)
+				diff := fileInfo.ModTime().Sub(remoteModTime)
+				tolerance := service.timestampTolerance(localPath)
+				if remote.Size == fileInfo.Size() && diff <= tolerance && diff >= -tolerance {
+					file.Status = EXPORT_SYNCED
+					file.Md5 = remote.Md5Checksum
+					file.Sha256 = remote.Sha256
+				}
+			}
+			exported = append(exported, file)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, localPath := range sortedMetadataKeys(remoteFiles) {
+			remote := remoteFiles[localPath]
+			if seen[localPath] || remote.MimeType == "application/vnd.google-apps.folder" || isIgnoredFile(remote.Name) {
+				continue
+			}
+			if service.isIgnoredPath(localPath, false) {
+				continue
+			}
+			modTime, _ := time.Parse(time.RFC3339Nano, remote.ModifiedTime)
+			exported = append(exported, ExportedFile{Path: localPath, Size: remote.Size, Md5: remote.Md5Checksum, Sha256: remote.Sha256,
+				DriveId: remote.ID, ModifiedTime: modTime, Status: EXPORT_REMOTE_ONLY})
+		}
+	}
+
+	sortExportedFiles(exported)
+	return exported, nil
+}
+
+//*********************************************************
+
+// the files that are not the same on both sides, nothing is changed, the Google Docs are left out since they are
+// never downloaded
+func (service *Service) Verify(ctx context.Context) ([]ExportedFile, error) {
+	exported, err := service.ExportedFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var different []ExportedFile
+	for _, file := range exported {
+		googleDoc := file.Status == EXPORT_REMOTE_ONLY && file.Md5 == ""
+		if file.Status != EXPORT_SYNCED && !googleDoc {
+			different = append(different, file)
+		}
+	}
+	return different, nil
+}
+
+//*********************************************************
+
+// writes the list of files to fileName as csv or json, returns how many files were written
+func (service *Service) ExportChecksums(ctx context.Context, fileName string, format string) (int, error) {
+	if format != "csv" && format != "json" {
+		return 0, fmt.Errorf("unknown export format %v, expected csv or json", format)
+	}
+	exported, err := service.ExportedFiles(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	fh, err := os.Create(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	if format == "json" {
+		err = json.NewEncoder(fh).Encode(exported)
+	} else {
+		err = writeExportCsv(fh, exported)
+	}
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return len(exported), err
+}
+
+//*********************************************************
+
+func writeExportCsv(fh *os.File, exported []ExportedFile) error {
+	writer := csv.NewWriter(fh)
+	writer.Write([]string{"path", "size", "md5", "sha256", "drive_id", "modified_time", "status"})
+	for _, file := range exported {
+		writer.Write([]string{file.Path, strconv.FormatInt(file.Size, 10), file.Md5, file.Sha256, file.DriveId,
+			file.ModifiedTime.UTC().Format(time.RFC3339Nano), file.Status})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,122 @@
+package drivesync
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the local side of the sync, every read and write of the synced folders goes through an FS so the engine can
+// run against an in-memory or read-only filesystem, the config folder is always read from the real filesystem,
+// the files are downloaded by several workers so it has to be safe to use from more than one goroutine
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Open(name string) (File, error)
+	ReadFile(name string) ([]byte, error)
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+	RemoveAll(name string) error
+	Rename(oldName string, newName string) error
+	Mkdir(name string, perm fs.FileMode) error
+	Chtimes(name string, atime time.Time, mtime time.Time) error
+	Walk(root string, walkFn filepath.WalkFunc) error
+}
+
+// a file opened for reading, the large uploads need to seek when they resume, and each attempt of a small upload
+// reads the file at its own offset
+type File interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the FS that is used unless SetFileSystem is called
+type osFS struct{}
+
+func (osFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFS) Open(name string) (File, error) {
+	return os.Open(name)
+}
+
+func (osFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (osFS) Create(name string) (io.WriteCloser, error) {
+	return os.Create(name)
+}
+
+func (osFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (osFS) RemoveAll(name string) error {
+	return os.RemoveAll(name)
+}
+
+func (osFS) Rename(oldName string, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+func (osFS) Mkdir(name string, perm fs.FileMode) error {
+	return os.Mkdir(name, perm)
+}
+
+func (osFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (osFS) Walk(root string, walkFn filepath.WalkFunc) error {
+	return filepath.Walk(root, walkFn)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+var errReadOnly = errors.New("the filesystem is read-only")
+
+// wraps another FS and refuses every write, the uploads still work but nothing is downloaded
+type ReadOnlyFS struct {
+	FS
+}
+
+func (ReadOnlyFS) Create(name string) (io.WriteCloser, error) {
+	return nil, &fs.PathError{Op: "create", Path: name, Err: errReadOnly}
+}
+
+func (ReadOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: errReadOnly}
+}
+
+func (ReadOnlyFS) RemoveAll(name string) error {
+	return &fs.PathError{Op: "removeall", Path: name, Err: errReadOnly}
+}
+
+func (ReadOnlyFS) Rename(oldName string, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: errReadOnly}
+}
+
+func (ReadOnlyFS) Mkdir(name string, perm fs.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: name, Err: errReadOnly}
+}
+
+func (ReadOnlyFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return &fs.PathError{Op: "chtimes", Path: name, Err: errReadOnly}
+}
+
+//*********************************************************
+
+func (service *Service) SetFileSystem(fileSystem FS) {
+	service.fileSystem = fileSystem
+}
@@ -0,0 +1,463 @@
+package drivesync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// an FS that keeps everything in memory, so the walk and the planners can be tested without touching the disk
+type memFS struct {
+	mutex sync.Mutex
+	clock Clock
+	files map[string]*memFile // key = cleaned path
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+	dir     bool
+}
+
+type memFileInfo struct {
+	name string
+	file memFile
+}
+
+func (info memFileInfo) Name() string       { return info.name }
+func (info memFileInfo) Size() int64        { return int64(len(info.file.data)) }
+func (info memFileInfo) ModTime() time.Time { return info.file.modTime }
+func (info memFileInfo) IsDir() bool        { return info.file.dir }
+func (info memFileInfo) Sys() interface{}   { return nil }
+
+func (info memFileInfo) Mode() fs.FileMode {
+	if info.file.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
+
+type memReader struct {
+	*bytes.Reader
+}
+
+func (memReader) Close() error { return nil }
+
+// what is written shows up in the FS when it's closed, like a file that was written and then renamed
+type memWriter struct {
+	bytes.Buffer
+	fileSystem *memFS
+	name       string
+}
+
+func (w *memWriter) Close() error {
+	w.fileSystem.mutex.Lock()
+	defer w.fileSystem.mutex.Unlock()
+	w.fileSystem.files[w.name] = &memFile{data: append([]byte(nil), w.Bytes()...), modTime: w.fileSystem.clock.Now()}
+	return nil
+}
+
+//*********************************************************
+
+func newMemFS(clock Clock) *memFS {
+	return &memFS{clock: clock, files: make(map[string]*memFile)}
+}
+
+// adds a file or, when the path ends in /, a folder, with the folders above it
+func (m *memFS) add(path string, contents string, modTime time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	isDir := strings.HasSuffix(path, "/")
+	name := filepath.Clean(filepath.FromSlash(path))
+	for parent := filepath.Dir(name); parent != filepath.Dir(parent); parent = filepath.Dir(parent) {
+		if m.files[parent] == nil {
+			m.files[parent] = &memFile{dir: true, modTime: modTime}
+		}
+	}
+	m.files[name] = &memFile{data: []byte(contents), modTime: modTime, dir: isDir}
+}
+
+func (m *memFS) lookup(op string, name string) (string, *memFile, error) {
+	name = filepath.Clean(name)
+	file := m.files[name]
+	if file == nil {
+		return name, nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return name, file, nil
+}
+
+func (m *memFS) Stat(name string) (fs.FileInfo, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, file, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return memFileInfo{filepath.Base(name), *file}, nil
+}
+
+func (m *memFS) Open(name string) (File, error) {
+	contents, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return memReader{bytes.NewReader(contents)}, nil
+}
+
+func (m *memFS) ReadFile(name string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, file, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if file.dir {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), file.data...), nil
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = filepath.Clean(name)
+	if parent := m.files[filepath.Dir(name)]; parent == nil || !parent.dir {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &memWriter{fileSystem: m, name: name}, nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name, _, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if len(m.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) RemoveAll(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = filepath.Clean(name)
+	for path := range m.files {
+		if path == name || strings.HasPrefix(path, name+string(filepath.Separator)) {
+			delete(m.files, path)
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Rename(oldName string, newName string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	oldName, _, err := m.lookup("rename", oldName)
+	if err != nil {
+		return err
+	}
+	newName = filepath.Clean(newName)
+	for path, file := range m.files {
+		if path == oldName || strings.HasPrefix(path, oldName+string(filepath.Separator)) {
+			delete(m.files, path)
+			m.files[newName+path[len(oldName):]] = file
+		}
+	}
+	return nil
+}
+
+func (m *memFS) Mkdir(name string, perm fs.FileMode) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	name = filepath.Clean(name)
+	if m.files[name] != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	if parent := m.files[filepath.Dir(name)]; parent == nil || !parent.dir {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrNotExist}
+	}
+	m.files[name] = &memFile{dir: true, modTime: m.clock.Now()}
+	return nil
+}
+
+func (m *memFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, file, err := m.lookup("chtimes", name)
+	if err != nil {
+		return err
+	}
+	file.modTime = mtime
+	return nil
+}
+
+// the paths right under the folder, sorted like filepath.Walk visits them, the caller holds the mutex
+func (m *memFS) children(folder string) []string {
+	var children []string
+	for path := range m.files {
+		if path != folder && filepath.Dir(path) == folder {
+			children = append(children, path)
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// walks in lexical order like filepath.Walk, without holding the mutex while walkFn runs
+func (m *memFS) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := m.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	err = m.walk(filepath.Clean(root), info, walkFn)
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (m *memFS) walk(path string, info fs.FileInfo, walkFn filepath.WalkFunc) error {
+	if err := walkFn(path, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+	m.mutex.Lock()
+	children := m.children(path)
+	m.mutex.Unlock()
+	for _, child := range children {
+		childInfo, err := m.Stat(child)
+		if err != nil {
+			continue
+		}
+		if err := m.walk(child, childInfo, walkFn); err != nil {
+			if err == filepath.SkipDir {
+				// skips the folder, or the rest of this folder when it's returned for a file
+				if childInfo.IsDir() {
+					continue
+				}
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a Clock that only moves when the test moves it
+type fakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at      time.Time
+	channel chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (clock *fakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	return clock.now
+}
+
+func (clock *fakeClock) After(d time.Duration) <-chan time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	channel := make(chan time.Time, 1)
+	if d <= 0 {
+		channel <- clock.now
+		return channel
+	}
+	clock.waiters = append(clock.waiters, fakeWaiter{clock.now.Add(d), channel})
+	return channel
+}
+
+// moves the time forward and fires the After channels that are due
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+	clock.now = clock.now.Add(d)
+	waiting := clock.waiters[:0]
+	for _, waiter := range clock.waiters {
+		if waiter.at.After(clock.now) {
+			waiting = append(waiting, waiter)
+			continue
+		}
+		waiter.channel <- clock.now
+	}
+	clock.waiters = waiting
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestMemFS(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	fileSystem := newMemFS(clock)
+	root := filepath.FromSlash("/sync")
+	fileSystem.add("/sync/b/c.txt", "c", clock.Now())
+	fileSystem.add("/sync/a.txt", "a", clock.Now())
+
+	writer, err := fileSystem.Create(filepath.Join(root, "b", "d.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(writer, "written")
+	if _, err := fileSystem.Stat(filepath.Join(root, "b", "d.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected the file to show up only when it's closed, got %v", err)
+	}
+	writer.Close()
+	if err := fileSystem.Rename(filepath.Join(root, "b"), filepath.Join(root, "e")); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := fileSystem.ReadFile(filepath.Join(root, "e", "d.txt"))
+	if err != nil || string(contents) != "written" {
+		t.Errorf("read %q, %v after the rename", contents, err)
+	}
+	if err := fileSystem.Remove(filepath.Join(root, "e")); err == nil {
+		t.Error("expected removing a folder that isn't empty to fail")
+	}
+
+	var walked []string
+	fileSystem.Walk(root, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, _ := filepath.Rel(root, path)
+		walked = append(walked, filepath.ToSlash(relativePath))
+		return nil
+	})
+	expected := []string{".", "a.txt", "e", "e/c.txt", "e/d.txt"}
+	if !equalStrings(walked, expected) {
+		t.Errorf("walked %q, expected %q", walked, expected)
+	}
+}
+
+//*********************************************************
+
+func TestFakeClock(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	fired := clock.After(time.Minute)
+
+	clock.Advance(59 * time.Second)
+	select {
+	case <-fired:
+		t.Fatal("fired before the time was up")
+	default:
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case at := <-fired:
+		if !at.Equal(clock.Now()) {
+			t.Errorf("fired at %v, expected %v", at, clock.Now())
+		}
+	default:
+		t.Fatal("didn't fire when the time was up")
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the whole local side in memory: the walk finds the changes, holds what is still changing until it settles
+// and the upload planner compares the contents with what is on Google Drive
+func TestWalkAndPlanUploadsInMemory(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	fileSystem := newMemFS(clock)
+	baseFolder := filepath.FromSlash("/sync")
+	minuteAgo := clock.Now().Add(-time.Minute)
+	hourAgo := clock.Now().Add(-time.Hour)
+
+	fileSystem.add("/sync/", "", hourAgo)
+	fileSystem.add("/sync/new.txt", "new", minuteAgo)
+	fileSystem.add("/sync/docs/report.txt", "report", minuteAgo)
+	fileSystem.add("/sync/changed.txt", "changed", minuteAgo)
+	fileSystem.add("/sync/same.txt", "same", minuteAgo)
+	fileSystem.add("/sync/desktop.ini", "ignored", minuteAgo)
+	fileSystem.add("/sync/~$report.docx", "lock", minuteAgo)
+	fileSystem.add("/sync/saving.txt", "still being written", clock.Now().Add(-time.Second))
+
+	service := newTestService(t, fileSystem, baseFolder)
+	service.SetClock(clock)
+	service.conn.client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		files := `{"files":[
+			{"id":"changed","name":"changed.txt","parents":["base-id"],"md5Checksum":"` + md5Of("old") + `","modifiedTime":"` + driveTime(hourAgo) + `"},
+			{"id":"same","name":"same.txt","parents":["base-id"],"md5Checksum":"` + md5Of("same") + `","modifiedTime":"` + driveTime(hourAgo) + `"}]}`
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(files)), Request: req}, nil
+	})}
+
+	plan, err := service.Planner().PlanUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, baseFolder, []string{"CreateRemote docs", "UpdateRemote changed.txt", "CreateRemote docs/report.txt",
+		"CreateRemote new.txt"})
+
+	// once it has settled the file that was being written is uploaded too
+	clock.Advance(SETTLE_TIME)
+	plan, err = service.Planner().PlanUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, baseFolder, []string{"CreateRemote docs", "UpdateRemote changed.txt", "CreateRemote docs/report.txt",
+		"CreateRemote new.txt", "CreateRemote saving.txt"})
+}
+
+//*********************************************************
+
+func TestExcelTempFileOnlyNextToAnOpenWorkbook(t *testing.T) {
+	clock := newFakeClock(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	fileSystem := newMemFS(clock)
+	minuteAgo := clock.Now().Add(-time.Minute)
+	fileSystem.add("/sync/open/~$budget.xlsx", "owner", minuteAgo)
+	fileSystem.add("/sync/open/budget.xlsx", "workbook", minuteAgo)
+	fileSystem.add("/sync/open/3A7F09C1", "temp", minuteAgo)
+	fileSystem.add("/sync/open/DEADBEEF/", "", minuteAgo)
+	fileSystem.add("/sync/closed/3A7F09C1", "a file with a name like that", minuteAgo)
+
+	service := newTestService(t, fileSystem, filepath.FromSlash("/sync"))
+	tests := []struct {
+		path     string
+		expected bool
+	}{
+		{"/sync/open/3A7F09C1", true},
+		{"/sync/open/DEADBEEF", false},
+		{"/sync/open/budget.xlsx", false},
+		{"/sync/closed/3A7F09C1", false},
+	}
+	for _, test := range tests {
+		path := filepath.FromSlash(test.path)
+		info, err := fileSystem.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if skipped := service.isExcelTempFile(path, info); skipped != test.expected {
+			t.Errorf("isExcelTempFile(%v) = %v, expected %v", test.path, skipped, test.expected)
+		}
+	}
+}
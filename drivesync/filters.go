@@ -0,0 +1,94 @@
+package drivesync
+
+import (
+	"fmt"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The include, exclude and max_file_mb settings filter what is synced in one base folder, like a .driveignore
+// but kept in config/settings.txt instead of in the folder. A filtered item is never uploaded, downloaded, or
+// removed locally when it's trashed on Google Drive. The patterns are the same as in a .driveignore.
+
+type FolderFilter struct {
+	Include  []string // if there are any, only the files that match one of these are synced
+	Exclude  []string // files and folders that are not synced, this wins over Include
+	MaxBytes int64    // files bigger than this are not synced, 0 means no limit
+
+	includeRules []ignoreRule
+	excludeRules []ignoreRule
+}
+
+//*********************************************************
+
+// include=folder=pattern, exclude=folder=pattern and max_file_mb=folder=size, all of them can be repeated
+func parseFilterSetting(key string, value string, settings *Settings) error {
+	splitAt := strings.LastIndex(value, "=")
+	if splitAt <= 0 {
+		return fmt.Errorf("expected folder=value")
+	}
+	folder := configNameToLocalPath(value[:splitAt])
+	value = strings.TrimSpace(value[splitAt+1:])
+
+	filter, found := settings.FolderFilters[folder]
+	if !found {
+		filter = &FolderFilter{}
+		settings.FolderFilters[folder] = filter
+	}
+
+	switch key {
+	case "include", "exclude":
+		rules := parseIgnoreRules(value)
+		if len(rules) != 1 || rules[0].negate {
+			return fmt.Errorf("expected a single pattern")
+		}
+		if key == "include" {
+			filter.Include = append(filter.Include, value)
+			filter.includeRules = append(filter.includeRules, rules[0])
+		} else {
+			filter.Exclude = append(filter.Exclude, value)
+			filter.excludeRules = append(filter.excludeRules, rules[0])
+		}
+	case "max_file_mb":
+		filter.MaxBytes = int64(parseIntSetting(key, value, 0)) * 1024 * 1024
+	}
+	return nil
+}
+
+//*********************************************************
+
+// names are the components of the path inside the base folder, the folders only have to pass the exclude
+// patterns so the files inside them can still be included
+func (filter *FolderFilter) excludes(names []string, isDir bool) bool {
+	for _, rule := range filter.excludeRules {
+		if rule.matches(names, isDir) {
+			return true
+		}
+	}
+	if isDir || len(filter.includeRules) == 0 {
+		return false
+	}
+	for _, rule := range filter.includeRules {
+		if rule.matches(names, isDir) {
+			return false
+		}
+	}
+	return true
+}
+
+//*********************************************************
+
+// returns true if a file is bigger than the max_file_mb of its base folder
+func (service *Service) tooBigForFolder(localPath string, size int64) bool {
+	if len(service.settings.FolderFilters) == 0 {
+		return false
+	}
+	baseFolder, _, found := service.splitLocalPath(localPath)
+	if !found {
+		return false
+	}
+	filter, hasFilter := service.settings.FolderFilters[baseFolder]
+	return hasFilter && filter.MaxBytes > 0 && size > filter.MaxBytes
+}
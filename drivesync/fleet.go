@@ -0,0 +1,241 @@
+package drivesync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A fleet runs the sync for many users in one process. Each tenant is a user of the Google Workspace domain
+// that the service account acts as through domain-wide delegation. A tenant has its own folder under
+// config/tenants/ with its own folder-ids.txt, settings.txt, state and status, only the credentials of the
+// service account are shared.
+
+const DEFAULT_CONFIG_DIR = "config"
+const TENANTS_FILE_NAME = "config/tenants.txt"
+const TENANTS_DIR = "config/tenants"
+
+// the config folder that is used, the config file names are all given relative to DEFAULT_CONFIG_DIR
+var configDir string = DEFAULT_CONFIG_DIR
+
+// one user of a fleet, the name is also the name of its folder in config/tenants/
+type Tenant struct {
+	Name string
+	User string // the email address the service account acts as, empty means the service account itself
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// uses another folder than ./config for the settings, credentials and state, call it before NewService
+func SetConfigDir(dir string) {
+	configDir = filepath.Clean(dir)
+}
+
+//*********************************************************
+
+// moves a config file name like config/settings.txt into the config folder that is used
+func configPath(fileName string) string {
+	if configDir == DEFAULT_CONFIG_DIR {
+		return fileName
+	}
+	relativePath, err := filepath.Rel(DEFAULT_CONFIG_DIR, fileName)
+	if err != nil {
+		return fileName
+	}
+	return filepath.Join(configDir, relativePath)
+}
+
+//*********************************************************
+
+// the config file names are relative to the default config folder, a tenant keeps its own copy of
+// everything except the credentials in its own folder
+func (service *Service) configFile(fileName string) string {
+	if service.tenant.Name == "" {
+		return configPath(fileName)
+	}
+	relativePath, err := filepath.Rel(DEFAULT_CONFIG_DIR, fileName)
+	if err != nil {
+		return configPath(fileName)
+	}
+	return filepath.Join(configPath(TENANTS_DIR), service.tenant.Name, relativePath)
+}
+
+//*********************************************************
+
+// a tenant acts as its user, and its requests count against its own quota instead of the machine's
+func (service *Service) applyTenantSettings() {
+	if service.tenant.Name == "" {
+		return
+	}
+	if service.tenant.User != "" {
+		service.settings.ImpersonateUser = service.tenant.User
+	}
+	if service.settings.QuotaUser == service.settings.MachineId {
+		service.settings.QuotaUser = service.tenant.Name
+		if len(service.settings.QuotaUser) > 40 {
+			service.settings.QuotaUser = service.settings.QuotaUser[:40]
+		}
+	}
+
+	// one metrics server is shared by the whole fleet
+	service.settings.MetricsAddress = ""
+	service.settings.EnablePprof = false
+}
+
+//*********************************************************
+
+// reads the settings, base folders and state of one tenant from config/tenants/<name>/
+func NewTenantService(tenant Tenant) *Service {
+	var service Service
+	service.tenant = tenant
+	service.initializeService()
+	return &service
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// reads config/tenants.txt, one name=user line per tenant, lines starting with # are ignored
+func LoadTenants(fileName string) ([]Tenant, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var tenants []Tenant
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line_split := strings.SplitN(line, "=", 2)
+		name := strings.TrimSpace(line_split[0])
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return nil, fmt.Errorf("invalid tenant name in %v: %v", fileName, line)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("tenant %v is listed twice in %v", name, fileName)
+		}
+		seen[name] = true
+
+		tenant := Tenant{Name: name}
+		if len(line_split) == 2 {
+			tenant.User = strings.TrimSpace(line_split[1])
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, scanner.Err()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type Fleet struct {
+	settings Settings // from config/settings.txt, only the metrics settings are used
+	services []*Service
+}
+
+//*********************************************************
+
+// reads config/tenants.txt and sets up a service for each tenant
+func NewFleet() (*Fleet, error) {
+	tenants, err := LoadTenants(configPath(TENANTS_FILE_NAME))
+	if err != nil {
+		return nil, err
+	}
+	if len(tenants) == 0 {
+		return nil, fmt.Errorf("no tenants in %v", configPath(TENANTS_FILE_NAME))
+	}
+
+	settings, _, err := loadConfig(configPath(CONFIG_FILE_NAME), configPath("config/settings.txt"))
+	if err != nil {
+		return nil, err
+	}
+	fleet := Fleet{settings: settings}
+	for _, tenant := range tenants {
+		serviceLog.Info("starting tenant", tenant.Name, "as", tenant.User)
+		fleet.services = append(fleet.services, NewTenantService(tenant))
+	}
+	return &fleet, nil
+}
+
+//*********************************************************
+
+func (fleet *Fleet) Services() []*Service {
+	return fleet.services
+}
+
+//*********************************************************
+
+// syncs every tenant until the context is cancelled. A tenant only stops by itself when its cycle is stuck and
+// can't be cancelled, then the whole fleet stops and returns the error so the service manager starts the program
+// again, the stuck goroutine can't be stopped any other way.
+func (fleet *Fleet) Run(ctx context.Context, fullRescan bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var stopErr error
+	for _, service := range fleet.services {
+		wg.Add(1)
+		go func(service *Service) {
+			defer wg.Done()
+			err := service.Run(ctx, fullRescan)
+			service.log.Warn("stopped:", err)
+			if ctx.Err() == nil {
+				once.Do(func() {
+					stopErr = fmt.Errorf("tenant %v: %w", service.tenant.Name, err)
+					cancel()
+				})
+			}
+		}(service)
+	}
+	wg.Wait()
+	if stopErr != nil {
+		return stopErr
+	}
+	return ctx.Err()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// serves the metrics of every tenant with a tenant label, and the status of one tenant at /status?tenant=<name>
+func (fleet *Fleet) StartMetricsServer(ctx context.Context) {
+	startMetricsServer(ctx, fleet.settings, fleet.handleMetrics, fleet.handleStatus, nil)
+}
+
+//*********************************************************
+
+func (fleet *Fleet) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeServiceMetrics(w, fleet.services)
+	writeMetrics(w, processMetrics())
+}
+
+//*********************************************************
+
+func (fleet *Fleet) handleStatus(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("tenant")
+	for _, service := range fleet.services {
+		if service.tenant.Name == name {
+			service.handleStatus(w, r)
+			return
+		}
+	}
+	http.Error(w, "unknown tenant", http.StatusNotFound)
+}
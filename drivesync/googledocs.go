@@ -0,0 +1,111 @@
+package drivesync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Google Docs, Sheets and Slides are not files, they have no md5 and can't be downloaded as they are. They are
+// exported instead, a Doc named Budget is saved as Budget.docx, and the export is made again whenever the
+// modifiedTime on Google Drive is newer than the local file. The exports only go one way, a change to the local
+// copy is not uploaded, since that would turn the Doc into a Word file. export_format picks the format of each
+// type of Google file, the ones without a format, like Forms, are skipped.
+
+const GOOGLE_APPS_PREFIX = "application/vnd.google-apps."
+
+// the formats Google Drive can export to, key = the extension of the local file
+var exportMimeTypes = map[string]string{
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"odt":  "application/vnd.oasis.opendocument.text",
+	"rtf":  "application/rtf",
+	"txt":  "text/plain",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"ods":  "application/vnd.oasis.opendocument.spreadsheet",
+	"csv":  "text/csv",
+	"pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"odp":  "application/vnd.oasis.opendocument.presentation",
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"svg":  "image/svg+xml",
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// key = the type of Google file, value = the extension it's exported as
+func defaultExportFormats() map[string]string {
+	return map[string]string{
+		GOOGLE_APPS_PREFIX + "document":     "docx",
+		GOOGLE_APPS_PREFIX + "spreadsheet":  "xlsx",
+		GOOGLE_APPS_PREFIX + "presentation": "pptx",
+	}
+}
+
+//*********************************************************
+
+// the value is type=extension, like spreadsheet=ods, or type=off to skip that type
+func parseExportSetting(value string, settings *Settings) error {
+	value_split := strings.SplitN(value, "=", 2)
+	if len(value_split) != 2 {
+		return fmt.Errorf("expected type=extension")
+	}
+	googleType := GOOGLE_APPS_PREFIX + strings.TrimSpace(value_split[0])
+	extension := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(value_split[1]), "."))
+
+	if extension == "off" {
+		delete(settings.ExportFormats, googleType)
+		return nil
+	}
+	if _, known := exportMimeTypes[extension]; !known {
+		extensions := make([]string, 0, len(exportMimeTypes))
+		for known := range exportMimeTypes {
+			extensions = append(extensions, known)
+		}
+		sort.Strings(extensions)
+		return fmt.Errorf("can't export to %v, the formats are %v or off", extension, strings.Join(extensions, ", "))
+	}
+	settings.ExportFormats[googleType] = extension
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// true for the Google Docs, Sheets, Forms and so on, which are not files
+func isGoogleFile(remoteFileInfo FileMetaData) bool {
+	return strings.HasPrefix(remoteFileInfo.MimeType, GOOGLE_APPS_PREFIX) &&
+		remoteFileInfo.MimeType != "application/vnd.google-apps.folder"
+}
+
+//*********************************************************
+
+// the mime type to export the Google file as, false if it's not a Google file or it's not exported
+func (service *Service) exportMimeType(remoteFileInfo FileMetaData) (string, bool) {
+	if !isGoogleFile(remoteFileInfo) {
+		return "", false
+	}
+	extension, exported := service.settings.ExportFormats[remoteFileInfo.MimeType]
+	if !exported {
+		return "", false
+	}
+	return exportMimeTypes[extension], true
+}
+
+//*********************************************************
+
+// the name of the local copy, an exported Google file gets the extension of its format, see shortcutLocalName for
+// the shortcuts
+func (service *Service) localNameOf(remoteFileInfo FileMetaData) string {
+	if isShortcut(remoteFileInfo) {
+		return service.shortcutLocalName(remoteFileInfo)
+	}
+	if extension, exported := service.settings.ExportFormats[remoteFileInfo.MimeType]; exported && isGoogleFile(remoteFileInfo) {
+		return remoteFileInfo.Name + "." + extension
+	}
+	return remoteFileInfo.Name
+}
@@ -0,0 +1,153 @@
+package drivesync
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// how a file that keeps growing (a log, a recording) is uploaded, instead of re-uploading all of it every loop
+type GrowingFileMode string
+
+const (
+	GROWING_WAIT_IDLE      GrowingFileMode = "idle"    // upload once it has stopped growing for GROWING_IDLE_TIME
+	GROWING_EVERY          GrowingFileMode = "every"   // upload at most once per interval while it keeps growing
+	GROWING_AFTER_ROTATION GrowingFileMode = "rotated" // only upload it once a newer file matching the same pattern shows up
+)
+
+const GROWING_IDLE_TIME = 15 * time.Minute
+
+// the files whose name matches the pattern are uploaded according to the mode
+type GrowingFilePolicy struct {
+	Pattern  string
+	Mode     GrowingFileMode
+	Interval time.Duration // only used by GROWING_EVERY
+}
+
+// a file that matched a GROWING_AFTER_ROTATION policy during the walk
+type rotatingFile struct {
+	path       string
+	modifiedAt time.Time
+	changed    bool
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// parses the value of a growing_file setting, for example "*.log=rotated" or "*.mkv=every 6h"
+func parseGrowingFilePolicy(value string) (GrowingFilePolicy, error) {
+	policy := GrowingFilePolicy{}
+
+	splitAt := strings.LastIndex(value, "=")
+	if splitAt <= 0 {
+		return policy, fmt.Errorf("expected pattern=policy")
+	}
+	policy.Pattern = strings.TrimSpace(value[:splitAt])
+	if _, err := filepath.Match(policy.Pattern, ""); err != nil {
+		return policy, fmt.Errorf("invalid pattern %v: %w", policy.Pattern, err)
+	}
+
+	fields := strings.Fields(value[splitAt+1:])
+	if len(fields) == 0 {
+		return policy, fmt.Errorf("missing the policy")
+	}
+
+	policy.Mode = GrowingFileMode(fields[0])
+	switch policy.Mode {
+	case GROWING_WAIT_IDLE, GROWING_AFTER_ROTATION:
+		if len(fields) != 1 {
+			return policy, fmt.Errorf("%v does not take an interval", policy.Mode)
+		}
+	case GROWING_EVERY:
+		if len(fields) != 2 {
+			return policy, fmt.Errorf("every needs an interval, for example every 6h")
+		}
+		interval, err := time.ParseDuration(fields[1])
+		if err != nil || interval <= 0 {
+			return policy, fmt.Errorf("invalid interval %v", fields[1])
+		}
+		policy.Interval = interval
+	default:
+		return policy, fmt.Errorf("unknown policy %v, expected idle, every or rotated", fields[0])
+	}
+
+	return policy, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns the first policy whose pattern matches the file name, or nil if the file is synced normally
+func (service *Service) growingFilePolicy(name string) *GrowingFilePolicy {
+	for i := range service.settings.GrowingFiles {
+		policy := &service.settings.GrowingFiles[i]
+		if matched, _ := filepath.Match(policy.Pattern, name); matched {
+			return policy
+		}
+	}
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if a changed file should wait for a later loop, only for the idle and every policies
+func (service *Service) growingFileIsHeld(policy *GrowingFilePolicy, path string, modifiedAt time.Time) bool {
+	now := service.clock.Now()
+
+	switch policy.Mode {
+	case GROWING_WAIT_IDLE:
+		return now.Sub(modifiedAt) < GROWING_IDLE_TIME
+	case GROWING_EVERY:
+		uploadedAt, uploadedBefore := service.growingUploadedAt[path]
+		if uploadedBefore && now.Sub(uploadedAt) < policy.Interval {
+			return true
+		}
+		service.growingUploadedAt[path] = now
+	}
+
+	return false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// after the walk, upload the changed files of each rotation group except the newest one which is still being written
+func (service *Service) handleRotatingFiles(groups map[string][]rotatingFile) {
+	for _, key := range sortedRotationKeys(groups) {
+		files := groups[key]
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].modifiedAt.Equal(files[j].modifiedAt) {
+				return files[i].path < files[j].path
+			}
+			return files[i].modifiedAt.Before(files[j].modifiedAt)
+		})
+
+		for i, file := range files {
+			if !file.changed {
+				continue
+			}
+			if i == len(files)-1 {
+				service.holdLocalFile(file.path, "is the active file and has not been rotated yet")
+				continue
+			}
+			service.queueLocalUpload(file.path, file.modifiedAt)
+		}
+	}
+}
+
+//*********************************************************
+
+func sortedRotationKeys(groups map[string][]rotatingFile) []string {
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,335 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A handler is a command that transforms a file on its way between Google Drive and the local folder, for
+// example to decrypt, unzip or convert it. download_handler=*.gpg=gpg --decrypt --output {out} {in} runs on each
+// downloaded file whose name matches before it's put in place, and upload_handler does the same to a local file
+// before it's uploaded. The local and remote md5's of a handled file no longer match, so the pair is kept in
+// config/handled-files.json and counts as the same contents. When a handler fails the file is quarantined instead
+// of being retried every cycle: a download is kept in config/quarantine until the remote file changes, and an
+// upload is skipped until the local file changes.
+
+const HANDLED_FILES_FILE_NAME = "config/handled-files.json"
+const QUARANTINE_FOLDER = "config/quarantine"
+const HANDLER_TEMP_FOLDER = "config/handler-tmp"
+
+// a handler that runs longer than this is stopped and counts as failed
+const HANDLER_TIMEOUT = 10 * time.Minute
+
+type FileHandler struct {
+	Pattern string   // matched against the name of the file, like *.gpg
+	Command []string // {in} and {out} are replaced with the file to read and the file to write
+}
+
+// the md5 of the local file and the md5 of the remote file it goes with, or the modifiedTime when there's no md5
+type handledFile struct {
+	LocalMd5    string `json:"localMd5"`
+	Remote      string `json:"remote"`
+	Quarantined bool   `json:"quarantined,omitempty"`
+}
+
+type handledFiles struct {
+	mutex  sync.Mutex
+	byPath map[string]handledFile // key = local path
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the value is pattern=command, the command is split on spaces and needs {out}
+func parseFileHandler(value string) (FileHandler, error) {
+	value_split := strings.SplitN(value, "=", 2)
+	if len(value_split) != 2 {
+		return FileHandler{}, fmt.Errorf("expected pattern=command")
+	}
+	handler := FileHandler{Pattern: strings.TrimSpace(value_split[0]), Command: strings.Fields(value_split[1])}
+	if _, err := filepath.Match(handler.Pattern, ""); err != nil {
+		return handler, fmt.Errorf("invalid pattern %v: %w", handler.Pattern, err)
+	}
+	if len(handler.Command) == 0 {
+		return handler, fmt.Errorf("missing the command")
+	}
+	if !strings.Contains(value_split[1], "{out}") {
+		return handler, fmt.Errorf("the command has to write to {out}")
+	}
+	return handler, nil
+}
+
+//*********************************************************
+
+// the first handler whose pattern matches the name of the file, nil if there isn't one
+func matchHandler(handlers []FileHandler, localPath string) *FileHandler {
+	for i := range handlers {
+		if matched, _ := filepath.Match(handlers[i].Pattern, filepath.Base(localPath)); matched {
+			return &handlers[i]
+		}
+	}
+	return nil
+}
+
+//*********************************************************
+
+func (service *Service) runHandler(ctx context.Context, handler *FileHandler, inPath string, outPath string) error {
+	ctx, cancel := context.WithTimeout(ctx, HANDLER_TIMEOUT)
+	defer cancel()
+
+	args := make([]string, len(handler.Command))
+	for i, arg := range handler.Command {
+		args[i] = strings.NewReplacer("{in}", inPath, "{out}", outPath).Replace(arg)
+	}
+	output, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v failed: %w %v", handler.Command[0], err, strings.TrimSpace(string(output)))
+	}
+	if _, err := os.Stat(outPath); err != nil {
+		return fmt.Errorf("%v didn't write {out}: %w", handler.Command[0], err)
+	}
+	return nil
+}
+
+//*********************************************************
+
+// a folder for the files of one handler run, the caller removes it
+func (service *Service) handlerTempDir() (string, error) {
+	parent := service.configFile(HANDLER_TEMP_FOLDER)
+	err := os.MkdirAll(parent, 0700)
+	if err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, "run-")
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// downloads the file into the temp folder, runs the handler on it and puts what it wrote in place
+func (service *Service) downloadThroughHandler(ctx context.Context, handler *FileHandler, action Action) error {
+	tempDir, err := service.handlerTempDir()
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// the handler is a real command, so it works on real files whatever the FS of the service is
+	inPath := filepath.Join(tempDir, "in")
+	outPath := filepath.Join(tempDir, "out")
+	exportMimeType, _ := service.exportMimeType(action.Remote)
+	downloadStarted := service.clock.Now()
+	progress, finished := service.startProgress(TRANSFER_DOWNLOAD, action.LocalPath, action.Remote.Size)
+	downloadedMd5, err := service.conn.downloadFile(ctx, osFS{}, contentsId(action.Remote), inPath, action.Remote.Md5Checksum, action.Remote.Size, exportMimeType,
+		progress)
+	finished()
+	if downloadedMd5 != "" {
+		service.logDownload(osFS{}, inPath, action, downloadedMd5, downloadStarted)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = service.runHandler(ctx, handler, inPath, outPath)
+	if err != nil {
+		quarantinePath, quarantineErr := service.quarantine(inPath, action.LocalPath)
+		if quarantineErr != nil {
+			service.log.Warn("failed to quarantine", action.LocalPath, quarantineErr)
+		}
+		service.rememberHandled(action.LocalPath, handledFile{Remote: remoteVersion(action.Remote), Quarantined: true})
+		return fmt.Errorf("the download handler failed, the downloaded file is in %v until it changes on Google Drive: %w", quarantinePath, err)
+	}
+
+	localMd5, err := copyIntoFS(service.fileSystem, outPath, action.LocalPath)
+	if err != nil {
+		return err
+	}
+	service.rememberHandled(action.LocalPath, handledFile{LocalMd5: localMd5, Remote: remoteVersion(action.Remote)})
+	return nil
+}
+
+//*********************************************************
+
+// runs the handler on the local file and uploads what it wrote, returns the md5 of what was uploaded
+func (service *Service) uploadThroughHandler(ctx context.Context, handler *FileHandler, localPath string, id string, uploadRequest UploadRequest) (FileMetaData, string, error) {
+	originalMd5 := service.getMd5OfFile(localPath)
+
+	tempDir, err := service.handlerTempDir()
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	defer os.RemoveAll(tempDir)
+
+	outPath := filepath.Join(tempDir, "out")
+	err = service.runHandler(ctx, handler, localPath, outPath)
+	if err != nil {
+		service.rememberHandled(localPath, handledFile{LocalMd5: originalMd5, Quarantined: true})
+		return FileMetaData{}, "", fmt.Errorf("the upload handler failed, %v is not uploaded until it changes: %w", localPath, err)
+	}
+
+	fh, err := os.Open(outPath)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	defer fh.Close()
+	hash := md5.New()
+	fileSize, err := io.Copy(hash, fh)
+	if err == nil {
+		_, err = fh.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	uploadedMd5 := fmt.Sprintf("%x", hash.Sum(nil))
+
+	progress, finished := service.startProgress(TRANSFER_UPLOAD, localPath, fileSize)
+	defer finished()
+	var remoteMetaData FileMetaData
+	if fileSize > LARGE_FILE_THRESHOLD_BYTES {
+		remoteMetaData, err = service.conn.uploadLargeFile(ctx, id, uploadRequest, progress.file(fh), fileSize)
+	} else {
+		remoteMetaData, uploadedMd5, err = service.conn.uploadFile(ctx, id, uploadRequest, progress.file(fh), fileSize)
+	}
+	if err == nil {
+		service.rememberHandled(localPath, handledFile{LocalMd5: originalMd5, Remote: uploadedMd5})
+	}
+	return remoteMetaData, uploadedMd5, err
+}
+
+//*********************************************************
+
+// moves a download that the handler failed on to config/quarantine/<time>/<local path>
+func (service *Service) quarantine(inPath string, localPath string) (string, error) {
+	stamp := service.clock.Now().Format("2006-01-02T15-04-05")
+	quarantinePath := filepath.Join(service.configFile(QUARANTINE_FOLDER), stamp, filepath.Clean(localPath))
+	err := os.MkdirAll(filepath.Dir(quarantinePath), 0700)
+	if err != nil {
+		return quarantinePath, err
+	}
+	return quarantinePath, os.Rename(inPath, quarantinePath)
+}
+
+//*********************************************************
+
+// copies a real file into the FS of the service, returns the md5 of what was copied
+func copyIntoFS(fileSystem FS, fromPath string, toPath string) (string, error) {
+	from, err := os.Open(fromPath)
+	if err != nil {
+		return "", err
+	}
+	defer from.Close()
+
+	to, err := fileSystem.Create(toPath)
+	if err != nil {
+		return "", err
+	}
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(to, hash), from)
+	closeErr := to.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fileSystem.Remove(toPath) // so the half written file isn't uploaded later on
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func remoteVersion(remoteFileInfo FileMetaData) string {
+	if remoteFileInfo.Md5Checksum != "" {
+		return remoteFileInfo.Md5Checksum
+	}
+	return remoteFileInfo.ModifiedTime
+}
+
+//*********************************************************
+
+// true if the local file has the same contents as the remote one, either the same md5 or the two sides of a
+// file that went through a handler
+func (service *Service) sameContents(localPath string, localMd5 string, remoteMd5 string) bool {
+	if localMd5 == remoteMd5 {
+		return true
+	}
+	handled, found := service.handledFile(localPath)
+	return found && !handled.Quarantined && handled.LocalMd5 == localMd5 && handled.Remote == remoteMd5
+}
+
+//*********************************************************
+
+// true if the download handler failed on this version of the remote file
+func (service *Service) downloadIsQuarantined(localPath string, remoteFileInfo FileMetaData) bool {
+	handled, found := service.handledFile(localPath)
+	return found && handled.Quarantined && handled.LocalMd5 == "" && handled.Remote == remoteVersion(remoteFileInfo)
+}
+
+//*********************************************************
+
+// true if the upload handler failed on the file and it hasn't changed since
+func (service *Service) uploadIsQuarantined(localPath string) bool {
+	handled, found := service.handledFile(localPath)
+	return found && handled.Quarantined && handled.LocalMd5 != "" && handled.LocalMd5 == service.getMd5OfFile(localPath)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadHandledFiles() {
+	service.handled.mutex.Lock()
+	defer service.handled.mutex.Unlock()
+	service.handled.byPath = make(map[string]handledFile)
+
+	data, err := os.ReadFile(service.configFile(HANDLED_FILES_FILE_NAME))
+	if err != nil {
+		return // nothing has been handled yet
+	}
+	err = json.Unmarshal(data, &service.handled.byPath)
+	if err != nil {
+		service.log.Warn("ignoring", service.configFile(HANDLED_FILES_FILE_NAME), ":", err)
+		service.handled.byPath = make(map[string]handledFile)
+	}
+}
+
+//*********************************************************
+
+func (service *Service) handledFile(localPath string) (handledFile, bool) {
+	service.handled.mutex.Lock()
+	defer service.handled.mutex.Unlock()
+	handled, found := service.handled.byPath[localPath]
+	return handled, found
+}
+
+//*********************************************************
+
+// the workers call this, so the file is written while the mutex is held
+func (service *Service) rememberHandled(localPath string, handled handledFile) {
+	service.handled.mutex.Lock()
+	defer service.handled.mutex.Unlock()
+	service.handled.byPath[localPath] = handled
+
+	data, err := json.MarshalIndent(service.handled.byPath, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to save the handled files:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(HANDLED_FILES_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the handled files:", err)
+	}
+}
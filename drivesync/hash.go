@@ -0,0 +1,242 @@
+package drivesync
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const HASH_CHUNK_BYTES = 1024 * 1024
+
+const MD5_CACHE_FILE_NAME = "config/md5-cache.json"
+
+// a file modified this recently might still be written to within the same modification time, so its md5 is not
+// cached, otherwise a change that keeps the size and the time would never be hashed
+const MD5_CACHE_MIN_AGE = 2 * time.Second
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) initializeHashing() {
+	service.hashSlots = make(chan struct{}, service.settings.HashWorkers)
+	service.md5s.byPath = make(map[string]hashedFile)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the md5's of the local files, a file is only hashed again when its size or modification time changes, so a
+// large file isn't read again each time it's planned or verified, or after a restart since the sync saves them in
+// config/md5-cache.json
+type md5Cache struct {
+	mutex  sync.Mutex
+	byPath map[string]hashedFile
+	dirty  bool
+}
+
+type hashedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Md5     string    `json:"md5"`
+}
+
+// returns the cached md5 if the file has not changed since it was hashed
+func (cache *md5Cache) get(path string, fileInfo os.FileInfo) (string, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	hashed, found := cache.byPath[path]
+	if !found || hashed.Size != fileInfo.Size() || !hashed.ModTime.Equal(fileInfo.ModTime()) {
+		return "", false
+	}
+	return hashed.Md5, true
+}
+
+func (cache *md5Cache) put(path string, fileInfo os.FileInfo, md5 string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.byPath[path] = hashedFile{Size: fileInfo.Size(), ModTime: fileInfo.ModTime(), Md5: md5}
+	cache.dirty = true
+}
+
+//*********************************************************
+
+// loads the md5's saved by the last sync, a missing or damaged file just means hashing the files again
+func (service *Service) loadMd5Cache() {
+	data, err := os.ReadFile(service.configFile(MD5_CACHE_FILE_NAME))
+	if err != nil {
+		return
+	}
+	var byPath map[string]hashedFile
+	err = json.Unmarshal(data, &byPath)
+	if err != nil {
+		service.log.Warn("ignoring", service.configFile(MD5_CACHE_FILE_NAME), ":", err)
+		return
+	}
+
+	service.md5s.mutex.Lock()
+	defer service.md5s.mutex.Unlock()
+	for path, hashed := range byPath {
+		if _, found := service.md5s.byPath[path]; !found {
+			service.md5s.byPath[path] = hashed
+		}
+	}
+}
+
+//*********************************************************
+
+// saves the md5's of the files that are still synced, the ones that are gone are dropped
+func (service *Service) saveMd5Cache() {
+	service.md5s.mutex.Lock()
+	defer service.md5s.mutex.Unlock()
+	if !service.md5s.dirty {
+		return
+	}
+	for path := range service.md5s.byPath {
+		if !service.localFiles[path] {
+			delete(service.md5s.byPath, path)
+		}
+	}
+	data, err := json.Marshal(service.md5s.byPath)
+	if err != nil {
+		service.log.Warn("failed to save the md5 cache:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(MD5_CACHE_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the md5 cache:", err)
+		return
+	}
+	service.md5s.dirty = false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// hashes the file one chunk at a time, pausing between chunks if throttling is turned on so hashing
+// a lot of large files doesn't peg the CPU
+func (service *Service) getMd5OfFile(path string) string {
+	fileInfo, statErr := service.fileSystem.Stat(path)
+	if statErr == nil {
+		if md5, found := service.md5s.get(path, fileInfo); found {
+			return md5
+		}
+	}
+
+	// limit how many files are hashed at the same time
+	service.hashSlots <- struct{}{}
+	defer func() { <-service.hashSlots }()
+
+	fh, err := service.fileSystem.Open(path)
+	if err != nil {
+		service.log.Warn("could not open file for md5", err)
+		return ""
+	}
+	defer fh.Close()
+
+	// the large files are also split into chunks so the next upload can tell how much of it changed
+	var fileChunker *chunker
+	if statErr == nil && fileInfo.Size() >= CHUNKED_FILE_THRESHOLD_BYTES {
+		fileChunker = &chunker{}
+	}
+
+	result := md5.New()
+	buffer := make([]byte, HASH_CHUNK_BYTES)
+	for {
+		n, err := fh.Read(buffer)
+		if n > 0 {
+			result.Write(buffer[:n])
+			if fileChunker != nil {
+				fileChunker.Write(buffer[:n])
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			service.log.Warn("could not read data from file for md5", err)
+			return ""
+		}
+
+		if service.settings.HashChunkPause > 0 {
+			time.Sleep(service.settings.HashChunkPause)
+		}
+	}
+
+	if fileChunker != nil {
+		service.rememberScannedChunks(path, fileChunker.finish())
+	}
+
+	result_string := fmt.Sprintf("%x", result.Sum(nil))
+	if statErr == nil && service.clock.Now().Sub(fileInfo.ModTime()) >= MD5_CACHE_MIN_AGE {
+		service.md5s.put(path, fileInfo, result_string)
+	}
+	return result_string
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// hashes the files in parallel, using up to HashWorkers at a time
+func (service *Service) hashFiles(paths []string) map[string]string {
+	results := make(map[string]string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+
+	pathChannel := make(chan string)
+	for i := 0; i < service.settings.HashWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathChannel {
+				md5 := service.getMd5OfFile(path)
+				mutex.Lock()
+				results[path] = md5
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, path := range paths {
+		pathChannel <- path
+	}
+	close(pathChannel)
+	wg.Wait()
+
+	return results
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// starts hashing the files that are about to be uploaded while the caller lists the remote folders, the
+// listing waits on the network and the hashing on the disk so they can overlap, the channel is closed when done
+func (service *Service) warmUpHashes(paths []string) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		var filePaths []string
+		for _, path := range paths {
+			if fileInfo, err := service.fileSystem.Stat(path); err == nil && !fileInfo.IsDir() {
+				filePaths = append(filePaths, path)
+			}
+		}
+		service.hashFiles(filePaths)
+	}()
+
+	return done
+}
+
+// returns true if hashing should wait until the computer is plugged in
+func (service *Service) hashingDeferred() bool {
+	return service.settings.HashOnlyOnACPower && !onACPower()
+}
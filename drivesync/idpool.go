@@ -0,0 +1,105 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Every create needs an id from generateIds first, which would double the API calls of a big import. So the ids
+// are asked for ID_POOL_SIZE at a time and handed out one by one. The ids that are left are saved in
+// config/id-pool.json so they are used after a restart too, and an id is taken out of the file before it's used
+// so it's never handed out twice.
+
+const ID_POOL_FILE_NAME = "config/id-pool.json"
+const ID_POOL_SIZE = 100
+
+// the saved ids are thrown away after this long in case Google Drive stops accepting them
+const ID_POOL_MAX_AGE = 7 * 24 * time.Hour
+
+type idPool struct {
+	mutex    sync.Mutex
+	fileName string // empty means the ids are not saved
+	saved    idPoolFile
+}
+
+type idPoolFile struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	IDs         []string  `json:"ids"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// reads the ids that were left over from the last run
+func (conn *Connection) loadIdPool(fileName string) {
+	conn.idPool.mutex.Lock()
+	defer conn.idPool.mutex.Unlock()
+
+	conn.idPool.fileName = fileName
+	conn.idPool.saved = idPoolFile{}
+
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return // nothing was left over
+	}
+	err = json.Unmarshal(data, &conn.idPool.saved)
+	if err != nil {
+		conn.log.Warn("ignoring the saved ids:", err)
+		conn.idPool.saved = idPoolFile{}
+	}
+}
+
+//*********************************************************
+
+// an id for a new file or folder, more are generated when the pool is empty
+func (conn *Connection) nextId(ctx context.Context) (string, error) {
+	conn.idPool.mutex.Lock()
+	defer conn.idPool.mutex.Unlock()
+
+	pool := &conn.idPool.saved
+	if len(pool.IDs) > 0 && time.Since(pool.GeneratedAt) > ID_POOL_MAX_AGE {
+		pool.IDs = nil
+	}
+	if len(pool.IDs) == 0 {
+		ids, err := conn.generateIds(ctx, ID_POOL_SIZE)
+		if err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			return "", fmt.Errorf("no ids were generated")
+		}
+		pool.IDs = ids
+		pool.GeneratedAt = time.Now()
+	}
+
+	id := pool.IDs[0]
+	pool.IDs = pool.IDs[1:]
+	conn.saveIdPool()
+	return id, nil
+}
+
+//*********************************************************
+
+// called with the mutex held
+func (conn *Connection) saveIdPool() {
+	if conn.idPool.fileName == "" {
+		return
+	}
+	data, err := json.Marshal(conn.idPool.saved)
+	if err != nil {
+		conn.log.Warn("failed to save the ids:", err)
+		return
+	}
+
+	err = writeFileAtomically(conn.idPool.fileName, data)
+	if err != nil {
+		conn.log.Warn("failed to save the ids:", err)
+	}
+}
@@ -0,0 +1,90 @@
+package drivesync
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a file modified more recently than this might still be in the middle of a save, so it waits for the next loop
+const SETTLE_TIME = 10 * time.Second
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true for the files that are never synced: desktop.ini and the lock and temp files that Office
+// and LibreOffice create while a document is open or being saved
+func isIgnoredFile(name string) bool {
+	lowerName := strings.ToLower(name)
+
+	switch {
+	case lowerName == "desktop.ini":
+		return true
+	case strings.HasPrefix(name, "~$"):
+		// Office owner/lock files, for example ~$report.docx
+		return true
+	case strings.HasPrefix(name, ".~lock.") && strings.HasSuffix(name, "#"):
+		// LibreOffice lock files, for example .~lock.report.odt#
+		return true
+	case strings.HasPrefix(name, "~") && strings.HasSuffix(lowerName, ".tmp"):
+		// Word saves by writing ~WRD0001.tmp and moving the old version to ~WRL0001.tmp, then renaming
+		return true
+	case strings.HasPrefix(lowerName, "ppt") && strings.HasSuffix(lowerName, ".tmp"):
+		// PowerPoint's temp files
+		return true
+	case strings.HasSuffix(name, PARTIAL_DOWNLOAD_SUFFIX) || strings.HasSuffix(name, DOWNLOAD_TEMP_SUFFIX):
+		// our own downloads that are not finished yet
+		return true
+	}
+
+	return false
+}
+
+//*********************************************************
+
+// Excel saves by writing to a file named with 8 hex digits and no extension, then renaming it over the workbook.
+// A file or folder can have a name like that too, so it's only skipped when it's a local file and a workbook in
+// the same folder is open, Excel keeps a ~$ owner file next to the workbook while it's open.
+func (service *Service) isExcelTempFile(path string, fileInfo fs.FileInfo) bool {
+	if !fileInfo.Mode().IsRegular() || !isExcelTempName(fileInfo.Name()) {
+		return false
+	}
+
+	folder := filepath.Dir(path)
+	workbookOpen := false
+	service.fileSystem.Walk(folder, func(childPath string, childInfo fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if childPath == folder {
+			return nil
+		}
+		if childInfo.IsDir() {
+			return filepath.SkipDir
+		}
+		name := childInfo.Name()
+		if strings.HasPrefix(name, "~$") && strings.HasPrefix(strings.ToLower(filepath.Ext(name)), ".xl") {
+			workbookOpen = true
+			// skips the rest of the folder
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return workbookOpen
+}
+
+func isExcelTempName(name string) bool {
+	if len(name) != 8 {
+		return false
+	}
+	for _, r := range name {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return false
+		}
+	}
+	return true
+}
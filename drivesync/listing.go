@@ -0,0 +1,142 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The list command shows what is in a folder on Google Drive with the size, md5, modified time and owner of each
+// item, for checking what the sync should end up with or for an audit. The folder is a Drive id, or a path that
+// starts with a base folder like Projects/2024, which is looked up one folder at a time. The list can be written
+// as text, csv for a spreadsheet, or json.
+
+type ListedItem struct {
+	Path         string    `json:"path"`
+	DriveId      string    `json:"driveId"`
+	MimeType     string    `json:"mimeType"`
+	Size         int64     `json:"size"`
+	Md5          string    `json:"md5"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+	Owner        string    `json:"owner"` // the email of the owner, empty on a Shared Drive
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// lists the items in the folder, and everything under it with recursive, sorted by path, the trashed items are
+// left out
+func (service *Service) ListFolder(ctx context.Context, folder string, recursive bool) ([]ListedItem, error) {
+	folderId, folderPath, err := service.resolveRemoteFolder(ctx, folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var remoteFiles map[string]FileMetaData
+	if recursive {
+		remoteFiles, err = service.conn.listTree(ctx, folderId, folderPath, localChildPath)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		data, err := service.conn.getItemsInFolders(ctx, folderPath, []string{folderId})
+		if err != nil {
+			return nil, err
+		}
+		remoteFiles = make(map[string]FileMetaData)
+		for _, file := range data.Files {
+			itemPath := localChildPath(folderPath, file.Name)
+			if existing, found := remoteFiles[itemPath]; file.Trashed || (found && !preferRemoteItem(existing, file)) {
+				continue
+			}
+			remoteFiles[itemPath] = file
+		}
+	}
+
+	var items []ListedItem
+	for itemPath, file := range remoteFiles {
+		modTime, _ := time.Parse(time.RFC3339Nano, file.ModifiedTime)
+		item := ListedItem{Path: itemPath, DriveId: file.ID, MimeType: file.MimeType, Size: file.Size, Md5: file.Md5Checksum,
+			ModifiedTime: modTime}
+		if len(file.Owners) > 0 {
+			item.Owner = file.Owners[0].EmailAddress
+		}
+		items = append(items, item)
+	}
+	sortListedItems(items)
+	return items, nil
+}
+
+//*********************************************************
+
+// returns the id of the folder and the path its items are listed under, a path inside a base folder is followed
+// down from the base folder, anything else is taken as a Drive id and its items are listed by name
+func (service *Service) resolveRemoteFolder(ctx context.Context, folder string) (string, string, error) {
+	baseFolder, names, found := service.splitLocalPath(configNameToLocalPath(folder))
+	if !found {
+		return folder, "", nil
+	}
+
+	folderId := service.baseFolders[baseFolder]
+	folderPath := baseFolder
+	for _, name := range names {
+		data, err := service.conn.getItemsInFolders(ctx, folderPath, []string{folderId})
+		if err != nil {
+			return "", "", err
+		}
+		childId := ""
+		for _, file := range data.Files {
+			if !file.Trashed && file.MimeType == "application/vnd.google-apps.folder" && remoteNameToLocalName(file.Name) == name {
+				childId = file.ID
+				break
+			}
+		}
+		folderPath = localChildPath(folderPath, name)
+		if childId == "" {
+			return "", "", fmt.Errorf("%v is not a folder on Google Drive", folderPath)
+		}
+		folderId = childId
+	}
+	return folderId, folderPath, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// writes the items as text, csv or json
+func WriteListing(writer io.Writer, items []ListedItem, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case "csv":
+		csvWriter := csv.NewWriter(writer)
+		csvWriter.Write([]string{"path", "size", "md5", "modified_time", "owner", "drive_id", "mime_type"})
+		for _, item := range items {
+			csvWriter.Write([]string{item.Path, strconv.FormatInt(item.Size, 10), item.Md5, item.ModifiedTime.UTC().Format(time.RFC3339Nano),
+				item.Owner, item.DriveId, item.MimeType})
+		}
+		csvWriter.Flush()
+		return csvWriter.Error()
+	case "text":
+		tabs := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+		for _, item := range items {
+			size := strconv.FormatInt(item.Size, 10)
+			if item.MimeType == "application/vnd.google-apps.folder" {
+				size = "<dir>"
+			}
+			fmt.Fprintf(tabs, "%v\t%v\t%v\t%v\t%v\n", size, item.Md5, item.ModifiedTime.Local().Format("2006-01-02 15:04:05"), item.Owner, item.Path)
+		}
+		return tabs.Flush()
+	}
+	return fmt.Errorf("unknown list format %v, expected text, csv or json", format)
+}
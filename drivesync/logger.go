@@ -0,0 +1,210 @@
+package drivesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Everything the sync prints goes through a logger for the part of the engine it comes from, so each line says
+// where it's from and how important it is, for example "2006/01/02 15:04:05 WARN connection: ...". The debug lines
+// explain what the sync is doing and why and are only printed with --log-level=debug or --debug. The lines go to
+// stdout like before, so the launchd agent and the service managers pick them up the same way. log/slog would do
+// this too, but it needs Go 1.21 and the module still builds with Go 1.17.
+//
+// With --log-format=json each line is a JSON record instead, for shipping the logs to Loki or Elasticsearch and
+// alerting on the failures. The records have the time, level, module and message, the path, Drive id and bytes of
+// the file when the line is about one, and how many API calls the connection made since startup.
+//
+// Each Service has its own loggers, so in a fleet the lines of a tenant have its name, "WARN service [alice]: ..."
+// or "tenant" in the JSON records, and the API calls are counted for its own connection. The package loggers are
+// for the lines that don't belong to a service, like reading the settings.
+
+type LogLevel int32
+
+const (
+	LOG_DEBUG LogLevel = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// read from every goroutine, an embedding program may change it while the sync is running
+var logLevel int32 = int32(LOG_INFO)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+var logJson int32 // 1 with LOG_FORMAT_JSON
+
+var logOutput = log.New(os.Stdout, "", log.LstdFlags)
+var jsonLogOutput = log.New(os.Stdout, "", 0)
+
+type logger struct {
+	module   string
+	tenant   string // empty unless the service is a tenant of a fleet
+	apiCalls *int64 // the count of the connection, nil for the package loggers
+	fields   logFields
+}
+
+// what a line is about, only written to the JSON records since the text has them in the message already
+type logFields struct {
+	Path   string
+	FileId string
+	Bytes  int64
+}
+
+type logRecord struct {
+	Time         string `json:"timestamp"`
+	Level        string `json:"level"`
+	Module       string `json:"module"`
+	Tenant       string `json:"tenant,omitempty"`
+	Message      string `json:"message"`
+	Path         string `json:"path,omitempty"`
+	FileId       string `json:"fileID,omitempty"`
+	Bytes        int64  `json:"bytes,omitempty"`
+	ApiCallCount int64  `json:"apiCallCount"`
+}
+
+var serviceLog = logger{module: "service"}
+var connLog = logger{module: "connection"}
+var cleanupLog = logger{module: "cleanup"}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// only the lines at this level or above are printed, the default is LOG_INFO
+func SetLogLevel(level LogLevel) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+//*********************************************************
+
+// turns on the debug lines for the whole package, the same as SetLogLevel(LOG_DEBUG)
+func SetDebug(enabled bool) {
+	if enabled {
+		SetLogLevel(LOG_DEBUG)
+	} else if logEnabled(LOG_DEBUG) {
+		SetLogLevel(LOG_INFO)
+	}
+}
+
+//*********************************************************
+
+// LOG_FORMAT_TEXT, the default, or LOG_FORMAT_JSON
+func SetLogFormat(format string) error {
+	switch format {
+	case LOG_FORMAT_TEXT:
+		atomic.StoreInt32(&logJson, 0)
+	case LOG_FORMAT_JSON:
+		atomic.StoreInt32(&logJson, 1)
+	default:
+		return fmt.Errorf("unknown log format %v, should be %v or %v", format, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	}
+	return nil
+}
+
+//*********************************************************
+
+// the level for debug, info, warn or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range logLevelNames {
+		if strings.EqualFold(strings.TrimSpace(name), levelName) {
+			return LogLevel(level), nil
+		}
+	}
+	return LOG_INFO, fmt.Errorf("unknown log level %v, should be %v", name, strings.Join(logLevelNames, ", "))
+}
+
+//*********************************************************
+
+func (level LogLevel) String() string {
+	if level < LOG_DEBUG || level > LOG_ERROR {
+		return "unknown"
+	}
+	return logLevelNames[level]
+}
+
+//*********************************************************
+
+// true if the lines at this level are printed, for the debug output that takes some work to put together
+func logEnabled(level LogLevel) bool {
+	return LogLevel(atomic.LoadInt32(&logLevel)) <= level
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the arguments are put together like fmt.Println and the formats like fmt.Printf
+
+func (l logger) Debug(args ...interface{}) { l.print(LOG_DEBUG, fmt.Sprintln(args...)) }
+func (l logger) Info(args ...interface{})  { l.print(LOG_INFO, fmt.Sprintln(args...)) }
+func (l logger) Warn(args ...interface{})  { l.print(LOG_WARN, fmt.Sprintln(args...)) }
+func (l logger) Error(args ...interface{}) { l.print(LOG_ERROR, fmt.Sprintln(args...)) }
+
+func (l logger) Debugf(format string, args ...interface{}) {
+	l.print(LOG_DEBUG, fmt.Sprintf(format, args...))
+}
+func (l logger) Infof(format string, args ...interface{}) {
+	l.print(LOG_INFO, fmt.Sprintf(format, args...))
+}
+func (l logger) Warnf(format string, args ...interface{}) {
+	l.print(LOG_WARN, fmt.Sprintf(format, args...))
+}
+func (l logger) Errorf(format string, args ...interface{}) {
+	l.print(LOG_ERROR, fmt.Sprintf(format, args...))
+}
+
+//*********************************************************
+
+// the logger for a line about one file
+func (l logger) with(fields logFields) logger {
+	l.fields = fields
+	return l
+}
+
+//*********************************************************
+
+// the logger for the lines of one service, with its tenant and the API call count of its connection
+func (l logger) forService(tenant string, apiCalls *int64) logger {
+	l.tenant = tenant
+	l.apiCalls = apiCalls
+	return l
+}
+
+//*********************************************************
+
+func (l logger) print(level LogLevel, message string) {
+	if !logEnabled(level) {
+		return
+	}
+	if atomic.LoadInt32(&logJson) == 1 {
+		var apiCalls int64
+		if l.apiCalls != nil {
+			apiCalls = atomic.LoadInt64(l.apiCalls)
+		}
+		data, err := json.Marshal(logRecord{Time: time.Now().Format(time.RFC3339Nano), Level: level.String(), Module: l.module,
+			Tenant: l.tenant, Message: strings.TrimSpace(message), Path: l.fields.Path, FileId: l.fields.FileId,
+			Bytes: l.fields.Bytes, ApiCallCount: apiCalls})
+		if err == nil {
+			jsonLogOutput.Print(string(data))
+			return
+		}
+	}
+	module := l.module
+	if l.tenant != "" {
+		module += " [" + l.tenant + "]"
+	}
+	logOutput.Print(strings.ToUpper(level.String()) + " " + module + ": " + strings.TrimLeft(message, "\n"))
+}
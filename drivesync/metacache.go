@@ -0,0 +1,171 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Looking for the remote copies of the changed local files lists every folder on their paths, which is one or more
+// requests per level of folders on every cycle with an upload. The metadata cache keeps what those listings found
+// in config/metadata-cache.json, by id, and before each lookup it reads the changes on Google Drive since the last
+// one and applies them, so a folder that was listed once is served from the cache after that. A folder is only
+// served from the cache once it was listed, the changes alone can't tell whether a folder that was moved in from
+// elsewhere has all of its children. The cache has its own changes token, it's independent of the sync's, and it's
+// started over whenever its changes token expires and when the state is rebuilt.
+
+const METADATA_CACHE_FILE_NAME = "config/metadata-cache.json"
+
+type metadataCache struct {
+	PageToken string                  `json:"pageToken"` // the changes after this have not been applied yet
+	Items     map[string]FileMetaData `json:"items"`     // key = id
+	Listed    map[string]bool         `json:"listed"`    // key = folder id, all of its children are in Items
+
+	loaded bool
+	dirty  bool
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// brings the cache up to date with the changes on Google Drive, it's started over if that's not possible
+func (service *Service) refreshMetadataCache(ctx context.Context) {
+	cache := &service.metadataCache
+	if !cache.loaded {
+		service.loadMetadataCache()
+	}
+
+	if cache.PageToken != "" {
+		files, removedIds, pageToken, err := service.conn.getChanges(ctx, cache.PageToken)
+		if err == nil {
+			for _, file := range files {
+				cache.Items[file.ID] = file
+			}
+			for _, id := range removedIds {
+				delete(cache.Items, id)
+				delete(cache.Listed, id)
+			}
+			cache.dirty = cache.dirty || pageToken != cache.PageToken
+			cache.PageToken = pageToken
+			return
+		}
+		if !errors.Is(err, ErrExpired) {
+			service.log.Warn("failed to read the changes for the metadata cache, starting it over:", err)
+		}
+	}
+
+	// the token is taken before the folders are listed, so nothing that changes during the listings is missed
+	service.resetMetadataCache()
+	pageToken, err := service.conn.getStartPageToken(ctx)
+	if err != nil {
+		service.log.Warn("failed to start the metadata cache:", err)
+		return
+	}
+	cache.PageToken = pageToken
+}
+
+//*********************************************************
+
+// lists the folders, the ones that were listed before come from the cache and the rest from Google Drive
+func (service *Service) listFolders(ctx context.Context, folderIds []string) (ListFilesResponse, error) {
+	cache := &service.metadataCache
+	if !service.settings.MetadataCache || cache.PageToken == "" {
+		return service.conn.getItemsInFolders(ctx, "(combined query)", folderIds)
+	}
+
+	var toList []string
+	requested := make(map[string]bool)
+	for _, folderId := range folderIds {
+		if cache.Listed[folderId] {
+			requested[folderId] = true
+		} else {
+			toList = append(toList, folderId)
+		}
+	}
+
+	var data ListFilesResponse
+	if len(toList) > 0 {
+		var err error
+		data, err = service.conn.getItemsInFolders(ctx, "(combined query)", toList)
+		if err != nil {
+			return data, err
+		}
+		for _, file := range data.Files {
+			cache.Items[file.ID] = file
+		}
+		for _, folderId := range toList {
+			cache.Listed[folderId] = true
+		}
+		cache.dirty = true
+	}
+
+	if len(requested) > 0 {
+		for _, id := range sortedMetadataKeys(cache.Items) {
+			file := cache.Items[id]
+			for _, parentId := range file.Parents {
+				if requested[parentId] {
+					data.Files = append(data.Files, file)
+					break
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) resetMetadataCache() {
+	service.metadataCache = metadataCache{
+		Items:  make(map[string]FileMetaData),
+		Listed: make(map[string]bool),
+		loaded: true,
+		dirty:  true,
+	}
+}
+
+//*********************************************************
+
+func (service *Service) loadMetadataCache() {
+	service.resetMetadataCache()
+	service.metadataCache.dirty = false
+
+	fileName := service.configFile(METADATA_CACHE_FILE_NAME)
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return // there's no cache yet
+	}
+	var cache metadataCache
+	err = json.Unmarshal(data, &cache)
+	if err != nil || cache.Items == nil || cache.Listed == nil {
+		service.log.Warn("ignoring the damaged", fileName, err)
+		return
+	}
+	cache.loaded = true
+	service.metadataCache = cache
+}
+
+//*********************************************************
+
+func (service *Service) saveMetadataCache() {
+	if !service.metadataCache.dirty {
+		return
+	}
+	data, err := json.Marshal(service.metadataCache)
+	if err != nil {
+		service.log.Warn("failed to save the metadata cache:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(METADATA_CACHE_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the metadata cache:", err)
+		return
+	}
+	service.metadataCache.dirty = false
+}
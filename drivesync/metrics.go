@@ -0,0 +1,193 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const DEFAULT_METRICS_ADDRESS = "localhost:6060"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// serves /metrics, /status, optionally /debug/pprof/ so memory growth on very large trees can be diagnosed, and
+// optionally the control api at /api/, the server is shut down when the context is cancelled
+func (service *Service) StartMetricsServer(ctx context.Context) {
+	startMetricsServer(ctx, service.settings, service.handleMetrics, service.handleStatus, service.controlApi())
+}
+
+//*********************************************************
+
+// the control api is nil when there's no single sync to control
+func startMetricsServer(ctx context.Context, settings Settings, handleMetrics http.HandlerFunc, handleStatus http.HandlerFunc,
+	controlApi http.Handler) {
+	address := settings.MetricsAddress
+	if address == "" {
+		if !settings.EnablePprof && !settings.ControlApi {
+			return
+		}
+		address = DEFAULT_METRICS_ADDRESS
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+
+	// the status api shows the paths of the user's files, so only serve it on localhost
+	if isLoopbackAddress(address) {
+		mux.HandleFunc("/status", handleStatus)
+	}
+
+	if settings.EnablePprof {
+		// the profiles expose a lot about the process, so never serve them on anything but localhost
+		if isLoopbackAddress(address) {
+			mux.HandleFunc("/debug/pprof/", pprof.Index)
+			mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+			mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+			mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+			mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		} else {
+			serviceLog.Warn("pprof is only allowed on localhost, not enabling it on", address)
+		}
+	}
+
+	if settings.ControlApi {
+		// anyone who can reach the api can pause the sync, so it's localhost only like the profiles
+		if controlApi == nil {
+			serviceLog.Warn("the control api is only available for a single sync, not enabling it")
+		} else if isLoopbackAddress(address) {
+			mux.Handle("/api/", controlApi)
+		} else {
+			serviceLog.Warn("the control api is only allowed on localhost, not enabling it on", address)
+		}
+	}
+
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		serviceLog.Info("serving metrics on", address)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			serviceLog.Warn("metrics server stopped:", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func isLoopbackAddress(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// one value in the Prometheus text format
+type metric struct {
+	name       string
+	metricType string
+	help       string
+	value      float64
+}
+
+//*********************************************************
+
+// writes the stats in the Prometheus text format
+func (service *Service) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeServiceMetrics(w, []*Service{service})
+	writeMetrics(w, processMetrics())
+}
+
+//*********************************************************
+
+// the metrics that each tenant of a fleet has its own copy of
+func (service *Service) serviceMetrics() []metric {
+	return []metric{
+		{"gdfdl_api_calls_total", "counter", "Drive API calls made since startup", float64(service.conn.getNumApiCalls())},
+		{"gdfdl_rate_limited_total", "counter", "Drive API responses that were rate limited since startup", float64(atomic.LoadInt64(&service.conn.rateLimitedTotal))},
+		{"gdfdl_stuck_cycles_total", "counter", "sync cycles that ran longer than max_cycle_minutes and were cancelled", float64(atomic.LoadInt64(&service.stuckCycles))},
+		{"gdfdl_throttle_level", "gauge", "how many times the sync interval was doubled because of rate limits", float64(atomic.LoadInt64(&service.throttleLevel))},
+		{"gdfdl_watched_directories", "gauge", "local directories watched for changes", float64(atomic.LoadInt64(&service.localWatch.watchedDirs))},
+	}
+}
+
+//*********************************************************
+
+// the metrics of the whole process, shared by all the tenants of a fleet
+func processMetrics() []metric {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var lastPause time.Duration
+	if mem.NumGC > 0 {
+		lastPause = time.Duration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+
+	return []metric{
+		{"gdfdl_goroutines", "gauge", "number of goroutines", float64(runtime.NumGoroutine())},
+		{"gdfdl_heap_alloc_bytes", "gauge", "bytes of allocated heap objects", float64(mem.HeapAlloc)},
+		{"gdfdl_heap_inuse_bytes", "gauge", "bytes in in-use heap spans", float64(mem.HeapInuse)},
+		{"gdfdl_heap_objects", "gauge", "number of allocated heap objects", float64(mem.HeapObjects)},
+		{"gdfdl_sys_bytes", "gauge", "bytes of memory obtained from the OS", float64(mem.Sys)},
+		{"gdfdl_gc_runs_total", "counter", "completed GC cycles", float64(mem.NumGC)},
+		{"gdfdl_gc_pause_seconds_total", "counter", "total time spent in GC pauses", time.Duration(mem.PauseTotalNs).Seconds()},
+		{"gdfdl_gc_last_pause_seconds", "gauge", "duration of the most recent GC pause", lastPause.Seconds()},
+	}
+}
+
+//*********************************************************
+
+// each metric is written once with a tenant label for each service, or without a label for a single service
+func writeServiceMetrics(w http.ResponseWriter, services []*Service) {
+	var values [][]metric
+	for _, service := range services {
+		values = append(values, service.serviceMetrics())
+	}
+	if len(values) == 0 {
+		return
+	}
+
+	for i, first := range values[0] {
+		fmt.Fprintf(w, "# HELP %v %v\n", first.name, first.help)
+		fmt.Fprintf(w, "# TYPE %v %v\n", first.name, first.metricType)
+		for j, service := range services {
+			labels := ""
+			if service.tenant.Name != "" {
+				labels = "{tenant=" + strconv.Quote(service.tenant.Name) + "}"
+			}
+			fmt.Fprintf(w, "%v%v %v\n", first.name, labels, values[j][i].value)
+		}
+	}
+}
+
+//*********************************************************
+
+func writeMetrics(w http.ResponseWriter, metrics []metric) {
+	for _, m := range metrics {
+		fmt.Fprintf(w, "# HELP %v %v\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %v %v\n", m.name, m.metricType)
+		fmt.Fprintf(w, "%v %v\n", m.name, m.value)
+	}
+}
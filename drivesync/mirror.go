@@ -0,0 +1,160 @@
+package drivesync
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// With mirror=true the sync only publishes Google Drive to this computer, for handing the same files out to many
+// machines. Nothing is ever uploaded, a local change is put back from Google Drive by a full reconciliation that
+// compares the md5 of every file, and after each sync that ends up the same as Google Drive a stamp is written
+// listing the md5 of every file. The stamp is signed with a key kept in config/mirror-key, so the automation that
+// consumes the folders can check the stamp with config/mirror-key.pub and the files with the stamp first.
+
+const MIRROR_KEY_FILE_NAME = "config/mirror-key"
+const MIRROR_PUBLIC_KEY_FILE_NAME = "config/mirror-key.pub"
+
+// the first line of a stamp, changes if the format ever does
+const MIRROR_STAMP_HEADER = "google-drive-mirror-stamp v1"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// local changes are not uploaded, they're put back by a full reconciliation which is started right away, the stamp
+// is not written again until then, during the reconciliation itself every file looks changed so they're just dropped
+func (service *Service) ignoreLocalChanges(reconciling bool) {
+	if !reconciling {
+		service.log.Info("mirror=true, not uploading", len(service.filesToUpload), "local changes, they will be put back from Google Drive")
+		service.mirrorDirty = true
+		service.setReconcileTime(time.Time{})
+	}
+	for localPath := range service.filesToUpload {
+		delete(service.filesToUpload, localPath)
+	}
+}
+
+//*********************************************************
+
+// called after a verified cycle, writes the stamp if anything changed, reconciled is true after a full
+// reconciliation which is the only time the local changes are known to be undone
+func (service *Service) updateMirrorStamp(reconciled bool) {
+	if reconciled {
+		service.mirrorDirty = false
+	}
+	if service.mirrorDirty {
+		return
+	}
+	_, err := os.Stat(service.settings.MirrorStampFile)
+	if err == nil && !reconciled && service.cycle.isEmpty() {
+		return // nothing changed since the last stamp
+	}
+
+	err = service.writeMirrorStamp()
+	if err != nil {
+		service.log.Warn("failed to write the mirror stamp:", err)
+	}
+}
+
+//*********************************************************
+
+// the stamp is plain text so a script can read it, one line for each file: md5, size and the path with /
+func (service *Service) writeMirrorStamp() error {
+	privateKey, err := service.loadMirrorKey()
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, localPath := range sortedPaths(service.localFiles) {
+		fileInfo, err := service.fileSystem.Stat(localPath)
+		if err == nil && !fileInfo.IsDir() {
+			paths = append(paths, localPath)
+		}
+	}
+	md5s := service.hashFiles(paths)
+
+	lines := []string{
+		MIRROR_STAMP_HEADER,
+		"synced " + service.clock.Now().UTC().Format(time.RFC3339),
+		"machine " + service.settings.MachineId,
+	}
+	for _, localPath := range paths {
+		fileInfo, err := service.fileSystem.Stat(localPath)
+		if err != nil {
+			return fmt.Errorf("%v changed while writing the stamp: %w", localPath, err)
+		}
+		lines = append(lines, fmt.Sprintf("%v %v %v", md5s[localPath], fileInfo.Size(), filepath.ToSlash(localPath)))
+	}
+	stamp := []byte(strings.Join(lines, "\n") + "\n")
+	signature := ed25519.Sign(privateKey, stamp)
+
+	// the signature is written first, so a stamp is never next to the signature of the one before it for long
+	err = writeSharedFileAtomically(service.settings.MirrorStampFile+".sig", signature)
+	if err == nil {
+		err = writeSharedFileAtomically(service.settings.MirrorStampFile, stamp)
+	}
+	if err == nil {
+		service.log.Info("wrote the mirror stamp for", len(paths), "files to", service.settings.MirrorStampFile)
+	}
+	return err
+}
+
+//*********************************************************
+
+// the signing key is made the first time, the public key is saved next to it in PEM for the downstream machines
+func (service *Service) loadMirrorKey() (ed25519.PrivateKey, error) {
+	fileName := service.configFile(MIRROR_KEY_FILE_NAME)
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("no PEM key in " + fileName)
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the key in %v: %w", fileName, err)
+		}
+		privateKey, isEd25519 := key.(ed25519.PrivateKey)
+		if !isEd25519 {
+			return nil, errors.New("the key in " + fileName + " is not an ed25519 key")
+		}
+		return privateKey, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	privateBytes, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	publicBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateBytes}), 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(service.configFile(MIRROR_PUBLIC_KEY_FILE_NAME), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicBytes}), 0644)
+	if err != nil {
+		return nil, err
+	}
+	service.log.Info("made a new key for signing the mirror stamp, the downstream machines check it with", service.configFile(MIRROR_PUBLIC_KEY_FILE_NAME))
+	return privateKey, nil
+}
@@ -0,0 +1,193 @@
+package drivesync
+
+import (
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The live state of a running sync for the monitor command, what it's doing right now, the file being
+// transferred, and the most recent transfers and conflicts. The monitor can also pause the sync and start a
+// cycle early.
+
+// how many of the most recent transfers and conflicts are kept
+const MAX_MONITOR_HISTORY = 50
+
+type MonitorSnapshot struct {
+	Paused      bool
+	Activity    string    // what the sync is doing, like "uploading", empty while it waits for the next cycle
+	Transfer    string    // the file being transferred, like "uploading 3 of 10: folder/file.txt"
+	Queued      int       // the transfers of the running cycle that haven't started yet
+	LastCycleAt time.Time // when the last cycle finished, zero before the first one
+	VerifiedAt  time.Time // everything older than this was in sync when the state was last verified
+
+	PendingUploads   int
+	PendingDownloads int
+
+	Active           []TransferProgress // the uploads and downloads that are running
+	Pending          []string
+	Errors           map[string]string // key = local path, value = the last error for that file
+	PendingDeletions []PendingDeletion
+
+	Transfers []string // the most recent uploads and downloads, newest last
+	Conflicts []string // the most recent conflict copies, newest last
+	Failures  []string // the most recent cycles that failed, newest last
+}
+
+type monitorState struct {
+	mutex       sync.Mutex
+	paused      bool
+	activity    string
+	transfer    string
+	queued      int
+	lastCycleAt time.Time
+	verifiedAt  time.Time
+	uploads     int
+	downloads   int
+	transfers   []string
+	conflicts   []string
+	failures    []string
+
+	syncNow chan struct{} // wakes up Run before the sync interval is over
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) setActivity(activity string) {
+	service.monitor.mutex.Lock()
+	service.monitor.activity = activity
+	service.monitor.mutex.Unlock()
+}
+
+//*********************************************************
+
+// the queued are the transfers after this one that haven't started yet
+func (service *Service) setTransfer(transfer string, queued int) {
+	service.monitor.mutex.Lock()
+	service.monitor.transfer = transfer
+	service.monitor.queued = queued
+	service.monitor.mutex.Unlock()
+}
+
+//*********************************************************
+
+// keeps what the cycle synced for the monitor, called before the cycle summary is sent and cleared
+func (service *Service) recordCycle() {
+	service.monitor.mutex.Lock()
+	defer service.monitor.mutex.Unlock()
+
+	service.monitor.lastCycleAt = service.clock.Now()
+	for _, localPath := range service.cycle.uploaded {
+		service.monitor.transfers = append(service.monitor.transfers, "uploaded: "+localPath)
+	}
+	for _, localPath := range service.cycle.downloaded {
+		service.monitor.transfers = append(service.monitor.transfers, "downloaded: "+localPath)
+	}
+	service.monitor.conflicts = append(service.monitor.conflicts, service.cycle.conflicts...)
+
+	if len(service.monitor.transfers) > MAX_MONITOR_HISTORY {
+		service.monitor.transfers = service.monitor.transfers[len(service.monitor.transfers)-MAX_MONITOR_HISTORY:]
+	}
+	if len(service.monitor.conflicts) > MAX_MONITOR_HISTORY {
+		service.monitor.conflicts = service.monitor.conflicts[len(service.monitor.conflicts)-MAX_MONITOR_HISTORY:]
+	}
+}
+
+//*********************************************************
+
+// keeps the size of the queues between the cycles, called when the status is published
+func (service *Service) recordQueues() {
+	service.monitor.mutex.Lock()
+	service.monitor.verifiedAt = service.verifiedAt
+	service.monitor.uploads = len(service.filesToUpload)
+	service.monitor.downloads = len(service.filesToDownload)
+	service.monitor.mutex.Unlock()
+}
+
+//*********************************************************
+
+// keeps a cycle that failed as a whole, the errors of single files are in the status
+func (service *Service) recordFailure(err error) {
+	service.monitor.mutex.Lock()
+	defer service.monitor.mutex.Unlock()
+
+	failure := service.clock.Now().Local().Format("2006-01-02 15:04:05") + ": " + err.Error()
+	service.monitor.failures = append(service.monitor.failures, failure)
+	if len(service.monitor.failures) > MAX_MONITOR_HISTORY {
+		service.monitor.failures = service.monitor.failures[len(service.monitor.failures)-MAX_MONITOR_HISTORY:]
+	}
+}
+
+//*********************************************************
+
+func (service *Service) isPaused() bool {
+	service.monitor.mutex.Lock()
+	defer service.monitor.mutex.Unlock()
+	return service.monitor.paused
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a paused sync skips its cycles until it's resumed, a transfer that is already running is finished first
+func (service *Service) Pause() {
+	service.monitor.mutex.Lock()
+	service.monitor.paused = true
+	service.monitor.mutex.Unlock()
+}
+
+//*********************************************************
+
+func (service *Service) Resume() {
+	service.monitor.mutex.Lock()
+	service.monitor.paused = false
+	service.monitor.mutex.Unlock()
+	service.SyncNow()
+}
+
+//*********************************************************
+
+// starts the next cycle now instead of at the end of the sync interval, does nothing while paused
+func (service *Service) SyncNow() {
+	select {
+	case service.monitor.syncNow <- struct{}{}:
+	default:
+		// a cycle is already waiting to start
+	}
+}
+
+//*********************************************************
+
+// safe to call from another goroutine while Run is going
+func (service *Service) MonitorSnapshot() MonitorSnapshot {
+	var snapshot MonitorSnapshot
+
+	service.statusMutex.Lock()
+	snapshot.Pending = append([]string{}, service.status.Pending...)
+	snapshot.Errors = make(map[string]string)
+	for localPath, message := range service.status.Errors {
+		snapshot.Errors[localPath] = message
+	}
+	snapshot.PendingDeletions = append([]PendingDeletion{}, service.status.PendingDeletions...)
+	service.statusMutex.Unlock()
+
+	service.monitor.mutex.Lock()
+	snapshot.Paused = service.monitor.paused
+	snapshot.Activity = service.monitor.activity
+	snapshot.Transfer = service.monitor.transfer
+	snapshot.Queued = service.monitor.queued
+	snapshot.LastCycleAt = service.monitor.lastCycleAt
+	snapshot.VerifiedAt = service.monitor.verifiedAt
+	snapshot.PendingUploads = service.monitor.uploads
+	snapshot.PendingDownloads = service.monitor.downloads
+	snapshot.Transfers = append([]string{}, service.monitor.transfers...)
+	snapshot.Conflicts = append([]string{}, service.monitor.conflicts...)
+	snapshot.Failures = append([]string{}, service.monitor.failures...)
+	service.monitor.mutex.Unlock()
+
+	snapshot.Active = service.activeTransfers()
+	return snapshot
+}
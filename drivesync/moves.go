@@ -0,0 +1,225 @@
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A renamed or moved local file looks like a file that disappeared and a new file that appeared. If the new
+// file has the same size and md5 as a file that disappeared, the file on Google Drive is renamed and moved
+// instead of uploading the whole file again and leaving the old one behind.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// remembers that a local path is gone, along with everything that was inside it if it was a folder
+func (service *Service) localPathMissing(path string) {
+	if !service.localFiles[path] {
+		return
+	}
+	if _, err := service.fileSystem.Stat(path); err == nil {
+		return
+	}
+	for knownPath := range service.localFiles {
+		if localPathIsInside(path, knownPath) {
+			service.missingLocalFiles[knownPath] = true
+		}
+	}
+}
+
+//*********************************************************
+
+// a file that disappeared and wasn't found somewhere else is left alone, like before
+func (service *Service) forgetMissingLocalFiles() {
+	if len(service.missingLocalFiles) > 0 {
+		service.missingLocalFiles = make(map[string]bool)
+	}
+}
+
+//*********************************************************
+
+// returns the files that disappeared and are still on Google Drive, key = size, then md5, value = the old local path
+func (service *Service) movedFileCandidates() map[int64]map[string]string {
+	candidates := make(map[int64]map[string]string)
+	for _, localPath := range sortedPaths(service.missingLocalFiles) {
+		remoteFileData, existsOnServer := service.uploadLookupMap[localPath]
+		if !existsOnServer || remoteFileData.Md5Checksum == "" || remoteFileData.Trashed || strings.Contains(remoteFileData.MimeType, "folder") {
+			continue
+		}
+		if _, err := service.fileSystem.Stat(localPath); err == nil {
+			continue // it came back
+		}
+
+		sameSize, found := candidates[remoteFileData.Size]
+		if !found {
+			sameSize = make(map[string]string)
+			candidates[remoteFileData.Size] = sameSize
+		}
+		if _, taken := sameSize[remoteFileData.Md5Checksum]; !taken {
+			sameSize[remoteFileData.Md5Checksum] = localPath
+		}
+	}
+	return candidates
+}
+
+//*********************************************************
+
+// returns the old local path if the new file is one that disappeared, the md5 is only calculated when
+// a file of the same size disappeared, each old file can only be matched once
+func (service *Service) findMovedFile(candidates map[int64]map[string]string, localPath string, localFileInfo os.FileInfo) (string, bool) {
+	sameSize, found := candidates[localFileInfo.Size()]
+	if !found {
+		return "", false
+	}
+
+	localMd5 := service.getMd5OfFile(localPath)
+	fromPath, found := sameSize[localMd5]
+	if found {
+		delete(sameSize, localMd5)
+	}
+	return fromPath, found
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// renames and moves the remote file to match the local file, the contents are already the same
+func (service *Service) handleMove(ctx context.Context, action Action) error {
+	oldParent, oldParentInMap := service.uploadLookupMap[filepath.Dir(action.FromPath)]
+	newParent, newParentInMap := service.uploadLookupMap[filepath.Dir(action.LocalPath)]
+	if !oldParentInMap || !newParentInMap {
+		// we'll try again next time
+		return errors.New("parent not in map yet")
+	}
+
+	request := MoveFileRequest{Name: action.LocalInfo.Name(), ModifiedTime: action.LocalInfo.ModTime().Format(time.RFC3339Nano)}
+	moved, err := service.conn.moveFile(ctx, action.Remote.ID, oldParent.ID, newParent.ID, request)
+	if err != nil {
+		return err
+	}
+	service.log.with(logFields{Path: action.LocalPath, FileId: moved.ID}).Info("moved", action.FromPath, "to", action.LocalPath, "on Google Drive instead of uploading it again")
+
+	if moved.ID == "" {
+		moved = action.Remote
+	}
+	service.uploadLookupMap[action.LocalPath] = moved
+	service.rememberRemoteId(action.LocalPath, moved.ID)
+	delete(service.uploadLookupMap, action.FromPath)
+	delete(service.missingLocalFiles, action.FromPath)
+	delete(service.localFiles, action.FromPath)
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A renamed or moved remote item shows up as a change with a new path. The id is looked up in remoteIds to
+// find where it was synced to before, and the local file or folder is moved there instead of downloading a
+// second copy and leaving the old one behind.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// remembers where a remote item is on the local side, once both sides are known to match
+func (service *Service) rememberRemoteId(localPath string, id string) {
+	if id != "" {
+		service.remoteIds[id] = localPath
+	}
+}
+
+//*********************************************************
+
+// returns the local path that a remote item was synced to before it was renamed or moved on Google Drive
+func (service *Service) findRemotelyMovedItem(localPath string, remoteFileInfo FileMetaData) (string, bool) {
+	fromPath, known := service.remoteIds[remoteFileInfo.ID]
+	if !known || fromPath == localPath || localPathIsInside(fromPath, localPath) {
+		return "", false
+	}
+	if _, err := service.fileSystem.Stat(localPath); err == nil {
+		return "", false // something is already there
+	}
+
+	// the old path might belong to another remote item by now
+	if other, found := service.downloadLookupMap[fromPath]; found && other.ID != remoteFileInfo.ID {
+		return "", false
+	}
+
+	fromInfo, err := service.fileSystem.Stat(fromPath)
+	if err != nil {
+		return "", false
+	}
+	if strings.Contains(remoteFileInfo.MimeType, "folder") {
+		return fromPath, fromInfo.IsDir()
+	}
+	if fromInfo.IsDir() {
+		return "", false
+	}
+
+	// a file that was changed locally since it was synced is left where it is so the changes can be uploaded,
+	// otherwise it's moved even if the contents changed on Google Drive too, they are downloaded after the move
+	unchanged := !fromInfo.ModTime().After(service.verifiedAt) || service.getMd5OfFile(fromPath) == remoteFileInfo.Md5Checksum
+	return fromPath, unchanged
+}
+
+//*********************************************************
+
+// moves the local file or folder to where it is now on Google Drive, along with everything inside it
+func (service *Service) handleLocalMove(action Action) error {
+	// a folder that was moved earlier in the plan could have brought it along already
+	fromPath := service.remoteIds[action.Remote.ID]
+	if fromPath == action.LocalPath {
+		return nil
+	}
+
+	err := service.fileSystem.Rename(fromPath, action.LocalPath)
+	if err != nil {
+		return err
+	}
+	service.log.with(logFields{Path: action.LocalPath, FileId: action.Remote.ID}).Info("moved", fromPath, "to", action.LocalPath, "because it was renamed or moved on Google Drive")
+
+	// save the new paths so we aren't surprised later that they appeared
+	for _, oldPath := range sortedPaths(service.localFiles) {
+		if localPathIsInside(fromPath, oldPath) {
+			delete(service.localFiles, oldPath)
+			service.localFiles[movedPath(oldPath, fromPath, action.LocalPath)] = true
+		}
+	}
+	for id, oldPath := range service.remoteIds {
+		if localPathIsInside(fromPath, oldPath) {
+			service.remoteIds[id] = movedPath(oldPath, fromPath, action.LocalPath)
+		}
+	}
+	service.localFiles[action.LocalPath] = true
+	return nil
+}
+
+//*********************************************************
+
+// a folder that was moved earlier in the plan brings the files inside it along, so they don't need to be downloaded
+func (service *Service) alreadyMovedHere(action Action) bool {
+	if action.Remote.Md5Checksum == "" {
+		return false
+	}
+	if _, err := service.fileSystem.Stat(action.LocalPath); err != nil {
+		return false
+	}
+	return service.getMd5OfFile(action.LocalPath) == action.Remote.Md5Checksum
+}
+
+//*********************************************************
+
+// returns where a path ends up when the folder it's in is moved
+func movedPath(path string, fromPath string, toPath string) string {
+	relativePath, err := filepath.Rel(fromPath, path)
+	if err != nil || relativePath == "." {
+		return toPath
+	}
+	return filepath.Join(toPath, relativePath)
+}
@@ -0,0 +1,285 @@
+package drivesync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the most file names listed in the message of a cycle notification
+const MAX_NOTIFY_DETAILS = 20
+
+// how many cycles in a row have to end without verifying before the alert is sent
+const VERIFY_FAILURES_TO_NOTIFY = 3
+
+// how long a webhook has to answer
+const WEBHOOK_TIMEOUT = 30 * time.Second
+
+const (
+	WEBHOOK_FORMAT_JSON  = "json"  // {"app", "host", "tenant", "time", "title", "message"}
+	WEBHOOK_FORMAT_SLACK = "slack" // {"text"} for a Slack incoming webhook
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a channel that tells the user about something that happened while running unattended
+type Notifier interface {
+	Notify(ctx context.Context, title string, message string) error
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// satisfies the Notifier interface, runs a user defined command with the title and message as the last two arguments
+type commandNotifier struct {
+	command []string
+}
+
+func (notifier *commandNotifier) Notify(ctx context.Context, title string, message string) error {
+	var args []string
+	args = append(args, notifier.command[1:]...)
+	args = append(args, title, message)
+	return exec.CommandContext(ctx, notifier.command[0], args...).Run()
+}
+
+//*********************************************************
+
+// satisfies the Notifier interface, shows a notification on the desktop with what the OS has for it, a toast on
+// Windows, the Notification Center on macOS and libnotify elsewhere
+type desktopNotifier struct{}
+
+func (desktopNotifier) Notify(ctx context.Context, title string, message string) error {
+	return showDesktopNotification(ctx, title, message)
+}
+
+//*********************************************************
+
+// satisfies the Notifier interface, posts the notification as json to a url, so it can go to a chat room, an
+// incident tool or anything else that takes a webhook
+type webhookNotifier struct {
+	url    string
+	format string
+	host   string
+	tenant string
+}
+
+type webhookPayload struct {
+	App     string    `json:"app"`
+	Host    string    `json:"host"`
+	Tenant  string    `json:"tenant,omitempty"` // the user of a fleet
+	Time    time.Time `json:"time"`
+	Title   string    `json:"title"`
+	Message string    `json:"message"`
+}
+
+func (notifier *webhookNotifier) Notify(ctx context.Context, title string, message string) error {
+	var payload interface{} = webhookPayload{App: APP_NAME, Host: notifier.host, Tenant: notifier.tenant, Time: time.Now().UTC(),
+		Title: title, Message: message}
+	if notifier.format == WEBHOOK_FORMAT_SLACK {
+		from := notifier.host
+		if notifier.tenant != "" {
+			from = notifier.tenant + " on " + from
+		}
+		payload = map[string]string{"text": fmt.Sprintf("*%v* (%v)\n%v", title, from, message)}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, WEBHOOK_TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notifier.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("the webhook answered with status %v", response.StatusCode)
+	}
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) initializeNotifiers() {
+	if len(service.settings.NotifyCommand) > 0 {
+		command := strings.Fields(service.settings.NotifyCommand)
+		service.notifiers = append(service.notifiers, &commandNotifier{command: command})
+	}
+	if service.settings.DesktopNotifications {
+		if err := desktopNotificationsAvailable(); err != nil {
+			service.log.Warn("desktop notifications are not available:", err)
+		} else {
+			service.alertNotifiers = append(service.alertNotifiers, desktopNotifier{})
+		}
+	}
+	if service.settings.NotifyWebhook != "" {
+		host, _ := os.Hostname()
+		service.alertNotifiers = append(service.alertNotifiers, &webhookNotifier{url: service.settings.NotifyWebhook,
+			format: service.settings.NotifyWebhookFormat, host: host, tenant: service.tenant.Name})
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// sends the message to every notification channel, a failing channel doesn't stop the others,
+// the sync itself only notifies once per cycle through notifyCycle so the user isn't flooded, a notification
+// isn't part of the cycle so it still goes out when the cycle was cancelled
+func (service *Service) notify(title string, message string) {
+	for _, notifier := range service.notifiers {
+		err := notifier.Notify(context.Background(), title, message)
+		if err != nil {
+			service.log.Warn("failed to send notification:", err)
+		}
+	}
+}
+
+//*********************************************************
+
+// like notify, but for something that needs the user's attention, so it's also shown on the desktop and posted to
+// the webhook, a popup or a message for every cycle that synced something would be too much for a sync that runs
+// all day
+func (service *Service) alert(title string, message string) {
+	service.notify(title, message)
+	for _, notifier := range service.alertNotifiers {
+		err := notifier.Notify(context.Background(), title, message)
+		if err != nil {
+			service.log.Warn("failed to send the alert:", err)
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// what one sync cycle did, sent as one notification instead of one per file
+type cycleSummary struct {
+	uploaded   []string
+	downloaded []string
+	conflicts  []string // the conflict copies that were made
+}
+
+func (summary cycleSummary) isEmpty() bool {
+	return len(summary.uploaded) == 0 && len(summary.downloaded) == 0 && len(summary.conflicts) == 0
+}
+
+//*********************************************************
+
+// the title has the counts, like "Synced 3 uploads, 1 download, 1 conflict"
+func (summary cycleSummary) title() string {
+	var parts []string
+	if len(summary.uploaded) > 0 {
+		parts = append(parts, plural(len(summary.uploaded), "upload"))
+	}
+	if len(summary.downloaded) > 0 {
+		parts = append(parts, plural(len(summary.downloaded), "download"))
+	}
+	if len(summary.conflicts) > 0 {
+		parts = append(parts, plural(len(summary.conflicts), "conflict"))
+	}
+	return "Synced " + strings.Join(parts, ", ")
+}
+
+//*********************************************************
+
+// the message lists the files one per line, conflicts first since they need the user's attention, most
+// notification popups only show the first line or two until they are expanded
+func (summary cycleSummary) details() string {
+	var lines []string
+	for _, path := range summary.conflicts {
+		lines = append(lines, "conflict copy: "+path)
+	}
+	for _, path := range summary.uploaded {
+		lines = append(lines, "uploaded: "+path)
+	}
+	for _, path := range summary.downloaded {
+		lines = append(lines, "downloaded: "+path)
+	}
+
+	if len(lines) > MAX_NOTIFY_DETAILS {
+		more := len(lines) - MAX_NOTIFY_DETAILS
+		lines = append(lines[:MAX_NOTIFY_DETAILS], fmt.Sprintf("and %d more", more))
+	}
+	return strings.Join(lines, "\n")
+}
+
+//*********************************************************
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("%d %v", count, noun)
+	}
+	return fmt.Sprintf("%d %vs", count, noun)
+}
+
+//*********************************************************
+
+// sends one notification for everything that was synced since the last one, it's an alert when a conflict copy
+// was made
+func (service *Service) notifyCycle() {
+	if service.cycle.isEmpty() {
+		return
+	}
+	if len(service.cycle.conflicts) > 0 {
+		service.alert(service.cycle.title(), service.cycle.details())
+	} else {
+		service.notify(service.cycle.title(), service.cycle.details())
+	}
+	service.cycle = cycleSummary{}
+}
+
+//*********************************************************
+
+// counts the cycles that ended without verifying, the alert is sent once when there have been too many in a row
+func (service *Service) notifyVerifyFailure() {
+	service.verifyFailures++
+	if service.verifyFailures != VERIFY_FAILURES_TO_NOTIFY {
+		return
+	}
+
+	var lines []string
+	for _, localPath := range sortedStringKeys(service.syncErrors) {
+		lines = append(lines, localPath+": "+service.syncErrors[localPath])
+	}
+	if len(lines) > MAX_NOTIFY_DETAILS {
+		more := len(lines) - MAX_NOTIFY_DETAILS
+		lines = append(lines[:MAX_NOTIFY_DETAILS], fmt.Sprintf("and %d more", more))
+	}
+	title := fmt.Sprintf("Sync could not be verified %d times in a row", service.verifyFailures)
+	message := fmt.Sprintf("%v to upload and %v to download are still not synced", plural(len(service.filesToUpload), "file"),
+		plural(len(service.filesToDownload), "file"))
+	if len(lines) > 0 {
+		message += "\n" + strings.Join(lines, "\n")
+	}
+	service.alert(title, message)
+}
+
+//*********************************************************
+
+// alerts once when Google Drive refuses the credentials, and again only after a cycle got through
+func (service *Service) notifyAuthError(err error) {
+	if service.authFailing || !isAuthError(err) {
+		return
+	}
+	service.authFailing = true
+	service.alert("Google Drive refused the credentials", "nothing is synced until this is fixed, sign in again with the login "+
+		"command or check the service account: "+err.Error())
+}
@@ -0,0 +1,26 @@
+package drivesync
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// shows the notification in the Notification Center through AppleScript
+func showDesktopNotification(ctx context.Context, title string, message string) error {
+	quote := func(text string) string {
+		return `"` + strings.ReplaceAll(strings.ReplaceAll(text, `\`, `\\`), `"`, `\"`) + `"`
+	}
+	script := "display notification " + quote(message) + " with title " + quote(APP_NAME) + " subtitle " + quote(title)
+	return exec.CommandContext(ctx, "osascript", "-e", script).Run()
+}
+
+//*********************************************************
+
+func desktopNotificationsAvailable() error {
+	_, err := exec.LookPath("osascript")
+	return err
+}
@@ -0,0 +1,24 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package drivesync
+
+import (
+	"context"
+	"os/exec"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// shows the notification through libnotify, which every freedesktop.org desktop has a notification server for
+func showDesktopNotification(ctx context.Context, title string, message string) error {
+	return exec.CommandContext(ctx, "notify-send", "--app-name="+APP_NAME, title, message).Run()
+}
+
+//*********************************************************
+
+func desktopNotificationsAvailable() error {
+	_, err := exec.LookPath("notify-send")
+	return err
+}
@@ -0,0 +1,38 @@
+package drivesync
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the title and message are passed in the environment so nothing in them has to be quoted for PowerShell, and the
+// toast is shown as PowerShell since a toast needs an app that is registered with Windows
+const TOAST_SCRIPT = `$ErrorActionPreference = 'Stop'
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$texts = $template.GetElementsByTagName('text')
+$texts.Item(0).AppendChild($template.CreateTextNode($env:GDFDL_NOTIFY_TITLE)) > $null
+$texts.Item(1).AppendChild($template.CreateTextNode($env:GDFDL_NOTIFY_MESSAGE)) > $null
+$appId = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appId).Show([Windows.UI.Notifications.ToastNotification]::new($template))`
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// shows the notification as a toast in the Action Center
+func showDesktopNotification(ctx context.Context, title string, message string) error {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", TOAST_SCRIPT)
+	cmd.Env = append(os.Environ(), "GDFDL_NOTIFY_TITLE="+APP_NAME+": "+title, "GDFDL_NOTIFY_MESSAGE="+message)
+	return cmd.Run()
+}
+
+//*********************************************************
+
+func desktopNotificationsAvailable() error {
+	_, err := exec.LookPath("powershell")
+	return err
+}
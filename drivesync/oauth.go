@@ -0,0 +1,250 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v2"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// With auth=user the sync signs in as the user instead of as the service account, so the uploaded files are
+// owned by the user and count against the user's storage. The first time a browser is opened to ask the user
+// for permission, and the refresh token is saved in config/oauth-token.json so it's only asked once. The OAuth
+// client is a "Desktop app" client created in the Google Cloud Console, saved to config/oauth-client.json.
+
+const AUTH_SERVICE_ACCOUNT = "service_account"
+const AUTH_USER = "user"
+
+const OAUTH_TOKEN_FILE_NAME = "config/oauth-token.json"
+
+// how long to wait for the user to finish in the browser
+const OAUTH_LOGIN_TIMEOUT = 10 * time.Minute
+
+// saves the token again whenever it's refreshed so the next run doesn't start with an expired one
+type savingTokenSource struct {
+	base     oauth2.TokenSource
+	fileName string
+
+	mutex sync.Mutex
+	saved string // the access token that is in the file
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a client that acts as the user, asks the user for permission in the browser if there is no saved token
+func userClient(ctx context.Context, settings Settings) (*http.Client, error) {
+	config, err := readOAuthClient(settings.OAuthClientFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenFileName := configPath(OAUTH_TOKEN_FILE_NAME)
+	token, err := readToken(tokenFileName)
+	if err != nil {
+		connLog.Warn("no saved sign in for Google Drive:", err)
+		loginCtx, cancel := context.WithTimeout(ctx, OAUTH_LOGIN_TIMEOUT)
+		defer cancel()
+		token, err = runOAuthFlow(loginCtx, config)
+		if err != nil {
+			return nil, err
+		}
+		err = saveToken(tokenFileName, token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	source := &savingTokenSource{base: config.TokenSource(ctx, token), fileName: tokenFileName, saved: token.AccessToken}
+	return oauth2.NewClient(ctx, source), nil
+}
+
+//*********************************************************
+
+// asks the user for permission again and saves the new token, for when the old one was revoked or to sign in
+// as someone else
+func Login(ctx context.Context) error {
+	settings, _, err := loadConfig(configPath(CONFIG_FILE_NAME), configPath("config/settings.txt"))
+	if err != nil {
+		return err
+	}
+	config, err := readOAuthClient(settings.OAuthClientFile)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, OAUTH_LOGIN_TIMEOUT)
+	defer cancel()
+	token, err := runOAuthFlow(ctx, config)
+	if err != nil {
+		return err
+	}
+	tokenFileName := configPath(OAUTH_TOKEN_FILE_NAME)
+	err = saveToken(tokenFileName, token)
+	if err == nil {
+		connLog.Info("saved the sign in to", tokenFileName)
+	}
+	return err
+}
+
+//*********************************************************
+
+func readOAuthClient(fileName string) (*oauth2.Config, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the OAuth client, create a Desktop app client in the Google Cloud Console and save its JSON to %v: %w", fileName, err)
+	}
+	config, err := google.ConfigFromJSON(data, drive.DriveScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the OAuth client in %v: %w", fileName, err)
+	}
+	return config, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the installed app flow, the browser is sent back to a local port with the code, PKCE keeps another program
+// from using the code
+func runOAuthFlow(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%v/", listener.Addr().(*net.TCPAddr).Port)
+
+	state := randomString()
+	verifier := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+	authUrl := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	codes := make(chan string, 1)
+	failures := make(chan error, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("state") != state {
+			http.Error(w, "unexpected request", http.StatusBadRequest) // like the browser asking for a favicon
+			return
+		}
+		if reason := query.Get("error"); reason != "" {
+			fmt.Fprintln(w, "Google Drive was not authorized, you can close this window.")
+			select {
+			case failures <- fmt.Errorf("Google Drive was not authorized: %v", reason):
+			default:
+			}
+			return
+		}
+		fmt.Fprintln(w, "Google Drive is authorized, you can close this window.")
+		select {
+		case codes <- query.Get("code"):
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	fmt.Println("Open this url to let Google-Drive-For-Desktop-Lite use your Google Drive:")
+	fmt.Println(authUrl)
+	if err := OpenInBrowser(authUrl); err != nil {
+		connLog.Debug("failed to open the browser:", err)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for the sign in: %w", ctx.Err())
+	case err := <-failures:
+		return nil, err
+	case code := <-codes:
+		return config.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+}
+
+//*********************************************************
+
+func randomString() string {
+	data := make([]byte, 32)
+	rand.Read(data)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//*********************************************************
+
+// opens a url in the default browser
+func OpenInBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	case "darwin":
+		cmd = exec.Command("open", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func readToken(fileName string) (*oauth2.Token, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var token oauth2.Token
+	err = json.Unmarshal(data, &token)
+	if err == nil && token.RefreshToken == "" {
+		err = fmt.Errorf("%v has no refresh token", fileName)
+	}
+	return &token, err
+}
+
+//*********************************************************
+
+// the token is as good as a password, so only the user can read the file
+func saveToken(fileName string, token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(fileName, data)
+}
+
+//*********************************************************
+
+func (source *savingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := source.base.Token()
+	if err != nil {
+		return nil, fmt.Errorf("the sign in for Google Drive failed, run the login command to sign in again: %w", err)
+	}
+
+	source.mutex.Lock()
+	defer source.mutex.Unlock()
+	if token.AccessToken != source.saved {
+		err = saveToken(source.fileName, token)
+		if err != nil {
+			connLog.Warn("failed to save the refreshed token:", err)
+		} else {
+			source.saved = token.AccessToken
+		}
+	}
+	return token, nil
+}
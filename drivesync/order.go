@@ -0,0 +1,106 @@
+package drivesync
+
+import (
+	"sort"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Go randomizes the map iteration order, so anything that is processed from a map goes through these
+// functions to be processed in the same order every run. Paths and ids are sorted by their bytes
+// instead of by the locale so the order doesn't change when the language settings do, and since a
+// folder's path is a prefix of everything inside it, a folder always comes before its contents.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func sortedPaths(pathMap map[string]bool) []string {
+	paths := make([]string, 0, len(pathMap))
+	for path := range pathMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+//*********************************************************
+
+func sortedMetadataKeys(metadataMap map[string]FileMetaData) []string {
+	keys := make([]string, 0, len(metadataMap))
+	for key := range metadataMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//*********************************************************
+
+func sortedStringKeys(stringMap map[string]string) []string {
+	keys := make([]string, 0, len(stringMap))
+	for key := range stringMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Drive allows more than one item with the same name in a folder, but they all map to the same local path.
+// Returns true if the candidate should replace the existing item, an item that is not in the trash wins, then
+// the most recently modified one, and the lowest id breaks a tie, so the same one is picked every time.
+func preferRemoteItem(existing FileMetaData, candidate FileMetaData) bool {
+	if candidate.Trashed != existing.Trashed {
+		return !candidate.Trashed
+	}
+	if candidate.ModifiedTime != existing.ModifiedTime {
+		return candidate.ModifiedTime > existing.ModifiedTime
+	}
+	return candidate.ID < existing.ID
+}
+
+//*********************************************************
+
+func sortActionsByRemoteName(actions []Action) {
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].Remote.Name != actions[j].Remote.Name {
+			return actions[i].Remote.Name < actions[j].Remote.Name
+		}
+		return actions[i].Remote.ID < actions[j].Remote.ID
+	})
+}
+
+//*********************************************************
+
+func sortExportedFiles(files []ExportedFile) {
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+}
+
+//*********************************************************
+
+func sortListedItems(items []ListedItem) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Path < items[j].Path
+	})
+}
+
+//*********************************************************
+
+func sortSearchResults(results []SearchResult) {
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Path < results[j].Path
+	})
+}
+
+//*********************************************************
+
+func sortTransferProgress(transfers []TransferProgress) {
+	sort.Slice(transfers, func(i, j int) bool {
+		return transfers[i].Path < transfers[j].Path
+	})
+}
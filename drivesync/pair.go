@@ -0,0 +1,395 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A BackendPair keeps two trees the same in both directions, for example a folder on a Shared Drive and a
+// folder in someone's My Drive. Nothing goes through the local disk when both sides are on Google Drive.
+// What was the same on both sides after the last sync is saved, so an item that is only on one side can be
+// told apart: if it was on both sides before then it was removed from the other side, otherwise it is new.
+//
+// A mirror is a BackendPair that only goes one way, b is made the same as a and nothing is saved. It's for
+// keeping a copy of a synced folder on another disk, like a USB drive, on a schedule.
+
+const PAIRS_DIR = "config/pairs"
+
+type BackendPair struct {
+	a, b      Backend
+	stateFile string
+	synced    map[string]string // key = path, value = the fingerprint of the item on both sides after the last sync
+	clock     Clock
+	mirror    bool          // b is only a copy of a
+	interval  time.Duration // how long to wait between the syncs in Run
+}
+
+// what a BackendPair saves in config/pairs/<name>.json
+type persistedPair struct {
+	Synced map[string]string `json:"synced"`
+}
+
+//*********************************************************
+
+func NewBackendPair(a Backend, b Backend, stateFile string, clock Clock) *BackendPair {
+	pair := BackendPair{a: a, b: b, stateFile: stateFile, synced: make(map[string]string), clock: clock, interval: SYNC_INTERVAL}
+
+	data, err := os.ReadFile(stateFile)
+	if err == nil {
+		var state persistedPair
+		err = json.Unmarshal(data, &state)
+		if err == nil && state.Synced != nil {
+			pair.synced = state.Synced
+		}
+	}
+	if err != nil && !os.IsNotExist(err) {
+		serviceLog.Warn("failed to read", stateFile, "so everything is treated as new:", err)
+	}
+	return &pair
+}
+
+//*********************************************************
+
+// makes the mirror the same as the source every interval, what is removed from the source is removed from the
+// mirror too, and anything changed on the mirror is replaced
+func NewBackendMirror(source Backend, mirror Backend, interval time.Duration) *BackendPair {
+	return &BackendPair{a: source, b: mirror, synced: make(map[string]string), clock: realClock{}, mirror: true, interval: interval}
+}
+
+//*********************************************************
+
+// pairs two folders on Google Drive that the service account can access
+func (service *Service) NewRemotePair(folderIdA string, folderIdB string) *BackendPair {
+	stateFile := service.configFile(filepath.Join(PAIRS_DIR, folderIdA+"_"+folderIdB+".json"))
+	os.MkdirAll(filepath.Dir(stateFile), 0700)
+	return NewBackendPair(NewDriveBackend(&service.conn, folderIdA), NewDriveBackend(&service.conn, folderIdB), stateFile, service.clock)
+}
+
+//*********************************************************
+
+// mirrors a local folder, usually one of the base folders, to another local folder
+func (service *Service) NewLocalMirror(sourceFolder string, mirrorFolder string, interval time.Duration) *BackendPair {
+	return NewBackendMirror(NewLocalBackend(service.fileSystem, sourceFolder), NewLocalBackend(service.fileSystem, mirrorFolder), interval)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// lists both sides and decides what to copy and remove to make them the same, nothing is changed yet
+func (pair *BackendPair) Plan(ctx context.Context) (Plan, error) {
+	itemsA, itemsB, err := pair.list(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	return pair.plan(itemsA, itemsB), nil
+}
+
+//*********************************************************
+
+func (pair *BackendPair) list(ctx context.Context) (map[string]BackendItem, map[string]BackendItem, error) {
+	itemsA, err := pair.a.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	itemsB, err := pair.b.List(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return itemsA, itemsB, nil
+}
+
+//*********************************************************
+
+func (pair *BackendPair) plan(itemsA map[string]BackendItem, itemsB map[string]BackendItem) Plan {
+	if pair.mirror {
+		return pair.planMirror(itemsA, itemsB)
+	}
+
+	var plan Plan
+	var removedFolders []string
+
+	for _, itemPath := range unionOfPaths(itemsA, itemsB) {
+		if pathIsInsideAny(removedFolders, itemPath) {
+			continue // it goes along with its folder
+		}
+		itemA, inA := itemsA[itemPath]
+		itemB, inB := itemsB[itemPath]
+
+		switch {
+		case inA && inB:
+			pair.planBothSides(&plan, itemA, itemB, itemsA, itemsB)
+		case inA:
+			if pair.planOneSide(&plan, pair.a, pair.b, itemA, itemsA) {
+				removedFolders = append(removedFolders, itemPath)
+			}
+		case inB:
+			if pair.planOneSide(&plan, pair.b, pair.a, itemB, itemsB) {
+				removedFolders = append(removedFolders, itemPath)
+			}
+		}
+	}
+
+	return plan
+}
+
+//*********************************************************
+
+// copies what is missing or different on the mirror and removes what is no longer in the source
+func (pair *BackendPair) planMirror(source map[string]BackendItem, mirror map[string]BackendItem) Plan {
+	var plan Plan
+	var removedFolders []string
+
+	// a source that is suddenly empty is more likely a drive that isn't mounted than a folder that was emptied
+	if len(source) == 0 && len(mirror) > 0 {
+		serviceLog.Warn("not mirroring", pair.a.Name(), "because it is empty, remove the files from", pair.b.Name(), "by hand if that's intended")
+		return plan
+	}
+
+	for _, itemPath := range unionOfPaths(source, mirror) {
+		if pathIsInsideAny(removedFolders, itemPath) {
+			continue
+		}
+		sourceItem, inSource := source[itemPath]
+		mirrorItem, inMirror := mirror[itemPath]
+
+		switch {
+		case inSource && inMirror && sourceItem.IsDir != mirrorItem.IsDir:
+			// the old one has to go before the new one can be copied
+			plan.add(Action{Type: ACTION_REMOVE_ITEM, LocalPath: itemPath, To: pair.b, Item: mirrorItem, Reason: "is a different kind of item in " + pair.a.Name()})
+			plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: itemPath, From: pair.a, To: pair.b, Item: sourceItem, Reason: "is not on " + pair.b.Name()})
+			if mirrorItem.IsDir {
+				removedFolders = append(removedFolders, itemPath)
+			}
+		case inSource && inMirror:
+			if sourceItem.fingerprint() != mirrorItem.fingerprint() {
+				plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: itemPath, From: pair.a, To: pair.b, Item: sourceItem, Reason: "changed on " + pair.a.Name()})
+			}
+		case inSource:
+			plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: itemPath, From: pair.a, To: pair.b, Item: sourceItem, Reason: "is not on " + pair.b.Name()})
+		case inMirror:
+			plan.add(Action{Type: ACTION_REMOVE_ITEM, LocalPath: itemPath, To: pair.b, Item: mirrorItem, Reason: "is no longer on " + pair.a.Name()})
+			if mirrorItem.IsDir {
+				removedFolders = append(removedFolders, itemPath)
+			}
+		}
+	}
+
+	return plan
+}
+
+//*********************************************************
+
+// an item that is only on one side is copied to the other side, unless it was removed from the other side
+// since the last sync, then it's removed from this side too, returns true if a folder is removed
+func (pair *BackendPair) planOneSide(plan *Plan, side Backend, other Backend, item BackendItem, sideItems map[string]BackendItem) bool {
+	if pair.unchangedSinceSync(item, sideItems) {
+		plan.add(Action{Type: ACTION_REMOVE_ITEM, LocalPath: item.Path, To: side, Item: item, Reason: "was removed from " + other.Name()})
+		return item.IsDir
+	}
+	plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: item.Path, From: side, To: other, Item: item, Reason: "is not on " + other.Name()})
+	return false
+}
+
+//*********************************************************
+
+// the side that didn't change gets the version of the side that did, if both changed then the newer one
+// wins and the older one is kept next to it as a conflict copy
+func (pair *BackendPair) planBothSides(plan *Plan, itemA BackendItem, itemB BackendItem, itemsA map[string]BackendItem, itemsB map[string]BackendItem) {
+	if itemA.IsDir != itemB.IsDir {
+		serviceLog.Warn("not syncing", itemA.Path, "because it is a folder on one side and a file on the other")
+		return
+	}
+	if itemA.fingerprint() == itemB.fingerprint() {
+		return
+	}
+
+	previous, wasSynced := pair.synced[itemA.Path]
+	switch {
+	case wasSynced && itemA.fingerprint() == previous:
+		plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: itemA.Path, From: pair.b, To: pair.a, Item: itemB, Reason: "changed on " + pair.b.Name()})
+	case wasSynced && itemB.fingerprint() == previous:
+		plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: itemB.Path, From: pair.a, To: pair.b, Item: itemA, Reason: "changed on " + pair.a.Name()})
+	default:
+		newer, older, newerSide, olderSide, olderItems := itemA, itemB, pair.a, pair.b, itemsB
+		if itemB.ModifiedTime.After(itemA.ModifiedTime) {
+			newer, older, newerSide, olderSide, olderItems = itemB, itemA, pair.b, pair.a, itemsA
+		}
+		conflictPath := pair.conflictPath(older.Path, olderItems)
+		plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: conflictPath, From: olderSide, To: olderSide, Item: older, Conflict: true,
+			Reason: "changed on both sides, keeping the older version from " + olderSide.Name()})
+		plan.add(Action{Type: ACTION_COPY_ITEM, LocalPath: newer.Path, From: newerSide, To: olderSide, Item: newer, Conflict: true,
+			Reason: "changed on both sides, the version from " + newerSide.Name() + " is newer"})
+	}
+}
+
+//*********************************************************
+
+// true if the item was on both sides after the last sync and hasn't changed since, for a folder that
+// includes everything in it, so a folder with new or changed items in it is never removed
+func (pair *BackendPair) unchangedSinceSync(item BackendItem, sideItems map[string]BackendItem) bool {
+	if pair.synced[item.Path] != item.fingerprint() {
+		return false
+	}
+	if !item.IsDir {
+		return true
+	}
+	prefix := item.Path + "/"
+	for itemPath, inside := range sideItems {
+		if strings.HasPrefix(itemPath, prefix) && pair.synced[itemPath] != inside.fingerprint() {
+			return false
+		}
+	}
+	return true
+}
+
+//*********************************************************
+
+// a name for the conflict copy that isn't used on that side yet
+func (pair *BackendPair) conflictPath(itemPath string, sideItems map[string]BackendItem) string {
+	now := pair.clock.Now()
+	for count := 1; ; count++ {
+		candidate := path.Join(path.Dir(itemPath), conflictName(path.Base(itemPath), now, count))
+		if _, taken := sideItems[candidate]; !taken {
+			return candidate
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// carries out a plan, stops at the first error since a missing folder or conflict copy would make the
+// actions after it wrong, the next sync starts over with a new plan
+func (pair *BackendPair) Execute(ctx context.Context, plan Plan) error {
+	for _, action := range plan.Actions {
+		var err error
+		switch action.Type {
+		case ACTION_COPY_ITEM:
+			if action.Item.IsDir {
+				err = action.To.CreateFolder(ctx, action.LocalPath, action.Item.ModifiedTime)
+			} else {
+				err = action.To.Copy(ctx, action.From, action.Item, action.LocalPath)
+			}
+		case ACTION_REMOVE_ITEM:
+			err = action.To.Remove(ctx, action.Item)
+		}
+		if err != nil {
+			return fmt.Errorf("%v: %w", action, err)
+		}
+	}
+	return nil
+}
+
+//*********************************************************
+
+// plans and executes one sync, then lists both sides again to save what is the same on both of them
+func (pair *BackendPair) Sync(ctx context.Context) error {
+	itemsA, itemsB, err := pair.list(ctx)
+	if err != nil {
+		return err
+	}
+	plan := pair.plan(itemsA, itemsB)
+	plan.Print()
+
+	// when there was nothing to do the lists are still up to date, a mirror doesn't need them
+	if len(plan.Actions) > 0 {
+		err = pair.Execute(ctx, plan)
+		if err != nil || pair.mirror {
+			return err
+		}
+		itemsA, itemsB, err = pair.list(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	pair.saveSynced(itemsA, itemsB)
+	return nil
+}
+
+//*********************************************************
+
+func (pair *BackendPair) saveSynced(itemsA map[string]BackendItem, itemsB map[string]BackendItem) {
+	if pair.mirror {
+		return
+	}
+
+	synced := make(map[string]string)
+	for itemPath, itemA := range itemsA {
+		if itemB, inB := itemsB[itemPath]; inB && itemA.fingerprint() == itemB.fingerprint() {
+			synced[itemPath] = itemA.fingerprint()
+		}
+	}
+	pair.synced = synced
+
+	data, err := json.Marshal(persistedPair{Synced: synced})
+	if err != nil {
+		serviceLog.Warn("failed to save", pair.stateFile, err)
+		return
+	}
+
+	err = writeFileAtomically(pair.stateFile, data)
+	if err != nil {
+		serviceLog.Warn("failed to save", pair.stateFile, err)
+	}
+}
+
+//*********************************************************
+
+// syncs every 300 seconds, or every interval for a mirror, until the context is cancelled
+func (pair *BackendPair) Run(ctx context.Context) error {
+	for {
+		if pair.mirror {
+			serviceLog.Info("mirroring", pair.a.Name(), "to", pair.b.Name())
+		} else {
+			serviceLog.Info("syncing", pair.a.Name(), "and", pair.b.Name())
+		}
+		err := pair.Sync(ctx)
+		if err != nil {
+			serviceLog.Warn("the sync failed, trying again next time:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pair.interval):
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func unionOfPaths(itemsA map[string]BackendItem, itemsB map[string]BackendItem) []string {
+	paths := make([]string, 0, len(itemsA))
+	for itemPath := range itemsA {
+		paths = append(paths, itemPath)
+	}
+	for itemPath := range itemsB {
+		if _, inA := itemsA[itemPath]; !inA {
+			paths = append(paths, itemPath)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+//*********************************************************
+
+func pathIsInsideAny(folders []string, itemPath string) bool {
+	for _, folder := range folders {
+		if strings.HasPrefix(itemPath, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,175 @@
+package drivesync
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Google Drive identifies items by id and gives each one a name, the local side identifies them by path.
+// Everything that converts between the two goes through these functions so that the separators and
+// the characters that are not allowed in local file names are handled the same way everywhere.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// characters that Drive allows in a name but that can't be in a local file name
+func invalidLocalNameChars(goos string) string {
+	if goos == "windows" {
+		return `/\:*?"<>|`
+	}
+	return "/"
+}
+
+//*********************************************************
+
+// converts a Drive name into a name that is safe to use as a single component of a local path,
+// for example a Drive file named "a/b" becomes the local file "a_b" instead of the file "b" in the folder "a"
+func remoteNameToLocalName(name string) string {
+	return remoteNameToLocalNameOn(runtime.GOOS, name)
+}
+
+// the local name on the given platform, so the rules of each of them can be checked anywhere
+func remoteNameToLocalNameOn(goos string, name string) string {
+	if name == "" || name == "." || name == ".." {
+		return "_"
+	}
+
+	invalidChars := invalidLocalNameChars(goos)
+	localName := strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(invalidChars, r) {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if goos == "windows" {
+		// Windows drops the spaces and dots at the end of a name, so "a" and "a " would be the same file
+		trimmed := strings.TrimRight(localName, " .")
+		localName = trimmed + strings.Repeat("_", len(localName)-len(trimmed))
+
+		// and the device names can't be files, even with an extension
+		if isReservedWindowsName(localName) {
+			localName += "_"
+		}
+	}
+	return localName
+}
+
+//*********************************************************
+
+// CON, PRN, AUX, NUL, COM1-9 and LPT1-9, in any case and with or without an extension
+func isReservedWindowsName(name string) bool {
+	base := strings.ToUpper(strings.SplitN(name, ".", 2)[0])
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) && base[3] >= '1' && base[3] <= '9'
+}
+
+//*********************************************************
+
+// Windows and macOS don't tell the case of a name apart, and macOS doesn't tell the composed and decomposed
+// forms of an accented letter apart either, so on those "Report.txt" and "report.txt", or an "é" typed on
+// different systems, are the same local file. Two local paths with the same key are the same file.
+func localPathKey(localPath string) string {
+	return localPathKeyOn(runtime.GOOS, localPath)
+}
+
+func localPathKeyOn(goos string, localPath string) string {
+	switch goos {
+	case "darwin":
+		return strings.ToLower(norm.NFC.String(localPath))
+	case "windows":
+		return strings.ToLower(localPath)
+	}
+	return localPath
+}
+
+//*********************************************************
+
+// Google Drive can have items whose names only differ in case or in how an accent is written in the same folder,
+// they would all be written to the same local file, so only the one preferRemoteItem picks is kept, like for the
+// items with the exact same name
+func (service *Service) dropLocalPathCollisions(lookupMap map[string]FileMetaData) {
+	dropLocalPathCollisionsOn(runtime.GOOS, lookupMap, service.log)
+}
+
+func dropLocalPathCollisionsOn(goos string, lookupMap map[string]FileMetaData, pathLog logger) {
+	kept := make(map[string]string) // key = localPathKey, value = the local path that is kept
+	for _, localPath := range sortedMetadataKeys(lookupMap) {
+		key := localPathKeyOn(goos, localPath)
+		keptPath, collides := kept[key]
+		if !collides {
+			kept[key] = localPath
+			continue
+		}
+		if preferRemoteItem(lookupMap[keptPath], lookupMap[localPath]) {
+			keptPath, localPath = localPath, keptPath
+			kept[key] = keptPath
+		}
+		pathLog.Warn("not syncing", localPath, "because it would be the same local file as", keptPath)
+		delete(lookupMap, localPath)
+	}
+}
+
+//*********************************************************
+
+// true if the Drive name can't be used as it is for a local file
+func isUnsafeRemoteName(name string) bool {
+	return remoteNameToLocalName(name) != name
+}
+
+//*********************************************************
+
+// builds the local path of a remote item from the local path of the folder it's in
+func localChildPath(localParent string, remoteName string) string {
+	return filepath.Join(localParent, remoteNameToLocalName(remoteName))
+}
+
+//*********************************************************
+
+// the base folder names in config/folder-ids.txt can use / on every platform
+func configNameToLocalPath(name string) string {
+	return filepath.Clean(filepath.FromSlash(strings.TrimSpace(name)))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if child is the parent itself or is somewhere inside of it
+func localPathIsInside(parent string, child string) bool {
+	relativePath, err := filepath.Rel(filepath.Clean(parent), filepath.Clean(child))
+	if err != nil {
+		return false
+	}
+	return relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
+
+//*********************************************************
+
+// returns the base folder that contains the local path and the local names of the path components
+// below the base folder, names is empty for the base folder itself
+func (service *Service) splitLocalPath(localPath string) (baseFolder string, names []string, found bool) {
+	localPath = filepath.Clean(localPath)
+
+	for _, baseFolder := range service.getBaseFolderSlice() {
+		if !localPathIsInside(baseFolder, localPath) {
+			continue
+		}
+
+		relativePath, _ := filepath.Rel(baseFolder, localPath)
+		if relativePath == "." {
+			return baseFolder, []string{}, true
+		}
+		return baseFolder, strings.Split(relativePath, string(filepath.Separator)), true
+	}
+
+	return "", []string{}, false
+}
@@ -0,0 +1,214 @@
+package drivesync
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestRemoteNameToLocalName(t *testing.T) {
+	tests := []struct {
+		goos     string
+		name     string
+		expected string
+	}{
+		{"linux", "report.txt", "report.txt"},
+		{"linux", "", "_"},
+		{"linux", ".", "_"},
+		{"linux", "..", "_"},
+		{"linux", "a/b", "a_b"},
+		{"linux", `a\b`, `a\b`},
+		{"linux", "tab\there", "tab_here"},
+		{"linux", "CON", "CON"},
+		{"linux", "report.", "report."},
+
+		// the separators and the other characters Windows doesn't allow
+		{"windows", "a/b", "a_b"},
+		{"windows", `a\b`, "a_b"},
+		{"windows", `C:\Users\me`, "C__Users_me"},
+		{"windows", `what?*"<>|`, "what______"},
+		{"darwin", `a\b:c`, `a\b:c`},
+
+		// the spaces and dots at the end that Windows would drop
+		{"windows", "report.", "report_"},
+		{"windows", "report. .", "report___"},
+		{"windows", "notes ", "notes_"},
+
+		// the device names, in any case and with an extension
+		{"windows", "CON", "CON_"},
+		{"windows", "con", "con_"},
+		{"windows", "nul.txt", "nul.txt_"},
+		{"windows", "Aux.tar.gz", "Aux.tar.gz_"},
+		{"windows", "COM1", "COM1_"},
+		{"windows", "lpt9.log", "lpt9.log_"},
+		{"windows", "COM0", "COM0"},
+		{"windows", "COM10", "COM10"},
+		{"windows", "CONSOLE", "CONSOLE"},
+		{"windows", "my con.txt", "my con.txt"},
+	}
+	for _, test := range tests {
+		if got := remoteNameToLocalNameOn(test.goos, test.name); got != test.expected {
+			t.Errorf("remoteNameToLocalNameOn(%v, %q) = %q, expected %q", test.goos, test.name, got, test.expected)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestLocalPathKey(t *testing.T) {
+	composed := "caf\u00e9.txt"    // é as one code point, how Windows and Linux usually write it
+	decomposed := "cafe\u0301.txt" // e and a combining accent, how macOS used to store it
+
+	tests := []struct {
+		goos    string
+		a       string
+		b       string
+		collide bool
+	}{
+		{"linux", "Report.txt", "report.txt", false},
+		{"windows", "Report.txt", "report.txt", true},
+		{"darwin", "Report.txt", "report.txt", true},
+		{"windows", "Folder/Report.txt", "FOLDER/report.TXT", true},
+		{"darwin", "a.txt", "b.txt", false},
+
+		{"linux", composed, decomposed, false},
+		{"windows", composed, decomposed, false},
+		{"darwin", composed, decomposed, true},
+		{"darwin", "CAF\u00c9.txt", decomposed, true},
+		{"darwin", composed, composed, true},
+	}
+	for _, test := range tests {
+		collide := localPathKeyOn(test.goos, test.a) == localPathKeyOn(test.goos, test.b)
+		if collide != test.collide {
+			t.Errorf("on %v %q and %q collide = %v, expected %v", test.goos, test.a, test.b, collide, test.collide)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestDropLocalPathCollisions(t *testing.T) {
+	older := FileMetaData{ID: "older", ModifiedTime: "2022-01-01T00:00:00.000Z"}
+	newer := FileMetaData{ID: "newer", ModifiedTime: "2022-06-01T00:00:00.000Z"}
+	trashed := FileMetaData{ID: "trashed", ModifiedTime: "2022-09-01T00:00:00.000Z", Trashed: true}
+
+	tests := []struct {
+		goos     string
+		items    map[string]FileMetaData
+		expected map[string]string // key = local path, value = the id that is kept
+	}{
+		{"linux", map[string]FileMetaData{"base/Report.txt": newer, "base/report.txt": older},
+			map[string]string{"base/Report.txt": "newer", "base/report.txt": "older"}},
+		{"windows", map[string]FileMetaData{"base/Report.txt": newer, "base/report.txt": older},
+			map[string]string{"base/Report.txt": "newer"}},
+		{"windows", map[string]FileMetaData{"base/Report.txt": older, "base/report.txt": newer},
+			map[string]string{"base/report.txt": "newer"}},
+		{"darwin", map[string]FileMetaData{"base/REPORT.txt": trashed, "base/report.txt": older, "base/other.txt": newer},
+			map[string]string{"base/report.txt": "older", "base/other.txt": "newer"}},
+		{"darwin", map[string]FileMetaData{"base/caf\u00e9": older, "base/cafe\u0301": newer},
+			map[string]string{"base/cafe\u0301": "newer"}},
+		{"windows", map[string]FileMetaData{"base/caf\u00e9": older, "base/cafe\u0301": newer},
+			map[string]string{"base/caf\u00e9": "older", "base/cafe\u0301": "newer"}},
+	}
+	for _, test := range tests {
+		dropLocalPathCollisionsOn(test.goos, test.items, serviceLog)
+		kept := make(map[string]string)
+		for localPath, item := range test.items {
+			kept[localPath] = item.ID
+		}
+		if len(kept) != len(test.expected) {
+			t.Errorf("on %v kept %v, expected %v", test.goos, kept, test.expected)
+			continue
+		}
+		for localPath, id := range test.expected {
+			if kept[localPath] != id {
+				t.Errorf("on %v kept %v, expected %v", test.goos, kept, test.expected)
+				break
+			}
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestLocalPathIsInside(t *testing.T) {
+	base := filepath.Join("home", "drive")
+	tests := []struct {
+		child    string
+		expected bool
+	}{
+		{base, true},
+		{filepath.Join(base, "a", "b.txt"), true},
+		{filepath.Join(base, "a", "..", "b.txt"), true},
+		{filepath.Join(base, "..", "other"), false},
+		{filepath.Join("home", "drive2"), false},
+		{filepath.Join("home", "drive..x"), false},
+		{filepath.Join(base, "..drive"), true},
+		{"home", false},
+	}
+	for _, test := range tests {
+		if got := localPathIsInside(base, test.child); got != test.expected {
+			t.Errorf("localPathIsInside(%q, %q) = %v, expected %v", base, test.child, got, test.expected)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestConfigNameToLocalPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"Documents", "Documents"},
+		{"  Documents  ", "Documents"},
+		{"Work/Projects", filepath.Join("Work", "Projects")},
+		{"Work/Projects/", filepath.Join("Work", "Projects")},
+		{"Work//Projects/../Notes", filepath.Join("Work", "Notes")},
+	}
+	for _, test := range tests {
+		if got := configNameToLocalPath(test.name); got != test.expected {
+			t.Errorf("configNameToLocalPath(%q) = %q, expected %q", test.name, got, test.expected)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestSplitLocalPath(t *testing.T) {
+	base := filepath.Join("home", "Documents")
+	service := &Service{baseFolders: map[string]string{base: "base-id"}}
+
+	tests := []struct {
+		localPath string
+		found     bool
+		names     []string
+	}{
+		{base, true, []string{}},
+		{filepath.Join(base, "a", "b.txt"), true, []string{"a", "b.txt"}},
+		{filepath.Join(base, "a") + string(filepath.Separator), true, []string{"a"}},
+		{filepath.Join("home", "Documents2", "a"), false, []string{}},
+		{filepath.Join("home", "Other"), false, []string{}},
+	}
+	for _, test := range tests {
+		baseFolder, names, found := service.splitLocalPath(test.localPath)
+		if found != test.found || !equalStrings(names, test.names) || (found && baseFolder != base) {
+			t.Errorf("splitLocalPath(%q) = %q, %q, %v, expected %q, %v", test.localPath, baseFolder, names, found, test.names, test.found)
+		}
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
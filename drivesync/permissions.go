@@ -0,0 +1,136 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const PERMISSIONS_FILE_NAME = "config/permissions.json"
+
+// the sharing settings of a synced item the last time it changed on Google Drive
+type recordedPermissions struct {
+	FileID      string       `json:"fileId"`
+	Permissions []Permission `json:"permissions"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadPermissions() map[string]recordedPermissions {
+	records := make(map[string]recordedPermissions)
+
+	data, err := os.ReadFile(service.configFile(PERMISSIONS_FILE_NAME))
+	if err != nil {
+		return records
+	}
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		service.log.Warn("ignoring the recorded permissions:", err)
+		return make(map[string]recordedPermissions)
+	}
+	return records
+}
+
+//*********************************************************
+
+func (service *Service) savePermissions(records map[string]recordedPermissions) {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to save the permissions:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(PERMISSIONS_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the permissions:", err)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// records the sharing settings of the items that changed on Google Drive, sharing changes show up as changes too
+func (service *Service) recordPermissions(ctx context.Context, items map[string]FileMetaData) {
+	if len(items) == 0 {
+		return
+	}
+
+	records := service.loadPermissions()
+	for _, localPath := range sortedMetadataKeys(items) {
+		item := items[localPath]
+		permissions, err := service.conn.listPermissions(ctx, item.ID)
+		if err != nil {
+			service.log.Warn("could not record the permissions of", localPath, err)
+			continue
+		}
+		records[localPath] = recordedPermissions{FileID: item.ID, Permissions: permissions}
+	}
+	service.savePermissions(records)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// adds the recorded sharing settings back to the item at the local path, for when it was re-created under a
+// new id and lost them, returns how many permissions were added
+func (service *Service) RestorePermissions(ctx context.Context, localPath string) (int, error) {
+	record, found := service.loadPermissions()[localPath]
+	if !found {
+		return 0, fmt.Errorf("no permissions were recorded for %v, is record_permissions turned on?: %w", localPath, ErrNotFound)
+	}
+
+	remoteItem, err := service.FindRemoteItem(ctx, localPath)
+	if err != nil {
+		return 0, err
+	}
+	current, err := service.conn.listPermissions(ctx, remoteItem.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	added := 0
+	for _, permission := range record.Permissions {
+		if !permissionCanBeRestored(permission) || hasPermission(current, permission) {
+			continue
+		}
+		err := service.conn.createPermission(ctx, remoteItem.ID, permission)
+		if err != nil {
+			return added, err
+		}
+		added++
+	}
+
+	return added, nil
+}
+
+//*********************************************************
+
+// the owner can't be changed this way, and the inherited permissions come back from the parent folder on their own
+func permissionCanBeRestored(permission Permission) bool {
+	if permission.Role == "owner" {
+		return false
+	}
+	for _, detail := range permission.PermissionDetails {
+		if !detail.Inherited {
+			return true
+		}
+	}
+	return len(permission.PermissionDetails) == 0
+}
+
+//*********************************************************
+
+func hasPermission(permissions []Permission, wanted Permission) bool {
+	for _, permission := range permissions {
+		if permission.Type == wanted.Type && permission.Role == wanted.Role &&
+			permission.EmailAddress == wanted.EmailAddress && permission.Domain == wanted.Domain {
+			return true
+		}
+	}
+	return false
+}
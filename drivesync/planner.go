@@ -0,0 +1,766 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The planners compare the local and remote sides and decide what needs to happen, the executors carry out
+// the plan. Keeping the two apart means a plan can be logged or checked before anything is changed.
+
+type ActionType int
+
+const (
+	ACTION_CREATE_REMOTE   ActionType = iota // the local file/folder is not on Google Drive yet
+	ACTION_UPDATE_REMOTE                     // the local file is newer than the one on Google Drive
+	ACTION_DOWNLOAD_NEW                      // the remote file/folder is not on the local side yet
+	ACTION_OVERWRITE_LOCAL                   // the remote file is newer than the local one
+	ACTION_DELETE_REMOTE                     // the remote item is no longer in any of the user's folders
+	ACTION_CONFLICT                          // changed on both sides since the last verify, the remote version is newer and the local one is kept as a conflict copy
+	ACTION_MOVE_REMOTE                       // the local file was renamed or moved, so the remote file is too
+	ACTION_MOVE_LOCAL                        // the remote file/folder was renamed or moved, so the local one is too
+	ACTION_COPY_ITEM                         // a BackendPair copies an item to the other side, or creates the folder there
+	ACTION_REMOVE_ITEM                       // a BackendPair removes an item that was removed from the other side
+	ACTION_DELETE_LOCAL                      // the remote item was trashed, so the local copy is removed, only in a plan
+)
+
+func (actionType ActionType) String() string {
+	switch actionType {
+	case ACTION_CREATE_REMOTE:
+		return "CreateRemote"
+	case ACTION_UPDATE_REMOTE:
+		return "UpdateRemote"
+	case ACTION_DOWNLOAD_NEW:
+		return "DownloadNew"
+	case ACTION_OVERWRITE_LOCAL:
+		return "OverwriteLocal"
+	case ACTION_DELETE_REMOTE:
+		return "DeleteRemote"
+	case ACTION_CONFLICT:
+		return "Conflict"
+	case ACTION_MOVE_REMOTE:
+		return "MoveRemote"
+	case ACTION_MOVE_LOCAL:
+		return "MoveLocal"
+	case ACTION_COPY_ITEM:
+		return "CopyItem"
+	case ACTION_REMOVE_ITEM:
+		return "RemoveItem"
+	case ACTION_DELETE_LOCAL:
+		return "DeleteLocal"
+	}
+	return fmt.Sprintf("ActionType(%d)", int(actionType))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type Action struct {
+	Type      ActionType
+	LocalPath string      // empty for DeleteRemote, for CopyItem and RemoveItem it is the path on the To backend
+	LocalInfo os.FileInfo // only for the actions that upload
+	Remote    FileMetaData
+	Reason    string
+
+	// for DeleteRemote, the totals include everything inside a folder
+	// for the uploads, Bytes is how much will be sent since Drive always replaces the whole file
+	ItemCount int
+	Bytes     int64
+
+	// for UpdateRemote, the file changed on both sides so the remote version is kept as a conflict copy first
+	Conflict bool
+
+	// for MoveRemote and MoveLocal, the local path where the file was before it was renamed or moved
+	FromPath string
+
+	// for CopyItem, the item on the From backend is copied to the To backend, RemoveItem removes it from To
+	From Backend
+	To   Backend
+	Item BackendItem
+}
+
+func (action Action) String() string {
+	target := action.LocalPath
+	if action.To != nil {
+		target = action.To.Name() + "/" + action.LocalPath
+	} else if target == "" {
+		target = action.Remote.Name + " (" + action.Remote.ID + ")"
+	}
+	if action.Reason == "" {
+		return fmt.Sprintf("%v %v", action.Type, target)
+	}
+	return fmt.Sprintf("%v %v: %v", action.Type, target, action.Reason)
+}
+
+// what the log records about the file of the action
+func (service *Service) actionLogFields(action Action) logFields {
+	fields := logFields{Path: action.LocalPath, FileId: action.Remote.ID, Bytes: action.Bytes}
+	if fields.FileId == "" {
+		uploaded, _ := service.uploadedItem(action.LocalPath)
+		fields.FileId = uploaded.ID
+	}
+	if fields.Bytes == 0 {
+		fields.Bytes = action.Remote.Size
+	}
+	return fields
+}
+
+//*********************************************************
+
+type Plan struct {
+	Actions []Action
+}
+
+func (plan *Plan) add(action Action) {
+	plan.Actions = append(plan.Actions, action)
+}
+
+func (plan Plan) Print() {
+	for _, action := range plan.Actions {
+		fmt.Println("plan:", action)
+	}
+}
+
+//*********************************************************
+
+// the total number of bytes the uploads in the plan will send
+func (plan Plan) UploadBytes() int64 {
+	var total int64
+	for _, action := range plan.Actions {
+		if action.Type == ACTION_CREATE_REMOTE || action.Type == ACTION_UPDATE_REMOTE {
+			total += action.Bytes
+		}
+	}
+	return total
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// decides what to do with each of the filesToUpload, the folders come first so they exist before their contents
+func (service *Service) planUploads() Plan {
+	var folders, files Plan
+	moved := service.movedFileCandidates()
+
+	for _, localPath := range sortedPaths(service.filesToUpload) {
+		localFileInfo, err := service.fileSystem.Stat(localPath)
+		if err != nil {
+			// it must have been removed after we detected it but before we could upload it
+			delete(service.filesToUpload, localPath)
+			delete(service.localFiles, localPath)
+			continue
+		}
+
+		// the user opted out of uploading the files that are too big
+		maxBytes := service.settings.MaxUploadBytes
+		if maxBytes > 0 && !localFileInfo.IsDir() && localFileInfo.Size() > maxBytes {
+			service.log.Warn("not uploading", localPath, "because it is bigger than max_upload_mb")
+			service.syncErrors[localPath] = "bigger than max_upload_mb"
+			delete(service.filesToUpload, localPath)
+			continue
+		}
+
+		if !localFileInfo.IsDir() && service.uploadIsQuarantined(localPath) {
+			service.log.Warn("not uploading", localPath, "because the upload handler failed on it, it's tried again when it changes")
+			delete(service.filesToUpload, localPath)
+			continue
+		}
+
+		remoteFileData, existsOnServer := service.uploadLookupMap[localPath]
+
+		// the local copy of a Google Doc is only an export, uploading it would replace the Doc with a Word file
+		if existsOnServer && isGoogleFile(remoteFileData) {
+			service.log.Debug("not uploading", localPath, "because it is an export of a Google file")
+			delete(service.filesToUpload, localPath)
+			continue
+		}
+
+		// a file that was trashed on Google Drive and shows up again locally keeps its id, so the links people
+		// already have keep working, but only if it was trashed recently, otherwise a new file is created
+		if existsOnServer && remoteFileData.Trashed && !localFileInfo.IsDir() && service.settings.ReuseTrashedWindow > 0 {
+			if trashedFor, recent := service.trashedRecently(remoteFileData); recent {
+				files.add(Action{Type: ACTION_UPDATE_REMOTE, LocalPath: localPath, LocalInfo: localFileInfo, Remote: remoteFileData,
+					Reason: fmt.Sprintf("was trashed on Google Drive %v ago, restoring it with the local contents", trashedFor.Round(time.Second)),
+					Bytes:  localFileInfo.Size()})
+				continue
+			}
+			existsOnServer = false
+		}
+
+		if !existsOnServer && !localFileInfo.IsDir() {
+			if fromPath, found := service.findMovedFile(moved, localPath, localFileInfo); found {
+				files.add(Action{Type: ACTION_MOVE_REMOTE, LocalPath: localPath, LocalInfo: localFileInfo, Remote: service.uploadLookupMap[fromPath],
+					FromPath: fromPath, Reason: "was renamed or moved from " + fromPath})
+				continue
+			}
+		}
+		if !existsOnServer {
+			action := Action{Type: ACTION_CREATE_REMOTE, LocalPath: localPath, LocalInfo: localFileInfo, Reason: "does not exist on server"}
+			if localFileInfo.IsDir() {
+				folders.add(action)
+			} else {
+				action.Bytes = localFileInfo.Size()
+				files.add(action)
+			}
+			continue
+		}
+		if localFileInfo.IsDir() {
+			continue // the folder is already on the server
+		}
+
+		localModTime := localFileInfo.ModTime()
+		remoteModTime, _ := time.Parse(time.RFC3339Nano, remoteFileData.ModifiedTime)
+		diff := localModTime.Sub(remoteModTime)
+		service.log.Debug(localFileInfo.Name(), "local mod time is newer by", diff.Seconds(), "seconds")
+
+		// only calculate the md5's if one side is newer, allow for some roundoff error or the coarser times of some filesystems
+		tolerance := service.timestampTolerance(localPath)
+		if diff > tolerance || (diff < -tolerance && service.changedOnBothSides(localModTime, remoteModTime)) {
+			localMd5 := service.getMd5OfFile(localPath)
+			if service.sameContents(localPath, localMd5, remoteFileData.Md5Checksum) {
+				continue
+			}
+			service.log.Debug("md5's do not match", localMd5, remoteFileData.Md5Checksum)
+
+			if diff > tolerance {
+				reason := "local mod time is newer"
+				conflict := service.changedOnBothSides(localModTime, remoteModTime)
+				if conflict {
+					reason = "changed on both sides, the local version is newer and the remote version is kept as a conflict copy"
+				}
+				if changedBytes, known := service.changedChunkBytes(localPath); known {
+					reason += fmt.Sprintf(", %.1f MB of %.1f MB changed but the whole file is uploaded",
+						float64(changedBytes)/(1024*1024), float64(localFileInfo.Size())/(1024*1024))
+				}
+				files.add(Action{Type: ACTION_UPDATE_REMOTE, LocalPath: localPath, LocalInfo: localFileInfo, Remote: remoteFileData, Reason: reason,
+					Bytes: localFileInfo.Size(), Conflict: conflict})
+			} else {
+				files.add(Action{Type: ACTION_CONFLICT, LocalPath: localPath, LocalInfo: localFileInfo, Remote: remoteFileData,
+					Reason: "changed on both sides, the remote version is newer and the local version is kept as a conflict copy"})
+			}
+		}
+	}
+
+	return Plan{Actions: append(folders.Actions, files.Actions...)}
+}
+
+//*********************************************************
+
+// returns how long ago the remote item was trashed, and true if that's within the reuse_trashed_minutes window
+func (service *Service) trashedRecently(remoteFileData FileMetaData) (time.Duration, bool) {
+	trashedAt, err := time.Parse(time.RFC3339Nano, remoteFileData.TrashedTime)
+	if err != nil {
+		return 0, false
+	}
+	trashedFor := service.clock.Now().Sub(trashedAt)
+	return trashedFor, trashedFor <= service.settings.ReuseTrashedWindow
+}
+
+//*********************************************************
+
+// true if both sides were modified after the last verify, only possible once there has been a verify
+func (service *Service) changedOnBothSides(localModTime time.Time, remoteModTime time.Time) bool {
+	if service.verifiedAt.Year() <= 2000 {
+		return false
+	}
+	return localModTime.After(service.verifiedAt) && remoteModTime.After(service.verifiedAt)
+}
+
+//*********************************************************
+
+// decides what to do with each of the filesToDownload, the folders come first so they exist before their contents
+func (service *Service) planDownloads() Plan {
+	var folders, files Plan
+
+	for _, localPath := range sortedMetadataKeys(service.filesToDownload) {
+		remoteFileInfo := service.filesToDownload[localPath]
+
+		// something that was renamed or moved on Google Drive is moved locally instead of downloaded again
+		if fromPath, found := service.findRemotelyMovedItem(localPath, remoteFileInfo); found {
+			action := Action{Type: ACTION_MOVE_LOCAL, LocalPath: localPath, Remote: remoteFileInfo, FromPath: fromPath,
+				Reason: "was renamed or moved on Google Drive from " + fromPath}
+			if strings.Contains(remoteFileInfo.MimeType, "folder") {
+				folders.add(action)
+			} else {
+				files.add(action)
+			}
+			continue
+		}
+
+		if strings.Contains(remoteFileInfo.MimeType, "folder") {
+			folders.add(Action{Type: ACTION_DOWNLOAD_NEW, LocalPath: localPath, Remote: remoteFileInfo})
+			continue
+		}
+
+		// FAT can't hold a file of 4 GB or more, so skip it instead of failing partway through the write
+		maxSize := service.volumeFor(localPath).maxFileSize
+		if maxSize > 0 && remoteFileInfo.Size > maxSize {
+			err := fmt.Errorf("%v is %.1f GB which is too big for the %v filesystem: %w",
+				localPath, float64(remoteFileInfo.Size)/(1024*1024*1024), service.volumeFor(localPath).fsType, ErrFileTooLarge)
+			service.log.Warn("not downloading", err)
+			service.syncErrors[localPath] = err.Error()
+			delete(service.filesToDownload, localPath)
+			continue
+		}
+
+		if _, err := service.fileSystem.Stat(localPath); err != nil {
+			files.add(Action{Type: ACTION_DOWNLOAD_NEW, LocalPath: localPath, Remote: remoteFileInfo, Reason: "does not exist locally"})
+		} else {
+			files.add(Action{Type: ACTION_OVERWRITE_LOCAL, LocalPath: localPath, Remote: remoteFileInfo, Reason: "remote file is newer"})
+		}
+	}
+
+	return Plan{Actions: append(folders.Actions, files.Actions...)}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// carries out an upload plan, the folders, moves and conflicts are done in order first and stop at the first error
+// so a half uploaded tree is retried from the top next time, then the files are uploaded by a pool of workers
+func (service *Service) executeUploads(ctx context.Context, plan Plan) error {
+	defer service.setTransfer("", 0)
+
+	var fileActions []Action
+	for i, action := range plan.Actions {
+		if isParallelUpload(action) {
+			fileActions = append(fileActions, action)
+			continue
+		}
+		service.setTransfer(fmt.Sprintf("uploading %d of %d: %v", i+1, len(plan.Actions), action.LocalPath), len(plan.Actions)-i-1)
+		err := service.executeUpload(ctx, action)
+		if err != nil {
+			service.syncErrors[action.LocalPath] = err.Error()
+			service.log.with(service.actionLogFields(action)).Warn("failed to upload", action.LocalPath, ":", err)
+			return err
+		}
+		if action.Type == ACTION_UPDATE_REMOTE && !action.LocalInfo.IsDir() {
+			service.cycle.uploaded = append(service.cycle.uploaded, action.LocalPath)
+		}
+	}
+
+	// the workers only upload, the results are handled here in the order of the plan
+	errs := service.uploadFiles(ctx, fileActions)
+	var failed []error
+	for i, action := range fileActions {
+		if errs[i] != nil {
+			service.syncErrors[action.LocalPath] = errs[i].Error()
+			service.log.with(service.actionLogFields(action)).Warn("failed to upload", action.LocalPath, ":", errs[i])
+			failed = append(failed, errs[i])
+			continue
+		}
+		service.log.with(service.actionLogFields(action)).Info("uploaded", action.LocalPath)
+		service.cycle.uploaded = append(service.cycle.uploaded, action.LocalPath)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d uploads failed, the first one: %w", len(failed), len(fileActions), failed[0])
+	}
+	return nil
+}
+
+//*********************************************************
+
+// the creates and updates of files don't depend on each other, a conflict makes a copy first so it's done in order
+func isParallelUpload(action Action) bool {
+	if action.LocalInfo == nil || action.LocalInfo.IsDir() {
+		return false
+	}
+	return action.Type == ACTION_CREATE_REMOTE || (action.Type == ACTION_UPDATE_REMOTE && !action.Conflict)
+}
+
+//*********************************************************
+
+// uploads the files using up to uploadWorkers at a time, the starts are spread out by uploadRate so the workers
+// share one limit, returns the error of each one in the same order
+func (service *Service) uploadFiles(ctx context.Context, actions []Action) []error {
+	errs := make([]error, len(actions))
+	if len(actions) == 0 {
+		return errs
+	}
+	var numStarted int64
+	var wg sync.WaitGroup
+
+	jobs := make(chan int)
+	for i := 0; i < service.uploadWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				started := atomic.AddInt64(&numStarted, 1)
+				service.setTransfer(fmt.Sprintf("uploading %d of %d: %v", started, len(actions), actions[index].LocalPath),
+					len(actions)-int(started))
+				errs[index] = service.executeUpload(ctx, actions[index])
+			}
+		}()
+	}
+
+	var ticks <-chan time.Time // nil means no limit
+	if rate := service.uploadRate(); rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	for index := range actions {
+		if index > 0 && ticks != nil {
+			<-ticks
+		}
+		if ctx.Err() != nil {
+			errs[index] = ctx.Err() // cancelled, the rest are uploaded next time
+			continue
+		}
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}
+
+//*********************************************************
+
+func (service *Service) executeUpload(ctx context.Context, action Action) error {
+	var err error
+	switch action.Type {
+	case ACTION_CREATE_REMOTE:
+		err = service.handleCreate(ctx, action.LocalPath, action.LocalInfo)
+	case ACTION_UPDATE_REMOTE:
+		if action.Conflict {
+			err = service.keepRemoteConflictCopy(ctx, action.LocalPath, action.Remote)
+			if err != nil {
+				break
+			}
+		}
+		err = service.handleSingleUpload(ctx, action.LocalPath, action.LocalInfo.ModTime(), action.LocalInfo.Size())
+	case ACTION_CONFLICT:
+		// the download will bring over the newer remote version once the local version is out of the way
+		err = service.keepLocalConflictCopy(ctx, action.LocalPath)
+	case ACTION_MOVE_REMOTE:
+		err = service.handleMove(ctx, action)
+	}
+	return err
+}
+
+//*********************************************************
+
+// carries out a download plan, returns true if anything was downloaded, the moves and folders are done in order
+// first so every file has its folder, then the files are downloaded by a pool of workers
+func (service *Service) executeDownloads(ctx context.Context, plan Plan) bool {
+	somethingWasDownloaded := false
+	defer service.setTransfer("", 0)
+
+	var fileActions []Action
+	for _, action := range plan.Actions {
+		if action.Type == ACTION_MOVE_LOCAL {
+			err := service.handleLocalMove(action)
+			if err != nil {
+				service.log.Warn("failed to move", action.FromPath, "to", action.LocalPath, err)
+				service.syncErrors[action.LocalPath] = err.Error()
+				continue
+			}
+			somethingWasDownloaded = true
+			if strings.Contains(action.Remote.MimeType, "folder") || service.isShortcutLink(action.Remote) ||
+				service.sameContents(action.LocalPath, service.getMd5OfFile(action.LocalPath), action.Remote.Md5Checksum) {
+				continue
+			}
+			// it was also changed on Google Drive, so download the new contents over the moved file
+		} else if action.Type == ACTION_DOWNLOAD_NEW && service.alreadyMovedHere(action) {
+			continue
+		}
+
+		if strings.Contains(action.Remote.MimeType, "folder") {
+			err := service.fileSystem.Mkdir(action.LocalPath, 0766)
+			if err == nil {
+				service.localFiles[action.LocalPath] = true // save this so we aren't surprised later that a new folder appeared
+				somethingWasDownloaded = true
+				service.log.Debug("created local folder", action.LocalPath)
+			} else {
+				service.log.Error(err)
+			}
+			continue
+		}
+
+		fileActions = append(fileActions, action)
+	}
+
+	// the workers only download, the results are handled here in the order of the plan so none of the maps of the
+	// service are touched by more than one goroutine
+	errs := service.downloadFiles(ctx, fileActions)
+	for i, action := range fileActions {
+		if errs[i] != nil {
+			service.syncErrors[action.LocalPath] = errs[i].Error()
+			service.log.with(service.actionLogFields(action)).Warn("failed to download", action.LocalPath, ":", errs[i])
+			continue
+		}
+		service.log.with(service.actionLogFields(action)).Info("downloaded", action.LocalPath)
+		service.localFiles[action.LocalPath] = true // save this so we aren't surprised later that a new file appeared
+		somethingWasDownloaded = true
+		service.cycle.downloaded = append(service.cycle.downloaded, action.LocalPath)
+
+		modTime, _ := time.Parse(time.RFC3339Nano, action.Remote.ModifiedTime)
+		err := service.fileSystem.Chtimes(action.LocalPath, modTime, modTime)
+		if err != nil {
+			service.log.Error(err)
+		} else {
+			service.checkModTimeKept(action.LocalPath, modTime)
+		}
+	}
+
+	return somethingWasDownloaded
+}
+
+//*********************************************************
+
+// downloads the files using up to downloadWorkers at a time, returns the error of each one in the same order
+func (service *Service) downloadFiles(ctx context.Context, actions []Action) []error {
+	errs := make([]error, len(actions))
+	var numStarted int64
+	var wg sync.WaitGroup
+
+	jobs := make(chan int)
+	for i := 0; i < service.downloadWorkers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range jobs {
+				action := actions[index]
+				started := atomic.AddInt64(&numStarted, 1)
+				service.setTransfer(fmt.Sprintf("downloading %d of %d: %v", started, len(actions), action.LocalPath),
+					len(actions)-int(started))
+
+				if service.inRouteFolder(action.LocalPath) {
+					// the route folder might not be on Google Drive, so the sync hasn't made it
+					if err := service.makeLocalFolders(filepath.Dir(action.LocalPath)); err != nil {
+						errs[index] = err
+						continue
+					}
+				}
+
+				if service.isShortcutLink(action.Remote) {
+					errs[index] = writeShortcutLink(service.fileSystem, action.LocalPath, action.Remote)
+				} else if handler := matchHandler(service.settings.DownloadHandlers, action.LocalPath); handler != nil {
+					errs[index] = service.downloadThroughHandler(ctx, handler, action)
+				} else {
+					exportMimeType, _ := service.exportMimeType(action.Remote)
+					downloadStarted := service.clock.Now()
+					progress, finished := service.startProgress(TRANSFER_DOWNLOAD, action.LocalPath, action.Remote.Size)
+					localMd5, err := service.conn.downloadFile(ctx, service.fileSystem, contentsId(action.Remote), action.LocalPath,
+						action.Remote.Md5Checksum, action.Remote.Size, exportMimeType, progress)
+					finished()
+					if localMd5 != "" {
+						service.logDownload(service.fileSystem, action.LocalPath, action, localMd5, downloadStarted)
+					}
+					errs[index] = err
+				}
+			}
+		}()
+	}
+
+	for index := range actions {
+		if ctx.Err() != nil {
+			errs[index] = ctx.Err() // cancelled, the rest are downloaded next time
+			continue
+		}
+		jobs <- index
+	}
+	close(jobs)
+	wg.Wait()
+
+	return errs
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Planner decides what the next sync would do without uploading, downloading or deleting anything,
+// so a program embedding the engine can show or check the plan first. Nothing is saved or renamed while planning,
+// and what the service knows about both sides is put back afterwards, so the next sync does the same as if there
+// had been no plan. Only the caches of the md5's and the remote folders keep what was looked up.
+type Planner struct {
+	service *Service
+}
+
+// the state that planning changes, see savePlannerState
+type plannerState struct {
+	localFiles        map[string]bool
+	heldFiles         map[string]bool
+	missingLocalFiles map[string]bool
+	filesToUpload     map[string]bool
+	filesToDownload   map[string]FileMetaData
+	uploadLookupMap   map[string]FileMetaData
+	downloadLookupMap map[string]FileMetaData
+	syncErrors        map[string]string
+	knownFolders      map[string]time.Time
+	failedRemoteItems map[string]failedRemoteItem
+
+	mostRecentTimestampSeen time.Time
+	pendingChangesPageToken string
+	reconciledAt            time.Time
+	windowSkipped           int
+	windowBacklog           map[string]FileMetaData
+	plannedDeletions        Plan
+	dryRun                  bool
+
+	changedPaths map[string]bool // what the watcher saw change, a plan takes them like a sync cycle would
+	fullWalk     bool
+}
+
+func (service *Service) Planner() Planner {
+	return Planner{service: service}
+}
+
+//*********************************************************
+
+// copies everything planning changes and turns on the dry run so the pending deletions and the renames on
+// Google Drive are planned instead of saved or done
+func (service *Service) savePlannerState() plannerState {
+	state := plannerState{
+		localFiles:              copyPathSet(service.localFiles),
+		heldFiles:               copyPathSet(service.heldFiles),
+		missingLocalFiles:       copyPathSet(service.missingLocalFiles),
+		filesToUpload:           copyPathSet(service.filesToUpload),
+		filesToDownload:         copyMetadataMap(service.filesToDownload),
+		uploadLookupMap:         copyMetadataMap(service.uploadLookupMap),
+		downloadLookupMap:       copyMetadataMap(service.downloadLookupMap),
+		syncErrors:              make(map[string]string),
+		knownFolders:            make(map[string]time.Time),
+		failedRemoteItems:       make(map[string]failedRemoteItem),
+		mostRecentTimestampSeen: service.mostRecentTimestampSeen,
+		pendingChangesPageToken: service.pendingChangesPageToken,
+		reconciledAt:            service.reconciledAt,
+		windowSkipped:           service.window.skipped,
+		windowBacklog:           service.window.backlog,
+		plannedDeletions:        service.plannedDeletions,
+		dryRun:                  service.dryRun,
+	}
+	for path, message := range service.syncErrors {
+		state.syncErrors[path] = message
+	}
+	for id, seenAt := range service.knownFolders {
+		state.knownFolders[id] = seenAt
+	}
+	for id, item := range service.failedRemoteItems {
+		state.failedRemoteItems[id] = item
+	}
+	if service.window.backlog != nil {
+		state.windowBacklog = copyMetadataMap(service.window.backlog)
+	}
+
+	local := &service.localWatch
+	local.mutex.Lock()
+	state.changedPaths = copyPathSet(local.changedPaths)
+	state.fullWalk = local.fullWalk
+	local.mutex.Unlock()
+
+	service.dryRun = true
+	service.plannedDeletions = Plan{}
+	return state
+}
+
+//*********************************************************
+
+// puts back the state from before planning, the changes the watcher saw in the meantime are kept
+func (service *Service) restorePlannerState(state plannerState) {
+	service.localFiles = state.localFiles
+	service.heldFiles = state.heldFiles
+	service.missingLocalFiles = state.missingLocalFiles
+	service.filesToUpload = state.filesToUpload
+	service.filesToDownload = state.filesToDownload
+	service.uploadLookupMap = state.uploadLookupMap
+	service.downloadLookupMap = state.downloadLookupMap
+	service.syncErrors = state.syncErrors
+	service.knownFolders = state.knownFolders
+	service.failedRemoteItems = state.failedRemoteItems
+	service.mostRecentTimestampSeen = state.mostRecentTimestampSeen
+	service.pendingChangesPageToken = state.pendingChangesPageToken
+	service.reconciledAt = state.reconciledAt
+	service.window.skipped = state.windowSkipped
+	service.window.backlog = state.windowBacklog
+	service.plannedDeletions = state.plannedDeletions
+	service.dryRun = state.dryRun
+
+	local := &service.localWatch
+	local.mutex.Lock()
+	if local.changedPaths == nil {
+		local.changedPaths = make(map[string]bool)
+	}
+	for path := range state.changedPaths {
+		local.changedPaths[path] = true
+	}
+	local.fullWalk = local.fullWalk || state.fullWalk
+	local.mutex.Unlock()
+}
+
+//*********************************************************
+
+func copyPathSet(paths map[string]bool) map[string]bool {
+	result := make(map[string]bool, len(paths))
+	for path, value := range paths {
+		result[path] = value
+	}
+	return result
+}
+
+func copyMetadataMap(metadataMap map[string]FileMetaData) map[string]FileMetaData {
+	result := make(map[string]FileMetaData, len(metadataMap))
+	for path, metadata := range metadataMap {
+		result[path] = metadata
+	}
+	return result
+}
+
+//*********************************************************
+
+// looks for new or modified local files and plans how to get them onto Google Drive
+func (planner Planner) PlanUploads(ctx context.Context) (Plan, error) {
+	service := planner.service
+	defer service.restorePlannerState(service.savePlannerState())
+
+	service.localFilesModified()
+	service.clearUploadLookupMap()
+	err := service.fillUploadLookupMap(ctx, service.getBaseFolderSlice())
+	if err != nil {
+		return Plan{}, err
+	}
+	return service.planUploads(), nil
+}
+
+//*********************************************************
+
+// looks for new or modified remote files and plans how to get them onto the local side, along with the local
+// copies of the trashed items that would be deleted
+func (planner Planner) PlanDownloads(ctx context.Context) (Plan, error) {
+	service := planner.service
+	defer service.restorePlannerState(service.savePlannerState())
+
+	remoteModifiedFiles, err := service.getRemoteModifiedFiles(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	service.clearDownloadLookupMap()
+	err = service.fillDownloadLookupMap(ctx, remoteModifiedFiles, true)
+	if err != nil {
+		return Plan{}, err
+	}
+	service.checkForDownloads()
+	plan := service.planDownloads()
+	plan.Actions = append(plan.Actions, service.plannedDeletions.Actions...)
+	return plan, nil
+}
+
+//*********************************************************
+
+// finds the orphaned files and plans how to delete them
+func (planner Planner) PlanCleanup(ctx context.Context) (Plan, error) {
+	return planner.service.planCleanup(ctx)
+}
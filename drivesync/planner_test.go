@@ -0,0 +1,321 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a service with the default settings and one base folder, its config folder is a temp folder so nothing the
+// tests save ends up in ./config
+func newTestService(t *testing.T, fileSystem FS, baseFolder string) *Service {
+	t.Helper()
+	oldConfigDir := configDir
+	SetConfigDir(t.TempDir())
+	t.Cleanup(func() { SetConfigDir(oldConfigDir) })
+
+	service := &Service{}
+	service.initializeLoggers()
+	service.clock = realClock{}
+	service.fileSystem = fileSystem
+	service.settings = parseSettings("test settings", nil)
+	service.baseFolders = map[string]string{baseFolder: "base-id"}
+	service.initializeMaps()
+	service.initializeHashing()
+	service.resetVerifiedTime()
+	return service
+}
+
+//*********************************************************
+
+func md5Of(contents string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(contents)))
+}
+
+func driveTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// the plan as strings that are easy to compare, like "CreateRemote a.txt"
+func planSummary(plan Plan, baseFolder string) []string {
+	var summary []string
+	for _, action := range plan.Actions {
+		relativePath, _ := filepath.Rel(baseFolder, action.LocalPath)
+		summary = append(summary, fmt.Sprintf("%v %v", action.Type, filepath.ToSlash(relativePath)))
+	}
+	return summary
+}
+
+func checkPlan(t *testing.T, plan Plan, baseFolder string, expected []string) {
+	t.Helper()
+	summary := planSummary(plan, baseFolder)
+	if !equalStrings(summary, expected) {
+		t.Errorf("planned %q, expected %q", summary, expected)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type localTestFile struct {
+	path     string // relative to the base folder, ending in / for a folder
+	contents string
+	age      time.Duration // how long ago it was modified
+}
+
+func writeLocalTestFiles(t *testing.T, baseFolder string, files []localTestFile) {
+	t.Helper()
+	for _, file := range files {
+		localPath := filepath.Join(baseFolder, filepath.FromSlash(file.path))
+		if file.path[len(file.path)-1] == '/' {
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(localPath, []byte(file.contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-file.age)
+		if err := os.Chtimes(localPath, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestPlanUploads(t *testing.T) {
+	now := time.Now()
+	hourAgo := driveTime(now.Add(-time.Hour))
+	dayAgo := driveTime(now.Add(-24 * time.Hour))
+
+	tests := []struct {
+		name     string
+		local    []localTestFile
+		remote   map[string]FileMetaData // key = path relative to the base folder
+		missing  []string                // the files that disappeared since the last walk
+		maxBytes int64
+		expected []string
+	}{
+		{
+			name:     "new file and folder, the folder first",
+			local:    []localTestFile{{path: "a.txt", contents: "a", age: time.Minute}, {path: "docs/"}},
+			expected: []string{"CreateRemote docs", "CreateRemote a.txt"},
+		},
+		{
+			name:     "local is newer with other contents",
+			local:    []localTestFile{{path: "a.txt", contents: "new", age: time.Minute}},
+			remote:   map[string]FileMetaData{"a.txt": {ID: "a", Md5Checksum: md5Of("old"), ModifiedTime: hourAgo}},
+			expected: []string{"UpdateRemote a.txt"},
+		},
+		{
+			name:     "local is newer with the same contents",
+			local:    []localTestFile{{path: "a.txt", contents: "same", age: time.Minute}},
+			remote:   map[string]FileMetaData{"a.txt": {ID: "a", Md5Checksum: md5Of("same"), ModifiedTime: hourAgo}},
+			expected: nil,
+		},
+		{
+			name:     "same time",
+			local:    []localTestFile{{path: "a.txt", contents: "new", age: time.Hour}},
+			remote:   map[string]FileMetaData{"a.txt": {ID: "a", Md5Checksum: md5Of("old"), ModifiedTime: hourAgo}},
+			expected: nil,
+		},
+		{
+			name:     "folder that is already on the server",
+			local:    []localTestFile{{path: "docs/"}},
+			remote:   map[string]FileMetaData{"docs": {ID: "docs", MimeType: "application/vnd.google-apps.folder", ModifiedTime: dayAgo}},
+			expected: nil,
+		},
+		{
+			name:  "export of a Google Doc",
+			local: []localTestFile{{path: "notes.docx", contents: "exported", age: time.Minute}},
+			remote: map[string]FileMetaData{"notes.docx": {ID: "notes", MimeType: "application/vnd.google-apps.document",
+				ModifiedTime: dayAgo}},
+			expected: nil,
+		},
+		{
+			name:     "bigger than max_upload_mb",
+			local:    []localTestFile{{path: "big.bin", contents: "0123456789", age: time.Minute}},
+			maxBytes: 5,
+			expected: nil,
+		},
+		{
+			name:  "renamed locally",
+			local: []localTestFile{{path: "new name.txt", contents: "moved", age: time.Minute}},
+			remote: map[string]FileMetaData{"old name.txt": {ID: "moved", Md5Checksum: md5Of("moved"), Size: 5,
+				ModifiedTime: dayAgo}},
+			missing:  []string{"old name.txt"},
+			expected: []string{"MoveRemote new name.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseFolder := t.TempDir()
+			service := newTestService(t, osFS{}, baseFolder)
+			service.settings.MaxUploadBytes = test.maxBytes
+			writeLocalTestFiles(t, baseFolder, test.local)
+			for _, file := range test.local {
+				service.filesToUpload[filepath.Join(baseFolder, filepath.FromSlash(file.path))] = true
+			}
+			for relativePath, remote := range test.remote {
+				service.uploadLookupMap[filepath.Join(baseFolder, filepath.FromSlash(relativePath))] = remote
+			}
+			for _, relativePath := range test.missing {
+				service.missingLocalFiles[filepath.Join(baseFolder, filepath.FromSlash(relativePath))] = true
+			}
+
+			checkPlan(t, service.planUploads(), baseFolder, test.expected)
+		})
+	}
+}
+
+//*********************************************************
+
+func TestPlanUploadsConflict(t *testing.T) {
+	baseFolder := t.TempDir()
+	service := newTestService(t, osFS{}, baseFolder)
+	service.verifiedAt = time.Now().Add(-2 * time.Hour)
+	writeLocalTestFiles(t, baseFolder, []localTestFile{
+		{path: "local newer.txt", contents: "local", age: time.Hour},
+		{path: "remote newer.txt", contents: "local", age: time.Hour},
+	})
+	service.filesToUpload[filepath.Join(baseFolder, "local newer.txt")] = true
+	service.filesToUpload[filepath.Join(baseFolder, "remote newer.txt")] = true
+	service.uploadLookupMap[filepath.Join(baseFolder, "local newer.txt")] = FileMetaData{ID: "a", Md5Checksum: md5Of("remote"),
+		ModifiedTime: driveTime(time.Now().Add(-90 * time.Minute))}
+	service.uploadLookupMap[filepath.Join(baseFolder, "remote newer.txt")] = FileMetaData{ID: "b", Md5Checksum: md5Of("remote"),
+		ModifiedTime: driveTime(time.Now().Add(-time.Minute))}
+
+	plan := service.planUploads()
+	checkPlan(t, plan, baseFolder, []string{"UpdateRemote local newer.txt", "Conflict remote newer.txt"})
+	if !plan.Actions[0].Conflict {
+		t.Error("expected the remote version of the update to be kept as a conflict copy")
+	}
+}
+
+//*********************************************************
+
+func TestPlanUploadsDropsRemovedFiles(t *testing.T) {
+	baseFolder := t.TempDir()
+	service := newTestService(t, osFS{}, baseFolder)
+	gone := filepath.Join(baseFolder, "gone.txt")
+	service.filesToUpload[gone] = true
+	service.localFiles[gone] = true
+
+	checkPlan(t, service.planUploads(), baseFolder, nil)
+	if service.filesToUpload[gone] || service.localFiles[gone] {
+		t.Error("expected the removed file to be forgotten")
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestPlanDownloads(t *testing.T) {
+	folderMimeType := "application/vnd.google-apps.folder"
+
+	tests := []struct {
+		name      string
+		local     []localTestFile
+		remote    map[string]FileMetaData // key = path relative to the base folder
+		remoteIds map[string]string       // key = id, value = the path relative to the base folder it was synced to
+		expected  []string
+	}{
+		{
+			name:     "new file and folder, the folder first",
+			remote:   map[string]FileMetaData{"a.txt": {ID: "a"}, "docs": {ID: "docs", MimeType: folderMimeType}},
+			expected: []string{"DownloadNew docs", "DownloadNew a.txt"},
+		},
+		{
+			name:     "file that is already there",
+			local:    []localTestFile{{path: "a.txt", contents: "old", age: time.Hour}},
+			remote:   map[string]FileMetaData{"a.txt": {ID: "a"}},
+			expected: []string{"OverwriteLocal a.txt"},
+		},
+		{
+			name:      "renamed on Google Drive",
+			local:     []localTestFile{{path: "old.txt", contents: "same", age: time.Hour}},
+			remote:    map[string]FileMetaData{"new.txt": {ID: "a", Md5Checksum: md5Of("same")}},
+			remoteIds: map[string]string{"a": "old.txt"},
+			expected:  []string{"MoveLocal new.txt"},
+		},
+		{
+			name:      "folder moved on Google Drive",
+			local:     []localTestFile{{path: "old/"}},
+			remote:    map[string]FileMetaData{"new": {ID: "f", MimeType: folderMimeType}},
+			remoteIds: map[string]string{"f": "old"},
+			expected:  []string{"MoveLocal new"},
+		},
+		{
+			name:      "renamed to where a local file already is",
+			local:     []localTestFile{{path: "old.txt", contents: "same", age: time.Hour}, {path: "new.txt", contents: "x", age: time.Hour}},
+			remote:    map[string]FileMetaData{"new.txt": {ID: "a", Md5Checksum: md5Of("same")}},
+			remoteIds: map[string]string{"a": "old.txt"},
+			expected:  []string{"OverwriteLocal new.txt"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			baseFolder := t.TempDir()
+			service := newTestService(t, osFS{}, baseFolder)
+			writeLocalTestFiles(t, baseFolder, test.local)
+			for relativePath, remote := range test.remote {
+				service.filesToDownload[filepath.Join(baseFolder, filepath.FromSlash(relativePath))] = remote
+			}
+			for id, relativePath := range test.remoteIds {
+				service.remoteIds[id] = filepath.Join(baseFolder, filepath.FromSlash(relativePath))
+			}
+
+			checkPlan(t, service.planDownloads(), baseFolder, test.expected)
+		})
+	}
+}
+
+//*********************************************************
+
+func TestPlanningLeavesStateAsItWas(t *testing.T) {
+	baseFolder := t.TempDir()
+	service := newTestService(t, osFS{}, baseFolder)
+	service.conn.client = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"application/json"}},
+			Body: io.NopCloser(strings.NewReader(`{"files":[]}`)), Request: req}, nil
+	})}
+	writeLocalTestFiles(t, baseFolder, []localTestFile{{path: "new.txt", contents: "new", age: time.Minute}})
+
+	plan, err := service.Planner().PlanUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, baseFolder, []string{"CreateRemote new.txt"})
+
+	if len(service.filesToUpload) != 0 || len(service.localFiles) != 0 || len(service.uploadLookupMap) != 0 {
+		t.Errorf("planning changed the state: filesToUpload %v, localFiles %v, uploadLookupMap %v",
+			service.filesToUpload, service.localFiles, service.uploadLookupMap)
+	}
+	if service.dryRun {
+		t.Error("planning left the dry run on")
+	}
+
+	// and the next plan still finds the same change
+	plan, err = service.Planner().PlanUploads(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, baseFolder, []string{"CreateRemote new.txt"})
+}
@@ -0,0 +1,18 @@
+package drivesync
+
+import (
+	"os/exec"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if plugged in, or if there's no way to tell
+func onACPower() bool {
+	output, err := exec.Command("pmset", "-g", "batt").Output()
+	if err != nil {
+		return true
+	}
+	return !strings.Contains(string(output), "'Battery Power'")
+}
@@ -0,0 +1,51 @@
+package drivesync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if plugged in, or if there's no way to tell
+func onACPower() bool {
+	supplies, err := filepath.Glob("/sys/class/power_supply/*")
+	if err != nil || len(supplies) == 0 {
+		return true
+	}
+
+	foundMains := false
+	discharging := false
+	for _, supply := range supplies {
+		supplyType := readPowerSupplyFile(supply, "type")
+		switch supplyType {
+		case "Mains":
+			foundMains = true
+			if readPowerSupplyFile(supply, "online") == "1" {
+				return true
+			}
+		case "Battery":
+			if readPowerSupplyFile(supply, "status") == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+
+	// some laptops don't report an adapter, so fall back to the battery status
+	if !foundMains {
+		return !discharging
+	}
+	return false
+}
+
+//*********************************************************
+
+func readPowerSupplyFile(supply string, name string) string {
+	data, err := os.ReadFile(filepath.Join(supply, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package drivesync
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// there's no way to tell on this platform, so assume it's plugged in
+func onACPower() bool {
+	return true
+}
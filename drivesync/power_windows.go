@@ -0,0 +1,36 @@
+package drivesync
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// matches the SYSTEM_POWER_STATUS struct from the Windows API
+type systemPowerStatus struct {
+	ACLineStatus        byte
+	BatteryFlag         byte
+	BatteryLifePercent  byte
+	SystemStatusFlag    byte
+	BatteryLifeTime     uint32
+	BatteryFullLifeTime uint32
+}
+
+var procGetSystemPowerStatus = syscall.NewLazyDLL("kernel32.dll").NewProc("GetSystemPowerStatus")
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if plugged in, or if there's no way to tell
+func onACPower() bool {
+	var status systemPowerStatus
+	ret, _, _ := procGetSystemPowerStatus.Call(uintptr(unsafe.Pointer(&status)))
+	if ret == 0 {
+		return true
+	}
+
+	// 0 is offline, 1 is online, 255 is unknown
+	return status.ACLineStatus != 0
+}
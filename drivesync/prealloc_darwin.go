@@ -0,0 +1,37 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// matches the fstore_t struct from fcntl.h
+type fstore struct {
+	flags      uint32
+	posmode    int32
+	offset     int64
+	length     int64
+	bytesalloc int64
+}
+
+const (
+	F_ALLOCATEALL = 0x4
+	F_PEOFPOSMODE = 3
+	F_PREALLOCATE = 42
+)
+
+// reserves the disk space for the whole file without changing its size
+func preallocate(file *os.File, size int64) error {
+	store := fstore{flags: F_ALLOCATEALL, posmode: F_PEOFPOSMODE, length: size}
+	_, _, errno := syscall.Syscall(syscall.SYS_FCNTL, file.Fd(), F_PREALLOCATE, uintptr(unsafe.Pointer(&store)))
+	if errno == syscall.ENOSPC {
+		return fmt.Errorf("%v needs %.1f MB: %w", file.Name(), float64(size)/(1024*1024), ErrNoSpace)
+	}
+	// some filesystems can't preallocate, the download still works without it
+	return nil
+}
@@ -0,0 +1,22 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const FALLOC_FL_KEEP_SIZE = 1
+
+// reserves the disk space for the whole file without changing its size
+func preallocate(file *os.File, size int64) error {
+	err := syscall.Fallocate(int(file.Fd()), FALLOC_FL_KEEP_SIZE, 0, size)
+	if err == syscall.ENOSPC {
+		return fmt.Errorf("%v needs %.1f MB: %w", file.Name(), float64(size)/(1024*1024), ErrNoSpace)
+	}
+	// some filesystems can't preallocate, the download still works without it
+	return nil
+}
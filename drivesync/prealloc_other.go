@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package drivesync
+
+import "os"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// not supported here, the download still works without it
+func preallocate(file *os.File, size int64) error {
+	return nil
+}
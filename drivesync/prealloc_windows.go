@@ -0,0 +1,29 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const FILE_ALLOCATION_INFO_CLASS = 5
+const ERROR_DISK_FULL syscall.Errno = 112
+
+var procSetFileInformationByHandle = syscall.NewLazyDLL("kernel32.dll").NewProc("SetFileInformationByHandle")
+
+// reserves the disk space for the whole file without changing its size, SetFileValidData would also
+// skip zeroing the space but it needs a privilege that normal users don't have
+func preallocate(file *os.File, size int64) error {
+	allocationSize := size // matches the FILE_ALLOCATION_INFO struct
+	ret, _, err := procSetFileInformationByHandle.Call(file.Fd(), FILE_ALLOCATION_INFO_CLASS,
+		uintptr(unsafe.Pointer(&allocationSize)), unsafe.Sizeof(allocationSize))
+	if ret == 0 && err == ERROR_DISK_FULL {
+		return fmt.Errorf("%v needs %.1f MB: %w", file.Name(), float64(size)/(1024*1024), ErrNoSpace)
+	}
+	// some filesystems can't preallocate, the download still works without it
+	return nil
+}
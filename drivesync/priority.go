@@ -0,0 +1,110 @@
+package drivesync
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A few files, like a spreadsheet that the whole team keeps open, can be marked with priority_file so a change on
+// Google Drive is downloaded within seconds instead of at the next check. Between the cycles the priority files
+// are looked up by their ids every priority_poll_seconds, all of them in one batch request, and a file that is
+// newer on Google Drive is downloaded right away. Anything else, a move, a trash or a change on both sides, is left
+// for the next cycle. A file is only polled once it was synced, since its id comes from the sync.
+
+const DEFAULT_PRIORITY_POLL_INTERVAL = 15 * time.Second
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// fires when the priority files are due to be polled, never if there are none
+func (service *Service) priorityPollTimer() <-chan time.Time {
+	if len(service.settings.PriorityFiles) == 0 || service.settings.PriorityPollInterval <= 0 {
+		return nil
+	}
+	return service.clock.After(service.settings.PriorityPollInterval << atomic.LoadInt64(&service.throttleLevel))
+}
+
+//*********************************************************
+
+// downloads the priority files that changed on Google Drive since they were synced
+func (service *Service) pollPriorityFiles(ctx context.Context) {
+	pathsById := make(map[string]string)
+	var ids []string
+	for id, localPath := range service.remoteIds {
+		for _, priorityPath := range service.settings.PriorityFiles {
+			if localPath == priorityPath {
+				pathsById[id] = localPath
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	items, err := service.conn.getMetadataByIds(ctx, ids)
+	if err != nil {
+		service.log.Warn("failed to poll the priority files:", err)
+		return
+	}
+
+	var plan Plan
+	for _, id := range ids {
+		localPath := pathsById[id]
+		remoteFileInfo, found := items[id]
+		if !found || !service.priorityFileChanged(localPath, remoteFileInfo) {
+			continue
+		}
+		plan.add(Action{Type: ACTION_OVERWRITE_LOCAL, LocalPath: localPath, Remote: remoteFileInfo, Reason: "priority file is newer on Google Drive",
+			Bytes: remoteFileInfo.Size})
+	}
+	if len(plan.Actions) == 0 {
+		return
+	}
+
+	service.setActivity("downloading priority files")
+	service.executeDownloads(ctx, plan)
+	service.lastChangeAt = service.clock.Now()
+	service.recordCycle()
+	service.notifyCycle()
+	service.setActivity("")
+}
+
+//*********************************************************
+
+// true if the remote file is newer and can simply be downloaded over the local one
+func (service *Service) priorityFileChanged(localPath string, remoteFileInfo FileMetaData) bool {
+	if remoteFileInfo.Trashed || remoteFileInfo.MimeType == "application/vnd.google-apps.folder" || service.isShortcutLink(remoteFileInfo) {
+		return false
+	}
+	if _, waiting := service.filesToUpload[localPath]; waiting {
+		return false
+	}
+	if service.localNameOf(remoteFileInfo) != filepath.Base(localPath) {
+		return false // it was renamed, the next cycle moves it
+	}
+
+	localFileInfo, err := service.fileSystem.Stat(localPath)
+	if err != nil || localFileInfo.IsDir() {
+		return false
+	}
+	remoteModTime, _ := time.Parse(time.RFC3339Nano, remoteFileInfo.ModifiedTime)
+	if remoteModTime.Sub(localFileInfo.ModTime()) <= service.timestampTolerance(localPath) {
+		return false
+	}
+	if service.changedOnBothSides(localFileInfo.ModTime(), remoteModTime) {
+		return false // the next cycle keeps a conflict copy
+	}
+	if _, exported := service.exportMimeType(remoteFileInfo); exported {
+		return true // an export has no md5
+	}
+	if isGoogleFile(remoteFileInfo) {
+		return false
+	}
+	return !service.sameContents(localPath, service.getMd5OfFile(localPath), remoteFileInfo.Md5Checksum)
+}
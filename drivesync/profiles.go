@@ -0,0 +1,82 @@
+package drivesync
+
+import (
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A profile sets the knobs that decide how hard the sync pushes Google Drive and the computer, the workers, the
+// request rates, the retries and the page size, all at once. balanced is what the sync does without a profile,
+// conservative suits a shared connection or a small quota, and aggressive a fast connection with its own quota.
+// A knob that is set by itself in the settings wins over the profile, wherever the profile line is.
+
+const (
+	PROFILE_CONSERVATIVE = "conservative"
+	PROFILE_BALANCED     = "balanced"
+	PROFILE_AGGRESSIVE   = "aggressive"
+)
+
+type tuningProfile struct {
+	DownloadWorkers int
+	UploadWorkers   int
+	HashWorkers     int
+	CleanupWorkers  int
+
+	UploadRatePerSecond  float64
+	ApiRatePerSecond     float64
+	CleanupRatePerSecond float64
+
+	MaxRetries int
+	PageSize   int
+}
+
+var tuningProfiles = map[string]tuningProfile{
+	PROFILE_CONSERVATIVE: {DownloadWorkers: 2, UploadWorkers: 2, HashWorkers: 1, CleanupWorkers: 2,
+		UploadRatePerSecond: 5, ApiRatePerSecond: 50, CleanupRatePerSecond: 2, MaxRetries: 8, PageSize: 500},
+	PROFILE_BALANCED: {DownloadWorkers: 4, UploadWorkers: 4, HashWorkers: 2, CleanupWorkers: 4,
+		UploadRatePerSecond: 10, ApiRatePerSecond: 150, CleanupRatePerSecond: 5, MaxRetries: 5, PageSize: 1000},
+	PROFILE_AGGRESSIVE: {DownloadWorkers: 8, UploadWorkers: 8, HashWorkers: 4, CleanupWorkers: 8,
+		UploadRatePerSecond: 20, ApiRatePerSecond: 200, CleanupRatePerSecond: 10, MaxRetries: 3, PageSize: 1000},
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the most items Google Drive returns in one page of a listing
+const MAX_PAGE_SIZE = 1000
+
+// the name of the profile on the last profile= line, balanced if there isn't one or it's not a known profile, it's
+// looked up before the other settings are read so they can override it
+func findProfile(fileName string, lines []string) string {
+	name := PROFILE_BALANCED
+	for _, line := range lines {
+		line_split := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(line_split) != 2 || strings.TrimSpace(line_split[0]) != "profile" {
+			continue
+		}
+		value := strings.TrimSpace(line_split[1])
+		if _, found := tuningProfiles[value]; !found {
+			serviceLog.Warn("ignoring invalid setting in", fileName, ":", "profile", value, ": should be", PROFILE_CONSERVATIVE+",",
+				PROFILE_BALANCED, "or", PROFILE_AGGRESSIVE)
+			continue
+		}
+		name = value
+	}
+	return name
+}
+
+//*********************************************************
+
+func (profile tuningProfile) apply(settings *Settings) {
+	settings.DownloadWorkers = profile.DownloadWorkers
+	settings.UploadWorkers = profile.UploadWorkers
+	settings.HashWorkers = profile.HashWorkers
+	settings.CleanupWorkers = profile.CleanupWorkers
+	settings.UploadRatePerSecond = profile.UploadRatePerSecond
+	settings.ApiRatePerSecond = profile.ApiRatePerSecond
+	settings.CleanupRatePerSecond = profile.CleanupRatePerSecond
+	settings.MaxRetries = profile.MaxRetries
+	settings.PageSize = profile.PageSize
+}
@@ -0,0 +1,197 @@
+package drivesync
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The progress of the uploads and downloads that are running, so the monitor and the control api can show how
+// far along each file is, how fast it's going and when it should be done. The bytes are counted as the contents go
+// through, an upload counts what is read from the file and a download counts what is written to it. A large
+// transfer that is still going is also logged every PROGRESS_LOG_INTERVAL, so a multi-GB file isn't a silent wait
+// in the log either.
+
+// how often the progress of a large transfer is logged
+const PROGRESS_LOG_INTERVAL = 30 * time.Second
+
+type TransferProgress struct {
+	Direction      string  `json:"direction"` // TRANSFER_UPLOAD or TRANSFER_DOWNLOAD
+	Path           string  `json:"path"`
+	Size           int64   `json:"size"` // 0 when it's not known, like for an exported Google Doc
+	Done           int64   `json:"done"`
+	BytesPerSecond float64 `json:"bytesPerSecond"` // the average since the transfer started or was resumed
+	SecondsLeft    int64   `json:"secondsLeft"`    // at that speed, 0 when it's not known
+}
+
+type fileProgress struct {
+	direction string
+	path      string
+	size      int64
+	log       logger
+
+	// atomic, the speed is measured from the bytes that were there when the transfer started or was resumed
+	done   int64
+	base   int64
+	since  int64 // unix nanoseconds
+	logged int64 // unix nanoseconds of when the progress was last logged
+}
+
+type progressTable struct {
+	mutex  sync.Mutex
+	active map[*fileProgress]bool
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the progress of the file is shown until the returned function is called
+func (service *Service) startProgress(direction string, localPath string, size int64) (*fileProgress, func()) {
+	now := time.Now().UnixNano()
+	progress := &fileProgress{direction: direction, path: localPath, size: size, log: service.log, since: now, logged: now}
+
+	table := &service.progress
+	table.mutex.Lock()
+	if table.active == nil {
+		table.active = make(map[*fileProgress]bool)
+	}
+	table.active[progress] = true
+	table.mutex.Unlock()
+
+	return progress, func() {
+		table.mutex.Lock()
+		delete(table.active, progress)
+		table.mutex.Unlock()
+	}
+}
+
+//*********************************************************
+
+// the transfers that are running, sorted by path
+func (service *Service) activeTransfers() []TransferProgress {
+	table := &service.progress
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	now := time.Now()
+	transfers := make([]TransferProgress, 0, len(table.active))
+	for progress := range table.active {
+		transfers = append(transfers, progress.snapshot(now))
+	}
+	sortTransferProgress(transfers)
+	return transfers
+}
+
+//*********************************************************
+
+func (progress *fileProgress) snapshot(now time.Time) TransferProgress {
+	transfer := TransferProgress{Direction: progress.direction, Path: progress.path, Size: progress.size,
+		Done: atomic.LoadInt64(&progress.done)}
+	since := time.Unix(0, atomic.LoadInt64(&progress.since))
+	if elapsed := now.Sub(since).Seconds(); elapsed > 0 {
+		transfer.BytesPerSecond = float64(transfer.Done-atomic.LoadInt64(&progress.base)) / elapsed
+	}
+	if transfer.BytesPerSecond > 0 && transfer.Done < transfer.Size {
+		transfer.SecondsLeft = int64(float64(transfer.Size-transfer.Done) / transfer.BytesPerSecond)
+	}
+	return transfer
+}
+
+//*********************************************************
+
+// counts the bytes that went through, and logs how far along a large transfer is once per interval
+func (progress *fileProgress) add(n int) {
+	done := atomic.AddInt64(&progress.done, int64(n))
+	if progress.size <= LARGE_FILE_THRESHOLD_BYTES {
+		return
+	}
+
+	// only one of the readers and writers of the transfer logs it
+	now := time.Now()
+	logged := atomic.LoadInt64(&progress.logged)
+	if now.Sub(time.Unix(0, logged)) < PROGRESS_LOG_INTERVAL || !atomic.CompareAndSwapInt64(&progress.logged, logged, now.UnixNano()) {
+		return
+	}
+	transfer := progress.snapshot(now)
+	verb := "uploading"
+	if progress.direction == TRANSFER_DOWNLOAD {
+		verb = "downloading"
+	}
+	progress.log.with(logFields{Path: progress.path, Bytes: done}).Infof("%v %v: %.1f of %.1f MB (%d%%), %.1f MB/s, about %v left",
+		verb, progress.path, float64(done)/(1024*1024), float64(progress.size)/(1024*1024), done*100/progress.size,
+		transfer.BytesPerSecond/(1024*1024), time.Duration(transfer.SecondsLeft)*time.Second)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a resumed transfer starts part of the way through, a nil progress does nothing
+func (progress *fileProgress) set(done int64) {
+	if progress == nil {
+		return
+	}
+	atomic.StoreInt64(&progress.done, done)
+	atomic.StoreInt64(&progress.base, done)
+	atomic.StoreInt64(&progress.since, time.Now().UnixNano())
+}
+
+//*********************************************************
+
+// counts what is read from the file, seeking back for a retry starts the count over from there, a nil progress
+// passes the file through
+func (progress *fileProgress) file(fh File) File {
+	if progress == nil {
+		return fh
+	}
+	return &progressFile{File: fh, progress: progress}
+}
+
+type progressFile struct {
+	File
+	progress *fileProgress
+}
+
+func (f *progressFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.progress.add(n)
+	return n, err
+}
+
+func (f *progressFile) ReadAt(p []byte, offset int64) (int, error) {
+	n, err := f.File.ReadAt(p, offset)
+	f.progress.add(n)
+	return n, err
+}
+
+func (f *progressFile) Seek(offset int64, whence int) (int64, error) {
+	position, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.progress.set(position)
+	}
+	return position, err
+}
+
+//*********************************************************
+
+// counts what is written, a nil progress passes the writer through
+func (progress *fileProgress) writer(writer io.Writer) io.Writer {
+	if progress == nil {
+		return writer
+	}
+	return &progressWriter{writer: writer, progress: progress}
+}
+
+type progressWriter struct {
+	writer   io.Writer
+	progress *fileProgress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.progress.add(n)
+	return n, err
+}
@@ -0,0 +1,22 @@
+package drivesync
+
+import (
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// asks the Finder to move the path to the Trash, unlike a plain rename this lets Put Back restore it
+func moveToRecycleBin(path string) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	quotedPath := strings.ReplaceAll(strings.ReplaceAll(absolutePath, `\`, `\\`), `"`, `\"`)
+	script := `tell application "Finder" to delete POSIX file "` + quotedPath + `"`
+	return exec.Command("osascript", "-e", script).Run()
+}
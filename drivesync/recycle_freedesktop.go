@@ -0,0 +1,73 @@
+//go:build !darwin && !windows
+// +build !darwin,!windows
+
+package drivesync
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// moves the path to the home trash from the freedesktop.org trash spec, which the desktop file managers
+// show and can restore from
+func moveToRecycleBin(path string) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	filesDir := filepath.Join(dataHome, "Trash", "files")
+	infoDir := filepath.Join(dataHome, "Trash", "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		err = os.MkdirAll(dir, 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	// the info file is created first and exclusively, that's how the spec reserves a name in the trash
+	baseName := filepath.Base(absolutePath)
+	for attempt := 1; attempt < 1000; attempt++ {
+		name := baseName
+		if attempt > 1 {
+			name = baseName + "." + strconv.Itoa(attempt)
+		}
+
+		infoFileName := filepath.Join(infoDir, name+".trashinfo")
+		fh, err := os.OpenFile(infoFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		escapedPath := (&url.URL{Path: absolutePath}).EscapedPath()
+		_, err = fmt.Fprintf(fh, "[Trash Info]\nPath=%v\nDeletionDate=%v\n", escapedPath, time.Now().Format("2006-01-02T15:04:05"))
+		fh.Close()
+		if err == nil {
+			err = os.Rename(absolutePath, filepath.Join(filesDir, name))
+		}
+		if err != nil {
+			os.Remove(infoFileName)
+		}
+		return err
+	}
+
+	return fmt.Errorf("could not find a free name in the trash for %v", baseName)
+}
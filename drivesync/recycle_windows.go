@@ -0,0 +1,32 @@
+package drivesync
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// sends the path to the Recycle Bin through the .NET FileSystem class, so it can be restored from there
+func moveToRecycleBin(path string) error {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	fileInfo, err := os.Stat(absolutePath)
+	if err != nil {
+		return err
+	}
+
+	method := "DeleteFile"
+	if fileInfo.IsDir() {
+		method = "DeleteDirectory"
+	}
+	quotedPath := "'" + strings.ReplaceAll(absolutePath, "'", "''") + "'"
+	command := "Add-Type -AssemblyName Microsoft.VisualBasic; [Microsoft.VisualBasic.FileIO.FileSystem]::" + method +
+		"(" + quotedPath + ", 'OnlyErrorDialogs', 'SendToRecycleBin')"
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", command).Run()
+}
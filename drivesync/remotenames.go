@@ -0,0 +1,86 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Google Drive allows names that can't be local file names, like "a/b", or on Windows "report." and "CON". They
+// are downloaded under a safe local name (see remoteNameToLocalName), which works but leaves the two sides with
+// different names. With rename_remote_names the item is renamed on Google Drive to the safe name instead, so both
+// sides agree. Each rename is written to config/renamed-remote-names.log with the id and the old and new names, so
+// it can be undone by hand. When a sibling already has the safe name, " (2)", " (3)" and so on are added.
+
+const RENAMED_REMOTE_NAMES_FILE_NAME = "config/renamed-remote-names.log"
+
+// the most numbered names that are tried before giving up on an item
+const MAX_RENAME_ATTEMPTS = 10
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// renames the items with names that can't be used locally, the map is updated with the new names
+func (service *Service) renameUnsafeRemoteItems(ctx context.Context, tempIdToMetaData map[string]FileMetaData) {
+	if !service.settings.RenameRemoteNames || service.settings.Mirror || service.dryRun {
+		return
+	}
+
+	for _, id := range sortedMetadataKeys(tempIdToMetaData) {
+		metadata := tempIdToMetaData[id]
+		if metadata.Name == "" || len(metadata.Parents) == 0 || metadata.Trashed || !isUnsafeRemoteName(metadata.Name) {
+			continue // the base folders have no name
+		}
+
+		newName, err := service.freeRemoteName(ctx, metadata.Parents[0], remoteNameToLocalName(metadata.Name))
+		if err != nil {
+			service.log.Warn("not renaming", metadata.Name, id, ":", err)
+			continue
+		}
+		renamed, err := service.conn.moveFile(ctx, id, metadata.Parents[0], metadata.Parents[0],
+			MoveFileRequest{Name: newName, ModifiedTime: metadata.ModifiedTime})
+		if err != nil {
+			service.log.Warn("failed to rename", metadata.Name, id, ":", err)
+			continue
+		}
+		service.log.Info("renamed", metadata.Name, "to", newName, "on Google Drive since it can't be a local name")
+		service.logRemoteRename(id, metadata.Name, newName)
+		tempIdToMetaData[id] = renamed
+	}
+}
+
+//*********************************************************
+
+// the name, or the name with a number added, that no other item in the folder has
+func (service *Service) freeRemoteName(ctx context.Context, parentId string, name string) (string, error) {
+	candidate := name
+	for attempt := 2; attempt <= MAX_RENAME_ATTEMPTS+1; attempt++ {
+		existing, err := service.conn.getItemsByName(ctx, parentId, candidate)
+		if err != nil {
+			return "", err
+		}
+		if len(existing) == 0 {
+			return candidate, nil
+		}
+		candidate = name + " (" + strconv.Itoa(attempt) + ")"
+	}
+	return "", fmt.Errorf("the folder already has %v and %v numbered copies of it", name, MAX_RENAME_ATTEMPTS)
+}
+
+//*********************************************************
+
+func (service *Service) logRemoteRename(id string, oldName string, newName string) {
+	fileName := service.configFile(RENAMED_REMOTE_NAMES_FILE_NAME)
+	fh, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		service.log.Warn("failed to log the rename:", err)
+		return
+	}
+	defer fh.Close()
+	fmt.Fprintf(fh, "%v\t%v\t%q\t%q\n", time.Now().Format(time.RFC3339), id, oldName, newName)
+}
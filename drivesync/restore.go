@@ -0,0 +1,124 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The items of the service account that are in the trash, from the cleanup or from a deletion on another
+// computer, can be listed and taken back out of the trash into the folder they were in. The next sync then
+// downloads them again.
+
+type TrashedItem struct {
+	Remote    FileMetaData
+	LocalPath string // empty when the folder it was in is no longer in one of the base folders
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// lists the items of the service account that are in the trash, sorted by path
+func (service *Service) TrashedItems(ctx context.Context) ([]TrashedItem, error) {
+	items, _, err := service.trashedItems(ctx)
+	return items, err
+}
+
+//*********************************************************
+
+// also returns every file of the service account, key = id, along with the parents that had to be looked up
+func (service *Service) trashedItems(ctx context.Context) ([]TrashedItem, map[string]FileMetaData, error) {
+	allServiceAcctFiles, err := service.conn.GetFilesOwnedByServiceAcct(ctx, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filesById := make(map[string]FileMetaData)
+	for _, file := range allServiceAcctFiles {
+		filesById[file.ID] = file
+	}
+
+	var items []TrashedItem
+	for _, file := range allServiceAcctFiles {
+		if !file.Trashed {
+			continue
+		}
+		item := TrashedItem{Remote: file}
+		if service.addParents(ctx, file, filesById) == nil {
+			item.LocalPath, _ = service.getFullPath(file.ID, filesById)
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].LocalPath != items[j].LocalPath {
+			return items[i].LocalPath < items[j].LocalPath
+		}
+		return items[i].Remote.ID < items[j].Remote.ID
+	})
+	return items, filesById, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// takes the items out of the trash, each target is a local path or an id, the folders above an item that are in
+// the trash too are restored along with it
+func (service *Service) RestoreFromTrash(ctx context.Context, targets []string) error {
+	items, filesById, err := service.trashedItems(ctx)
+	if err != nil {
+		return err
+	}
+
+	var notFound []string
+	restored := make(map[string]bool) // key = id
+	for _, target := range targets {
+		found := false
+		for _, item := range items {
+			if item.Remote.ID != target && (item.LocalPath == "" || item.LocalPath != filepath.Clean(target)) {
+				continue
+			}
+			found = true
+
+			// the trashed folders above it come first, from the top down
+			chain := []FileMetaData{item.Remote}
+			for parent := item.Remote; len(parent.Parents) > 0 && len(chain) < MAX_FOLDER_DEPTH; {
+				var inList bool
+				parent, inList = filesById[parent.Parents[0]]
+				if !inList || !parent.Trashed {
+					break
+				}
+				chain = append(chain, parent)
+			}
+			for i := len(chain) - 1; i >= 0; i-- {
+				if restored[chain[i].ID] {
+					continue
+				}
+				err := service.conn.setTrashed(ctx, chain[i].ID, false)
+				if err != nil {
+					return fmt.Errorf("failed to restore %v: %w", chain[i].Name, err)
+				}
+				restored[chain[i].ID] = true
+			}
+
+			description := item.LocalPath
+			if description == "" {
+				description = item.Remote.Name + " (" + item.Remote.ID + ")"
+			}
+			fmt.Println("restored", description)
+		}
+		if !found {
+			notFound = append(notFound, target)
+		}
+	}
+
+	if len(notFound) > 0 {
+		return fmt.Errorf("not in the trash: %v", strings.Join(notFound, ", "))
+	}
+	return nil
+}
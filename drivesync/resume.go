@@ -0,0 +1,83 @@
+package drivesync
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"os"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A large file is downloaded into a partial file next to it and only renamed into place once it's complete and the
+// md5 matches. When the connection drops the partial file is kept, and the next try asks Google Drive for the rest
+// with a Range header instead of starting over. The end of the partial file is downloaded again and compared with
+// what's already there, so a partial file left over from another version of the file is thrown away instead of
+// being finished with the wrong bytes.
+
+const PARTIAL_DOWNLOAD_SUFFIX = ".gdrive.partial"
+
+// how much of the end of the partial file is downloaded again to check that it's the same version
+const RESUME_OVERLAP_BYTES = 64 * 1024
+
+// implemented by the filesystems that can add to the end of a file, the others always download the whole file
+type appendFS interface {
+	Append(name string) (io.WriteCloser, error)
+}
+
+func (osFS) Append(name string) (io.WriteCloser, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// true if the download goes through a partial file that can be resumed, the exports have no size or md5 to check
+// the pieces against
+func resumableDownload(fileSystem FS, expectedMd5 string, expectedSize int64, exportMimeType string) bool {
+	_, canAppend := fileSystem.(appendFS)
+	return canAppend && exportMimeType == "" && expectedMd5 != "" && expectedSize > LARGE_FILE_THRESHOLD_BYTES
+}
+
+//*********************************************************
+
+// hashes the partial file up to where the download picks up again and returns that offset and the bytes after it,
+// which are downloaded again and compared. Returns 0 when there's nothing to resume.
+func readPartialDownload(fileSystem FS, partialName string, expectedSize int64, hash hash.Hash) (int64, []byte) {
+	info, err := fileSystem.Stat(partialName)
+	if err != nil || info.Size() <= RESUME_OVERLAP_BYTES || info.Size() > expectedSize {
+		return 0, nil
+	}
+	fh, err := fileSystem.Open(partialName)
+	if err != nil {
+		return 0, nil
+	}
+	defer fh.Close()
+
+	offset := info.Size() - RESUME_OVERLAP_BYTES
+	_, err = io.CopyN(hash, fh, offset)
+	if err != nil {
+		hash.Reset()
+		return 0, nil
+	}
+	overlap := make([]byte, RESUME_OVERLAP_BYTES)
+	_, err = io.ReadFull(fh, overlap)
+	if err != nil {
+		hash.Reset()
+		return 0, nil
+	}
+	return offset, overlap
+}
+
+//*********************************************************
+
+// reads the start of the response, which has to be the same as the end of the partial file
+func overlapMatches(body io.Reader, overlap []byte) (bool, error) {
+	received := make([]byte, len(overlap))
+	_, err := io.ReadFull(body, received)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(received, overlap), nil
+}
@@ -0,0 +1,161 @@
+package drivesync
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A request that Google Drive turns away because of a rate limit or a passing problem on its side is sent again,
+// with the default max_retries after 1, 2, 4, 8 and then 16 seconds plus up to a second of jitter, so the workers
+// that were turned away together don't all come back at the same moment. That's a 429, a 403 with the reason
+// userRateLimitExceeded or rateLimitExceeded, or a 5xx like backendError. A 403 for anything else, like a missing
+// permission or a full storage quota, won't go away by waiting so it's returned right away, and so is the last
+// response when the retries run out. Each of the rate limited responses is still counted for the throttle.
+
+const RETRY_BASE_DELAY = time.Second
+const RETRY_MAX_DELAY = 32 * time.Second
+
+// the most max_retries can be, with the longest delay that's already more than 10 minutes for one request
+const MAX_RETRIES_LIMIT = 20
+
+// the reasons in the body of a 403 that mean to wait and try again
+var retryableReasons = map[string]bool{
+	"userRateLimitExceeded": true,
+	"rateLimitExceeded":     true,
+	"backendError":          true,
+	"internalError":         true,
+}
+
+type retryingTransport struct {
+	base       http.RoundTripper
+	maxRetries int // max_retries
+	log        logger
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (t *retryingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		response, err := t.base.RoundTrip(req)
+		if err != nil || attempt >= t.maxRetries || !canResend(req) {
+			return response, err
+		}
+		retry, reason := shouldRetry(response)
+		if !retry {
+			return response, nil
+		}
+
+		delay := retryDelay(attempt, response)
+		t.log.Debug("status", response.StatusCode, reason, "for", req.URL.Path, "retrying in", delay.Round(time.Millisecond))
+		io.Copy(io.Discard, io.LimitReader(response.Body, MAX_ERROR_BODY_BYTES))
+		response.Body.Close()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+
+		// the body was used up by the last attempt, so a fresh copy is sent
+		if req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+//*********************************************************
+
+// a request can only be sent again if its body can be read again, the uploads of large files can't, they have
+// their own retries with the Content-Range
+func canResend(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+//*********************************************************
+
+// returns true and the reason if the response means to wait and try again, the body is put back for the caller
+func shouldRetry(response *http.Response) (bool, string) {
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		return true, "too many requests"
+	case response.StatusCode >= 500:
+		return true, http.StatusText(response.StatusCode)
+	case response.StatusCode != http.StatusForbidden:
+		return false, ""
+	}
+
+	bodyData, err := readErrorBody(response.Body)
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(bodyData), response.Body), response.Body}
+	if err != nil {
+		return false, ""
+	}
+	for _, reason := range errorReasons(bodyData) {
+		if retryableReasons[reason] {
+			return true, reason
+		}
+	}
+	return false, ""
+}
+
+//*********************************************************
+
+// exponential with jitter, or what the server asked for in Retry-After if that's longer
+func retryDelay(attempt int, response *http.Response) time.Duration {
+	// doubled one attempt at a time, shifting by the attempt would overflow after about 30 of them
+	delay := RETRY_BASE_DELAY
+	for i := 0; i < attempt && delay < RETRY_MAX_DELAY; i++ {
+		delay *= 2
+	}
+	if delay > RETRY_MAX_DELAY {
+		delay = RETRY_MAX_DELAY
+	}
+	delay += time.Duration(rand.Int63n(int64(time.Second)))
+
+	if seconds, err := strconv.Atoi(response.Header.Get("Retry-After")); err == nil {
+		if asked := time.Duration(seconds) * time.Second; asked > delay && asked <= RETRY_MAX_DELAY {
+			delay = asked
+		}
+	}
+	return delay
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the reasons in an error response from the API, {"error": {"errors": [{"reason": "rateLimitExceeded", ...}]}}
+func errorReasons(bodyData []byte) []string {
+	var body struct {
+		Error struct {
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(bodyData, &body) != nil {
+		return nil
+	}
+	var reasons []string
+	for _, apiError := range body.Error.Errors {
+		reasons = append(reasons, apiError.Reason)
+	}
+	return reasons
+}
@@ -0,0 +1,88 @@
+package drivesync
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A route downloads the files with a property into a folder of their own instead of next to where they are on
+// Google Drive, so download_route=category=invoice=Finance/Invoices puts every file whose properties or
+// appProperties have category=invoice in Finance/Invoices. The folder has to be inside a base folder, and it's
+// download only: the files in it are copies that are kept up to date from Google Drive, a local change to them is
+// never uploaded. The first route that matches wins, and folders are never routed.
+
+type DownloadRoute struct {
+	Key    string // the name of the property
+	Value  string // * matches any value
+	Folder string // the local folder the matching files are downloaded to
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the value is key=value=folder
+func parseDownloadRoute(value string) (DownloadRoute, error) {
+	value_split := strings.SplitN(value, "=", 3)
+	if len(value_split) != 3 {
+		return DownloadRoute{}, fmt.Errorf("expected key=value=folder")
+	}
+	route := DownloadRoute{Key: strings.TrimSpace(value_split[0]), Value: strings.TrimSpace(value_split[1]),
+		Folder: configNameToLocalPath(value_split[2])}
+	if route.Key == "" || route.Value == "" {
+		return route, fmt.Errorf("missing the property")
+	}
+	if strings.TrimSpace(value_split[2]) == "" {
+		return route, fmt.Errorf("missing the folder")
+	}
+	return route, nil
+}
+
+//*********************************************************
+
+// true if the properties or appProperties of the file have the key with the value
+func (route DownloadRoute) matches(remoteFileInfo FileMetaData) bool {
+	for _, properties := range []map[string]string{remoteFileInfo.Properties, remoteFileInfo.AppProperties} {
+		value, found := properties[route.Key]
+		if found && (route.Value == "*" || value == route.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// where the file is downloaded to, the path from its parents unless a route matches it
+func (service *Service) routedPath(localPath string, remoteFileInfo FileMetaData) string {
+	if remoteFileInfo.MimeType == "application/vnd.google-apps.folder" {
+		return localPath
+	}
+	for _, route := range service.settings.DownloadRoutes {
+		if !route.matches(remoteFileInfo) {
+			continue
+		}
+		if _, _, found := service.splitLocalPath(route.Folder); !found {
+			service.log.Debug("the route folder", route.Folder, "is not inside a base folder")
+			continue
+		}
+		return filepath.Join(route.Folder, filepath.Base(localPath))
+	}
+	return localPath
+}
+
+//*********************************************************
+
+// true if the path is in one of the route folders, which are download only
+func (service *Service) inRouteFolder(localPath string) bool {
+	for _, route := range service.settings.DownloadRoutes {
+		if localPathIsInside(route.Folder, localPath) {
+			return true
+		}
+	}
+	return false
+}
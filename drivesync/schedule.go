@@ -0,0 +1,89 @@
+package drivesync
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The local folders and Google Drive are checked on their own schedules, local_scan_seconds and
+// remote_check_seconds, and a cycle starts whenever one of them is due. A change seen by the watcher or the
+// files still waiting to be synced make a cycle check both sides. Right after something was synced both sides
+// can be checked more often for a while with fast_poll_seconds, since one change is usually followed by more.
+
+const DEFAULT_FAST_POLL_WINDOW = 10 * time.Minute
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the intervals that are used right now, with fast polling and the throttle applied
+func (service *Service) scanIntervals() (time.Duration, time.Duration) {
+	local := service.settings.LocalScanInterval
+	remote := service.settings.RemoteCheckInterval
+
+	fast := service.settings.FastPollInterval
+	if fast > 0 && service.clock.Now().Sub(service.lastChangeAt) < service.settings.FastPollWindow {
+		if fast < local {
+			local = fast
+		}
+		if fast < remote {
+			remote = fast
+		}
+	}
+
+	level := atomic.LoadInt64(&service.throttleLevel)
+	return local << level, remote << level
+}
+
+//*********************************************************
+
+// how long to wait until the next side is due to be checked
+func (service *Service) syncInterval() time.Duration {
+	local, remote := service.scanIntervals()
+	now := service.clock.Now()
+
+	wait := service.localScannedAt.Add(local).Sub(now)
+	if remoteWait := service.remoteCheckedAt.Add(remote).Sub(now); remoteWait < wait {
+		wait = remoteWait
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+//*********************************************************
+
+// which sides are due to be checked, a side that is almost due is checked along with the other one so the
+// cycles don't come right after each other
+func (service *Service) scansDue() (bool, bool) {
+	if len(service.filesToUpload) > 0 || len(service.filesToDownload) > 0 {
+		return true, true
+	}
+
+	local, remote := service.scanIntervals()
+	now := service.clock.Now().Add(time.Second)
+	return !now.Before(service.localScannedAt.Add(local)), !now.Before(service.remoteCheckedAt.Add(remote))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// changes how often the local folders and Google Drive are checked, from the settings, 0 keeps the current one
+func (service *Service) SetSyncInterval(local time.Duration, remote time.Duration) {
+	if local > 0 {
+		service.settings.LocalScanInterval = local
+	}
+	if remote > 0 {
+		service.settings.RemoteCheckInterval = remote
+	}
+}
+
+//*********************************************************
+
+// after something is synced both sides are checked this often for the fast_poll_minutes, 0 turns it off
+func (service *Service) SetFastPollInterval(interval time.Duration) {
+	service.settings.FastPollInterval = interval
+}
@@ -0,0 +1,145 @@
+package drivesync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The search command finds files by their contents with the full text search of Google Drive, which looks inside
+// the documents, PDFs and so on, not only at the names. Google Drive searches everything the account can see, so
+// the results are put in their place under the base folders by following their parents and the ones outside of
+// the synced folders are left out. With -download the matches that are not on this computer yet are downloaded.
+
+// the most results that are looked at, a search that finds more should be narrowed down
+const MAX_SEARCH_RESULTS = 1000
+
+type SearchResult struct {
+	Path         string    `json:"path"`
+	DriveId      string    `json:"driveId"`
+	ModifiedTime time.Time `json:"modifiedTime"`
+	Local        bool      `json:"local"` // there's a local copy
+	WebViewLink  string    `json:"webViewLink"`
+
+	remote FileMetaData
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// searches the contents of the files in the base folders, sorted by path
+func (service *Service) Search(ctx context.Context, query string) ([]SearchResult, error) {
+	service.loadIgnoreFiles()
+	service.loadNotSyncedFolders()
+
+	found, err := service.conn.searchFullText(ctx, query, MAX_SEARCH_RESULTS)
+	if err != nil {
+		return nil, err
+	}
+	if len(found) >= MAX_SEARCH_RESULTS {
+		service.log.Info("only looking at the first", MAX_SEARCH_RESULTS, "matches, try a longer query")
+	}
+
+	// the parents are looked up once for all of the results, the base folders end the paths
+	tempIdToMetaData := make(map[string]FileMetaData)
+	for _, folderName := range service.getBaseFolderSlice() {
+		id := service.baseFolders[folderName]
+		tempIdToMetaData[id] = FileMetaData{ID: id}
+	}
+
+	var results []SearchResult
+	for _, remoteFileInfo := range found {
+		if remoteFileInfo.MimeType == "application/vnd.google-apps.folder" || isIgnoredFile(remoteFileInfo.Name) {
+			continue
+		}
+		err := service.addParents(ctx, remoteFileInfo, tempIdToMetaData)
+		if err != nil {
+			continue // a parent that can't be seen, so it's not in the synced folders
+		}
+		tempIdToMetaData[remoteFileInfo.ID] = remoteFileInfo
+		localPath, err := service.getFullPath(remoteFileInfo.ID, tempIdToMetaData)
+		if err != nil || localPath == "" || service.isIgnoredPath(localPath, false) {
+			continue // outside of the base folders
+		}
+
+		modTime, _ := time.Parse(time.RFC3339Nano, remoteFileInfo.ModifiedTime)
+		_, statErr := service.fileSystem.Stat(localPath)
+		results = append(results, SearchResult{Path: localPath, DriveId: remoteFileInfo.ID, ModifiedTime: modTime, Local: statErr == nil,
+			WebViewLink: remoteFileInfo.WebViewLink, remote: remoteFileInfo})
+	}
+
+	sortSearchResults(results)
+	return results, nil
+}
+
+//*********************************************************
+
+// downloads the results that have no local copy yet, returns how many were downloaded, the folders they're in are
+// made if the sync hasn't made them yet
+func (service *Service) DownloadSearchResults(ctx context.Context, results []SearchResult) (int, error) {
+	var plan Plan
+	for _, result := range results {
+		if result.Local {
+			continue
+		}
+		if _, exported := service.exportMimeType(result.remote); isGoogleFile(result.remote) && !exported && !service.isShortcutLink(result.remote) {
+			continue // a Form or a type with export_format=off
+		}
+		err := service.makeLocalFolders(filepath.Dir(result.Path))
+		if err != nil {
+			return 0, err
+		}
+		plan.add(Action{Type: ACTION_DOWNLOAD_NEW, LocalPath: result.Path, Remote: result.remote, Reason: "matched the search",
+			Bytes: result.remote.Size})
+	}
+
+	service.executeDownloads(ctx, plan)
+	downloaded := 0
+	for _, action := range plan.Actions {
+		if err, failed := service.syncErrors[action.LocalPath]; failed {
+			service.log.Warn("failed to download", action.LocalPath, err)
+		} else {
+			downloaded++
+		}
+	}
+	if downloaded < len(plan.Actions) {
+		return downloaded, fmt.Errorf("%d of %d downloads failed", len(plan.Actions)-downloaded, len(plan.Actions))
+	}
+	return downloaded, nil
+}
+
+//*********************************************************
+
+// makes the folder and its parents inside a base folder
+func (service *Service) makeLocalFolders(folder string) error {
+	if _, err := service.fileSystem.Stat(folder); err == nil {
+		return nil
+	}
+	if _, names, found := service.splitLocalPath(folder); !found || len(names) == 0 {
+		return fmt.Errorf("the base folder of %v doesn't exist", folder)
+	}
+	err := service.makeLocalFolders(filepath.Dir(folder))
+	if err != nil {
+		return err
+	}
+	err = service.fileSystem.Mkdir(folder, 0766)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the query for the full text search, the quotes and backslashes in the text are escaped
+func fullTextQuery(text string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(text)
+	return "fullText contains '" + escaped + "' and trashed = false"
+}
@@ -0,0 +1,145 @@
+package drivesync
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Selective sync: a folder inside a base folder can be marked as not synced with the unsync command, then
+// nothing in it is uploaded or downloaded and its remote contents are not listed. The local copy is left alone.
+// The folders are kept in config/not-synced.txt, one per line, and a running sync picks up a change to it.
+
+const NOT_SYNCED_FILE_NAME = "config/not-synced.txt"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func readNotSyncedFolders(fileName string) (map[string]bool, error) {
+	folders := make(map[string]bool)
+
+	fh, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return folders, nil
+	}
+	if err != nil {
+		return folders, err
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		folders[configNameToLocalPath(line)] = true
+	}
+	return folders, scanner.Err()
+}
+
+//*********************************************************
+
+// reads config/not-synced.txt again if it changed, returns true if it did, since then the local folders have to be
+// walked and the remote folders listed again to pick up what is synced again
+func (service *Service) loadNotSyncedFolders() bool {
+	fileName := service.configFile(NOT_SYNCED_FILE_NAME)
+	var modTime time.Time
+	if fileInfo, err := os.Stat(fileName); err == nil {
+		modTime = fileInfo.ModTime()
+	}
+
+	service.ignores.mutex.Lock()
+	unchanged := modTime.Equal(service.ignores.notSyncedModTime)
+	service.ignores.mutex.Unlock()
+	if unchanged {
+		return false
+	}
+
+	folders, err := readNotSyncedFolders(fileName)
+	if err != nil {
+		service.log.Warn("failed to read", fileName, err)
+		return false
+	}
+
+	service.ignores.mutex.Lock()
+	service.ignores.notSynced = folders
+	service.ignores.notSyncedModTime = modTime
+	service.ignores.mutex.Unlock()
+	return true
+}
+
+//*********************************************************
+
+// returns true if the path is in a folder that is not synced, the caller holds the ignores mutex
+func (service *Service) inNotSyncedFolder(localPath string) bool {
+	for folder := range service.ignores.notSynced {
+		if localPathIsInside(folder, localPath) {
+			return true
+		}
+	}
+	return false
+}
+
+//*********************************************************
+
+func (service *Service) isNotSynced(localPath string) bool {
+	service.ignores.mutex.Lock()
+	defer service.ignores.mutex.Unlock()
+	return service.inNotSyncedFolder(localPath)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the folders that are not synced, sorted
+func (service *Service) NotSyncedFolders(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	folders, err := readNotSyncedFolders(service.configFile(NOT_SYNCED_FILE_NAME))
+	return sortedPaths(folders), err
+}
+
+//*********************************************************
+
+// marks a folder inside a base folder as synced or not synced, a base folder itself can't be unsynced, remove
+// it from config/folder-ids.txt instead
+func (service *Service) SetFolderSynced(ctx context.Context, localPath string, synced bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	localPath = filepath.Clean(localPath)
+	_, names, found := service.splitLocalPath(localPath)
+	if !found || len(names) == 0 {
+		return fmt.Errorf("%v is not a folder inside one of the base folders", localPath)
+	}
+
+	fileName := service.configFile(NOT_SYNCED_FILE_NAME)
+	folders, err := readNotSyncedFolders(fileName)
+	if err != nil {
+		return err
+	}
+	if synced {
+		if !folders[localPath] {
+			return fmt.Errorf("%v is not marked as not synced", localPath)
+		}
+		delete(folders, localPath)
+	} else {
+		folders[localPath] = true
+	}
+
+	var builder strings.Builder
+	for _, folder := range sortedPaths(folders) {
+		builder.WriteString(filepath.ToSlash(folder) + "\n")
+	}
+
+	return writeFileAtomically(fileName, []byte(builder.String()))
+}
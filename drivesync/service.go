@@ -0,0 +1,1264 @@
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type Service struct {
+	conn        Connection
+	settings    Settings
+	tenant      Tenant // only set when the service is one of the tenants of a Fleet
+	log         logger // serviceLog with the tenant and the API calls of conn, see initializeLoggers
+	cleanupLog  logger
+	clock       Clock
+	fileSystem  FS
+	baseFolders map[string]string // key = local folder name, value = folder id on Google Drive
+
+	localFiles        map[string]bool
+	heldFiles         map[string]bool // files that changed but were held back during the last walk
+	missingLocalFiles map[string]bool // files in localFiles that disappeared, they might have been renamed or moved
+
+	remoteIds map[string]string // key = id on Google Drive, value = the local path it was last synced to
+
+	pendingCreates map[string]pendingCreate // key = local path, the creates that were started but not finished
+	uploadMutex    sync.Mutex               // guards uploadLookupMap and pendingCreates while the upload workers run
+	parentsMutex   sync.Mutex               // only one upload worker at a time creates the missing folders
+
+	handled handledFiles // the files that went through a download or upload handler
+
+	window syncWindow // with initial_sync_days, the older files that are not downloaded yet
+
+	growingUploadedAt map[string]time.Time // key = local path, when a file with the every policy was last uploaded
+
+	filesToUpload     map[string]bool
+	filesToDownload   map[string]FileMetaData
+	uploadLookupMap   map[string]FileMetaData
+	downloadLookupMap map[string]FileMetaData // key = path + filename, value = metadata
+
+	verifiedAt              time.Time // if anything is newer than the verifiedAt timestamp, then we will upload/download
+	verifiedAtPlusOneSec    time.Time
+	mostRecentTimestampSeen time.Time // when successfully verified, the most recent timestamp seen will be set to verifiedAt
+
+	changesPageToken        string // the changes after this token still need to be looked at, empty means do a full search
+	pendingChangesPageToken string // becomes the changesPageToken once the changes we read have been handled
+
+	reconciledAt        time.Time
+	reconcileTimeLoaded bool // the saved state had reconciledAt, older versions didn't save it
+
+	localScannedAt  time.Time // when the local folders were last checked for changes, see scansDue
+	remoteCheckedAt time.Time // when Google Drive was last checked for changes
+	lastChangeAt    time.Time // when something was last synced, for the fast polling
+
+	failedRemoteItems map[string]failedRemoteItem // key = id, items that could not be placed in the download lookup map
+
+	knownFolders map[string]time.Time // key = folder id, value = when it was last seen in the user's folders
+
+	notifiers      []Notifier
+	alertNotifiers []Notifier // only get the alerts, the desktop and the webhook
+	verifyFailures int        // the cycles in a row that ended without verifying
+	authFailing    bool       // the credentials were refused and the alert was sent
+
+	throttleLevel int64        // the cycles are slowed down this many times because of rate limits, see adjustThrottle
+	stuckCycles   int64        // the cycles the watchdog cancelled, see watchedSyncCycle
+	cycle         cycleSummary // what was synced since the last notification
+
+	hashSlots     chan struct{} // limits how many files are hashed at the same time
+	chunks        chunkCache
+	md5s          md5Cache
+	metadataCache metadataCache // the remote folder listings, see metacache.go
+
+	volumes map[string]*volumeInfo // key = base folder
+
+	dryRun           bool       // nothing is changed, see DryRun
+	mirrorDirty      bool       // with mirror=true, a local change was seen and hasn't been put back by a reconciliation yet
+	plannedDeletions Plan       // the local deletions a dry run found
+	deletionsMutex   sync.Mutex // guards config/pending-deletions.json, the cycle, the cleanup and the api all change it
+
+	localWatch localWatch
+	ignores    driveIgnores  // the .driveignore of each base folder
+	monitor    monitorState  // what the monitor command shows
+	progress   progressTable // the uploads and downloads that are running
+
+	syncErrors    map[string]string // key = local path, value = the last error when syncing that file
+	statusMutex   sync.Mutex        // guards status and pendingStatus which are read by the status api
+	status        statusSnapshot
+	pendingStatus map[string]bool
+}
+
+// a remote item whose parents could not be looked up, it will be retried with a backoff
+type failedRemoteItem struct {
+	metadata FileMetaData
+	attempts int
+	retryAt  time.Time
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const LARGE_FILE_THRESHOLD_BYTES int64 = 5 * 1024 * 1024
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) initializeService() {
+	service.initializeLoggers()
+	service.clock = realClock{}
+	service.fileSystem = osFS{}
+	settings, config, err := loadConfig(service.configFile(CONFIG_FILE_NAME), service.configFile("config/settings.txt"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	service.settings = settings
+	service.applyTenantSettings()
+	service.conn.initializeGoogleDrive(service.settings)
+	service.initializeNotifiers()
+	service.initializeHashing()
+	service.loadChunkCache()
+	service.loadPendingCreates()
+	service.loadHandledFiles()
+	if service.settings.ReplayTrace == "" {
+		service.conn.loadIdPool(service.configFile(ID_POOL_FILE_NAME)) // a replay gets its ids from the trace
+	}
+
+	// get the id number for each main folder that is shared, save it for later
+	if config != nil {
+		service.baseFolders = config.baseFolders()
+	} else {
+		service.baseFolders, err = readFolderIds(service.configFile("config/folder-ids.txt"))
+		if err != nil {
+			log.Fatal("failed to read folder IDs, add them to ", service.configFile(CONFIG_FILE_NAME), " or ",
+				service.configFile("config/folder-ids.txt"), ": ", err)
+		}
+	}
+	err = validateBaseFolders(service.baseFolders, service.configFile(DEFAULT_CONFIG_DIR))
+	if err != nil {
+		log.Fatal("refusing to sync: ", err)
+	}
+
+	service.log.Info("these are our starting baseFolders:", service.baseFolders)
+	service.initializeMaps()
+}
+
+//*********************************************************
+
+// the lines of this service and its connection have the tenant name and count its own API calls
+func (service *Service) initializeLoggers() {
+	service.log = serviceLog.forService(service.tenant.Name, &service.conn.numApiCalls)
+	service.cleanupLog = cleanupLog.forService(service.tenant.Name, &service.conn.numApiCalls)
+	service.conn.log = connLog.forService(service.tenant.Name, &service.conn.numApiCalls)
+}
+
+//*********************************************************
+
+func (service *Service) initializeMaps() {
+	service.localFiles = make(map[string]bool)
+	service.heldFiles = make(map[string]bool)
+	service.missingLocalFiles = make(map[string]bool)
+	service.remoteIds = make(map[string]string)
+	service.growingUploadedAt = make(map[string]time.Time)
+	service.syncErrors = make(map[string]string)
+	service.filesToUpload = make(map[string]bool)
+	service.filesToDownload = make(map[string]FileMetaData)
+	service.uploadLookupMap = make(map[string]FileMetaData)
+	service.downloadLookupMap = make(map[string]FileMetaData)
+	service.failedRemoteItems = make(map[string]failedRemoteItem)
+	service.knownFolders = make(map[string]time.Time)
+	service.localWatch.localChanges = make(chan struct{}, 1)
+	service.monitor.syncNow = make(chan struct{}, 1)
+	service.volumes = make(map[string]*volumeInfo)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) resetVerifiedTime() {
+	service.verifiedAt = time.Date(2000, time.January, 1, 12, 0, 0, 0, time.UTC)
+	service.verifiedAtPlusOneSec = service.verifiedAt
+	service.changesPageToken = ""
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) setVerifiedTime() {
+	service.verifiedAt = service.mostRecentTimestampSeen
+	service.verifiedAtPlusOneSec = service.verifiedAt.Add(time.Second)
+	service.commitChangesPageToken()
+	service.saveState()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the changes we read have all been handled, so next time we only need the changes after them
+func (service *Service) commitChangesPageToken() {
+	if service.pendingChangesPageToken != "" && service.pendingChangesPageToken != service.changesPageToken {
+		service.changesPageToken = service.pendingChangesPageToken
+		service.saveState()
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// a full reconciliation re-walks the local folders and re-lists the remote folders to catch any missed changes
+func (service *Service) reconciliationIsDue() bool {
+	hoursSinceReconcile := service.clock.Now().Sub(service.reconciledAt).Hours()
+	return hoursSinceReconcile >= service.settings.ReconcileHours
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) setReconcileTime(reconcilingAt time.Time) {
+	service.reconciledAt = reconcilingAt
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) saveTimestamp(timestamp time.Time) {
+	// always keep the newest timestamp
+	diff := timestamp.Sub(service.mostRecentTimestampSeen)
+	if diff > 0 {
+		service.mostRecentTimestampSeen = timestamp
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) fillLocalMap() {
+	// use a closure so the walk function has access to localFiles
+
+	var walkFunc = func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if service.isIgnoredPath(path, fileInfo.IsDir()) {
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		service.localFiles[path] = true
+		return nil
+	}
+
+	for _, folder := range service.getBaseFolderSlice() {
+		service.fileSystem.Walk(folder, walkFunc)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) getBaseFolderSlice() []string {
+	return sortedStringKeys(service.baseFolders)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// finds the remote metadata for a local path by starting at the base folder and listing one folder at a time
+func (service *Service) FindRemoteItem(ctx context.Context, localPath string) (FileMetaData, error) {
+	baseFolder, names, found := service.splitLocalPath(localPath)
+	if found {
+		baseId := service.baseFolders[baseFolder]
+		if len(names) == 0 {
+			// the base folder itself, get the full metadata so we have the link
+			return service.conn.getMetadataById(ctx, baseFolder, baseId)
+		}
+
+		current := FileMetaData{ID: baseId, Name: baseFolder, MimeType: "application/vnd.google-apps.folder"}
+		currentPath := baseFolder
+		for _, name := range names {
+			data, err := service.conn.GetItemsInSharedFolder(ctx, currentPath, current.ID)
+			if err != nil {
+				return FileMetaData{}, err
+			}
+
+			found := false
+			for _, file := range data.Files {
+				if remoteNameToLocalName(file.Name) == name {
+					current = file
+					found = true
+					break
+				}
+			}
+			if !found {
+				return FileMetaData{}, errors.New("not found on Google Drive: " + filepath.Join(currentPath, name))
+			}
+			currentPath = filepath.Join(currentPath, name)
+		}
+
+		return current, nil
+	}
+
+	return FileMetaData{}, errors.New("path is not inside any of the base folders: " + localPath)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// remembers that the folder is in one of the user's folders, the cleanup uses this to avoid re-listing the whole tree
+func (service *Service) rememberFolder(folderId string) {
+	service.knownFolders[folderId] = service.clock.Now()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) clearUploadLookupMap() {
+	if len(service.uploadLookupMap) > 0 {
+		service.uploadLookupMap = make(map[string]FileMetaData)
+	}
+}
+
+//*********************************************************
+
+// the upload workers share the lookup map, everything that can run in a worker goes through these two
+func (service *Service) uploadedItem(localPath string) (FileMetaData, bool) {
+	service.uploadMutex.Lock()
+	defer service.uploadMutex.Unlock()
+	remoteMetaData, found := service.uploadLookupMap[localPath]
+	return remoteMetaData, found
+}
+
+func (service *Service) setUploadedItem(localPath string, remoteMetaData FileMetaData) {
+	service.uploadMutex.Lock()
+	service.uploadLookupMap[localPath] = remoteMetaData
+	service.uploadMutex.Unlock()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func localPathIsNeeded(localPath string, filesToUpload map[string]bool) bool {
+	// if any of the files to upload are inside this path then we need this path
+	for fileToUpload := range filesToUpload {
+		if localPathIsInside(localPath, fileToUpload) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (service *Service) fillUploadLookupMap(ctx context.Context, localFolders []string) error {
+	if service.settings.MetadataCache {
+		service.refreshMetadataCache(ctx)
+		if !service.dryRun {
+			defer service.saveMetadataCache()
+		}
+	}
+
+	// walk the remote tree one level at a time so all the sibling folders at a level can be listed together
+	for len(localFolders) > 0 {
+		folderPaths := make(map[string]string) // key = folder id, value = local folder path
+		var folderIds []string
+
+		for _, localFolder := range localFolders {
+			if service.isNotSynced(localFolder) {
+				continue // the user chose not to sync it, so don't even list it
+			}
+
+			// check if this localFolder is in the path of any of the filesToUpload, or of a file that
+			// disappeared, since a new file might be that one after it was renamed or moved
+			if !localPathIsNeeded(localFolder, service.filesToUpload) && !localPathIsNeeded(localFolder, service.missingLocalFiles) {
+				continue
+			}
+
+			var folderId string
+
+			// if localFolder is a base folder and not in the lookupMap, then add it
+			baseId, isBaseFolder := service.baseFolders[localFolder]
+			remoteMetaData, inLookupMap := service.uploadLookupMap[localFolder]
+			if isBaseFolder && !inLookupMap {
+				service.uploadLookupMap[localFolder] = FileMetaData{ID: baseId}
+				folderId = baseId
+			} else if inLookupMap {
+				folderId = remoteMetaData.ID
+			}
+
+			if folderId != "" {
+				folderPaths[folderId] = localFolder
+				folderIds = append(folderIds, folderId)
+				service.rememberFolder(folderId)
+			}
+		}
+
+		if len(folderIds) == 0 {
+			break
+		}
+
+		data, err := service.listFolders(ctx, folderIds)
+		if err != nil {
+			return err
+		}
+
+		// add the files and folders to our map, using the parent id to figure out which folder each one belongs to
+		var nextLevel []string
+		for _, file := range data.Files {
+			for _, parentId := range file.Parents {
+				localFolder, requested := folderPaths[parentId]
+				if !requested {
+					continue
+				}
+
+				localPath := localChildPath(localFolder, service.localNameOf(file))
+				existing, duplicate := service.uploadLookupMap[localPath]
+				if duplicate && !preferRemoteItem(existing, file) {
+					continue
+				}
+				service.uploadLookupMap[localPath] = file
+
+				// if any are folders then we will need to look up their contents as well on the next level
+				if strings.Contains(file.MimeType, "folder") {
+					nextLevel = append(nextLevel, localPath)
+				}
+			}
+		}
+
+		localFolders = nextLevel
+	}
+
+	service.dropLocalPathCollisions(service.uploadLookupMap)
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) clearDownloadLookupMap() {
+	if len(service.downloadLookupMap) > 0 {
+		service.downloadLookupMap = make(map[string]FileMetaData)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) fillDownloadLookupMap(ctx context.Context, remoteModifiedFiles []FileMetaData, doExtraFolderSearch bool) error {
+	tempIdToMetaData := make(map[string]FileMetaData) // key = id, value = metadata
+
+	// add the known base folders to the temp map and download lookup map
+	for _, folderName := range service.getBaseFolderSlice() {
+		id := service.baseFolders[folderName]
+		tempIdToMetaData[id] = FileMetaData{ID: id}
+		service.downloadLookupMap[folderName] = FileMetaData{ID: id}
+	}
+
+	// add the contents of all the modified folders, the folders are listed together in combined queries
+	if doExtraFolderSearch {
+		var folderIds []string
+		for _, remoteMetaData := range remoteModifiedFiles {
+			if strings.Contains(remoteMetaData.MimeType, "folder") {
+				folderIds = append(folderIds, remoteMetaData.ID)
+			}
+		}
+		if len(folderIds) > 0 {
+			response, err := service.conn.getItemsInFolders(ctx, "(modified folders)", folderIds)
+			if err != nil {
+				return err
+			}
+			for _, metadata := range response.Files {
+				tempIdToMetaData[metadata.ID] = metadata
+			}
+		}
+	}
+
+	// add all the modified files/folders to our temp map, and the parents if necessary
+	for _, remoteMetaData := range service.addItemsToRetry(remoteModifiedFiles) {
+		tempIdToMetaData[remoteMetaData.ID] = remoteMetaData
+
+		// add all the parents recursively
+		// if it fails then leave this item out so we don't download the wrong path, its children won't be able to
+		// find their full path either so the whole subtree is skipped, everything else is processed as usual
+		err := service.addParents(ctx, remoteMetaData, tempIdToMetaData)
+		if err != nil {
+			delete(tempIdToMetaData, remoteMetaData.ID)
+			service.saveFailedRemoteItem(remoteMetaData, err)
+		} else {
+			delete(service.failedRemoteItems, remoteMetaData.ID)
+		}
+	}
+
+	service.renameUnsafeRemoteItems(ctx, tempIdToMetaData)
+
+	// now piece together all the modified items by using the parent ids to create the file hierarchy
+	for _, id := range sortedMetadataKeys(tempIdToMetaData) {
+		metadata := tempIdToMetaData[id]
+		fullPath, err := service.getFullPath(id, tempIdToMetaData)
+
+		// for deleted files the path might be "" with an error, we won't add those to the lookup map
+		if fullPath != "" && err == nil {
+			fullPath = service.routedPath(fullPath, metadata)
+			existing, duplicate := service.downloadLookupMap[fullPath]
+			if duplicate && existing.ModifiedTime != "" && !preferRemoteItem(existing, metadata) {
+				continue
+			}
+			service.downloadLookupMap[fullPath] = metadata
+			if strings.Contains(metadata.MimeType, "folder") {
+				service.rememberFolder(id)
+			}
+		}
+	}
+
+	service.dropLocalPathCollisions(service.downloadLookupMap)
+	return service.followShortcuts(ctx, service.downloadLookupMap)
+}
+
+//***********************************************
+
+const MAX_REMOTE_ITEM_ATTEMPTS = 10
+
+// appends the previously failed items that are due for a retry, they are no longer in the list of modified
+// files if the verified timestamp has moved past them
+func (service *Service) addItemsToRetry(remoteModifiedFiles []FileMetaData) []FileMetaData {
+	if len(service.failedRemoteItems) == 0 {
+		return remoteModifiedFiles
+	}
+
+	alreadyIncluded := make(map[string]bool)
+	for _, remoteMetaData := range remoteModifiedFiles {
+		alreadyIncluded[remoteMetaData.ID] = true
+	}
+
+	now := service.clock.Now()
+	items := remoteModifiedFiles
+	var failedIds []string
+	for id := range service.failedRemoteItems {
+		failedIds = append(failedIds, id)
+	}
+	sort.Strings(failedIds)
+
+	for _, id := range failedIds {
+		failedItem := service.failedRemoteItems[id]
+		if !alreadyIncluded[id] && now.After(failedItem.retryAt) {
+			service.log.Debug("retrying remote item", failedItem.metadata.Name, id, "attempt", failedItem.attempts+1)
+			items = append(items, failedItem.metadata)
+		}
+	}
+
+	return items
+}
+
+//***********************************************
+
+func (service *Service) saveFailedRemoteItem(metadata FileMetaData, err error) {
+	failedItem := service.failedRemoteItems[metadata.ID]
+	failedItem.metadata = metadata
+	failedItem.attempts++
+
+	if failedItem.attempts >= MAX_REMOTE_ITEM_ATTEMPTS {
+		service.log.Warn("giving up on remote item", metadata.Name, metadata.ID, "after", failedItem.attempts, "attempts:", err)
+		delete(service.failedRemoteItems, metadata.ID)
+		return
+	}
+
+	// back off exponentially starting at one minute, but never wait more than an hour
+	backoff := time.Minute << (failedItem.attempts - 1)
+	if backoff > time.Hour {
+		backoff = time.Hour
+	}
+	failedItem.retryAt = service.clock.Now().Add(backoff)
+	service.failedRemoteItems[metadata.ID] = failedItem
+
+	service.log.Warn("skipping remote item", metadata.Name, metadata.ID, "until", failedItem.retryAt.Format(time.Kitchen), "because:", err)
+}
+
+//***********************************************
+
+func (service *Service) addParents(ctx context.Context, metadata FileMetaData, tempIdToMetaData map[string]FileMetaData) error {
+	if len(metadata.Parents) > 0 {
+		parentId := metadata.Parents[0]
+		_, parentInMap := tempIdToMetaData[parentId]
+
+		if parentId != "" && !parentInMap {
+			parentMetadata, err := service.conn.getMetadataById(ctx, "?", parentId)
+			if err != nil {
+				return err
+			}
+			tempIdToMetaData[parentMetadata.ID] = parentMetadata
+			err = service.addParents(ctx, parentMetadata, tempIdToMetaData)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//***********************************************
+
+func (service *Service) getFullPath(id string, tempIdToMetaData map[string]FileMetaData) (string, error) {
+	metadata, inMap := tempIdToMetaData[id]
+
+	if inMap {
+		if len(metadata.Parents) > 0 {
+			parentPath, err := service.getFullPath(metadata.Parents[0], tempIdToMetaData)
+			if err != nil {
+				return "", err
+			}
+
+			if parentPath == "" {
+				return "", errors.New("something went wrong when trying to getFullPath")
+			} else {
+				fullPath := localChildPath(parentPath, service.localNameOf(metadata))
+				return fullPath, nil
+			}
+		} else {
+			// check if this is a base folder
+			for baseFolderName, baseFolderId := range service.baseFolders {
+				if id == baseFolderId {
+					return baseFolderName, nil
+				}
+			}
+			msg := fmt.Sprintln("no base folder found for file:", metadata.Name, "id:", id)
+			return "", errors.New(msg)
+		}
+	}
+	return "", errors.New("id was not found")
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) localFilesModified() bool {
+	// use a closure to give the walk function access to filesToUpload and localFiles
+
+	// the files that are only uploaded after they have been rotated, key = folder + pattern
+	rotationGroups := make(map[string][]rotatingFile)
+
+	// everything the full walk finds, so the files that are gone can be noticed
+	seen := make(map[string]bool)
+
+	// this is the callback function that Walk will call for each local file/folder
+	var walkAndCheckForModified = func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		seen[path] = true
+
+		// ignore desktop.ini and the lock and temp files from Office
+		if isIgnoredFile(fileInfo.Name()) || service.isExcelTempFile(path, fileInfo) {
+			return nil
+		}
+
+		// and whatever the .driveignore or the filters of the base folder exclude, and the download only route folders
+		if service.isIgnoredPath(path, fileInfo.IsDir()) || service.inRouteFolder(path) {
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fileInfo.IsDir() && service.tooBigForFolder(path, fileInfo.Size()) {
+			return nil
+		}
+
+		modifiedAt := fileInfo.ModTime()
+
+		// Office saves a document by writing a temp file and renaming it over the original, so wait for a
+		// file to stop changing before uploading it, otherwise it gets uploaded twice
+		if !fileInfo.IsDir() && service.clock.Now().Sub(modifiedAt) < SETTLE_TIME {
+			service.holdLocalFile(path, "is still changing")
+			return nil
+		}
+
+		changed := service.localFileChanged(path, modifiedAt)
+
+		// files that keep growing are uploaded according to their policy instead of every time they change
+		if !fileInfo.IsDir() {
+			if policy := service.growingFilePolicy(fileInfo.Name()); policy != nil {
+				if policy.Mode == GROWING_AFTER_ROTATION {
+					key := filepath.Dir(path) + string(filepath.Separator) + policy.Pattern
+					rotationGroups[key] = append(rotationGroups[key], rotatingFile{path, modifiedAt, changed})
+					return nil
+				}
+				if changed && service.growingFileIsHeld(policy, path, modifiedAt) {
+					service.holdLocalFile(path, "is still growing")
+					return nil
+				}
+			}
+		}
+
+		if changed {
+			service.queueLocalUpload(path, modifiedAt)
+		}
+		return nil
+	}
+
+	// only look at what the watcher saw change, or walk everything if that's not enough, a changed
+	// .driveignore can bring back files that were excluded before so that needs everything too
+	ignoresChanged := service.loadIgnoreFiles()
+	if service.loadNotSyncedFolders() {
+		// the remote contents of a folder that is synced again have to be listed too
+		ignoresChanged = true
+		service.setReconcileTime(time.Time{})
+	}
+	changedPaths, watched := service.takeChangedPaths()
+	if watched && !ignoresChanged {
+		for _, path := range changedPaths {
+			service.walkChangedPath(path, walkAndCheckForModified)
+		}
+	} else {
+		for _, folder := range service.getBaseFolderSlice() {
+			service.fileSystem.Walk(folder, walkAndCheckForModified)
+		}
+		for path := range service.localFiles {
+			if !seen[path] && !service.isIgnoredPath(path, false) && !service.inRouteFolder(path) {
+				service.missingLocalFiles[path] = true
+			}
+		}
+	}
+	service.handleRotatingFiles(rotationGroups)
+
+	return len(service.filesToUpload) > 0
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// walks a path the watcher saw change, a file with a rotated policy brings along its whole folder so the
+// newest file in the rotation can still be found
+func (service *Service) walkChangedPath(path string, walkFn filepath.WalkFunc) {
+	if policy := service.growingFilePolicy(filepath.Base(path)); policy != nil && policy.Mode == GROWING_AFTER_ROTATION {
+		path = filepath.Dir(path)
+	}
+	if _, _, found := service.splitLocalPath(path); !found {
+		return
+	}
+	service.fileSystem.Walk(path, walkFn)
+}
+
+//*********************************************************
+
+// returns true if a local file or folder is new, has been modified since we last verified, or was held back last time
+func (service *Service) localFileChanged(path string, modifiedAt time.Time) bool {
+	_, inLocalMap := service.localFiles[path]
+	return service.heldFiles[path] || !inLocalMap || modifiedAt.Sub(service.verifiedAt) > 0
+}
+
+//*********************************************************
+
+func (service *Service) queueLocalUpload(path string, modifiedAt time.Time) {
+	if logEnabled(LOG_DEBUG) {
+		_, inLocalMap := service.localFiles[path]
+		if !inLocalMap {
+			service.log.Debug(path, "suddenly appeared")
+		} else {
+			service.log.Debug(path, "has changed")
+		}
+	}
+
+	delete(service.heldFiles, path)
+	service.filesToUpload[path] = true
+	service.localFiles[path] = true
+	service.saveTimestamp(modifiedAt)
+}
+
+//*********************************************************
+
+// the file is checked again on the next loop, even if the verified timestamp moves past it in the meantime
+func (service *Service) holdLocalFile(path string, reason string) {
+	service.log.Debug(path, reason+", waiting for the next loop")
+	service.heldFiles[path] = true
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) getRemoteModifiedFiles(ctx context.Context) ([]FileMetaData, error) {
+	// rate limits are:
+	// Queries per 100 seconds	20,000
+	// Queries per day	1,000,000,000
+
+	service.log.Debug("checking if remote side was modified")
+
+	var files []FileMetaData
+	var err error
+	if service.changesPageToken != "" {
+		// the cheap way, only look at what changed since the last time
+		files, _, service.pendingChangesPageToken, err = service.conn.getChanges(ctx, service.changesPageToken)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+	} else {
+		// get the token before searching so nothing that changes during the search is missed next time
+		service.pendingChangesPageToken, err = service.conn.getStartPageToken(ctx)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+
+		timestamp := service.verifiedAtPlusOneSec.UTC().Format(time.RFC3339)
+		files, err = service.conn.getModifiedItems(ctx, timestamp)
+		if err != nil {
+			return []FileMetaData{}, err
+		}
+	}
+
+	service.log.Debug(len(files), "files were modified")
+	service.log.Debug(files)
+
+	// save the newest timestamp that we see
+	for _, file := range files {
+		modifiedAt, err := time.Parse(time.RFC3339Nano, file.ModifiedTime)
+		if err == nil {
+			service.saveTimestamp(modifiedAt)
+		}
+	}
+
+	return files, nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) checkForDownloads() {
+	// only read here, the items that were trashed or restored are applied to the pending deletions as they are
+	// at the end, so a deletion that was cancelled in the meantime stays cancelled
+	deletions := service.loadPendingDeletions()
+	var trashed, restored []PendingDeletion
+	defer func() {
+		if service.dryRun || (len(trashed) == 0 && len(restored) == 0) {
+			return
+		}
+		service.updatePendingDeletions(func(latest *pendingDeletionsFile) bool {
+			changed := false
+			for _, item := range restored {
+				changed = service.unscheduleDeletion(latest, item.LocalPath, item.Remote) || changed
+			}
+			for _, item := range trashed {
+				changed = service.scheduleDeletion(latest, item.LocalPath, item.Remote) || changed
+			}
+			return changed
+		})
+	}()
+
+	service.window.skipped = 0
+	if service.window.backlog == nil {
+		service.window.backlog = make(map[string]FileMetaData)
+	}
+	for _, localPath := range sortedMetadataKeys(service.downloadLookupMap) {
+		remoteFileInfo := service.downloadLookupMap[localPath]
+		if isIgnoredFile(remoteFileInfo.Name) {
+			continue // a lock or temp file that was uploaded before they were ignored
+		}
+		isFolder := remoteFileInfo.MimeType == "application/vnd.google-apps.folder"
+		if service.isIgnoredPath(localPath, isFolder) || (!isFolder && service.tooBigForFolder(localPath, remoteFileInfo.Size)) {
+			delete(service.filesToDownload, localPath)
+			continue
+		}
+		_, exported := service.exportMimeType(remoteFileInfo)
+		exported = exported || service.isShortcutLink(remoteFileInfo)
+		if isGoogleFile(remoteFileInfo) && !exported {
+			delete(service.filesToDownload, localPath)
+			continue // a Form, a type with export_format=off or a skipped shortcut, it can't be downloaded
+		}
+		if !remoteFileInfo.Trashed && service.downloadIsQuarantined(localPath, remoteFileInfo) {
+			delete(service.filesToDownload, localPath)
+			continue // the download handler failed on this version, it's tried again when it changes on Google Drive
+		}
+		if remoteFileInfo.Trashed && service.dryRun {
+			delete(service.filesToDownload, localPath)
+			if !deletions.isCancelled(remoteFileInfo.ID) && deletions.indexOf(localPath) < 0 {
+				reason := "was trashed on Google Drive"
+				if service.settings.DeletionDelay > 0 {
+					reason += fmt.Sprintf(", the local copy would be removed %v from now", service.settings.DeletionDelay)
+				}
+				service.planLocalDeletion(localPath, remoteFileInfo, reason)
+			}
+			continue
+		}
+		if remoteFileInfo.Trashed {
+			delete(service.filesToDownload, localPath)
+			trashed = append(trashed, PendingDeletion{LocalPath: localPath, Remote: remoteFileInfo})
+			continue
+		}
+		if deletions.indexOf(localPath) >= 0 || deletions.isCancelled(remoteFileInfo.ID) {
+			restored = append(restored, PendingDeletion{LocalPath: localPath, Remote: remoteFileInfo})
+		}
+
+		// first check if it already exists
+		localFileInfo, err := service.fileSystem.Stat(localPath)
+		if err != nil && !remoteFileInfo.Trashed && service.outsideSyncWindow(localPath, remoteFileInfo) {
+			// older than initial_sync_days, it's downloaded by the backfill
+			service.window.skipped++
+			service.window.backlog[localPath] = remoteFileInfo
+			delete(service.filesToDownload, localPath)
+		} else if err != nil {
+			// doesn't exist on local side, add to download list
+			service.filesToDownload[localPath] = remoteFileInfo
+		} else {
+			// it does exist locally
+
+			// if folder then don't need to download
+			if localFileInfo.IsDir() {
+				delete(service.filesToDownload, localPath)
+				service.rememberRemoteId(localPath, remoteFileInfo.ID)
+				continue
+			}
+
+			// it's a file, but check if the remote file is newer
+			localModTime := localFileInfo.ModTime()
+			remoteModTime, _ := time.Parse(time.RFC3339Nano, remoteFileInfo.ModifiedTime)
+			diff := remoteModTime.Sub(localModTime)
+
+			// allow for some roundoff error, or the coarser times of some filesystems
+			if diff > service.timestampTolerance(localPath) {
+				// the remote file is newer, an export or a link has no md5 so it's written again
+				if exported {
+					service.filesToDownload[localPath] = remoteFileInfo
+					continue
+				}
+				localMD5 := service.getMd5OfFile(localPath)
+				if !service.sameContents(localPath, localMD5, remoteFileInfo.Md5Checksum) {
+					service.filesToDownload[localPath] = remoteFileInfo
+				} else {
+					delete(service.filesToDownload, localPath)
+					service.rememberRemoteId(localPath, remoteFileInfo.ID)
+				}
+			} else if service.settings.Mirror && !exported && !service.sameContents(localPath, service.getMd5OfFile(localPath), remoteFileInfo.Md5Checksum) {
+				// a mirror puts back the local changes
+				service.filesToDownload[localPath] = remoteFileInfo
+			} else {
+				delete(service.filesToDownload, localPath)
+				service.rememberRemoteId(localPath, remoteFileInfo.ID)
+			}
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) handleDownloads(ctx context.Context) bool {
+	plan := service.planDownloads()
+	if logEnabled(LOG_DEBUG) {
+		plan.Print()
+	}
+	return service.executeDownloads(ctx, plan)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) handleCreate(ctx context.Context, localPath string, localFileInfo fs.FileInfo) error {
+	// a folder that was created along with the folders above another item is done already
+	if _, created := service.uploadedItem(localPath); created && localFileInfo.IsDir() {
+		return nil
+	}
+
+	parentPath := filepath.Dir(localPath)
+	parentId, parentInMap := service.uploadedItem(parentPath)
+	if !parentInMap {
+		// only one worker at a time creates the missing folders, so a folder isn't created twice
+		service.parentsMutex.Lock()
+		var err error
+		parentId, err = service.createMissingParents(ctx, localPath)
+		service.parentsMutex.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	parents := []string{parentId.ID}
+
+	var id string
+	pending, startedBefore := service.pendingCreate(localPath)
+	if startedBefore {
+		// an earlier create didn't finish, it might have made the item anyway
+		existing, found, err := service.findCreatedCopy(ctx, localPath, localFileInfo, parentId.ID)
+		if err != nil {
+			return err
+		}
+		if found {
+			service.log.Info(localPath, "was already created on Google Drive by an earlier sync, not creating it again")
+			service.setUploadedItem(localPath, existing)
+			service.forgetPendingCreate(localPath)
+			return nil
+		}
+		id = pending.ID
+	} else {
+		var err error
+		id, err = service.conn.nextId(ctx)
+		if err != nil {
+			service.log.Warn("failed to get ids for new file:", localPath, "err:", err)
+			return errors.New("failed to generate id") // we'll try again next time
+		}
+		service.rememberPendingCreate(localPath, id)
+	}
+
+	formattedTime := localFileInfo.ModTime().Format(time.RFC3339Nano)
+
+	if localFileInfo.IsDir() {
+		request := CreateFolderRequest{ID: id, Name: localFileInfo.Name(), MimeType: "application/vnd.google-apps.folder", Parents: parents, ModifiedTime: formattedTime}
+		err := service.conn.createRemoteFolder(ctx, request)
+		if err != nil {
+			return err
+		} else {
+			service.setUploadedItem(localPath, FileMetaData{ID: id, Name: localFileInfo.Name(), MimeType: "application/vnd.google-apps.folder", Md5Checksum: ""})
+		}
+	} else {
+		var request UploadRequest = &CreateFileRequest{ID: id, Name: localFileInfo.Name(), Parents: parents, ModifiedTime: formattedTime}
+		if startedBefore {
+			// the earlier create may have made the file with different contents, then it's updated instead
+			if _, err := service.conn.getMetadataById(ctx, localPath, id); err == nil {
+				request = &UpdateFileRequest{ModifiedTime: formattedTime}
+			}
+		}
+		err := service.uploadAndCheckMd5(ctx, localPath, id, request, formattedTime, localFileInfo.Size())
+		if err != nil {
+			return err
+		}
+	}
+
+	service.forgetPendingCreate(localPath)
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) handleSingleUpload(ctx context.Context, localPath string, modifiedTime time.Time, fileLength int64) error {
+	fileMetaData, _ := service.uploadedItem(localPath)
+
+	formattedTime := modifiedTime.Format(time.RFC3339Nano)
+	request := UpdateFileRequest{ModifiedTime: formattedTime}
+	if fileMetaData.Trashed {
+		restore := false
+		request.Trashed = &restore
+	}
+
+	return service.uploadAndCheckMd5(ctx, localPath, fileMetaData.ID, &request, formattedTime, fileLength)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const MAX_UPLOAD_ATTEMPTS = 2
+
+// uploads the file then compares the md5 returned by the API with the local md5, if they don't match then
+// the file is uploaded again right away instead of waiting for the verify phase to notice on the next loop
+func (service *Service) uploadAndCheckMd5(ctx context.Context, localPath string, id string, uploadRequest UploadRequest, formattedTime string, fileLength int64) error {
+	for attempt := 1; ; attempt++ {
+		uploadStarted := service.clock.Now()
+		remoteMetaData, localMd5, err := service.uploadContents(ctx, localPath, id, uploadRequest, fileLength)
+		if err != nil {
+			return err
+		}
+		service.logTransfer(TransferRecord{Direction: TRANSFER_UPLOAD, Path: localPath, DriveId: remoteMetaData.ID, Size: fileLength,
+			SourceMd5: localMd5, DestinationMd5: remoteMetaData.Md5Checksum}, uploadStarted)
+
+		// the md5 can be missing if the response was lost, the verify phase will check it later
+		if remoteMetaData.Md5Checksum == "" || remoteMetaData.Md5Checksum == localMd5 {
+			if remoteMetaData.ID != "" {
+				service.setUploadedItem(localPath, remoteMetaData)
+			}
+			service.commitUploadedChunks(localPath)
+			return nil
+		}
+
+		service.log.with(logFields{Path: localPath, FileId: remoteMetaData.ID}).Error("md5 mismatch after uploading", localPath, "local:", localMd5, "remote:", remoteMetaData.Md5Checksum)
+		if attempt >= MAX_UPLOAD_ATTEMPTS {
+			return errors.New("md5 mismatch after uploading " + localPath)
+		}
+
+		// the file exists on the server now, so the retry has to update it instead of creating it again
+		uploadRequest = &UpdateFileRequest{ModifiedTime: formattedTime}
+	}
+}
+
+//*********************************************************
+
+// returns the metadata from the server along with the md5 of the bytes that were sent
+func (service *Service) uploadContents(ctx context.Context, localPath string, id string, uploadRequest UploadRequest, fileLength int64) (FileMetaData, string, error) {
+	if handler := matchHandler(service.settings.UploadHandlers, localPath); handler != nil {
+		return service.uploadThroughHandler(ctx, handler, localPath, id, uploadRequest)
+	}
+	progress, finished := service.startProgress(TRANSFER_UPLOAD, localPath, fileLength)
+	defer finished()
+
+	if fileLength > LARGE_FILE_THRESHOLD_BYTES {
+		localMd5 := service.getMd5OfFile(localPath)
+
+		fh, err := service.fileSystem.Open(localPath)
+		if err != nil {
+			return FileMetaData{}, "", err
+		}
+		defer fh.Close()
+
+		remoteMetaData, err := service.conn.uploadLargeFile(ctx, id, uploadRequest, progress.file(fh), fileLength)
+		return remoteMetaData, localMd5, err
+	}
+
+	fh, err := service.fileSystem.Open(localPath)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	defer fh.Close()
+
+	// the length from before the file was opened might be out of date
+	fileInfo, err := service.fileSystem.Stat(localPath)
+	if err != nil {
+		return FileMetaData{}, "", err
+	}
+	return service.conn.uploadFile(ctx, id, uploadRequest, progress.file(fh), fileInfo.Size())
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) handleUploads(ctx context.Context) error {
+	plan := service.planUploads()
+	if logEnabled(LOG_DEBUG) {
+		plan.Print()
+	}
+	if uploadBytes := plan.UploadBytes(); uploadBytes >= CHUNKED_FILE_THRESHOLD_BYTES {
+		service.log.Infof("uploading %.1f MB\n", float64(uploadBytes)/(1024*1024))
+	}
+	err := service.executeUploads(ctx, plan)
+	if err == nil {
+		service.forgetMissingLocalFiles()
+	}
+	return err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// gets the metadata of only the items waiting to be verified by their ids, instead of listing the base folders
+// again, returns false if the id of one of them is not known so the folders have to be listed after all
+func (service *Service) refreshUploadedItems(ctx context.Context) (bool, error) {
+	pathsById := make(map[string]string)
+	var ids []string
+	for _, localPath := range sortedPaths(service.filesToUpload) {
+		id := ""
+		if remoteFileData, onServer := service.uploadLookupMap[localPath]; onServer {
+			id = remoteFileData.ID
+		} else if pending, started := service.pendingCreates[localPath]; started {
+			id = pending.ID // the create might have made it even though the response was lost
+		}
+		if id == "" {
+			return false, nil
+		}
+		pathsById[id] = localPath
+		ids = append(ids, id)
+	}
+
+	items, err := service.conn.getMetadataByIds(ctx, ids)
+	if err != nil {
+		return false, err
+	}
+	for id, localPath := range pathsById {
+		item, found := items[id]
+		if !found || item.Trashed {
+			delete(service.uploadLookupMap, localPath)
+			continue
+		}
+		service.uploadLookupMap[localPath] = item
+	}
+	return true, nil
+}
+
+//*********************************************************
+
+func (service *Service) verifyUploads() {
+	// hash everything that is on the server up front so the files can be hashed in parallel
+	var pathsToHash []string
+	for _, localPath := range sortedPaths(service.filesToUpload) {
+		if remoteFileData, onServer := service.uploadLookupMap[localPath]; onServer && !isGoogleFile(remoteFileData) {
+			pathsToHash = append(pathsToHash, localPath)
+		}
+	}
+	localMd5s := service.hashFiles(pathsToHash)
+
+	for _, localPath := range sortedPaths(service.filesToUpload) {
+
+		localFileInfo, err := service.fileSystem.Stat(localPath)
+		if err != nil {
+			service.log.Warn("error from Stat", err)
+			delete(service.filesToUpload, localPath)
+			continue
+		}
+		remoteFileData, onServer := service.uploadLookupMap[localPath]
+
+		if !onServer {
+			service.log.Debug(localPath, "not on server")
+			continue
+		}
+
+		// if we got this far it is on the server, an export of a Google file is never uploaded
+		if localFileInfo.IsDir() || isGoogleFile(remoteFileData) {
+			delete(service.filesToUpload, localPath)
+		} else {
+			localMd5 := localMd5s[localPath]
+			if service.sameContents(localPath, localMd5, remoteFileData.Md5Checksum) {
+				delete(service.filesToUpload, localPath)
+				service.rememberRemoteId(localPath, remoteFileData.ID)
+			} else {
+				service.log.Debug("md5 did not match for", localPath)
+			}
+		}
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) verifyDownloads() {
+	// hash all the downloaded files up front so the files can be hashed in parallel
+	var pathsToHash []string
+	for _, localPath := range sortedMetadataKeys(service.filesToDownload) {
+		remoteFileData := service.downloadLookupMap[localPath]
+		if !strings.Contains(remoteFileData.MimeType, "folder") && !isGoogleFile(remoteFileData) {
+			pathsToHash = append(pathsToHash, localPath)
+		}
+	}
+	localMd5s := service.hashFiles(pathsToHash)
+
+	// iterating over a sorted copy of the keys, so deleting from the map while looping is safe
+	for _, localPath := range sortedMetadataKeys(service.filesToDownload) {
+		remoteFileData := service.downloadLookupMap[localPath]
+
+		if strings.Contains(remoteFileData.MimeType, "folder") {
+			// it's a folder
+			folderInfo, err := service.fileSystem.Stat(localPath)
+			if err == nil && folderInfo.IsDir() {
+				delete(service.filesToDownload, localPath)
+				service.rememberRemoteId(localPath, remoteFileData.ID)
+			}
+		} else if isGoogleFile(remoteFileData) {
+			// an export or a link has no md5, it's done when the local file has the modifiedTime of the Google file
+			localFileInfo, err := service.fileSystem.Stat(localPath)
+			remoteModTime, _ := time.Parse(time.RFC3339Nano, remoteFileData.ModifiedTime)
+			if err == nil && remoteModTime.Sub(localFileInfo.ModTime()) <= service.timestampTolerance(localPath) {
+				delete(service.filesToDownload, localPath)
+				service.rememberRemoteId(localPath, remoteFileData.ID)
+			}
+		} else {
+			// it's a file
+			localMd5 := localMd5s[localPath]
+
+			if service.sameContents(localPath, localMd5, remoteFileData.Md5Checksum) || service.downloadIsQuarantined(localPath, remoteFileData) {
+				delete(service.filesToDownload, localPath)
+				service.rememberRemoteId(localPath, remoteFileData.ID)
+			}
+		}
+	}
+}
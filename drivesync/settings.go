@@ -0,0 +1,485 @@
+package drivesync
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const APP_NAME = "Google-Drive-For-Desktop-Lite"
+
+// the version in the User-Agent, the program sets this from its own version which can be set at build time
+var AppVersion = "dev"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// optional settings, each one can be overridden in config/settings.txt using a key=value line
+type Settings struct {
+	MachineId string // key=machine_id, defaults to the hostname
+	UserAgent string // key=user_agent, defaults to APP_NAME/AppVersion (MachineId)
+	QuotaUser string // key=quota_user, defaults to MachineId, sent as the quotaUser parameter
+
+	ImpersonateUser string // key=impersonate_user, acts as this user with domain-wide delegation instead of as the service account
+
+	Auth            string // key=auth, service_account (the default) or user to sign in as the user with OAuth
+	CredentialsFile string // key=credentials_file, the key of the service account, defaults to config/service-account.json
+	ApiKeyFile      string // key=api_key_file, defaults to config/api-key.txt
+	OAuthClientFile string // key=oauth_client_file, the OAuth client for auth=user, defaults to config/oauth-client.json
+
+	CleanupMode          string  // key=cleanup_mode, trash (the default) moves the orphans to the trash, delete deletes them for good
+	CleanupWorkers       int     // key=cleanup_workers, the number of deletes that can run at the same time
+	CleanupRatePerSecond float64 // key=cleanup_rate, the maximum number of deletes per second to stay under the quota
+	ApiRatePerSecond     float64 // key=api_rate, the maximum number of requests per second to Google Drive, 0 means no limit
+
+	Profile    string // key=profile, conservative, balanced (the default) or aggressive, sets the workers, rates, retries and page size together
+	MaxRetries int    // key=max_retries, how many times a rate limited or failed request is sent again
+	PageSize   int    // key=page_size, how many items are asked for in each page of a listing, at most 1000
+
+	ErrorBudget float64 // key=error_budget, notify when more than this share of the requests in the last 15 minutes failed
+
+	NotifyCommand        string // key=notify_command, runs this command with a title and message for each notification
+	DesktopNotifications bool   // key=desktop_notifications, also shows the conflicts, cleanups and failures on the desktop
+	NotifyWebhook        string // key=notify_webhook, also posts the conflicts, cleanups and failures to this url
+	NotifyWebhookFormat  string // key=notify_webhook_format, json (the default) or slack for a Slack incoming webhook
+	NotifyCleanupOver    int    // key=notify_cleanup_over, the alert for a cleanup is only sent when it deletes more than this many files
+
+	ReconcileHours float64 // key=reconcile_hours, how often to do a full reconciliation that re-walks and re-lists everything
+
+	TransferLog bool // key=transfer_log, writes the md5's of every finished transfer to config/transfers.jsonl, defaults to false
+
+	MaxCycleDuration time.Duration // key=max_cycle_minutes, a cycle that runs longer is cancelled by the watchdog, 0 means no watchdog
+
+	LocalScanInterval   time.Duration // key=local_scan_seconds, how often the local folders are checked for changes
+	RemoteCheckInterval time.Duration // key=remote_check_seconds, how often Google Drive is checked for changes
+	FastPollInterval    time.Duration // key=fast_poll_seconds, how often both are checked right after something was synced, 0 means never
+	FastPollWindow      time.Duration // key=fast_poll_minutes, how long the fast polling lasts after the last change
+
+	PriorityFiles        []string      // key=priority_file, can be repeated, a file that is downloaded within seconds when it changes on Google Drive
+	PriorityPollInterval time.Duration // key=priority_poll_seconds, how often the priority files are checked
+
+	MetricsAddress string // key=metrics_address, serves the runtime stats on this address, empty means no metrics server
+	EnablePprof    bool   // key=pprof, also serves the pprof profiles, only allowed on localhost
+	ControlApi     bool   // key=control_api, also serves the status and control api, only allowed on localhost
+
+	HashWorkers       int           // key=hash_workers, the number of files that can be hashed at the same time
+	HashChunkPause    time.Duration // key=hash_pause_ms, how long to sleep after hashing each 1 MB chunk, 0 means no throttling
+	HashOnlyOnACPower bool          // key=hash_on_ac_power_only, put off the hashing of local files while running on battery
+
+	MetadataCache bool // key=metadata_cache, keeps the remote folder listings in config/metadata-cache.json between cycles, defaults to true
+
+	RecordTrace         string // key=record_trace, saves every API call to this file so a bug can be reproduced offline
+	RecordTraceContents bool   // key=record_trace_contents, also saves the contents of the downloaded files in the trace
+	ReplayTrace         string // key=replay_trace, serves the API calls from this trace file instead of Google Drive
+
+	MaxUploadBytes int64 // key=max_upload_mb, files bigger than this are not uploaded, 0 means no limit
+
+	DownloadWorkers     int     // key=download_workers, the number of files that can be downloaded at the same time
+	UploadWorkers       int     // key=upload_workers, the number of files that can be uploaded at the same time
+	UploadRatePerSecond float64 // key=upload_rate, the most uploads started per second, shared by the upload workers, 0 means no limit
+
+	UploadLimit   int64 // key=upload_limit, the most bytes per second sent by all of the uploads together, 0 means no limit
+	DownloadLimit int64 // key=download_limit, the most bytes per second received by all of the downloads together, 0 means no limit
+
+	Mirror          bool   // key=mirror, only downloads, puts back the local changes and writes a signed stamp after each good sync
+	MirrorStampFile string // key=mirror_stamp, defaults to config/mirror-stamp.txt
+
+	WatchModes map[string]WatchMode // key=watch, can be repeated, folder=all|hot|off, the folders default to hot
+	MaxWatches int                  // key=max_watches, the most directories to watch, 0 means half of the system limit
+
+	RecordPermissions bool // key=record_permissions, saves the sharing settings of the changed items in config/permissions.json
+
+	InitialSyncDays int       // key=initial_sync_days, the first sync only downloads the files modified this recently, 0 means everything
+	Backfill        bool      // key=backfill, downloads the older files in batches while the sync is idle, defaults to true
+	BackfillHours   HourRange // key=backfill_hours, like 22-6, the hours of the day the backfill runs, all day by default
+	BackfillBatch   int       // key=backfill_batch, the most older files downloaded after each cycle
+
+	ReuseTrashedWindow time.Duration // key=reuse_trashed_minutes, a file trashed on Google Drive this recently is restored and updated when it reappears locally
+
+	DeletionPolicy         DeletionPolicy            // key=deletion_policy, what to do with the local copy of an item trashed on Google Drive: keep, trash, recycle or delete
+	FolderDeletionPolicies map[string]DeletionPolicy // key=deletion_policy, folder=policy overrides it for one base folder
+	DeletionDelay          time.Duration             // key=deletion_delay_hours, how long to wait before removing the local copy, 0 means right away
+
+	GrowingFiles []GrowingFilePolicy // key=growing_file, can be repeated, pattern=idle|every <interval>|rotated
+
+	FolderFilters map[string]*FolderFilter // key=include, exclude and max_file_mb, can be repeated, folder=pattern or folder=size in MB
+
+	ExportFormats map[string]string // key=export_format, can be repeated, type=extension or type=off, how the Google Docs, Sheets and Slides are downloaded
+	Shortcuts     string            // key=shortcuts, link (the default) saves a shortcut as a .url file, follow downloads its target, off skips it
+
+	DownloadHandlers []FileHandler // key=download_handler, can be repeated, pattern=command, runs on the matching files after they're downloaded
+	UploadHandlers   []FileHandler // key=upload_handler, can be repeated, pattern=command, runs on the matching files before they're uploaded
+
+	DownloadRoutes []DownloadRoute // key=download_route, can be repeated, key=value=folder, downloads the files with that property into the folder
+
+	RenameRemoteNames bool // key=rename_remote_names, renames the items on Google Drive whose names can't be local names, defaults to false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func loadSettings(fileName string) Settings {
+	// the settings file is optional, if it's missing then we just use the defaults
+	var lines []string
+	fh, err := os.Open(fileName)
+	if err == nil {
+		scanner := bufio.NewScanner(fh)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		fh.Close()
+	}
+	return parseSettings(fileName, lines)
+}
+
+//*********************************************************
+
+// each line is key=value, the file name is only for the messages
+func parseSettings(fileName string, lines []string) Settings {
+	settings := Settings{
+		CleanupMode:            CLEANUP_TRASH,
+		ReconcileHours:         24,
+		ErrorBudget:            0.1,
+		LocalScanInterval:      SYNC_INTERVAL,
+		RemoteCheckInterval:    SYNC_INTERVAL,
+		FastPollWindow:         DEFAULT_FAST_POLL_WINDOW,
+		PriorityPollInterval:   DEFAULT_PRIORITY_POLL_INTERVAL,
+		Backfill:               true,
+		MetadataCache:          true,
+		BackfillBatch:          100,
+		DeletionPolicy:         DELETION_TRASH,
+		FolderDeletionPolicies: make(map[string]DeletionPolicy),
+		DeletionDelay:          24 * time.Hour,
+		WatchModes:             make(map[string]WatchMode),
+		FolderFilters:          make(map[string]*FolderFilter),
+		ExportFormats:          defaultExportFormats(),
+		Shortcuts:              SHORTCUTS_LINK,
+		NotifyWebhookFormat:    WEBHOOK_FORMAT_JSON,
+	}
+
+	// the profile fills in the defaults of the knobs, the settings for each of them below override it
+	settings.Profile = findProfile(fileName, lines)
+	tuningProfiles[settings.Profile].apply(&settings)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line_split := strings.SplitN(line, "=", 2)
+		if len(line_split) != 2 {
+			serviceLog.Warn("ignoring invalid line in", fileName, ":", line)
+			continue
+		}
+		key := strings.TrimSpace(line_split[0])
+		value := strings.TrimSpace(line_split[1])
+
+		switch key {
+		case "machine_id":
+			settings.MachineId = value
+		case "user_agent":
+			settings.UserAgent = value
+		case "quota_user":
+			settings.QuotaUser = value
+		case "impersonate_user":
+			settings.ImpersonateUser = value
+		case "auth":
+			if value != AUTH_SERVICE_ACCOUNT && value != AUTH_USER {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be", AUTH_SERVICE_ACCOUNT, "or", AUTH_USER)
+				continue
+			}
+			settings.Auth = value
+		case "oauth_client_file":
+			settings.OAuthClientFile = value
+		case "credentials_file":
+			settings.CredentialsFile = value
+		case "api_key_file":
+			settings.ApiKeyFile = value
+		case "cleanup_mode":
+			if value != CLEANUP_TRASH && value != CLEANUP_DELETE {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be", CLEANUP_TRASH, "or", CLEANUP_DELETE)
+				continue
+			}
+			settings.CleanupMode = value
+		case "cleanup_workers":
+			settings.CleanupWorkers = parseIntSetting(key, value, settings.CleanupWorkers)
+		case "cleanup_rate":
+			settings.CleanupRatePerSecond = parseFloatSetting(key, value, settings.CleanupRatePerSecond)
+		case "api_rate":
+			settings.ApiRatePerSecond = parseFloatSetting(key, value, settings.ApiRatePerSecond)
+		case "error_budget":
+			budget := parseFloatSetting(key, value, settings.ErrorBudget)
+			if budget >= 1 {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be less than 1")
+				continue
+			}
+			settings.ErrorBudget = budget
+		case "profile":
+			// already applied before the other settings
+		case "max_retries":
+			maxRetries := parseIntSetting(key, value, settings.MaxRetries)
+			if maxRetries > MAX_RETRIES_LIMIT {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be at most", MAX_RETRIES_LIMIT)
+				continue
+			}
+			settings.MaxRetries = maxRetries
+		case "page_size":
+			pageSize := parseIntSetting(key, value, settings.PageSize)
+			if pageSize > MAX_PAGE_SIZE {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be at most", MAX_PAGE_SIZE)
+				continue
+			}
+			settings.PageSize = pageSize
+		case "notify_command":
+			settings.NotifyCommand = value
+		case "desktop_notifications":
+			settings.DesktopNotifications = parseBoolSetting(key, value, settings.DesktopNotifications)
+		case "notify_webhook":
+			settings.NotifyWebhook = value
+		case "notify_webhook_format":
+			if value != WEBHOOK_FORMAT_JSON && value != WEBHOOK_FORMAT_SLACK {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be", WEBHOOK_FORMAT_JSON, "or", WEBHOOK_FORMAT_SLACK)
+				continue
+			}
+			settings.NotifyWebhookFormat = value
+		case "notify_cleanup_over":
+			settings.NotifyCleanupOver = parseIntSetting(key, value, settings.NotifyCleanupOver)
+		case "reconcile_hours":
+			settings.ReconcileHours = parseFloatSetting(key, value, settings.ReconcileHours)
+		case "local_scan_seconds":
+			settings.LocalScanInterval = time.Duration(parseIntSetting(key, value, 300)) * time.Second
+		case "remote_check_seconds":
+			settings.RemoteCheckInterval = time.Duration(parseIntSetting(key, value, 300)) * time.Second
+		case "priority_file":
+			settings.PriorityFiles = append(settings.PriorityFiles, configNameToLocalPath(value))
+		case "priority_poll_seconds":
+			settings.PriorityPollInterval = time.Duration(parseIntSetting(key, value, 0)) * time.Second
+		case "fast_poll_seconds":
+			settings.FastPollInterval = time.Duration(parseIntSetting(key, value, 0)) * time.Second
+		case "fast_poll_minutes":
+			settings.FastPollWindow = time.Duration(parseIntSetting(key, value, 10)) * time.Minute
+		case "transfer_log":
+			settings.TransferLog = parseBoolSetting(key, value, settings.TransferLog)
+		case "max_cycle_minutes":
+			settings.MaxCycleDuration = time.Duration(parseIntSetting(key, value, 0)) * time.Minute
+		case "metrics_address":
+			settings.MetricsAddress = value
+		case "pprof":
+			settings.EnablePprof = parseBoolSetting(key, value, settings.EnablePprof)
+		case "control_api":
+			settings.ControlApi = parseBoolSetting(key, value, settings.ControlApi)
+		case "download_workers":
+			settings.DownloadWorkers = parseIntSetting(key, value, settings.DownloadWorkers)
+		case "upload_workers":
+			settings.UploadWorkers = parseIntSetting(key, value, settings.UploadWorkers)
+		case "upload_rate":
+			settings.UploadRatePerSecond = parseFloatSetting(key, value, settings.UploadRatePerSecond)
+		case "mirror":
+			settings.Mirror = parseBoolSetting(key, value, settings.Mirror)
+		case "mirror_stamp":
+			settings.MirrorStampFile = value
+		case "hash_workers":
+			settings.HashWorkers = parseIntSetting(key, value, settings.HashWorkers)
+		case "hash_pause_ms":
+			// 0 turns the pause off, so unlike the other settings it's allowed
+			pauseMs, err := strconv.Atoi(value)
+			if err != nil || pauseMs < 0 {
+				serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", settings.HashChunkPause)
+				continue
+			}
+			settings.HashChunkPause = time.Duration(pauseMs) * time.Millisecond
+		case "hash_on_ac_power_only":
+			settings.HashOnlyOnACPower = parseBoolSetting(key, value, settings.HashOnlyOnACPower)
+		case "record_trace":
+			settings.RecordTrace = value
+		case "record_trace_contents":
+			settings.RecordTraceContents = parseBoolSetting(key, value, settings.RecordTraceContents)
+		case "replay_trace":
+			settings.ReplayTrace = value
+		case "upload_limit", "download_limit":
+			limit, err := parseBandwidthSetting(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			if key == "upload_limit" {
+				settings.UploadLimit = limit
+			} else {
+				settings.DownloadLimit = limit
+			}
+		case "max_upload_mb":
+			settings.MaxUploadBytes = int64(parseIntSetting(key, value, 0)) * 1024 * 1024
+		case "watch":
+			folder, mode, err := parseWatchSetting(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			settings.WatchModes[folder] = mode
+		case "max_watches":
+			settings.MaxWatches = parseIntSetting(key, value, 0)
+		case "record_permissions":
+			settings.RecordPermissions = parseBoolSetting(key, value, settings.RecordPermissions)
+		case "initial_sync_days":
+			settings.InitialSyncDays = parseIntSetting(key, value, 0)
+		case "backfill":
+			settings.Backfill = parseBoolSetting(key, value, settings.Backfill)
+		case "metadata_cache":
+			settings.MetadataCache = parseBoolSetting(key, value, settings.MetadataCache)
+		case "backfill_hours":
+			hours, err := parseHourRange(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			settings.BackfillHours = hours
+		case "backfill_batch":
+			settings.BackfillBatch = parseIntSetting(key, value, settings.BackfillBatch)
+		case "reuse_trashed_minutes":
+			minutes := parseIntSetting(key, value, 0)
+			settings.ReuseTrashedWindow = time.Duration(minutes) * time.Minute
+		case "deletion_policy":
+			parseDeletionPolicySetting(key, value, &settings)
+		case "deletion_delay_hours":
+			settings.DeletionDelay = parseDelaySetting(key, value, settings.DeletionDelay)
+		case "growing_file":
+			policy, err := parseGrowingFilePolicy(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			settings.GrowingFiles = append(settings.GrowingFiles, policy)
+		case "include", "exclude", "max_file_mb":
+			err := parseFilterSetting(key, value, &settings)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+		case "shortcuts":
+			if value != SHORTCUTS_LINK && value != SHORTCUTS_FOLLOW && value != SHORTCUTS_OFF {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ": should be", SHORTCUTS_LINK+",", SHORTCUTS_FOLLOW, "or", SHORTCUTS_OFF)
+				continue
+			}
+			settings.Shortcuts = value
+		case "export_format":
+			err := parseExportSetting(value, &settings)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+		case "download_handler", "upload_handler":
+			handler, err := parseFileHandler(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			if key == "download_handler" {
+				settings.DownloadHandlers = append(settings.DownloadHandlers, handler)
+			} else {
+				settings.UploadHandlers = append(settings.UploadHandlers, handler)
+			}
+		case "download_route":
+			route, err := parseDownloadRoute(value)
+			if err != nil {
+				serviceLog.Warn("ignoring invalid setting in", fileName, ":", key, value, ":", err)
+				continue
+			}
+			settings.DownloadRoutes = append(settings.DownloadRoutes, route)
+		case "rename_remote_names":
+			settings.RenameRemoteNames = parseBoolSetting(key, value, settings.RenameRemoteNames)
+		default:
+			serviceLog.Warn("ignoring unknown setting in", fileName, ":", key)
+		}
+	}
+
+	// fill in the defaults for anything that was not set
+	if settings.CredentialsFile == "" {
+		settings.CredentialsFile = configPath("config/service-account.json")
+	}
+	if settings.ApiKeyFile == "" {
+		settings.ApiKeyFile = configPath("config/api-key.txt")
+	}
+	if settings.Auth == "" {
+		settings.Auth = AUTH_SERVICE_ACCOUNT
+	}
+	if settings.OAuthClientFile == "" {
+		settings.OAuthClientFile = configPath("config/oauth-client.json")
+	}
+	if settings.MirrorStampFile == "" {
+		settings.MirrorStampFile = configPath("config/mirror-stamp.txt")
+	}
+	if settings.MachineId == "" {
+		hostname, err := os.Hostname()
+		if err != nil || hostname == "" {
+			hostname = "unknown"
+		}
+		settings.MachineId = hostname
+	}
+	if settings.UserAgent == "" {
+		settings.UserAgent = fmt.Sprintf("%v/%v (%v)", APP_NAME, AppVersion, settings.MachineId)
+	}
+	if settings.QuotaUser == "" {
+		settings.QuotaUser = settings.MachineId
+	}
+
+	// the Drive API only accepts quotaUser values up to 40 characters
+	if len(settings.QuotaUser) > 40 {
+		settings.QuotaUser = settings.QuotaUser[:40]
+	}
+
+	return settings
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func parseIntSetting(key string, value string, defaultValue int) int {
+	result, err := strconv.Atoi(value)
+	if err != nil || result <= 0 {
+		serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", defaultValue)
+		return defaultValue
+	}
+	return result
+}
+
+//*********************************************************
+
+func parseFloatSetting(key string, value string, defaultValue float64) float64 {
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil || result <= 0 || math.IsNaN(result) || math.IsInf(result, 0) {
+		serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", defaultValue)
+		return defaultValue
+	}
+	return result
+}
+
+//*********************************************************
+
+// hours as a float, unlike the other settings 0 is allowed
+func parseDelaySetting(key string, value string, defaultValue time.Duration) time.Duration {
+	hours, err := strconv.ParseFloat(value, 64)
+	if err != nil || hours < 0 {
+		serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", defaultValue)
+		return defaultValue
+	}
+	return time.Duration(hours * float64(time.Hour))
+}
+
+//*********************************************************
+
+func parseBoolSetting(key string, value string, defaultValue bool) bool {
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", defaultValue)
+		return defaultValue
+	}
+	return result
+}
@@ -0,0 +1,149 @@
+package drivesync
+
+import (
+	"context"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A shortcut on Google Drive points at a file or folder somewhere else and has no contents of its own. With
+// shortcuts=link (the default) it's saved as a .url file that opens the target in the browser, with
+// shortcuts=follow the contents of the target file are downloaded under the name of the shortcut, and with
+// shortcuts=off the shortcuts are skipped. A shortcut to a folder is always saved as a link, following it would
+// sync a whole tree from outside the base folders. Either way nothing is uploaded, the local copy only goes one way.
+
+const SHORTCUT_MIME_TYPE = GOOGLE_APPS_PREFIX + "shortcut"
+
+const SHORTCUTS_LINK = "link"
+const SHORTCUTS_FOLLOW = "follow"
+const SHORTCUTS_OFF = "off"
+
+const SHORTCUT_LINK_EXTENSION = ".url"
+
+type ShortcutDetails struct {
+	TargetId       string `json:"targetId"`
+	TargetMimeType string `json:"targetMimeType"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func isShortcut(remoteFileInfo FileMetaData) bool {
+	return remoteFileInfo.MimeType == SHORTCUT_MIME_TYPE && remoteFileInfo.ShortcutDetails != nil
+}
+
+//*********************************************************
+
+// true if the shortcut is saved as a .url file instead of the contents of its target
+func (service *Service) isShortcutLink(remoteFileInfo FileMetaData) bool {
+	if !isShortcut(remoteFileInfo) {
+		return false
+	}
+	switch service.settings.Shortcuts {
+	case SHORTCUTS_LINK:
+		return true
+	case SHORTCUTS_FOLLOW:
+		return remoteFileInfo.ShortcutDetails.TargetMimeType == "application/vnd.google-apps.folder"
+	}
+	return false
+}
+
+//*********************************************************
+
+// true if the contents of the target are downloaded under the name of the shortcut
+func (service *Service) isFollowedShortcut(remoteFileInfo FileMetaData) bool {
+	return isShortcut(remoteFileInfo) && service.settings.Shortcuts == SHORTCUTS_FOLLOW && !service.isShortcutLink(remoteFileInfo)
+}
+
+//*********************************************************
+
+// the name of the local copy of a shortcut, the target decides the extension of a followed shortcut to a Google Doc
+func (service *Service) shortcutLocalName(remoteFileInfo FileMetaData) string {
+	if service.isShortcutLink(remoteFileInfo) {
+		return remoteFileInfo.Name + SHORTCUT_LINK_EXTENSION
+	}
+	if service.isFollowedShortcut(remoteFileInfo) {
+		target := FileMetaData{Name: remoteFileInfo.Name, MimeType: remoteFileInfo.ShortcutDetails.TargetMimeType}
+		return service.localNameOf(target)
+	}
+	return remoteFileInfo.Name
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// swaps each followed shortcut in the lookup map for the metadata of its target, so it's downloaded like any
+// other file, the targets are looked up together, the shortcuts whose target is gone or not shared are skipped
+func (service *Service) followShortcuts(ctx context.Context, lookupMap map[string]FileMetaData) error {
+	var targetIds []string
+	for _, localPath := range sortedMetadataKeys(lookupMap) {
+		if remoteFileInfo := lookupMap[localPath]; service.isFollowedShortcut(remoteFileInfo) {
+			targetIds = append(targetIds, remoteFileInfo.ShortcutDetails.TargetId)
+		}
+	}
+	if len(targetIds) == 0 {
+		return nil
+	}
+
+	targets, err := service.conn.getMetadataByIds(ctx, targetIds)
+	if err != nil {
+		return err
+	}
+	for _, localPath := range sortedMetadataKeys(lookupMap) {
+		remoteFileInfo := lookupMap[localPath]
+		if !service.isFollowedShortcut(remoteFileInfo) {
+			continue
+		}
+		target, found := targets[remoteFileInfo.ShortcutDetails.TargetId]
+		if !found || target.Trashed {
+			service.log.Debug("skipping the shortcut", localPath, "because its target can't be found")
+			delete(lookupMap, localPath)
+			continue
+		}
+
+		// it keeps the id and parents of the shortcut, so it's not taken for a move of the target
+		target.ID = remoteFileInfo.ID
+		target.Parents = remoteFileInfo.Parents
+		target.ShortcutDetails = remoteFileInfo.ShortcutDetails
+		lookupMap[localPath] = target
+	}
+	return nil
+}
+
+//*********************************************************
+
+// the id to download, the target of a followed shortcut
+func contentsId(remoteFileInfo FileMetaData) string {
+	if remoteFileInfo.ShortcutDetails != nil {
+		return remoteFileInfo.ShortcutDetails.TargetId
+	}
+	return remoteFileInfo.ID
+}
+
+//*********************************************************
+
+// the contents of a .url file, which opens the target in the browser on Windows and in most file managers
+func shortcutLinkContents(remoteFileInfo FileMetaData) string {
+	link := "https://drive.google.com/open?id=" + remoteFileInfo.ShortcutDetails.TargetId
+	return strings.Join([]string{"[InternetShortcut]", "URL=" + link, ""}, "\r\n")
+}
+
+//*********************************************************
+
+func writeShortcutLink(fileSystem FS, localPath string, remoteFileInfo FileMetaData) error {
+	fh, err := fileSystem.Create(localPath)
+	if err != nil {
+		return err
+	}
+	_, err = fh.Write([]byte(shortcutLinkContents(remoteFileInfo)))
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fileSystem.Remove(localPath)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// The stat command answers "why isn't this file syncing?" by putting everything known about one path side by
+// side: the local file, the item on Google Drive, and what the saved state and the running sync have to say about
+// it. Nothing is changed, the saved state is only read.
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// prints the local and remote details of the path and its sync state
+func (service *Service) PrintStat(ctx context.Context, w io.Writer, localPath string) error {
+	service.loadIgnoreFiles()
+	service.loadNotSyncedFolders()
+
+	// the paths we track are relative to the working directory
+	if filepath.IsAbs(localPath) {
+		if workingDir, err := os.Getwd(); err == nil {
+			if relativePath, err := filepath.Rel(workingDir, localPath); err == nil {
+				localPath = relativePath
+			}
+		}
+	}
+	localPath = filepath.Clean(localPath)
+	if _, _, found := service.splitLocalPath(localPath); !found {
+		return errors.New("path is not inside any of the base folders: " + localPath)
+	}
+
+	// the saved state, read without loading it so a damaged state file is left alone
+	var state persistedState
+	var stateErr error
+	if data, err := os.ReadFile(service.configFile(STATE_FILE_NAME)); err == nil {
+		state, stateErr = decodeState(data)
+	} else {
+		stateErr = err
+	}
+	savedId := ""
+	for id, path := range state.RemoteIds {
+		if path == localPath {
+			savedId = id
+		}
+	}
+
+	// the remote item where the path says it is, or where the saved id says it went
+	var remote *ItemDetails
+	remoteNote := ""
+	remoteItem, findErr := service.FindRemoteItem(ctx, localPath)
+	remoteId := remoteItem.ID
+	if findErr != nil && savedId != "" {
+		remoteId = savedId
+		remoteNote = "not at this path, this is the item it was last synced with"
+	}
+	if remoteId != "" {
+		details, err := service.conn.getItemDetails(ctx, remoteId)
+		if err != nil {
+			remoteNote = err.Error()
+		} else {
+			remote = &details
+		}
+	} else if findErr != nil {
+		remoteNote = findErr.Error()
+	}
+
+	localInfo, localErr := service.fileSystem.Stat(localPath)
+	localMd5 := ""
+	if localErr == nil && !localInfo.IsDir() {
+		service.loadMd5Cache()
+		localMd5 = service.getMd5OfFile(localPath)
+	}
+
+	tabs := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tabs, "\tlocal\tGoogle Drive\n")
+	row := func(name string, local string, remoteValue string) {
+		fmt.Fprintf(tabs, "%v\t%v\t%v\n", name, local, remoteValue)
+	}
+
+	localType, localSize, localModified := "missing", "", ""
+	if localErr == nil {
+		localType = "file"
+		if localInfo.IsDir() {
+			localType = "folder"
+		} else {
+			localSize = strconv.FormatInt(localInfo.Size(), 10)
+		}
+		localModified = localInfo.ModTime().Local().Format("2006-01-02 15:04:05.000")
+	}
+	if remote == nil {
+		row("type", localType, "missing")
+		row("size", localSize, "")
+		row("md5", localMd5, "")
+		row("modified", localModified, "")
+	} else {
+		remoteModTime, _ := time.Parse(time.RFC3339Nano, remote.ModifiedTime)
+		row("name", filepath.Base(localPath), remote.Name)
+		row("id", "", remote.ID)
+		row("type", localType, remote.MimeType)
+		row("size", localSize, strconv.FormatInt(remote.Size, 10))
+		row("md5", localMd5, remote.Md5Checksum)
+		row("modified", localModified, remoteModTime.Local().Format("2006-01-02 15:04:05.000"))
+		row("parents", "", strings.Join(remote.Parents, ", "))
+		editor := remote.LastModifyingUser.DisplayName
+		if remote.LastModifyingUser.EmailAddress != "" {
+			editor += " <" + remote.LastModifyingUser.EmailAddress + ">"
+		}
+		row("last editor", "", editor)
+		row("version", "", remote.Version)
+		row("trashed", "", strconv.FormatBool(remote.Trashed))
+	}
+	tabs.Flush()
+	if remoteNote != "" {
+		fmt.Fprintln(w, "Google Drive:", remoteNote)
+	}
+
+	fmt.Fprintln(w)
+	if stateErr != nil {
+		fmt.Fprintln(w, "saved state: not usable,", stateErr)
+	} else {
+		fmt.Fprintln(w, "verified at:", state.VerifiedAt.Local())
+		inState := false
+		for _, path := range state.LocalFiles {
+			if path == localPath {
+				inState = true
+			}
+		}
+		fmt.Fprintln(w, "known to the sync:", inState)
+		if savedId != "" {
+			fmt.Fprintln(w, "synced with id:", savedId)
+		}
+		if localErr == nil && !localInfo.IsDir() && localInfo.ModTime().After(state.VerifiedAt) {
+			fmt.Fprintln(w, "changed locally since it was verified")
+		}
+	}
+	if service.isIgnoredPath(localPath, localErr == nil && localInfo.IsDir()) {
+		fmt.Fprintln(w, "ignored: by a .driveignore, a filter or unsync")
+	}
+	if localErr == nil && !localInfo.IsDir() && service.tooBigForFolder(localPath, localInfo.Size()) {
+		fmt.Fprintln(w, "ignored: bigger than max_file_mb")
+	}
+
+	if err := service.loadPublishedStatus(); err == nil {
+		status := service.getFileStatus(localPath)
+		if status.Error != "" {
+			fmt.Fprintln(w, "status:", status.Status+",", status.Error)
+		} else {
+			fmt.Fprintln(w, "status:", status.Status)
+		}
+	}
+	if remote != nil && localMd5 != "" && remote.Md5Checksum != "" {
+		fmt.Fprintln(w, "same contents:", service.sameContents(localPath, localMd5, remote.Md5Checksum))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package drivesync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const STATE_FILE_NAME = "config/state.json"
+
+// what we need to remember across restarts so the first loop after a restart is as cheap as a normal loop
+type persistedState struct {
+	VerifiedAt       time.Time  `json:"verifiedAt"`
+	ReconciledAt     *time.Time `json:"reconciledAt"` // when the last full reconciliation started, so a restart doesn't put off the next one
+	ChangesPageToken string     `json:"changesPageToken"`
+	LocalFiles       []string   `json:"localFiles"`
+
+	RemoteIds map[string]string `json:"remoteIds"` // key = id on Google Drive, value = local path
+}
+
+// what is written to the state file, the checksum catches a file that was damaged after it was written, by a disk
+// error or a crash, so the sync doesn't act on wrong state, older versions wrote the persistedState by itself
+type stateFile struct {
+	Checksum string          `json:"checksum"` // the sha256 of State
+	State    json.RawMessage `json:"state"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns true if the saved state was loaded, otherwise the caller needs to do a full rescan, a damaged state file
+// is moved aside and replaced by the full rescan
+func (service *Service) loadState() bool {
+	fileName := service.configFile(STATE_FILE_NAME)
+	data, err := os.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return false
+	}
+	var state persistedState
+	if err == nil {
+		state, err = decodeState(data)
+	}
+	if err != nil && service.dryRun {
+		service.log.Warn("the saved state is damaged, the next sync will rebuild it:", err)
+		return false
+	}
+	if err != nil {
+		movedTo, moveErr := service.moveStateAside("corrupt")
+		if moveErr != nil {
+			service.log.Warn("failed to move the damaged state aside:", moveErr)
+		}
+		service.log.Warn("the saved state is damaged, moved it to", movedTo, "and doing a full rescan:", err)
+		service.alert("Rebuilding the sync state", "the saved state was damaged, a full rescan will rebuild it: "+err.Error())
+		return false
+	}
+	if state.ChangesPageToken == "" {
+		service.log.Warn("the saved state is not usable, doing a full rescan")
+		return false
+	}
+
+	service.verifiedAt = state.VerifiedAt
+	service.verifiedAtPlusOneSec = service.verifiedAt.Add(time.Second)
+	service.mostRecentTimestampSeen = state.VerifiedAt
+	service.changesPageToken = state.ChangesPageToken
+	if state.ReconciledAt != nil {
+		service.reconciledAt = *state.ReconciledAt
+		service.reconcileTimeLoaded = true
+	}
+	for _, localPath := range state.LocalFiles {
+		service.localFiles[localPath] = true
+	}
+	for id, localPath := range state.RemoteIds {
+		service.remoteIds[id] = localPath
+	}
+
+	service.log.Info("resuming from the saved state, verified timestamp:", service.verifiedAt.Local())
+	return true
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) saveState() {
+	state := persistedState{
+		VerifiedAt:       service.verifiedAt,
+		ReconciledAt:     &service.reconciledAt,
+		ChangesPageToken: service.changesPageToken,
+	}
+	state.LocalFiles = sortedPaths(service.localFiles)
+	state.RemoteIds = service.remoteIds
+
+	stateData, err := json.Marshal(state)
+	if err != nil {
+		service.log.Warn("failed to save the state:", err)
+		return
+	}
+	checksum := sha256.Sum256(stateData)
+	data, err := json.Marshal(stateFile{Checksum: hex.EncodeToString(checksum[:]), State: stateData})
+	if err != nil {
+		service.log.Warn("failed to save the state:", err)
+		return
+	}
+
+	err = writeFileAtomically(service.configFile(STATE_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to save the state:", err)
+	}
+	service.saveMd5Cache()
+}
+
+//*********************************************************
+
+// writes to a temp file first and renames it, so a crash while writing doesn't leave a half written file and a
+// reader never sees one, the files in config are only readable by this user
+func writeFileAtomically(fileName string, data []byte) error {
+	return writeFileAtomicallyWithPerm(fileName, data, 0600)
+}
+
+// the same for the files other programs read, like status.json and the mirror stamp
+func writeSharedFileAtomically(fileName string, data []byte) error {
+	return writeFileAtomicallyWithPerm(fileName, data, 0644)
+}
+
+func writeFileAtomicallyWithPerm(fileName string, data []byte, perm os.FileMode) error {
+	tempFileName := fileName + ".tmp"
+	err := os.WriteFile(tempFileName, data, perm)
+	if err == nil {
+		err = os.Rename(tempFileName, fileName)
+	}
+	return err
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func decodeState(data []byte) (persistedState, error) {
+	var state persistedState
+	var file stateFile
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return state, err
+	}
+
+	// written by an older version, without a checksum
+	if file.State == nil {
+		err = json.Unmarshal(data, &state)
+		return state, err
+	}
+
+	checksum := sha256.Sum256(file.State)
+	if hex.EncodeToString(checksum[:]) != file.Checksum {
+		return state, fmt.Errorf("the checksum doesn't match")
+	}
+	err = json.Unmarshal(file.State, &state)
+	return state, err
+}
+
+//*********************************************************
+
+// renames the state file so it can be looked at later, returns the new name
+func (service *Service) moveStateAside(reason string) (string, error) {
+	fileName := service.configFile(STATE_FILE_NAME)
+	movedTo := fileName + "." + reason + "-" + service.clock.Now().Format("20060102-150405")
+	err := os.Rename(fileName, movedTo)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return movedTo, err
+}
+
+//*********************************************************
+
+// moves the saved state aside and builds it again with a full reconciliation, for when the sync seems to be
+// acting on wrong state
+func (service *Service) RebuildState(ctx context.Context) error {
+	movedTo, err := service.moveStateAside("old")
+	if err != nil {
+		return err
+	}
+	if movedTo != "" {
+		service.log.Info("moved the saved state to", movedTo)
+	}
+	service.resetMetadataCache() // the listings are built again too
+	return service.RunOnce(ctx, true)
+}
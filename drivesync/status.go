@@ -0,0 +1,224 @@
+package drivesync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the sidecar file that file managers and shell extensions can read to show which files are fully synced
+const STATUS_FILE_NAME = "config/status.json"
+
+type FileStatus string
+
+const (
+	STATUS_SYNCED           FileStatus = "synced"
+	STATUS_PENDING          FileStatus = "pending"
+	STATUS_ERROR            FileStatus = "error"
+	STATUS_PENDING_DELETION FileStatus = "pending-deletion" // trashed on Google Drive, the local copy will be removed
+	STATUS_UNKNOWN          FileStatus = "unknown"          // not in any of the synced folders
+)
+
+// everything that is not synced yet, a file in a synced folder that is not listed here is synced
+type statusSnapshot struct {
+	UpdatedAt time.Time         `json:"updatedAt"`
+	Pending   []string          `json:"pending"`
+	Errors    map[string]string `json:"errors"` // key = local path, value = the last error for that file
+
+	PendingDeletions []PendingDeletion `json:"pendingDeletions"` // cancel them with the deletions cancel command
+}
+
+type fileStatusResponse struct {
+	Path   string     `json:"path"`
+	Status FileStatus `json:"status"`
+	Error  string     `json:"error,omitempty"`
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// saves what is still pending so the status can be read while the next sync is running,
+// also writes the sidecar status file
+func (service *Service) publishStatus() {
+	snapshot := statusSnapshot{
+		UpdatedAt: service.clock.Now().UTC(),
+		Pending:   []string{},
+		Errors:    make(map[string]string),
+
+		PendingDeletions: service.loadPendingDeletions().Pending,
+	}
+	if snapshot.PendingDeletions == nil {
+		snapshot.PendingDeletions = []PendingDeletion{}
+	}
+	pending := make(map[string]bool)
+	for localPath := range service.filesToUpload {
+		pending[localPath] = true
+	}
+	for localPath := range service.filesToDownload {
+		pending[localPath] = true
+	}
+	for localPath := range pending {
+		snapshot.Pending = append(snapshot.Pending, localPath)
+	}
+	sort.Strings(snapshot.Pending)
+
+	// only the files that still need to be synced can be in error
+	for localPath, message := range service.syncErrors {
+		if pending[localPath] {
+			snapshot.Errors[localPath] = message
+		} else {
+			delete(service.syncErrors, localPath)
+		}
+	}
+
+	service.statusMutex.Lock()
+	service.status = snapshot
+	service.pendingStatus = pending
+	service.statusMutex.Unlock()
+	service.recordQueues()
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to write the status file:", err)
+		return
+	}
+
+	err = writeSharedFileAtomically(service.configFile(STATUS_FILE_NAME), data)
+	if err != nil {
+		service.log.Warn("failed to write the status file:", err)
+	}
+}
+
+//*********************************************************
+
+// reads the status that a running sync last saved, so it can be checked from another process
+func (service *Service) loadPublishedStatus() error {
+	data, err := os.ReadFile(service.configFile(STATUS_FILE_NAME))
+	if err != nil {
+		return err
+	}
+	var snapshot statusSnapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to read the status file: %v", err)
+	}
+
+	pending := make(map[string]bool)
+	for _, localPath := range snapshot.Pending {
+		pending[localPath] = true
+	}
+	service.statusMutex.Lock()
+	service.status = snapshot
+	service.pendingStatus = pending
+	service.statusMutex.Unlock()
+	return nil
+}
+
+//*********************************************************
+
+// prints what the running sync last saved, the status of each path or everything that is not synced yet
+// when no paths are given
+func (service *Service) PrintStatus(w io.Writer, paths []string) error {
+	err := service.loadPublishedStatus()
+	if err != nil {
+		return err
+	}
+
+	for _, localPath := range paths {
+		status := service.getFileStatus(localPath)
+		if status.Error != "" {
+			fmt.Fprintf(w, "%v: %v, %v\n", status.Path, status.Status, status.Error)
+		} else {
+			fmt.Fprintf(w, "%v: %v\n", status.Path, status.Status)
+		}
+	}
+	if len(paths) > 0 {
+		return nil
+	}
+
+	service.statusMutex.Lock()
+	defer service.statusMutex.Unlock()
+	fmt.Fprintln(w, "updated at", service.status.UpdatedAt.Local())
+	fmt.Fprintln(w, len(service.status.Pending), "files still need to be synced")
+	for _, localPath := range service.status.Pending {
+		if message, found := service.status.Errors[localPath]; found {
+			fmt.Fprintf(w, "  %v (%v)\n", localPath, message)
+		} else {
+			fmt.Fprintln(w, " ", localPath)
+		}
+	}
+	fmt.Fprintln(w, len(service.status.PendingDeletions), "deletions are waiting")
+	for _, deletion := range service.status.PendingDeletions {
+		fmt.Fprintln(w, " ", deletion.Target(), "at", deletion.DeleteAt.Local())
+	}
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) getFileStatus(localPath string) fileStatusResponse {
+	// the paths we track are relative to the working directory
+	if filepath.IsAbs(localPath) {
+		workingDir, err := os.Getwd()
+		if err == nil {
+			relativePath, err := filepath.Rel(workingDir, localPath)
+			if err == nil {
+				localPath = relativePath
+			}
+		}
+	}
+	localPath = filepath.Clean(localPath)
+
+	service.statusMutex.Lock()
+	message, inError := service.status.Errors[localPath]
+	pending := service.pendingStatus[localPath]
+	pendingDeletions := service.status.PendingDeletions
+	service.statusMutex.Unlock()
+
+	if inError {
+		return fileStatusResponse{Path: localPath, Status: STATUS_ERROR, Error: message}
+	}
+	if pending {
+		return fileStatusResponse{Path: localPath, Status: STATUS_PENDING}
+	}
+	for _, deletion := range pendingDeletions {
+		if deletion.LocalPath == localPath {
+			return fileStatusResponse{Path: localPath, Status: STATUS_PENDING_DELETION}
+		}
+	}
+
+	if _, _, found := service.splitLocalPath(localPath); !found {
+		return fileStatusResponse{Path: localPath, Status: STATUS_UNKNOWN}
+	}
+	if _, err := service.fileSystem.Stat(localPath); err != nil {
+		return fileStatusResponse{Path: localPath, Status: STATUS_UNKNOWN}
+	}
+	return fileStatusResponse{Path: localPath, Status: STATUS_SYNCED}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// GET /status returns everything that is not synced yet, GET /status?path=<path> returns the status of one file
+func (service *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	localPath := r.URL.Query().Get("path")
+	if localPath != "" {
+		json.NewEncoder(w).Encode(service.getFileStatus(localPath))
+		return
+	}
+
+	service.statusMutex.Lock()
+	defer service.statusMutex.Unlock()
+	json.NewEncoder(w).Encode(service.status)
+}
@@ -0,0 +1,473 @@
+// Package drivesync is the sync engine behind Google-Drive-For-Desktop-Lite. It keeps local folders in sync
+// with folders on Google Drive that are shared with a service account, and can be embedded in other programs.
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// reads the settings, credentials and base folders from the config folder in the working directory
+func NewService() *Service {
+	var service Service
+	service.initializeService()
+	return &service
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// syncs until the context is cancelled, checking for new uploads/downloads every 300 seconds or the interval
+// set with SetSyncInterval, or less often while Google Drive is rate limiting us
+func (service *Service) Run(ctx context.Context, fullRescan bool) error {
+	verified := service.startSync(fullRescan)
+
+	firstPass := true
+
+	// without the saved state the first pass is a full reconciliation, with it the next one is due as if the
+	// program had kept running
+	if !verified || !service.reconcileTimeLoaded {
+		service.setReconcileTime(service.clock.Now())
+	}
+	service.checkVolumes()
+	service.startWatching()
+	defer service.stopWatching()
+
+	for {
+		// both sides are checked unless the cycle was started because only one of them was due
+		scanLocal, checkRemote := true, true
+		if !firstPass {
+			service.adjustThrottle()
+			service.checkErrorBudget()
+			service.publishStatus()
+			service.setActivity("")
+			for waiting := true; waiting; {
+				waiting = false
+				select {
+				case <-ctx.Done():
+				case <-service.clock.After(service.syncInterval()):
+					scanLocal, checkRemote = service.scansDue()
+				case <-service.monitor.syncNow:
+				case <-service.localWatch.localChanges:
+					// give the change a moment to finish so the files aren't held back as still changing
+					service.log.Debug("a watched folder changed, syncing early")
+					select {
+					case <-ctx.Done():
+					case <-service.clock.After(SETTLE_TIME):
+					}
+				case <-service.priorityPollTimer():
+					// in between the cycles, then back to waiting for the next one
+					if !service.isPaused() {
+						service.pollPriorityFiles(ctx)
+					}
+					waiting = true
+				}
+			}
+		}
+		firstPass = false
+
+		if ctx.Err() != nil {
+			service.publishStatus()
+			return ctx.Err()
+		}
+
+		if service.isPaused() {
+			continue
+		}
+
+		// the fetch command asked for the older files of a folder, they're found by listing everything
+		if service.syncWindowChanged() && verified {
+			service.setReconcileTime(service.clock.Now())
+			verified = false
+		}
+
+		// comparing the local changes and a full reconciliation hash files, so on battery they wait until the
+		// computer is plugged in while the changes from Google Drive are still downloaded
+		if service.hashingDeferred() {
+			if !verified {
+				service.log.Debug("running on battery, waiting for AC power before the full reconciliation")
+				continue
+			}
+			if scanLocal {
+				service.log.Debug("running on battery, the local changes wait for AC power")
+			}
+			scanLocal = false
+		}
+
+		var err error
+		var stuck bool
+		verified, stuck, err = service.watchedSyncCycle(ctx, verified, scanLocal, checkRemote)
+		if errors.Is(err, ErrStuck) {
+			return err
+		}
+		if stuck && ctx.Err() == nil {
+			firstPass = true // start the next cycle right away
+			continue
+		}
+		if err != nil {
+			service.log.Error(err)
+			service.recordFailure(err)
+			service.notifyAuthError(err)
+			continue
+		}
+		service.authFailing = false // a cycle got through, so the credentials work again
+
+		//***********************************************************
+
+		// cleanup section, once a day after 2am
+
+		if service.cleanupIsOff("the cleanup") == nil {
+			service.runScheduledCleanup(ctx)
+		}
+		now := service.clock.Now()
+
+		//***********************************************************
+
+		// re-verify section, the next loop will do a full reconciliation to catch any missed changes, or to
+		// find more older files to backfill
+
+		if verified && (service.backfill(ctx) || service.reconciliationIsDue()) {
+			service.log.Info("starting a full reconciliation at", now)
+			service.setReconcileTime(now)
+			service.startWatching()
+			verified = false
+		}
+	}
+}
+
+//*********************************************************
+
+// the saved state lets the first pass after a restart be as cheap as any other pass, returns true if the
+// state was loaded and the last sync was verified
+func (service *Service) startSync(fullRescan bool) bool {
+	service.loadIgnoreFiles()
+	service.loadNotSyncedFolders()
+
+	var verified bool = false
+	if !fullRescan {
+		verified = service.loadState()
+		service.loadMd5Cache()
+	}
+	if !verified {
+		service.fillLocalMap()
+	}
+	service.startSyncWindow()
+	return verified
+}
+
+//*********************************************************
+
+// uploads, downloads and verifies once, returns true if everything was verified, an error stops the cycle early
+// and it's tried again from the top next time, a side that isn't checked isn't looked at for new changes
+func (service *Service) syncCycle(ctx context.Context, verified bool, scanLocal bool, checkRemote bool) (bool, error) {
+	// a full reconciliation has to look at both sides
+	reconciling := !verified
+	if !verified {
+		service.resetVerifiedTime()
+		scanLocal, checkRemote = true, true
+	}
+
+	//***********************************************************
+
+	// upload section
+
+	// check if we need to upload anything
+	service.log.Debug("Checking for any new or modified local files/folders")
+	localModified := false
+	if scanLocal {
+		service.setActivity("checking local files")
+		service.localScannedAt = service.clock.Now()
+		localModified = service.localFilesModified()
+	}
+	if localModified && service.settings.Mirror {
+		service.ignoreLocalChanges(reconciling)
+		localModified = false
+	}
+
+	// do the upload
+	if localModified {
+		service.log.Debug("Preparing to upload files")
+		service.setActivity("uploading")
+		// hash the files while the remote folders are being listed instead of one after the other
+		warmUpDone := service.warmUpHashes(sortedPaths(service.filesToUpload))
+		service.clearUploadLookupMap()
+		err := service.fillUploadLookupMap(ctx, service.getBaseFolderSlice())
+		<-warmUpDone
+		if err != nil {
+			return verified, err
+		}
+		err = service.handleUploads(ctx)
+		if err != nil {
+			// if we only uploaded half a file then we don't want to download that half-written file,
+			// so we will try again from the beginning of the loop
+			return verified, err
+		}
+	}
+
+	//***********************************************************
+
+	// download section
+
+	// check if anything was modified on the remote shared drive
+	var remoteModifiedFiles []FileMetaData
+	if checkRemote {
+		service.setActivity("checking Google Drive")
+		service.remoteCheckedAt = service.clock.Now()
+		var err error
+		remoteModifiedFiles, err = service.getRemoteModifiedFiles(ctx)
+		if errors.Is(err, ErrExpired) && verified {
+			// a machine that was offline for a long time can't get the changes it missed, so look at everything
+			service.log.Warn("the saved changes from Google Drive have expired, starting a full reconciliation:", err)
+			service.setReconcileTime(service.clock.Now())
+			return service.syncCycle(ctx, false, true, true)
+		}
+		if err != nil {
+			return verified, err
+		}
+	}
+	if len(remoteModifiedFiles) > 0 {
+		// grab all the metadata for the files/folders that are currently on the remote shared drive
+		// because we need the ids of files/folders, timestamps, md5's, etc.
+		service.clearDownloadLookupMap()
+		err := service.fillDownloadLookupMap(ctx, remoteModifiedFiles, verified)
+		if err != nil {
+			return verified, err
+		}
+
+		// check if we need to download anything, a full reconciliation finds the whole backlog again
+		if reconciling {
+			service.window.backlog = nil
+		}
+		service.checkForDownloads()
+		if reconciling {
+			service.window.skippedAtReconcile = service.window.skipped
+		}
+
+		if service.settings.RecordPermissions {
+			service.recordPermissions(ctx, service.downloadLookupMap)
+		}
+	}
+
+	// remove the local copies of the items that were trashed on Google Drive once their grace period is over
+	service.applyDueDeletions()
+
+	// do the download or re-download if it was not verified from the last loop
+	if len(service.filesToDownload) > 0 {
+		service.log.Debug("Preparing to download files")
+		service.setActivity("downloading")
+		service.handleDownloads(ctx)
+	}
+
+	//***********************************************************
+
+	// verify section
+
+	service.setActivity("verifying")
+
+	if len(service.filesToUpload) > 0 {
+		service.log.Debug("Need to verify uploads. Grabbing remote metadata first.")
+		refreshed, err := service.refreshUploadedItems(ctx)
+		if err != nil {
+			return verified, err
+		}
+		if !refreshed {
+			service.clearUploadLookupMap()
+			err = service.fillUploadLookupMap(ctx, service.getBaseFolderSlice())
+			if err != nil {
+				return verified, err
+			}
+		}
+	}
+
+	if len(service.filesToDownload) > 0 {
+		service.log.Debug("Need to verify downloads. Grabbing remote metadata first.")
+		// again grab all the metadata for the files/folders that are currently on the remote shared drive
+		service.clearDownloadLookupMap()
+		err := service.fillDownloadLookupMap(ctx, remoteModifiedFiles, verified)
+		if err != nil {
+			return verified, err
+		}
+	}
+
+	// do a verify if we uploaded or downloaded anything
+	if len(service.filesToUpload) > 0 || len(service.filesToDownload) > 0 {
+		// verify local files were uploaded to the remote server
+		service.verifyUploads()
+
+		// verify remote files were downloaded to the local side
+		service.verifyDownloads()
+
+		if len(service.filesToUpload) == 0 && len(service.filesToDownload) == 0 {
+			service.log.Info("verified! new verified timestamp:", service.mostRecentTimestampSeen.Local(), "numApiCalls:", service.conn.getNumApiCalls())
+			service.setVerifiedTime()
+			service.clearUploadLookupMap()
+			service.clearDownloadLookupMap()
+			service.verifyFailures = 0
+			verified = true
+		} else {
+			service.log.Info("not verified, will try again next time")
+			service.notifyVerifyFailure()
+		}
+	} else if verified {
+		// nothing needed to be transferred, so the changes we just read don't need to be read again
+		service.commitChangesPageToken()
+	}
+
+	if verified && service.settings.Mirror {
+		service.updateMirrorStamp(reconciling)
+	}
+
+	if !service.cycle.isEmpty() {
+		service.lastChangeAt = service.clock.Now()
+	}
+
+	// one notification for the whole cycle, anything from a cycle that stopped early is included in the next one
+	service.recordCycle()
+	service.notifyCycle()
+
+	return verified, nil
+}
+
+//*********************************************************
+
+// uploads, downloads and verifies one time and returns, for running from cron or a script instead of leaving
+// it running, the error wraps ErrNotVerified if any of the files could not be synced
+func (service *Service) RunOnce(ctx context.Context, fullRescan bool) error {
+	verified := service.startSync(fullRescan)
+	service.setReconcileTime(service.clock.Now())
+	service.checkVolumes()
+
+	_, err := service.syncCycle(ctx, verified, true, true)
+	service.publishStatus()
+	if err != nil {
+		return err
+	}
+	if len(service.filesToUpload) > 0 || len(service.filesToDownload) > 0 {
+		return fmt.Errorf("%d uploads and %d downloads were not verified: %w", len(service.filesToUpload), len(service.filesToDownload), ErrNotVerified)
+	}
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// trashes or deletes the files belonging to the service account that are no longer in the user's folders, see
+// cleanup_mode
+func (service *Service) RemoveDeletedFiles(ctx context.Context) error {
+	service.cleanupLog.Debug("Proceeding to remove deleted files...")
+
+	startTime := service.clock.Now()
+	plan, err := service.planCleanup(ctx)
+	if err != nil {
+		service.cleanupLog.Error(err)
+		service.cleanupLog.Warn("failed to find the orphaned files, not removing the deleted files")
+		service.alert("Cleanup failed", "failed to find the orphaned files: "+err.Error())
+		return err
+	}
+
+	summary := CleanupSummary{Trashed: service.settings.CleanupMode == CLEANUP_TRASH}
+	for _, action := range plan.Actions {
+		summary.OrphansFound += action.ItemCount
+	}
+	service.cleanupLog.Info("cleanup found", summary.OrphansFound, "orphaned files/folders")
+	plan = service.dueCleanupActions(plan, true)
+
+	if logEnabled(LOG_DEBUG) {
+		plan.Print()
+	}
+	service.deleteRemoteItems(ctx, plan.Actions, &summary)
+	summary.Duration = service.clock.Now().Sub(startTime)
+
+	// always report the summary so it's clear the cleanup is actually doing something
+	service.cleanupLog.Info("cleanup summary:", summary)
+	if summary.Deleted > int64(service.settings.NotifyCleanupOver) {
+		service.alert("Cleanup finished", summary.String())
+	} else if summary.OrphansFound > 0 {
+		service.notify("Cleanup finished", summary.String())
+	}
+	return ctx.Err()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) Connection() *Connection {
+	return &service.conn
+}
+
+//*********************************************************
+
+// returns a copy of the base folders, key = local folder name, value = folder id on Google Drive
+func (service *Service) BaseFolders() map[string]string {
+	baseFolders := make(map[string]string)
+	for localName, id := range service.baseFolders {
+		baseFolders[localName] = id
+	}
+	return baseFolders
+}
+
+//*********************************************************
+
+// returns the local paths of the base folders in sorted order
+func (service *Service) BaseFolderPaths() []string {
+	return service.getBaseFolderSlice()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// adds a folder to sync, it is saved in config/config.json or config/folder-ids.txt and the local folder is
+// created
+func (service *Service) AddBaseFolder(localName string, folderId string) error {
+	fileName := service.configFile("config/folder-ids.txt")
+
+	baseFolders := service.BaseFolders()
+	baseFolders[configNameToLocalPath(localName)] = folderId
+	err := validateBaseFolders(baseFolders, service.configFile(DEFAULT_CONFIG_DIR))
+	if err != nil {
+		return err
+	}
+
+	// the local folder needs to exist before the first sync
+	err = os.MkdirAll(localName, 0766)
+	if err != nil {
+		return err
+	}
+
+	configFileName := service.configFile(CONFIG_FILE_NAME)
+	if _, err := os.Stat(configFileName); err == nil {
+		err = addFolderToConfigFile(configFileName, localName, folderId)
+		if err == nil {
+			service.baseFolders[configNameToLocalPath(localName)] = folderId
+		}
+		return err
+	}
+
+	fh, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	// make sure the new entry starts on its own line
+	info, err := fh.Stat()
+	if err == nil && info.Size() > 0 {
+		contents, err := os.ReadFile(fileName)
+		if err == nil && !strings.HasSuffix(string(contents), "\n") {
+			fh.WriteString("\n")
+		}
+	}
+
+	_, err = fh.WriteString(localName + "=" + folderId + "\n")
+	if err != nil {
+		return err
+	}
+	service.baseFolders[configNameToLocalPath(localName)] = folderId
+	return nil
+}
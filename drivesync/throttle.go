@@ -0,0 +1,262 @@
+package drivesync
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Every response that says we're being rate limited is counted. If the last cycle was rate limited then
+// the local scans and remote checks happen half as often and the cleanup uses half as many workers at half the rate, up to
+// MAX_THROTTLE_LEVEL times. Each cycle without a rate limit goes back one level, so it recovers gradually.
+
+const SYNC_INTERVAL = 300 * time.Second
+const MAX_THROTTLE_LEVEL = 4
+
+// the rates of the tickers are kept between these, a ticker panics below an interval of 1ns and the interval
+// overflows for a rate near 0, anything faster than 1000 per second is the same as no limit anyway
+const MAX_RATE_PER_SECOND = 1000
+const MIN_RATE_PER_SECOND = 1.0 / (24 * 60 * 60)
+
+// the reasons in the body of a 403 that mean we're being rate limited
+var rateLimitReasons = map[string]bool{
+	"userRateLimitExceeded": true,
+	"rateLimitExceeded":     true,
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// sits in front of the other transports and counts the rate limited responses, the body of a 403 is read to
+// tell a rate limit apart from a missing permission and is then put back for the caller
+type throttleObservingTransport struct {
+	base http.RoundTripper
+	conn *Connection
+}
+
+func (t *throttleObservingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := t.base.RoundTrip(req)
+	t.conn.errorBudget.record(time.Now(), isFailedRequest(response, err))
+	if err == nil && isRateLimited(response) {
+		atomic.AddInt64(&t.conn.rateLimited, 1)
+		atomic.AddInt64(&t.conn.rateLimitedTotal, 1)
+	}
+	return response, err
+}
+
+//*********************************************************
+
+func isRateLimited(response *http.Response) bool {
+	if response.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if response.StatusCode != http.StatusForbidden {
+		return false
+	}
+
+	// only the start of the body is needed, the rest is left for the caller
+	bodyData, err := readErrorBody(response.Body)
+	response.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(bodyData), response.Body), response.Body}
+	if err != nil {
+		return false
+	}
+	// only a rate limit, a 403 for the storage quota is also a quota error but slowing down won't fix it
+	for _, reason := range errorReasons(bodyData) {
+		if rateLimitReasons[reason] {
+			return true
+		}
+	}
+	return false
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// called once per cycle, slows down if anything was rate limited since the last call, otherwise speeds back up
+func (service *Service) adjustThrottle() {
+	rateLimited := atomic.SwapInt64(&service.conn.rateLimited, 0)
+	level := atomic.LoadInt64(&service.throttleLevel)
+
+	if rateLimited > 0 && level < MAX_THROTTLE_LEVEL {
+		level++
+		service.conn.log.Warn("rate limited", rateLimited, "times, slowing down to checking Google Drive every", service.settings.RemoteCheckInterval<<level)
+	} else if rateLimited == 0 && level > 0 {
+		level--
+		service.conn.log.Info("no longer rate limited, speeding up to checking Google Drive every", service.settings.RemoteCheckInterval<<level)
+	}
+	atomic.StoreInt64(&service.throttleLevel, level)
+	service.conn.apiLimiter.setRate(service.apiRate())
+}
+
+//*********************************************************
+
+// the number of deletes that can run at the same time during the cleanup, at least 1
+func (service *Service) cleanupWorkers() int {
+	workers := service.settings.CleanupWorkers >> atomic.LoadInt64(&service.throttleLevel)
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+//*********************************************************
+
+// the number of files that can be downloaded at the same time, at least 1
+func (service *Service) downloadWorkers() int {
+	workers := service.settings.DownloadWorkers >> atomic.LoadInt64(&service.throttleLevel)
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+//*********************************************************
+
+// the number of files that can be uploaded at the same time, at least 1
+func (service *Service) uploadWorkers() int {
+	workers := service.settings.UploadWorkers >> atomic.LoadInt64(&service.throttleLevel)
+	if workers < 1 {
+		workers = 1
+	}
+	return workers
+}
+
+//*********************************************************
+
+// the maximum number of uploads started per second, 0 means no limit
+func (service *Service) uploadRate() float64 {
+	if service.settings.UploadRatePerSecond <= 0 {
+		return 0
+	}
+	return clampRate(service.settings.UploadRatePerSecond / float64(int64(1)<<atomic.LoadInt64(&service.throttleLevel)))
+}
+
+//*********************************************************
+
+// the maximum number of deletes per second during the cleanup
+func (service *Service) cleanupRate() float64 {
+	return clampRate(service.settings.CleanupRatePerSecond / float64(int64(1)<<atomic.LoadInt64(&service.throttleLevel)))
+}
+
+//*********************************************************
+
+// keeps a rate that is turned into the interval of a ticker in range
+func clampRate(rate float64) float64 {
+	if math.IsNaN(rate) || rate > MAX_RATE_PER_SECOND {
+		return MAX_RATE_PER_SECOND
+	}
+	if rate < MIN_RATE_PER_SECOND {
+		return MIN_RATE_PER_SECOND
+	}
+	return rate
+}
+
+//*********************************************************
+
+// the maximum number of requests per second to Google Drive
+func (service *Service) apiRate() float64 {
+	return service.settings.ApiRatePerSecond / float64(int64(1)<<atomic.LoadInt64(&service.throttleLevel))
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Every request to Google Drive takes a token from one bucket shared by the whole connection, so the workers and
+// the recursive listings together stay under the quota of 20,000 requests per 100 seconds instead of running into
+// it and being rate limited all at once. The bucket refills at api_rate tokens per second and holds one second's
+// worth, so a short burst goes right through. A batch request takes a token for each request in it.
+
+type tokenBucket struct {
+	mutex     sync.Mutex
+	rate      float64 // tokens per second
+	tokens    float64 // below 0 when requests are waiting
+	updatedAt time.Time
+}
+
+// nil means no limit, a nil bucket never waits
+func newTokenBucket(rate float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	return &tokenBucket{rate: rate, tokens: rate, updatedAt: time.Now()}
+}
+
+//*********************************************************
+
+// takes n tokens, waits until they would have been there, the waits of the callers are lined up one after the other
+func (bucket *tokenBucket) wait(ctx context.Context, n int) error {
+	if bucket == nil {
+		return nil
+	}
+	bucket.mutex.Lock()
+	bucket.refill()
+	bucket.tokens -= float64(n)
+	var delay time.Duration
+	if bucket.tokens < 0 {
+		delay = time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+	}
+	bucket.mutex.Unlock()
+
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//*********************************************************
+
+// slows the bucket down while Google Drive is rate limiting, 0 keeps the current rate
+func (bucket *tokenBucket) setRate(rate float64) {
+	if bucket == nil || rate <= 0 {
+		return
+	}
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill()
+	bucket.rate = rate
+}
+
+//*********************************************************
+
+// the caller holds the mutex
+func (bucket *tokenBucket) refill() {
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.updatedAt).Seconds() * bucket.rate
+	if bucket.tokens > bucket.rate {
+		bucket.tokens = bucket.rate
+	}
+	bucket.updatedAt = now
+}
+
+//*********************************************************
+
+type rateLimitingTransport struct {
+	base   http.RoundTripper
+	bucket *tokenBucket
+}
+
+func (t *rateLimitingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	err := t.bucket.wait(req.Context(), 1)
+	if err != nil {
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
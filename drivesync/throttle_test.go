@@ -0,0 +1,90 @@
+package drivesync
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func TestIsRateLimited(t *testing.T) {
+	tests := []struct {
+		statusCode int
+		reason     string
+		expected   bool
+	}{
+		{http.StatusTooManyRequests, "", true},
+		{http.StatusForbidden, "userRateLimitExceeded", true},
+		{http.StatusForbidden, "rateLimitExceeded", true},
+		{http.StatusForbidden, "storageQuotaExceeded", false},
+		{http.StatusForbidden, "insufficientFilePermissions", false},
+		{http.StatusOK, "", false},
+	}
+
+	for _, test := range tests {
+		body := `{"error":{"errors":[{"reason":"` + test.reason + `"}]}}`
+		response := &http.Response{StatusCode: test.statusCode, Body: io.NopCloser(strings.NewReader(body))}
+		if rateLimited := isRateLimited(response); rateLimited != test.expected {
+			t.Errorf("isRateLimited(%v %v) = %v, expected %v", test.statusCode, test.reason, rateLimited, test.expected)
+		}
+		// the body is still there for the caller
+		if rest, _ := io.ReadAll(response.Body); string(rest) != body {
+			t.Errorf("the body was changed to %q", rest)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestUploadRateIsKeptInRange(t *testing.T) {
+	tests := []struct {
+		setting  float64
+		expected float64
+	}{
+		{0, 0},
+		{5, 5},
+		{math.Inf(1), MAX_RATE_PER_SECOND},
+		{math.NaN(), MAX_RATE_PER_SECOND},
+		{1e-300, MIN_RATE_PER_SECOND},
+	}
+
+	for _, test := range tests {
+		service := &Service{}
+		service.settings.UploadRatePerSecond = test.setting
+		rate := service.uploadRate()
+		if rate != test.expected {
+			t.Errorf("uploadRate() with upload_rate=%v = %v, expected %v", test.setting, rate, test.expected)
+		}
+		if rate > 0 {
+			// the upload workers turn it into a ticker, which panics if the interval isn't positive
+			time.NewTicker(time.Duration(float64(time.Second) / rate)).Stop()
+		}
+	}
+}
+
+//*********************************************************
+
+func TestParseFloatSettingRejectsNaNAndInf(t *testing.T) {
+	for _, value := range []string{"NaN", "Inf", "-1", "0", "x"} {
+		if result := parseFloatSetting("upload_rate", value, 10); result != 10 {
+			t.Errorf("parseFloatSetting(%q) = %v, expected the default", value, result)
+		}
+	}
+}
+
+//*********************************************************
+
+func TestRetryDelayDoesNotOverflow(t *testing.T) {
+	response := &http.Response{Header: http.Header{}}
+	for _, attempt := range []int{0, 5, 34, 63, 64, 1000} {
+		delay := retryDelay(attempt, response)
+		if delay < RETRY_BASE_DELAY || delay > RETRY_MAX_DELAY+time.Second {
+			t.Errorf("retryDelay(%v) = %v", attempt, delay)
+		}
+	}
+}
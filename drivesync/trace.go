@@ -0,0 +1,361 @@
+package drivesync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Recording saves every API call to a trace file, and replaying serves the calls back from the trace file
+// without talking to Google Drive. A user can record a trace of a sync bug and send it in, then the bug
+// can be reproduced offline. The traces are sanitized: the api key, the quota user and the upload session
+// ids are removed, the uploaded bytes are replaced with their size and md5, and the downloaded bytes are
+// replaced with theirs too unless record_trace_contents is turned on. The file names, the emails and the
+// display names in the responses are replaced with stand-ins, the same name gets the same stand-in for as long
+// as the sync runs so the trace still has the same folders and duplicates. The bodies are recorded as the engine
+// reads them, so a large download isn't held in memory just to be recorded.
+
+// one API call in the trace file, the file has one of these per line
+type recordedInteraction struct {
+	Method          string            `json:"method"`
+	URL             string            `json:"url"`
+	RequestSize     int64             `json:"requestSize,omitempty"`
+	RequestMd5      string            `json:"requestMd5,omitempty"`
+	StatusCode      int               `json:"statusCode"`
+	ResponseHeaders map[string]string `json:"responseHeaders,omitempty"`
+	ResponseBody    string            `json:"responseBody"`
+	BodyOmitted     bool              `json:"bodyOmitted,omitempty"`  // the downloaded file contents were left out
+	ResponseSize    int64             `json:"responseSize,omitempty"` // of the contents that were left out
+	ResponseMd5     string            `json:"responseMd5,omitempty"`
+}
+
+// only these response headers are needed by the engine
+var recordedHeaders = []string{"Content-Type", "Location", "Range"}
+
+// these query parameters identify the user or the upload session
+var sanitizedParams = []string{"key", "quotaUser", "upload_id"}
+
+// the keys in a json response that have a file name, or the name or email of a person
+var redactedFileNameKeys = map[string]bool{"name": true, "originalFilename": true, "title": true}
+var redactedPersonKeys = map[string]bool{"emailAddress": true, "displayName": true, "photoLink": true}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func sanitizeURL(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	query := parsed.Query()
+	for _, param := range sanitizedParams {
+		if query.Has(param) {
+			query.Set(param, "REDACTED")
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type recordingTransport struct {
+	base            http.RoundTripper
+	recordContents  bool
+	salt            string // so a stand-in can't be turned back into the name by hashing a list of common names
+	mutex           sync.Mutex
+	traceFileHandle *os.File
+	log             logger
+}
+
+func newRecordingTransport(base http.RoundTripper, fileName string, recordContents bool) (*recordingTransport, error) {
+	fh, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return &recordingTransport{base: base, recordContents: recordContents, salt: string(salt), traceFileHandle: fh, log: connLog}, nil
+}
+
+// the interaction is written when the engine is done with the response body
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	interaction := recordedInteraction{Method: req.Method, URL: sanitizeURL(req.URL.String())}
+
+	// keep the size and md5 of what was sent, but not the bytes themselves, they are counted as the request
+	// is sent instead of reading the body an extra time
+	var sent *hashingReader
+	if req.Body != nil && req.Body != http.NoBody {
+		sent = &hashingReader{ReadCloser: req.Body, hash: md5.New()}
+		req = req.Clone(req.Context())
+		req.Body = sent
+	}
+
+	response, err := t.base.RoundTrip(req)
+	if err != nil {
+		return response, err
+	}
+
+	interaction.StatusCode = response.StatusCode
+	interaction.ResponseHeaders = make(map[string]string)
+	for _, header := range recordedHeaders {
+		if value := response.Header.Get(header); value != "" {
+			interaction.ResponseHeaders[header] = sanitizeURL(value)
+		}
+	}
+
+	body := &recordingBody{hashingReader: hashingReader{ReadCloser: response.Body, hash: md5.New()}, transport: t,
+		interaction: interaction, sent: sent}
+	if req.URL.Query().Get("alt") == "media" && !t.recordContents {
+		body.interaction.BodyOmitted = true
+	} else {
+		body.contents = &bytes.Buffer{}
+		body.redact = strings.HasPrefix(response.Header.Get("Content-Type"), "application/json")
+	}
+	response.Body = body
+	return response, nil
+}
+
+//*********************************************************
+
+// counts and hashes what goes through, the request body is read by the transport on its own goroutine
+type hashingReader struct {
+	io.ReadCloser
+	mutex sync.Mutex
+	hash  hash.Hash
+	size  int64
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.mutex.Lock()
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	r.mutex.Unlock()
+	return n, err
+}
+
+func (r *hashingReader) sum() (int64, string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.size, fmt.Sprintf("%x", r.hash.Sum(nil))
+}
+
+//*********************************************************
+
+// a response body that records the interaction once it has been read to the end or closed, contents is nil when
+// only the size and md5 of the body are kept
+type recordingBody struct {
+	hashingReader
+	transport   *recordingTransport
+	interaction recordedInteraction
+	sent        *hashingReader
+	contents    *bytes.Buffer
+	redact      bool
+	once        sync.Once
+}
+
+func (body *recordingBody) Read(p []byte) (int, error) {
+	n, err := body.hashingReader.Read(p)
+	if body.contents != nil {
+		body.contents.Write(p[:n])
+	}
+	if err == io.EOF {
+		body.record()
+	}
+	return n, err
+}
+
+func (body *recordingBody) Close() error {
+	err := body.hashingReader.Close()
+	body.record()
+	return err
+}
+
+func (body *recordingBody) record() {
+	body.once.Do(func() {
+		interaction := body.interaction
+		if body.sent != nil {
+			interaction.RequestSize, interaction.RequestMd5 = body.sent.sum()
+		}
+		switch {
+		case body.contents == nil:
+			interaction.ResponseSize, interaction.ResponseMd5 = body.hashingReader.sum()
+		case body.redact:
+			interaction.ResponseBody = body.transport.redactNames(body.contents.Bytes())
+		default:
+			interaction.ResponseBody = body.contents.String()
+		}
+		body.transport.write(interaction)
+	})
+}
+
+//*********************************************************
+
+// replaces the file names and the people in a json response with stand-ins, a file name keeps its extension
+// because the engine decides how to export a file by it
+func (t *recordingTransport) redactNames(data []byte) string {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var parsed interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return string(data)
+	}
+	redacted, err := json.Marshal(t.redactValue(parsed))
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
+
+func (t *recordingTransport) redactValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			text, isString := item.(string)
+			switch {
+			case isString && text == "":
+			case isString && redactedFileNameKeys[key]:
+				value[key] = "file-" + t.standIn(text) + filepath.Ext(text)
+			case isString && key == "emailAddress":
+				value[key] = "user-" + t.standIn(text) + "@example.com"
+			case isString && redactedPersonKeys[key]:
+				value[key] = "user-" + t.standIn(text)
+			default:
+				value[key] = t.redactValue(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = t.redactValue(item)
+		}
+	}
+	return value
+}
+
+func (t *recordingTransport) standIn(text string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(t.salt+text)))[:12]
+}
+
+func (t *recordingTransport) write(interaction recordedInteraction) {
+	line, err := json.Marshal(interaction)
+	if err != nil {
+		t.log.Warn("failed to record the API call:", err)
+		return
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	_, err = t.traceFileHandle.Write(append(line, '\n'))
+	if err != nil {
+		t.log.Warn("failed to record the API call:", err)
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// serves the responses from a trace file, each request gets the first unused response that was recorded for the
+// same method and url, once they are all used the last one is repeated so the sync loop can keep polling
+type replayTransport struct {
+	mutex        sync.Mutex
+	interactions []recordedInteraction
+	used         []bool
+	log          logger
+}
+
+func newReplayTransport(fileName string) (*replayTransport, error) {
+	fh, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	t := &replayTransport{log: connLog}
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 1024*1024), 256*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var interaction recordedInteraction
+		err := json.Unmarshal([]byte(line), &interaction)
+		if err != nil {
+			return nil, fmt.Errorf("invalid line in %v: %w", fileName, err)
+		}
+		t.interactions = append(t.interactions, interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	t.used = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	requestUrl := sanitizeURL(req.URL.String())
+
+	t.mutex.Lock()
+	lastMatch := -1
+	for i, interaction := range t.interactions {
+		if interaction.Method != req.Method || interaction.URL != requestUrl {
+			continue
+		}
+		lastMatch = i
+		if !t.used[i] {
+			break
+		}
+	}
+	if lastMatch >= 0 {
+		t.used[lastMatch] = true
+	}
+	t.mutex.Unlock()
+
+	if lastMatch < 0 {
+		return nil, errors.New("no recorded response for " + req.Method + " " + requestUrl)
+	}
+	interaction := t.interactions[lastMatch]
+	if interaction.BodyOmitted {
+		t.log.Debug("the recorded response for", requestUrl, "does not include the file contents")
+	}
+
+	response := &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(interaction.ResponseBody)),
+		ContentLength: int64(len(interaction.ResponseBody)),
+		Request:       req,
+	}
+	for header, value := range interaction.ResponseHeaders {
+		response.Header.Set(header, value)
+	}
+	return response, nil
+}
@@ -0,0 +1,111 @@
+package drivesync
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func readTrace(t *testing.T, fileName string) []recordedInteraction {
+	t.Helper()
+	fh, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	var interactions []recordedInteraction
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		var interaction recordedInteraction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			t.Fatal(err)
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions
+}
+
+func recordOne(t *testing.T, recordContents bool, req *http.Request, contentType string, responseBody string) recordedInteraction {
+	t.Helper()
+	fileName := filepath.Join(t.TempDir(), "trace.jsonl")
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {contentType}},
+			Body: io.NopCloser(strings.NewReader(responseBody)), Request: req}, nil
+	})
+	transport, err := newRecordingTransport(base, fileName, recordContents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.traceFileHandle.Close()
+
+	response, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if string(received) != responseBody {
+		t.Errorf("the engine got %q, expected %q", received, responseBody)
+	}
+
+	interactions := readTrace(t, fileName)
+	if len(interactions) != 1 {
+		t.Fatalf("recorded %v interactions, expected 1", len(interactions))
+	}
+	return interactions[0]
+}
+
+//*********************************************************
+
+func TestRecordingRedactsNames(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://www.googleapis.com/drive/v3/files?key=secret", nil)
+	body := `{"files":[{"id":"a","name":"salary review.xlsx","owners":[{"emailAddress":"boss@example.org","displayName":"The Boss"}]},` +
+		`{"id":"b","name":"salary review.xlsx"}]}`
+	interaction := recordOne(t, false, req, "application/json; charset=UTF-8", body)
+
+	for _, secret := range []string{"salary", "boss@example.org", "The Boss", "secret"} {
+		if strings.Contains(interaction.ResponseBody+interaction.URL, secret) {
+			t.Errorf("%q was recorded: %v %v", secret, interaction.URL, interaction.ResponseBody)
+		}
+	}
+
+	var recorded ListFilesResponse
+	if err := json.Unmarshal([]byte(interaction.ResponseBody), &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.Files) != 2 || recorded.Files[0].ID != "a" || recorded.Files[0].Name != recorded.Files[1].Name ||
+		filepath.Ext(recorded.Files[0].Name) != ".xlsx" {
+		t.Errorf("expected the same stand-in with the same extension for both files, got %+v", recorded.Files)
+	}
+}
+
+//*********************************************************
+
+func TestRecordingOmitsDownloadedContents(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodPost, "https://www.googleapis.com/upload/drive/v3/files?alt=media",
+		strings.NewReader("uploaded contents"))
+	interaction := recordOne(t, false, req, "application/octet-stream", "downloaded contents")
+
+	if !interaction.BodyOmitted || interaction.ResponseBody != "" {
+		t.Errorf("expected the contents to be left out, got %q", interaction.ResponseBody)
+	}
+	if interaction.ResponseSize != int64(len("downloaded contents")) || interaction.ResponseMd5 != md5Of("downloaded contents") {
+		t.Errorf("recorded a response of %v bytes with md5 %v", interaction.ResponseSize, interaction.ResponseMd5)
+	}
+	if interaction.RequestSize != int64(len("uploaded contents")) || interaction.RequestMd5 != md5Of("uploaded contents") {
+		t.Errorf("recorded a request of %v bytes with md5 %v", interaction.RequestSize, interaction.RequestMd5)
+	}
+}
@@ -0,0 +1,211 @@
+package drivesync
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// With transfer_log=true every upload and download that finished is written to config/transfers.jsonl, one JSON
+// record per line, with the md5 of the source and of what arrived, how long it took, and whether the two match.
+// For an upload the source is the md5 of the bytes that were sent and the destination is the md5 Google Drive
+// computed, for a download it's the other way around. The compliance report reads the records back as evidence
+// that the synced data arrived intact. The file is only appended to, it's never pruned.
+
+const TRANSFER_LOG_FILE_NAME = "config/transfers.jsonl"
+
+const (
+	TRANSFER_UPLOAD   = "upload"
+	TRANSFER_DOWNLOAD = "download"
+)
+
+const (
+	TRANSFER_VERIFIED   = "verified"   // both md5's are known and match
+	TRANSFER_MISMATCH   = "mismatch"   // the md5's are different, the transfer is tried again
+	TRANSFER_UNVERIFIED = "unverified" // one of the md5's is unknown, an exported Google Doc or a lost response
+)
+
+type TransferRecord struct {
+	Time            time.Time `json:"time"` // when it finished
+	Direction       string    `json:"direction"`
+	Path            string    `json:"path"`
+	DriveId         string    `json:"driveId"`
+	Size            int64     `json:"size"`
+	SourceMd5       string    `json:"sourceMd5"`
+	DestinationMd5  string    `json:"destinationMd5"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	Result          string    `json:"result"`
+}
+
+// the uploads and downloads run in parallel, so the writes to the log take turns
+var transferLogMutex sync.Mutex
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// appends the record of a finished transfer to the log, the result is filled in from the md5's
+func (service *Service) logTransfer(record TransferRecord, started time.Time) {
+	if !service.settings.TransferLog || service.dryRun {
+		return
+	}
+	record.Time = service.clock.Now()
+	record.DurationSeconds = record.Time.Sub(started).Seconds()
+	switch {
+	case record.SourceMd5 == "" || record.DestinationMd5 == "":
+		record.Result = TRANSFER_UNVERIFIED
+	case record.SourceMd5 == record.DestinationMd5:
+		record.Result = TRANSFER_VERIFIED
+	default:
+		record.Result = TRANSFER_MISMATCH
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		service.log.Warn("failed to log the transfer of", record.Path, err)
+		return
+	}
+
+	transferLogMutex.Lock()
+	defer transferLogMutex.Unlock()
+	fh, err := os.OpenFile(service.configFile(TRANSFER_LOG_FILE_NAME), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		service.log.Warn("failed to log the transfer of", record.Path, err)
+		return
+	}
+	_, err = fh.Write(append(data, '\n'))
+	if err == nil {
+		err = fh.Sync()
+	}
+	closeErr := fh.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		service.log.Warn("failed to log the transfer of", record.Path, err)
+	}
+}
+
+//*********************************************************
+
+// the remote md5 is the source, an export has none so it's unverified and its size is taken from the file
+func (service *Service) logDownload(fileSystem FS, downloadedPath string, action Action, localMd5 string, started time.Time) {
+	size := action.Remote.Size
+	if info, err := fileSystem.Stat(downloadedPath); err == nil && size == 0 {
+		size = info.Size()
+	}
+	service.logTransfer(TransferRecord{Direction: TRANSFER_DOWNLOAD, Path: action.LocalPath, DriveId: action.Remote.ID, Size: size,
+		SourceMd5: action.Remote.Md5Checksum, DestinationMd5: localMd5}, started)
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the records of the transfers that finished at or after since, in the order they finished
+func (service *Service) TransferRecords(ctx context.Context, since time.Time) ([]TransferRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	fileName := service.configFile(TRANSFER_LOG_FILE_NAME)
+	fh, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var records []TransferRecord
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record TransferRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// a line cut short by a crash, everything before and after it is still good
+			service.log.Warn("ignoring line", lineNumber, "of", fileName, ":", err)
+			continue
+		}
+		if !record.Time.Before(since) {
+			records = append(records, record)
+		}
+	}
+	return records, scanner.Err()
+}
+
+//*********************************************************
+
+// writes the transfers since the time as text, csv or json, the text ends with how many of them were verified
+func (service *Service) WriteComplianceReport(ctx context.Context, w io.Writer, since time.Time, format string) error {
+	if format != "text" && format != "csv" && format != "json" {
+		return fmt.Errorf("unknown report format %v, expected text, csv or json", format)
+	}
+	records, err := service.TransferRecords(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if records == nil {
+			records = []TransferRecord{}
+		}
+		return encoder.Encode(records)
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"time", "direction", "path", "drive_id", "size", "source_md5", "destination_md5", "duration_seconds", "result"})
+		for _, record := range records {
+			writer.Write([]string{record.Time.UTC().Format(time.RFC3339Nano), record.Direction, record.Path, record.DriveId,
+				strconv.FormatInt(record.Size, 10), record.SourceMd5, record.DestinationMd5,
+				strconv.FormatFloat(record.DurationSeconds, 'f', 3, 64), record.Result})
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+
+	counts := make(map[string]int)
+	for _, record := range records {
+		fmt.Fprintf(w, "%v  %-8v  %-10v  %v  %v -> %v\n", record.Time.Local().Format("2006-01-02 15:04:05"), record.Direction,
+			record.Result, record.Path, record.SourceMd5, record.DestinationMd5)
+		counts[record.Result]++
+	}
+	if since.IsZero() {
+		fmt.Fprintf(w, "\n%d transfers", len(records))
+	} else {
+		fmt.Fprintf(w, "\n%d transfers since %v", len(records), since.Local().Format("2006-01-02 15:04:05"))
+	}
+	fmt.Fprintf(w, ": %d verified, %d mismatched and tried again, %d without an md5 to compare\n", counts[TRANSFER_VERIFIED],
+		counts[TRANSFER_MISMATCH], counts[TRANSFER_UNVERIFIED])
+	return nil
+}
+
+//*********************************************************
+
+// the --since of the report, a date, a time in RFC 3339, or a duration back from now like 720h
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if since, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return since, nil
+	}
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, nil
+	}
+	if duration, err := time.ParseDuration(value); err == nil && duration > 0 {
+		return now.Add(-duration), nil
+	}
+	return time.Time{}, fmt.Errorf("can't read the time %v, expected a date like 2006-01-02, a time in RFC 3339 or a duration like 720h", value)
+}
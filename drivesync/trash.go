@@ -0,0 +1,187 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// what happens to the local copy when an item is moved to the trash on Google Drive
+type DeletionPolicy string
+
+const (
+	DELETION_KEEP    DeletionPolicy = "keep"    // leave the local copy alone
+	DELETION_TRASH   DeletionPolicy = "trash"   // move the local copy into LOCAL_TRASH_FOLDER, the default
+	DELETION_RECYCLE DeletionPolicy = "recycle" // move the local copy to the recycle bin or trash of the OS
+	DELETION_DELETE  DeletionPolicy = "delete"  // delete the local copy
+)
+
+const LOCAL_TRASH_FOLDER = "config/trash"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func parseDeletionPolicy(key string, value string, defaultValue DeletionPolicy) DeletionPolicy {
+	policy := DeletionPolicy(value)
+	switch policy {
+	case DELETION_KEEP, DELETION_TRASH, DELETION_RECYCLE, DELETION_DELETE:
+		return policy
+	}
+	serviceLog.Warn("invalid value for setting", key, ":", value, "using the default", defaultValue)
+	return defaultValue
+}
+
+//*********************************************************
+
+// parses the value of a deletion_policy setting, either just the policy or folder=policy for one base folder
+func parseDeletionPolicySetting(key string, value string, settings *Settings) {
+	splitAt := strings.LastIndex(value, "=")
+	if splitAt < 0 {
+		settings.DeletionPolicy = parseDeletionPolicy(key, value, settings.DeletionPolicy)
+		return
+	}
+
+	folder := configNameToLocalPath(strings.TrimSpace(value[:splitAt]))
+	settings.FolderDeletionPolicies[folder] = parseDeletionPolicy(key, strings.TrimSpace(value[splitAt+1:]), settings.DeletionPolicy)
+}
+
+//*********************************************************
+
+// returns the deletion policy of the base folder that contains the local path
+func (service *Service) deletionPolicy(localPath string) DeletionPolicy {
+	if baseFolder, _, found := service.splitLocalPath(localPath); found {
+		if policy, set := service.settings.FolderDeletionPolicies[baseFolder]; set {
+			return policy
+		}
+	}
+	return service.settings.DeletionPolicy
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the item was trashed on Google Drive, so remove the local copy according to the deletion policy, unless the
+// local copy was changed after it was trashed since then it's the newer version and will be uploaded again
+func (service *Service) applyRemoteTrash(localPath string, remoteFileInfo FileMetaData) {
+	localFileInfo, err := service.fileSystem.Stat(localPath)
+	if err != nil {
+		service.forgetLocalPath(localPath)
+		return // already gone
+	}
+
+	policy := service.deletionPolicy(localPath)
+	if policy == DELETION_KEEP {
+		return
+	}
+
+	trashedAt, err := time.Parse(time.RFC3339Nano, remoteFileInfo.TrashedTime)
+	if err != nil {
+		service.log.Warn("not removing", localPath, "because the time it was trashed is unknown")
+		return
+	}
+	if !service.unchangedSinceTrashed(localPath, localFileInfo, remoteFileInfo, trashedAt) {
+		service.log.Warn("not removing", localPath, "even though it was trashed on Google Drive, it was changed locally")
+		return
+	}
+
+	switch policy {
+	case DELETION_DELETE:
+		err = service.fileSystem.RemoveAll(localPath)
+	case DELETION_RECYCLE:
+		err = service.moveToRecycleBin(localPath)
+	default:
+		err = service.moveToLocalTrash(localPath)
+	}
+	if err != nil {
+		service.log.with(logFields{Path: localPath}).Warn("failed to remove", localPath, "after it was trashed on Google Drive:", err)
+		service.syncErrors[localPath] = err.Error()
+		return
+	}
+
+	service.log.with(logFields{Path: localPath}).Info("removed", localPath, "because it was trashed on Google Drive, deletion policy:", policy)
+	service.forgetLocalPath(localPath)
+}
+
+//*********************************************************
+
+// true if nothing at the local path was modified after the item was trashed and a file still has the trashed contents
+func (service *Service) unchangedSinceTrashed(localPath string, localFileInfo os.FileInfo, remoteFileInfo FileMetaData, trashedAt time.Time) bool {
+	tolerance := service.timestampTolerance(localPath)
+
+	if !localFileInfo.IsDir() {
+		if localFileInfo.ModTime().Sub(trashedAt) > tolerance {
+			return false
+		}
+		return service.getMd5OfFile(localPath) == remoteFileInfo.Md5Checksum
+	}
+
+	unchanged := true
+	service.fileSystem.Walk(localPath, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			unchanged = false
+			return err
+		}
+		if !fileInfo.IsDir() && fileInfo.ModTime().Sub(trashedAt) > tolerance {
+			unchanged = false
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return unchanged
+}
+
+//*********************************************************
+
+// moves the local path to config/trash/<time>/<base folder>/<path inside the base folder>
+func (service *Service) moveToLocalTrash(localPath string) error {
+	baseFolder, names, found := service.splitLocalPath(localPath)
+	if !found || len(names) == 0 {
+		return fmt.Errorf("%v is not inside a base folder", localPath)
+	}
+
+	stamp := service.clock.Now().Format("2006-01-02T15-04-05")
+	trashPath := filepath.Join(append([]string{service.configFile(LOCAL_TRASH_FOLDER), stamp, filepath.Base(baseFolder)}, names...)...)
+
+	err := os.MkdirAll(filepath.Dir(trashPath), 0700)
+	if err != nil {
+		return err
+	}
+	return service.fileSystem.Rename(localPath, trashPath)
+}
+
+//*********************************************************
+
+// moves the local path to the recycle bin so it can be restored the usual way, if that doesn't work it's
+// moved to config/trash instead
+func (service *Service) moveToRecycleBin(localPath string) error {
+	// the recycle bin is on the real filesystem, so it can't be used with a different FS
+	if _, isOsFS := service.fileSystem.(osFS); !isOsFS {
+		return service.moveToLocalTrash(localPath)
+	}
+
+	err := moveToRecycleBin(localPath)
+	if err != nil {
+		service.log.Warn("could not move", localPath, "to the recycle bin, moving it to", service.configFile(LOCAL_TRASH_FOLDER), "instead:", err)
+		return service.moveToLocalTrash(localPath)
+	}
+	return nil
+}
+
+//*********************************************************
+
+// drops the local path and everything inside it from the maps that track the local files
+func (service *Service) forgetLocalPath(localPath string) {
+	prefix := localPath + string(filepath.Separator)
+	for _, paths := range []map[string]bool{service.localFiles, service.heldFiles, service.filesToUpload} {
+		for path := range paths {
+			if path == localPath || strings.HasPrefix(path, prefix) {
+				delete(paths, path)
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package drivesync
+
+import (
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// FAT32 stores the file size in 32 bits
+const FAT_MAX_FILE_SIZE int64 = 4*1024*1024*1024 - 1
+
+// the local and remote modification times are considered equal if they are this close, to allow for rounding
+const TIMESTAMP_TOLERANCE = 500 * time.Millisecond
+
+// network shares often round the modification times they report, so they are compared more loosely
+const NETWORK_TIMESTAMP_TOLERANCE = 2 * time.Second
+
+// what we know about the filesystem a base folder is on
+type volumeInfo struct {
+	fsType          string
+	network         bool
+	mtimeResolution time.Duration // how precise the modification times are, 0 if they are precise enough
+	maxFileSize     int64         // the biggest file the filesystem can hold, 0 if there's no practical limit
+	unreliableTimes bool          // set when a modification time we set did not stick
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// looks at the filesystem of each base folder and warns about the ones that behave differently
+func (service *Service) checkVolumes() {
+	for _, folder := range service.getBaseFolderSlice() {
+		volume := service.volumeFor(folder)
+		if volume.network {
+			service.log.Info(folder, "is on a network share ("+volume.fsType+"), the modification times are compared within",
+				volume.tolerance(), "and the changes made by other computers are only noticed by the walk every 300 seconds")
+		} else if volume.mtimeResolution > 0 {
+			service.log.Info(folder, "is on", volume.fsType, "which only keeps the modification times to within", volume.mtimeResolution)
+		}
+	}
+}
+
+//*********************************************************
+
+// returns the volume of the base folder that contains the local path, it's detected the first time it's needed
+func (service *Service) volumeFor(localPath string) *volumeInfo {
+	baseFolder, _, found := service.splitLocalPath(localPath)
+	if !found {
+		baseFolder = localPath
+	}
+
+	volume, detected := service.volumes[baseFolder]
+	if !detected {
+		volume = detectVolume(baseFolder)
+		service.volumes[baseFolder] = volume
+	}
+	return volume
+}
+
+//*********************************************************
+
+func (volume *volumeInfo) tolerance() time.Duration {
+	tolerance := TIMESTAMP_TOLERANCE
+	if volume.network {
+		tolerance = NETWORK_TIMESTAMP_TOLERANCE
+	}
+	if volume.mtimeResolution > tolerance {
+		tolerance = volume.mtimeResolution
+	}
+	return tolerance
+}
+
+//*********************************************************
+
+// how far apart the local and remote modification times can be and still be considered the same
+func (service *Service) timestampTolerance(localPath string) time.Duration {
+	return service.volumeFor(localPath).tolerance()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// some network shares ignore the modification time we set and use the time of the write instead, then
+// the timestamps can't be trusted and every change is confirmed by comparing the md5's, which the planner
+// already does before anything is transferred
+func (service *Service) checkModTimeKept(localPath string, modTime time.Time) {
+	volume := service.volumeFor(localPath)
+	if volume.unreliableTimes {
+		return
+	}
+
+	fileInfo, err := service.fileSystem.Stat(localPath)
+	if err != nil {
+		return
+	}
+
+	diff := fileInfo.ModTime().Sub(modTime)
+	if diff > volume.tolerance() || diff < -volume.tolerance() {
+		volume.unreliableTimes = true
+		service.log.Warn("the filesystem of", localPath, "did not keep the modification time that was set,",
+			"changes in this folder will be confirmed by comparing md5's which takes longer")
+	}
+}
@@ -0,0 +1,33 @@
+package drivesync
+
+import (
+	"syscall"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func detectVolume(path string) *volumeInfo {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return &volumeInfo{fsType: "unknown"}
+	}
+
+	var name []byte
+	for _, c := range stat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+	fsType := string(name)
+
+	switch fsType {
+	case "smbfs", "nfs", "afpfs", "webdav", "osxfuse", "macfuse":
+		return &volumeInfo{fsType: fsType, network: true}
+	case "msdos":
+		return &volumeInfo{fsType: fsType, mtimeResolution: 2 * time.Second, maxFileSize: FAT_MAX_FILE_SIZE}
+	}
+	return &volumeInfo{fsType: fsType}
+}
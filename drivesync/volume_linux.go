@@ -0,0 +1,52 @@
+package drivesync
+
+import (
+	"syscall"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// the f_type values from statfs, see man 2 statfs
+const (
+	NFS_SUPER_MAGIC   = 0x6969
+	SMB_SUPER_MAGIC   = 0x517B
+	CIFS_SUPER_MAGIC  = 0xFF534D42
+	SMB2_SUPER_MAGIC  = 0xFE534D42
+	FUSE_SUPER_MAGIC  = 0x65735546
+	V9FS_MAGIC        = 0x01021997
+	CEPH_SUPER_MAGIC  = 0x00C36400
+	MSDOS_SUPER_MAGIC = 0x4D44
+	EXFAT_SUPER_MAGIC = 0x2011BAB0
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func detectVolume(path string) *volumeInfo {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return &volumeInfo{fsType: "unknown"}
+	}
+
+	// Type is 32 bits on some architectures and 64 bits on others
+	switch uint32(stat.Type) {
+	case NFS_SUPER_MAGIC:
+		return &volumeInfo{fsType: "nfs", network: true}
+	case SMB_SUPER_MAGIC, CIFS_SUPER_MAGIC, SMB2_SUPER_MAGIC:
+		return &volumeInfo{fsType: "smb", network: true}
+	case FUSE_SUPER_MAGIC:
+		// sshfs, rclone and the like, they don't always keep the times either
+		return &volumeInfo{fsType: "fuse", network: true}
+	case V9FS_MAGIC:
+		return &volumeInfo{fsType: "9p", network: true}
+	case CEPH_SUPER_MAGIC:
+		return &volumeInfo{fsType: "ceph", network: true}
+	case MSDOS_SUPER_MAGIC:
+		return &volumeInfo{fsType: "fat", mtimeResolution: 2 * time.Second, maxFileSize: FAT_MAX_FILE_SIZE}
+	case EXFAT_SUPER_MAGIC:
+		return &volumeInfo{fsType: "exfat"}
+	}
+	return &volumeInfo{fsType: "local"}
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package drivesync
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// there's no way to tell yet, so assume a local filesystem
+func detectVolume(path string) *volumeInfo {
+	return &volumeInfo{fsType: "unknown"}
+}
@@ -0,0 +1,48 @@
+package drivesync
+
+import (
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const DRIVE_REMOTE = 4
+
+var procGetDriveType = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDriveTypeW")
+var procGetVolumeInformation = syscall.NewLazyDLL("kernel32.dll").NewProc("GetVolumeInformationW")
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func detectVolume(path string) *volumeInfo {
+	absolutePath, err := filepath.Abs(path)
+	if err != nil {
+		return &volumeInfo{fsType: "unknown"}
+	}
+	root, err := syscall.UTF16PtrFromString(filepath.VolumeName(absolutePath) + `\`)
+	if err != nil {
+		return &volumeInfo{fsType: "unknown"}
+	}
+
+	fsNameBuffer := make([]uint16, 64)
+	ret, _, _ := procGetVolumeInformation.Call(uintptr(unsafe.Pointer(root)), 0, 0, 0, 0, 0,
+		uintptr(unsafe.Pointer(&fsNameBuffer[0])), uintptr(len(fsNameBuffer)))
+	fsType := "unknown"
+	if ret != 0 {
+		fsType = strings.ToLower(syscall.UTF16ToString(fsNameBuffer))
+	}
+
+	driveType, _, _ := procGetDriveType.Call(uintptr(unsafe.Pointer(root)))
+	if driveType == DRIVE_REMOTE {
+		return &volumeInfo{fsType: fsType, network: true}
+	}
+	if fsType == "fat" || fsType == "fat32" {
+		return &volumeInfo{fsType: fsType, mtimeResolution: 2 * time.Second, maxFileSize: FAT_MAX_FILE_SIZE}
+	}
+	return &volumeInfo{fsType: fsType}
+}
@@ -0,0 +1,318 @@
+package drivesync
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// how the directories of a base folder are watched for changes
+type WatchMode string
+
+const (
+	WATCH_ALL WatchMode = "all" // watch every directory, the default
+	WATCH_HOT WatchMode = "hot" // only watch the directories that changed recently, the rest are walked every 300 seconds
+	WATCH_OFF WatchMode = "off" // only poll
+)
+
+// a directory that changed within this long is hot, the hot directories are watched first
+const HOT_DIRECTORY_AGE = 7 * 24 * time.Hour
+
+// a directory that could be watched, with how recently it changed so the hottest ones are watched first
+type watchCandidate struct {
+	path       string
+	modifiedAt time.Time
+}
+
+// the local changes reported by the watcher since the last loop
+type localWatch struct {
+	watcher      *fsnotify.Watcher
+	localChanges chan struct{} // wakes up the sync loop
+	watchedDirs  int64         // read by the metrics server
+
+	mutex        sync.Mutex
+	changedPaths map[string]bool
+	complete     bool // every directory of every base folder is watched, so the changed paths are all that changed
+	fullWalk     bool // the next loop has to walk everything, after starting or when events were lost
+	limit        int
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// parses the value of a watch setting, for example "Documents=all"
+func parseWatchSetting(value string) (string, WatchMode, error) {
+	splitAt := strings.LastIndex(value, "=")
+	if splitAt <= 0 {
+		return "", "", fmt.Errorf("expected folder=all|hot|off")
+	}
+
+	folder := configNameToLocalPath(strings.TrimSpace(value[:splitAt]))
+	mode := WatchMode(strings.TrimSpace(value[splitAt+1:]))
+	switch mode {
+	case WATCH_ALL, WATCH_HOT, WATCH_OFF:
+		return folder, mode, nil
+	}
+	return "", "", fmt.Errorf("unknown watch mode %v, expected all, hot or off", mode)
+}
+
+//*********************************************************
+
+func (service *Service) watchMode(baseFolder string) WatchMode {
+	if mode, found := service.settings.WatchModes[baseFolder]; found {
+		return mode
+	}
+	return WATCH_ALL
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// (re)starts watching the local directories so a change wakes up the sync loop within seconds, called when the
+// sync starts and at every full reconciliation, and the next loop walks everything once
+func (service *Service) startWatching() {
+	service.stopWatching()
+
+	local := &service.localWatch
+	local.mutex.Lock()
+	local.changedPaths = make(map[string]bool)
+	local.complete = false
+	local.fullWalk = true
+	local.mutex.Unlock()
+
+	var candidates []watchCandidate
+	complete := true
+	for _, folder := range service.getBaseFolderSlice() {
+		folderCandidates, allIncluded := service.watchCandidates(folder)
+		candidates = append(candidates, folderCandidates...)
+		complete = complete && allIncluded
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		service.log.Warn("not watching the local folders, they are walked every 300 seconds instead:", err)
+		return
+	}
+	local.watcher = watcher
+	go service.readWatchEvents(watcher)
+
+	// the most recently changed directories are the most likely to change again
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].modifiedAt.After(candidates[j].modifiedAt)
+	})
+
+	local.limit = service.settings.MaxWatches
+	if local.limit == 0 {
+		local.limit = defaultWatchLimit()
+	}
+
+	var watched int64
+	for _, candidate := range candidates {
+		if local.limit > 0 && watched >= int64(local.limit) {
+			service.log.Info("watching the", watched, "most recently changed directories, the other", int64(len(candidates))-watched,
+				"are walked every 300 seconds, raise max_watches to watch more")
+			complete = false
+			break
+		}
+
+		err := watcher.Add(candidate.path)
+		if isWatchLimitError(err) {
+			service.log.Warn("ran out of watches after", watched, "directories, the rest are walked every 300 seconds.", watchLimitGuidance())
+			complete = false
+			break
+		}
+		if err != nil {
+			service.log.Debug("could not watch", candidate.path, err)
+			complete = false
+			continue
+		}
+		watched++
+	}
+
+	atomic.StoreInt64(&local.watchedDirs, watched)
+	local.mutex.Lock()
+	local.complete = complete
+	local.mutex.Unlock()
+
+	service.log.Debug("watching", watched, "local directories, everything is watched:", complete)
+}
+
+//*********************************************************
+
+func (service *Service) stopWatching() {
+	local := &service.localWatch
+	if local.watcher != nil {
+		local.watcher.Close()
+		local.watcher = nil
+	}
+	atomic.StoreInt64(&local.watchedDirs, 0)
+}
+
+//*********************************************************
+
+// returns the directories in the base folder that should be watched according to its watch mode, and
+// false if some of its directories were left out
+func (service *Service) watchCandidates(baseFolder string) ([]watchCandidate, bool) {
+	mode := service.watchMode(baseFolder)
+	if mode == WATCH_OFF {
+		return nil, false
+	}
+
+	hotSince := service.clock.Now().Add(-HOT_DIRECTORY_AGE)
+	var candidates []watchCandidate
+	allIncluded := true
+
+	service.fileSystem.Walk(baseFolder, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil || !fileInfo.IsDir() {
+			return nil
+		}
+		if service.isIgnoredPath(path, true) {
+			return filepath.SkipDir // no need to spend watches on folders that aren't synced
+		}
+
+		if mode == WATCH_ALL || path == filepath.Clean(baseFolder) || fileInfo.ModTime().After(hotSince) {
+			candidates = append(candidates, watchCandidate{path, fileInfo.ModTime()})
+		} else {
+			allIncluded = false
+		}
+		return nil
+	})
+
+	// the base folder is always watched first so new items at the top level are noticed right away
+	for i := range candidates {
+		if candidates[i].path == filepath.Clean(baseFolder) {
+			candidates[i].modifiedAt = service.clock.Now()
+		}
+	}
+
+	return candidates, allIncluded
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) readWatchEvents(watcher *fsnotify.Watcher) {
+	local := &service.localWatch
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return // closed
+			}
+			if isIgnoredFile(filepath.Base(event.Name)) || service.isIgnoredPath(event.Name, false) {
+				continue
+			}
+
+			// a new directory needs its own watch, and whatever was moved into it is picked up when it's walked
+			if event.Op&fsnotify.Create != 0 {
+				if fileInfo, err := os.Stat(event.Name); err == nil && fileInfo.IsDir() && !service.isIgnoredPath(event.Name, true) {
+					service.watchNewDirectory(watcher, event.Name)
+				}
+			}
+
+			local.mutex.Lock()
+			local.changedPaths[event.Name] = true
+			local.mutex.Unlock()
+			service.wakeForLocalChange()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return // closed
+			}
+			// events were lost, so the next loop can't rely on the changed paths
+			service.log.Warn("the watcher lost track of the local changes, walking everything on the next loop:", err)
+			local.mutex.Lock()
+			local.fullWalk = true
+			local.mutex.Unlock()
+			service.wakeForLocalChange()
+		}
+	}
+}
+
+//*********************************************************
+
+func (service *Service) watchNewDirectory(watcher *fsnotify.Watcher, dir string) {
+	local := &service.localWatch
+
+	if local.limit > 0 && atomic.LoadInt64(&local.watchedDirs) >= int64(local.limit) {
+		local.mutex.Lock()
+		local.complete = false
+		local.mutex.Unlock()
+		return
+	}
+
+	err := watcher.Add(dir)
+	if err != nil {
+		if isWatchLimitError(err) {
+			service.log.Warn("ran out of watches, new directories are walked every 300 seconds.", watchLimitGuidance())
+		}
+		local.mutex.Lock()
+		local.complete = false
+		local.mutex.Unlock()
+		return
+	}
+	atomic.AddInt64(&local.watchedDirs, 1)
+}
+
+//*********************************************************
+
+// wakes up the sync loop unless it's already been woken up
+func (service *Service) wakeForLocalChange() {
+	select {
+	case service.localWatch.localChanges <- struct{}{}:
+	default:
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// returns the local paths that changed since the last call and true, or false if the base folders have
+// to be walked because not everything is watched or some events were lost
+func (service *Service) takeChangedPaths() ([]string, bool) {
+	local := &service.localWatch
+	local.mutex.Lock()
+	defer local.mutex.Unlock()
+
+	changedPaths := local.changedPaths
+	local.changedPaths = make(map[string]bool)
+
+	if !local.complete || local.fullWalk || local.watcher == nil {
+		local.fullWalk = false
+		return nil, false
+	}
+
+	// the files that were held back last time have to be looked at again even if they didn't change
+	for path := range service.heldFiles {
+		changedPaths[path] = true
+	}
+
+	// a path that is gone can't be walked, and it could be hidden by a folder above it
+	for path := range changedPaths {
+		service.localPathMissing(path)
+	}
+
+	// only keep the top most paths since walking a directory covers everything inside it
+	var paths []string
+	for _, path := range sortedPaths(changedPaths) {
+		if len(paths) > 0 && localPathIsInside(paths[len(paths)-1], path) {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths, true
+}
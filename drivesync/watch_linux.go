@@ -0,0 +1,44 @@
+package drivesync
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const INOTIFY_WATCHES_FILE_NAME = "/proc/sys/fs/inotify/max_user_watches"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// uses up to half of the system wide limit so other programs can still watch their files
+func defaultWatchLimit() int {
+	data, err := os.ReadFile(INOTIFY_WATCHES_FILE_NAME)
+	if err != nil {
+		return 0
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return limit / 2
+}
+
+//*********************************************************
+
+func isWatchLimitError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
+
+//*********************************************************
+
+func watchLimitGuidance() string {
+	return "To watch more, raise the inotify limit with: sudo sysctl fs.inotify.max_user_watches=524288 " +
+		"(add fs.inotify.max_user_watches=524288 to /etc/sysctl.conf to keep it after a reboot), " +
+		"or set watch=<folder>=off for the big folders that rarely change"
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+// +build !linux
+
+package drivesync
+
+import (
+	"errors"
+	"syscall"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// there's no system wide limit to stay under, only the open file limit on macOS and the BSDs
+func defaultWatchLimit() int {
+	return 0
+}
+
+//*********************************************************
+
+// kqueue needs an open file for every watched file
+func isWatchLimitError(err error) bool {
+	return errors.Is(err, syscall.EMFILE)
+}
+
+//*********************************************************
+
+func watchLimitGuidance() string {
+	return "To watch more, raise the open file limit with ulimit -n, or set watch=<folder>=off for the big folders that rarely change"
+}
@@ -0,0 +1,91 @@
+package drivesync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// A cycle that runs longer than max_cycle_minutes is taken to be stuck, on a request that never returns or on a
+// lock. The stacks of all the goroutines are logged and saved to config/watchdog.log, which the support bundle
+// includes, and the cycle's context is cancelled so its requests return. If it finishes within a minute after
+// that, a fresh cycle is started right away. If it doesn't, it can't be cancelled and another cycle can't safely
+// run next to it, so Run returns ErrStuck and the service manager (or the launchd agent) starts the program again.
+
+const WATCHDOG_FILE_NAME = "config/watchdog.log"
+
+// how long a cancelled cycle gets to finish
+const WATCHDOG_GRACE = time.Minute
+
+var ErrStuck = errors.New("the sync cycle is stuck and could not be cancelled")
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// runs one cycle, the stuck return value is true if the watchdog had to cancel it
+func (service *Service) watchedSyncCycle(ctx context.Context, verified bool, scanLocal bool, checkRemote bool) (bool, bool, error) {
+	if service.settings.MaxCycleDuration == 0 {
+		verified, err := service.syncCycle(ctx, verified, scanLocal, checkRemote)
+		return verified, false, err
+	}
+
+	cycleCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		verified bool
+		err      error
+	}
+	done := make(chan result, 1)
+	go func() {
+		verified, err := service.syncCycle(cycleCtx, verified, scanLocal, checkRemote)
+		done <- result{verified, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.verified, false, r.err
+	case <-service.clock.After(service.settings.MaxCycleDuration):
+	}
+
+	atomic.AddInt64(&service.stuckCycles, 1)
+	service.dumpStacks()
+	cancel()
+
+	select {
+	case r := <-done:
+		message := fmt.Sprintf("a sync cycle ran longer than %v and was cancelled, starting a new one", service.settings.MaxCycleDuration)
+		service.log.Error(message)
+		service.alert("Sync was stuck", message)
+		return r.verified, true, r.err
+	case <-service.clock.After(WATCHDOG_GRACE):
+	}
+
+	service.log.Error(ErrStuck)
+	service.alert("Sync is stuck", fmt.Sprintf("a sync cycle ran longer than %v and could not be cancelled, restart the sync, "+
+		"the stacks are in %v", service.settings.MaxCycleDuration, service.configFile(WATCHDOG_FILE_NAME)))
+	return false, true, ErrStuck
+}
+
+//*********************************************************
+
+// logs the stacks of all the goroutines and saves them for a bug report
+func (service *Service) dumpStacks() {
+	buffer := make([]byte, 1024*1024)
+	n := runtime.Stack(buffer, true)
+	stacks := fmt.Sprintf("%v the sync cycle ran longer than %v\n\n%s\n", service.clock.Now().Format(time.RFC3339),
+		service.settings.MaxCycleDuration, buffer[:n])
+	service.log.Error(stacks)
+
+	err := os.WriteFile(service.configFile(WATCHDOG_FILE_NAME), []byte(stacks), 0600)
+	if err != nil {
+		service.log.Warn("failed to save the stacks:", err)
+	}
+}
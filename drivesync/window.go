@@ -0,0 +1,265 @@
+package drivesync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// Adopting a huge shared folder can take days to download. With initial_sync_days=90 the first sync only downloads
+// the files modified in the last 90 days, the cutoff is saved in config/sync-window.json so a restart keeps it.
+// The older files found by each full reconciliation are the backlog, which is downloaded newest first in batches of
+// backfill_batch files, one batch after each cycle, but only while nothing else was synced for a while and within
+// backfill_hours. The changes of the day still sync at full speed, a batch never holds up the cycle in front of it.
+// When the backlog is empty a full reconciliation looks for more, and the backfill is done once one finds nothing
+// older than the cutoff. The fetch command downloads the older files of one folder right away. Folders are always
+// created, and a file that's already on this computer is synced as usual whatever its age.
+
+const SYNC_WINDOW_FILE_NAME = "config/sync-window.json"
+
+// the backlog is looked for at most this often, so the full reconciliations don't keep the sync busy
+const BACKFILL_INTERVAL = time.Hour
+
+// the sync counts as idle when nothing was synced for this long
+const BACKFILL_IDLE_TIME = 10 * time.Minute
+
+type syncWindow struct {
+	Cutoff  *time.Time `json:"cutoff,omitempty"`  // the older files are not downloaded yet, nil once the backfill is done
+	Fetched []string   `json:"fetched,omitempty"` // the folders the fetch command was used on, downloaded whatever their age
+
+	modTime            time.Time               // of the file when it was last read or written, to notice the fetch command
+	skipped            int                     // the files left out by the last checkForDownloads
+	skippedAtReconcile int                     // the files left out by the last full reconciliation, -1 until there was one
+	backlog            map[string]FileMetaData // key = local path, the older files waiting to be backfilled
+	backfilledAt       time.Time               // when a reconciliation was last started to find the backlog
+}
+
+// the hours of the day, local time, From up to but not including To, it wraps around midnight, From == To is all day
+type HourRange struct {
+	From int
+	To   int
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// called when the sync starts, the window is only made the first time, after that the saved one is used even if
+// initial_sync_days changes, and once the backfill is done the file stays behind without a cutoff
+func (service *Service) startSyncWindow() {
+	fileName := service.configFile(SYNC_WINDOW_FILE_NAME)
+	_, err := os.Stat(fileName)
+	if os.IsNotExist(err) && service.settings.InitialSyncDays > 0 {
+		cutoff := service.clock.Now().AddDate(0, 0, -service.settings.InitialSyncDays)
+		service.window = syncWindow{Cutoff: &cutoff, skippedAtReconcile: -1}
+		service.saveSyncWindow()
+		service.log.Info("only downloading the files modified since", cutoff.Format("2006-01-02"), "the older ones are backfilled later")
+		return
+	}
+	service.loadSyncWindow()
+}
+
+//*********************************************************
+
+// true if the file is not downloaded yet because it's older than the cutoff, the caller checks that there's no
+// local copy
+func (service *Service) outsideSyncWindow(localPath string, remoteFileInfo FileMetaData) bool {
+	if service.window.Cutoff == nil || remoteFileInfo.MimeType == "application/vnd.google-apps.folder" {
+		return false
+	}
+	remoteModTime, err := time.Parse(time.RFC3339Nano, remoteFileInfo.ModifiedTime)
+	if err != nil || !remoteModTime.Before(*service.window.Cutoff) {
+		return false
+	}
+	for _, folder := range service.window.Fetched {
+		if localPathIsInside(folder, localPath) {
+			return false
+		}
+	}
+	return true
+}
+
+//*********************************************************
+
+// the value is from-to in hours, like 22-6 for the night
+func parseHourRange(value string) (HourRange, error) {
+	var hours HourRange
+	_, err := fmt.Sscanf(strings.TrimSpace(value), "%d-%d", &hours.From, &hours.To)
+	if err != nil || hours.From < 0 || hours.From > 24 || hours.To < 0 || hours.To > 24 {
+		return HourRange{}, fmt.Errorf("expected from-to in hours, like 22-6")
+	}
+	hours.From %= 24
+	hours.To %= 24
+	return hours, nil
+}
+
+//*********************************************************
+
+func (hours HourRange) contains(hour int) bool {
+	if hours.From == hours.To {
+		return true
+	}
+	if hours.From < hours.To {
+		return hour >= hours.From && hour < hours.To
+	}
+	return hour >= hours.From || hour < hours.To
+}
+
+//*********************************************************
+
+// called after each verified cycle, while the sync is idle downloads the next batch of the backlog, returns true
+// if a full reconciliation is needed to find more of it
+func (service *Service) backfill(ctx context.Context) bool {
+	if service.window.Cutoff == nil || !service.settings.Backfill {
+		return false
+	}
+	now := service.clock.Now()
+	if now.Sub(service.lastChangeAt) < BACKFILL_IDLE_TIME || !service.settings.BackfillHours.contains(now.Hour()) {
+		return false
+	}
+
+	// only a full reconciliation has seen all of the older files
+	if service.window.skippedAtReconcile == 0 {
+		service.log.Info("the backfill is done, every file older than", service.window.Cutoff.Format("2006-01-02"), "is downloaded")
+		service.window.Cutoff = nil
+		service.window.Fetched = nil
+		service.saveSyncWindow()
+		return false
+	}
+	if len(service.window.backlog) == 0 {
+		if now.Sub(service.window.backfilledAt) < BACKFILL_INTERVAL {
+			return false
+		}
+		service.window.backfilledAt = now
+		return true
+	}
+
+	service.downloadBackfillBatch(ctx)
+	return false
+}
+
+//*********************************************************
+
+// downloads the newest files of the backlog, a file that fails is dropped from it and found again by the next
+// reconciliation
+func (service *Service) downloadBackfillBatch(ctx context.Context) {
+	paths := sortedMetadataKeys(service.window.backlog)
+	sort.SliceStable(paths, func(i, j int) bool {
+		return service.window.backlog[paths[i]].ModifiedTime > service.window.backlog[paths[j]].ModifiedTime
+	})
+	if len(paths) > service.settings.BackfillBatch {
+		paths = paths[:service.settings.BackfillBatch]
+	}
+
+	var plan Plan
+	for _, localPath := range paths {
+		remoteFileInfo := service.window.backlog[localPath]
+		delete(service.window.backlog, localPath)
+		if _, err := service.fileSystem.Stat(localPath); err == nil {
+			continue // it showed up in the meantime
+		}
+		plan.add(Action{Type: ACTION_DOWNLOAD_NEW, LocalPath: localPath, Remote: remoteFileInfo, Reason: "backfilling an older file",
+			Bytes: remoteFileInfo.Size})
+	}
+	if len(plan.Actions) == 0 {
+		return
+	}
+
+	service.log.Info("backfilling", len(plan.Actions), "older files,", len(service.window.backlog), "left")
+	service.setActivity("backfilling")
+	service.executeDownloads(ctx, plan)
+
+	// the batch is a cycle of its own, it doesn't count as a change so the sync stays idle for the next batch
+	service.recordCycle()
+	service.notifyCycle()
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// downloads the older files of a folder or file at the next sync, instead of waiting for the backfill to reach them
+func (service *Service) FetchOlderFiles(ctx context.Context, localPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	localPath = filepath.Clean(localPath)
+	if _, _, found := service.splitLocalPath(localPath); !found {
+		return fmt.Errorf("%v is not inside one of the base folders", localPath)
+	}
+	service.loadSyncWindow()
+	if service.window.Cutoff == nil {
+		return fmt.Errorf("there are no older files left to fetch, every file is synced")
+	}
+	for _, folder := range service.window.Fetched {
+		if localPathIsInside(folder, localPath) {
+			return fmt.Errorf("%v was already fetched", localPath)
+		}
+	}
+	service.window.Fetched = append(service.window.Fetched, localPath)
+	service.saveSyncWindow()
+	return nil
+}
+
+//*********************************************************
+
+// reads config/sync-window.json again if the fetch command changed it, returns true if it did, since then the
+// remote folders have to be listed again to find the older files
+func (service *Service) syncWindowChanged() bool {
+	fileInfo, err := os.Stat(service.configFile(SYNC_WINDOW_FILE_NAME))
+	if err != nil || fileInfo.ModTime().Equal(service.window.modTime) {
+		return false
+	}
+	service.loadSyncWindow()
+	return true
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func (service *Service) loadSyncWindow() {
+	fileName := service.configFile(SYNC_WINDOW_FILE_NAME)
+	window := syncWindow{skippedAtReconcile: -1, backfilledAt: service.window.backfilledAt}
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		service.window = window // there's no window, everything is synced
+		return
+	}
+	window.modTime = fileInfo.ModTime()
+
+	data, err := os.ReadFile(fileName)
+	if err == nil {
+		err = json.Unmarshal(data, &window)
+	}
+	if err != nil {
+		service.log.Warn("ignoring", fileName, ":", err)
+		window = syncWindow{modTime: window.modTime, skippedAtReconcile: -1, backfilledAt: window.backfilledAt}
+	}
+	service.window = window
+}
+
+//*********************************************************
+
+func (service *Service) saveSyncWindow() {
+	data, err := json.MarshalIndent(service.window, "", "  ")
+	if err != nil {
+		service.log.Warn("failed to save the sync window:", err)
+		return
+	}
+
+	fileName := service.configFile(SYNC_WINDOW_FILE_NAME)
+	err = writeFileAtomically(fileName, data)
+	if err != nil {
+		service.log.Warn("failed to save the sync window:", err)
+		return
+	}
+	if fileInfo, err := os.Stat(fileName); err == nil {
+		service.window.modTime = fileInfo.ModTime() // so the running sync doesn't take its own change for the fetch command
+	}
+}
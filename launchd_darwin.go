@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"JusticeProject/Google-Drive-For-Desktop-Lite/drivesync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+const LAUNCHD_LABEL = "com.github.justiceproject.google-drive-for-desktop-lite"
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func launchdPlistPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", LAUNCHD_LABEL+".plist"), nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// sets up a launchd agent that starts at login and is restarted if it exits, the output goes to the
+// unified log so it can be read with: log stream --predicate 'process == "logger"' --info
+func installService(service *drivesync.Service, addToFinderSidebar bool) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	executable, err = filepath.EvalSymlinks(executable)
+	if err != nil {
+		return err
+	}
+
+	// the config folder is found relative to the working directory
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	plistPath, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(plistPath), 0755)
+	if err != nil {
+		return err
+	}
+
+	// logger sends everything to the unified log
+	command := fmt.Sprintf("exec %v 2>&1 | /usr/bin/logger -t %v", shellQuote(executable), drivesync.APP_NAME)
+
+	plist := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>` + LAUNCHD_LABEL + `</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/bin/sh</string>
+		<string>-c</string>
+		<string>` + xmlEscape(command) + `</string>
+	</array>
+	<key>WorkingDirectory</key>
+	<string>` + xmlEscape(workingDir) + `</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ProcessType</key>
+	<string>Background</string>
+</dict>
+</plist>
+`
+	err = os.WriteFile(plistPath, []byte(plist), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Println("wrote", plistPath)
+
+	// unload first in case an older version of the agent is already running
+	exec.Command("launchctl", "unload", plistPath).Run()
+	output, err := exec.Command("launchctl", "load", "-w", plistPath).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl load failed: %v %v", err, string(output))
+	}
+	fmt.Println("the launchd agent is running, it will start automatically at login")
+
+	if addToFinderSidebar {
+		for _, folder := range service.BaseFolderPaths() {
+			folderPath := filepath.Join(workingDir, folder)
+			folderUrl := url.URL{Scheme: "file", Path: folderPath}
+			output, err := exec.Command("sfltool", "add-item", "com.apple.LSSharedFileList.FavoriteItems", folderUrl.String()).CombinedOutput()
+			if err != nil {
+				// sfltool is missing this command on some versions of macOS, so this is not fatal
+				fmt.Println("could not add", folderPath, "to the Finder sidebar, drag it there manually:", err, string(output))
+			} else {
+				fmt.Println("added", folderPath, "to the Finder sidebar")
+			}
+		}
+	}
+
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func uninstallService() error {
+	plistPath, err := launchdPlistPath()
+	if err != nil {
+		return err
+	}
+
+	exec.Command("launchctl", "unload", "-w", plistPath).Run()
+	err = os.Remove(plistPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Println("removed the launchd agent")
+	return nil
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+//*********************************************************
+
+func xmlEscape(value string) string {
+	replacer := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+	return replacer.Replace(value)
+}
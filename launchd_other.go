@@ -0,0 +1,23 @@
+//go:build !darwin
+// +build !darwin
+
+package main
+
+import (
+	"errors"
+
+	"JusticeProject/Google-Drive-For-Desktop-Lite/drivesync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+func installService(service *drivesync.Service, addToFinderSidebar bool) error {
+	return errors.New("service install is only supported on macOS")
+}
+
+//*********************************************************
+
+func uninstallService() error {
+	return errors.New("service uninstall is only supported on macOS")
+}
@@ -2,23 +2,31 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
 	"time"
+
+	"JusticeProject/Google-Drive-For-Desktop-Lite/drivesync"
 )
 
 //*************************************************************************************************
 //*************************************************************************************************
 
-var debug bool = false
+// can be set at build time with: go build -ldflags="-X main.appVersion=1.2.3"
+var appVersion = "dev"
 
 //*************************************************************************************************
 //*************************************************************************************************
 
-func removeDeletedFiles(service *GoogleDriveService, promptUser bool) {
+func removeDeletedFiles(ctx context.Context, service *drivesync.Service, promptUser bool) {
 	if promptUser {
-		fmt.Println("\nAre you sure you want to delete files belonging to the service account?")
-		fmt.Println("This only deletes files that are no longer in the user's shared folder.")
+		fmt.Println("\nAre you sure you want to remove files belonging to the service account?")
+		fmt.Println("This only removes files that are no longer in the user's shared folder, they are moved to the trash unless cleanup_mode=delete.")
 		fmt.Println("Type Y then hit Enter to proceed.")
 
 		scanner := bufio.NewScanner(os.Stdin)
@@ -33,216 +41,608 @@ func removeDeletedFiles(service *GoogleDriveService, promptUser bool) {
 		}
 	}
 
-	if debug {
-		fmt.Println("Proceeding to remove deleted files...")
-	}
-
-	// if there are any errors when filling the lookup map, then don't proceed!!
-	localToRemoteLookup := make(map[string]FileMetaData) // key=local file name
-	err := service.fillLookupMap(localToRemoteLookup, service.getBaseFolderSlice())
+	err := service.RemoveDeletedFiles(ctx)
 	if err != nil {
 		fmt.Println(err)
-		fmt.Println("failed to fillLookupMap, not removing the deleted files")
-		return
 	}
+}
 
-	allServiceAcctFiles, err := service.conn.getFilesOwnedByServiceAcct(false)
+//*************************************************************************************************
+//*************************************************************************************************
+
+// prints the Drive url of a synced file, optionally opening it in the default browser
+func openRemoteLink(ctx context.Context, service *drivesync.Service, localPath string, openBrowser bool) error {
+	remoteItem, err := service.FindRemoteItem(ctx, localPath)
 	if err != nil {
-		fmt.Println("failed to getFilesOwnedByServiceAcct, not removing the deleted files")
-		return
+		return err
+	}
+	if remoteItem.WebViewLink == "" {
+		return fmt.Errorf("no link available for %v", localPath)
 	}
-	for _, serviceFile := range allServiceAcctFiles {
-		needToDelete := true
-
-		// check if it's in one of the user's folders
-		for _, remoteMetaData := range localToRemoteLookup {
-			if len(serviceFile.Parents) == 0 || serviceFile.Parents[0] == remoteMetaData.ID {
-				needToDelete = false
-				break
-			}
-		}
 
-		if needToDelete {
-			err := service.conn.deleteFileOrFolder(serviceFile)
-			if err != nil {
-				fmt.Println(err)
-			}
-		}
+	fmt.Println(remoteItem.WebViewLink)
+	if !openBrowser {
+		return nil
 	}
+
+	return drivesync.OpenInBrowser(remoteItem.WebViewLink)
 }
 
 //*************************************************************************************************
 //*************************************************************************************************
 
-func main() {
-	var service GoogleDriveService
-	service.initializeService()
-
-	// check if we need to print debug statements
-	if len(os.Args) > 1 {
-		arg := os.Args[1]
-
-		switch arg {
-		case "debug":
-			debug = true
-		case "list":
-			if len(os.Args) > 2 {
-				debug = true
-				resp, err := service.conn.getItemsInSharedFolder("?", os.Args[2])
-				fmt.Println("err", err)
-				for _, file := range resp.Files {
-					fmt.Println(file)
-				}
-			} else {
-				service.conn.getFilesOwnedByServiceAcct(true)
-			}
-			os.Exit(0)
-		case "delete":
-			debug = true
-			removeDeletedFiles(&service, true)
-			os.Exit(0)
-		default:
-			fmt.Println("unknown arg", arg)
-			os.Exit(1)
+// lists the local copies waiting to be removed, or cancels one or all of them
+func handleDeletions(ctx context.Context, service *drivesync.Service, args []string) error {
+	if len(args) == 0 {
+		pending := service.PendingDeletions()
+		if len(pending) == 0 {
+			fmt.Println("no deletions are pending")
 		}
+		for _, deletion := range pending {
+			fmt.Println(deletion.DeleteAt.Local().Format(time.RFC1123), deletion.Target())
+		}
+		return nil
 	}
 
-	service.fillLocalMap()
-
-	var verified bool = false
-	const SLEEP_SECONDS time.Duration = 300
-	firstPass := true
+	if args[0] != "cancel" || len(args) != 2 {
+		return fmt.Errorf("usage: deletions [cancel <path>|<id>|all]")
+	}
 
-	for {
-		if !firstPass {
-			time.Sleep(SLEEP_SECONDS * time.Second)
+	if args[1] != "all" {
+		return service.CancelDeletion(ctx, args[1])
+	}
+	for _, deletion := range service.PendingDeletions() {
+		target := deletion.LocalPath
+		if deletion.OnGoogleDrive {
+			target = deletion.Remote.ID
 		}
-		firstPass = false
-
-		if !verified {
-			service.resetVerifiedTime()
+		err := service.CancelDeletion(ctx, target)
+		if err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		//***********************************************************
+//*************************************************************************************************
+//*************************************************************************************************
 
-		// upload section
+// one subcommand, setup adds the command's own flags and returns the function that runs it once the flags
+// are parsed, the args it gets are the ones left after the flags
+type command struct {
+	name    string
+	args    string // the args after the flags, for the usage
+	summary string
+	setup   func(flags *flag.FlagSet) func(ctx context.Context, args []string) error
+}
 
-		// check if we need to upload anything
-		if debug {
-			fmt.Println("Checking for any new or modified local files/folders")
-		}
-		localModified := service.localFilesModified()
+// returned by a command when it was given the wrong args, its usage is printed
+var errUsage = errors.New("wrong arguments")
 
-		// do the upload
-		if localModified {
-			if debug {
-				fmt.Println("Preparing to upload files")
-			}
-			service.clearUploadLookupMap()
-			err := service.fillUploadLookupMap(service.getBaseFolderSlice())
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-			err = service.handleUploads()
-			if err != nil {
-				// if we only uploaded half a file then we don't want to download that half-written file,
-				// so we will try again from the beginning of the loop
-				fmt.Println(err)
-				continue
+//*********************************************************
+
+func fullRescanFlag(flags *flag.FlagSet) *bool {
+	return flags.Bool("full-rescan", false, "ignore the saved state and check every local and remote file again")
+}
+
+//*********************************************************
+
+// the flags that override local_scan_seconds, remote_check_seconds and fast_poll_seconds, returns the function
+// that applies them to the service
+func intervalFlags(flags *flag.FlagSet) func(service *drivesync.Service) {
+	interval := flags.Duration("interval", 0, "how often to check both the local folders and Google Drive for changes, like 90s or 10m")
+	local := flags.Duration("local-interval", 0, "how often to check the local folders for changes")
+	remote := flags.Duration("remote-interval", 0, "how often to check Google Drive for changes")
+	fastPoll := flags.Duration("fast-poll", 0, "how often to check both right after something was synced, 0 turns it off")
+	return func(service *drivesync.Service) {
+		service.SetSyncInterval(*interval, *interval)
+		service.SetSyncInterval(*local, *remote)
+		flags.Visit(func(set *flag.Flag) {
+			if set.Name == "fast-poll" {
+				service.SetFastPollInterval(*fastPoll)
 			}
-		}
+		})
+	}
+}
 
-		//***********************************************************
+//*************************************************************************************************
+//*************************************************************************************************
 
-		// download section
+// every command, in the order they are listed by help
+func commands() []command {
+	return []command{
+		{"sync", "", "sync the base folders until stopped, this is what runs without a command",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				fullRescan := fullRescanFlag(flags)
+				applyIntervals := intervalFlags(flags)
+				dryRun := flags.Bool("dry-run", false, "print what one sync would do without changing anything, then exit")
+				once := flags.Bool("once", false, "sync one time and exit, the exit status is 1 if anything could not be synced")
+				tui := flags.Bool("tui", false, "show the progress of each transfer, the speed and the queue in the terminal, like the monitor command")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					service := drivesync.NewService()
+					if *dryRun {
+						return service.DryRun(ctx, *fullRescan)
+					}
+					if *once {
+						return service.RunOnce(ctx, *fullRescan)
+					}
+					applyIntervals(service)
+					service.StartMetricsServer(ctx)
+					var err error
+					if *tui {
+						err = runMonitor(ctx, service, *fullRescan)
+					} else {
+						err = service.Run(ctx, *fullRescan)
+					}
+					return stopped(err)
+				}
+			}},
+		{"once", "", "the same as sync --once, sync the base folders one time and exit",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				fullRescan := fullRescanFlag(flags)
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					return drivesync.NewService().RunOnce(ctx, *fullRescan)
+				}
+			}},
+		{"monitor", "", "the same as sync --tui, sync while showing the queues, transfers and errors in the terminal",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				fullRescan := fullRescanFlag(flags)
+				applyIntervals := intervalFlags(flags)
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					service := drivesync.NewService()
+					applyIntervals(service)
+					return stopped(runMonitor(ctx, service, *fullRescan))
+				}
+			}},
+		{"fleet", "", "sync the folders of every user in config/tenants.txt",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				fullRescan := fullRescanFlag(flags)
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					// a fleet doesn't have folders of its own, each tenant has its own config folder
+					fleet, err := drivesync.NewFleet()
+					if err != nil {
+						return err
+					}
+					fleet.StartMetricsServer(ctx)
+					return stopped(fleet.Run(ctx, *fullRescan))
+				}
+			}},
+		{"status", "[path...]", "print what a running sync has not synced yet, or the status of each path",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					return drivesync.NewService().PrintStatus(os.Stdout, args)
+				}
+			}},
+		{"verify", "", "compare every file with Google Drive without changing anything, fails if any are different",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					different, err := drivesync.NewService().Verify(ctx)
+					if err != nil {
+						return err
+					}
+					for _, file := range different {
+						fmt.Println(file.Status, file.Path)
+					}
+					if len(different) > 0 {
+						return fmt.Errorf("%d files are not the same on both sides", len(different))
+					}
+					fmt.Println("every file is the same on both sides")
+					return nil
+				}
+			}},
+		{"state", "rebuild", "move the saved state aside and build it again with a full reconciliation",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 || args[0] != "rebuild" {
+						return errUsage
+					}
+					return drivesync.NewService().RebuildState(ctx)
+				}
+			}},
+		{"list", "[folder id or path]", "list the items in a folder on Google Drive, or every file owned by the service account",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				recursive := flags.Bool("recursive", false, "also list everything in the subfolders")
+				format := flags.String("format", "text", "text, csv or json")
+				return func(ctx context.Context, args []string) error {
+					if len(args) > 1 {
+						return errUsage
+					}
+					service := drivesync.NewService()
+					if len(args) == 0 {
+						service.Connection().GetFilesOwnedByServiceAcct(ctx, true)
+						return nil
+					}
+					items, err := service.ListFolder(ctx, args[0], *recursive)
+					if err != nil {
+						return err
+					}
+					return drivesync.WriteListing(os.Stdout, items, *format)
+				}
+			}},
+		{"delete", "", "trash the files of the service account that are no longer in the user's folders",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				yes := flags.Bool("yes", false, "don't ask before deleting")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					drivesync.SetDebug(true)
+					removeDeletedFiles(ctx, drivesync.NewService(), !*yes)
+					return nil
+				}
+			}},
+		{"login", "", "sign in to Google Drive as the user, for auth=user",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 {
+						return errUsage
+					}
+					return drivesync.Login(ctx)
+				}
+			}},
+		{"empty-trash", "", "delete for good the files of the service account that have been in the trash for a while",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				days := flags.Int("days", 30, "only delete the items that have been in the trash for this many days")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 0 || *days < 0 {
+						return errUsage
+					}
+					return drivesync.NewService().EmptyTrash(ctx, time.Duration(*days)*24*time.Hour)
+				}
+			}},
+		{"restore", "[<path or id>...]", "list the files of the service account in the trash, or take some of them out of it",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					service := drivesync.NewService()
+					if len(args) > 0 {
+						return service.RestoreFromTrash(ctx, args)
+					}
+					items, err := service.TrashedItems(ctx)
+					if err != nil {
+						return err
+					}
+					if len(items) == 0 {
+						fmt.Println("nothing is in the trash")
+					}
+					for _, item := range items {
+						where := item.LocalPath
+						if where == "" {
+							where = item.Remote.Name + " (not in a synced folder)"
+						}
+						fmt.Println(item.Remote.ID, where)
+					}
+					return nil
+				}
+			}},
+		{"folders", "", "pick the Shared Drives and folders to sync",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					pickFolders(ctx, drivesync.NewService())
+					return nil
+				}
+			}},
+		{"open", "<path>", "print the Google Drive url of a synced file",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				browser := flags.Bool("browser", false, "also open the url in the default browser")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					return openRemoteLink(ctx, drivesync.NewService(), args[0], *browser)
+				}
+			}},
+		{"stat", "<path>", "compare a file with its copy on Google Drive and print what the sync knows about it",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					return drivesync.NewService().PrintStat(ctx, os.Stdout, args[0])
+				}
+			}},
+		{"restore-permissions", "<path>", "put back the recorded sharing settings of a file",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					added, err := drivesync.NewService().RestorePermissions(ctx, args[0])
+					fmt.Println("added", added, "permissions to", args[0])
+					return err
+				}
+			}},
+		{"deletions", "[cancel <path>|<id>|all]", "list the local copies and orphans waiting to be removed, or keep one or all of them",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					return handleDeletions(ctx, drivesync.NewService(), args)
+				}
+			}},
+		{"remote-sync", "<folder id> <folder id>", "keep two folders on Google Drive the same",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 2 {
+						return errUsage
+					}
+					return stopped(drivesync.NewService().NewRemotePair(args[0], args[1]).Run(ctx))
+				}
+			}},
+		{"mirror", "<folder> <mirror folder>", "keep a copy of a folder on another disk",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				interval := flags.Duration("interval", 24*time.Hour, "how long to wait between the mirrors")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 2 {
+						return errUsage
+					}
+					if *interval <= 0 {
+						return fmt.Errorf("invalid interval %v", *interval)
+					}
+					return stopped(drivesync.NewService().NewLocalMirror(args[0], args[1], *interval).Run(ctx))
+				}
+			}},
+		{"unsync", "<folder>", "stop syncing a folder inside a base folder",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					return drivesync.NewService().SetFolderSynced(ctx, args[0], false)
+				}
+			}},
+		{"resync", "<folder>", "sync a folder again after unsync",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					return drivesync.NewService().SetFolderSynced(ctx, args[0], true)
+				}
+			}},
+		{"fetch", "<folder>", "download the files older than initial_sync_days in a folder at the next sync",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					return drivesync.NewService().FetchOlderFiles(ctx, args[0])
+				}
+			}},
+		{"unsynced", "", "list the folders that are not synced",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					folders, err := drivesync.NewService().NotSyncedFolders(ctx)
+					for _, folder := range folders {
+						fmt.Println(folder)
+					}
+					return err
+				}
+			}},
+		{"search", "<text>", "find the files in the synced folders whose contents match the text",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				download := flags.Bool("download", false, "also download the matches that are not on this computer yet")
+				return func(ctx context.Context, args []string) error {
+					if len(args) == 0 {
+						return errUsage
+					}
+					service := drivesync.NewService()
+					results, err := service.Search(ctx, strings.Join(args, " "))
+					if err != nil {
+						return err
+					}
+					for _, result := range results {
+						if result.Local {
+							fmt.Println(result.Path)
+						} else {
+							fmt.Println(result.Path, "(not downloaded)", result.WebViewLink)
+						}
+					}
+					fmt.Println(len(results), "matches")
+					if !*download {
+						return nil
+					}
+					count, err := service.DownloadSearchResults(ctx, results)
+					fmt.Println("downloaded", count, "files")
+					return err
+				}
+			}},
+		{"export", "[file]", "export the checksums and Google Drive ids of the synced files",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				format := flags.String("format", "csv", "csv or json")
+				return func(ctx context.Context, args []string) error {
+					if len(args) > 1 {
+						return errUsage
+					}
+					fileName := "checksums." + *format
+					if len(args) > 0 {
+						fileName = args[0]
+					}
+					count, err := drivesync.NewService().ExportChecksums(ctx, fileName, *format)
+					if err != nil {
+						return err
+					}
+					fmt.Println("exported", count, "files to", fileName)
+					return nil
+				}
+			}},
+		{"report", "compliance", "print the md5's of the finished transfers that transfer_log=true recorded",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				since := flags.String("since", "", "only the transfers since this date (2006-01-02), time (RFC 3339) or duration ago (720h)")
+				format := flags.String("format", "text", "text, csv or json")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 || args[0] != "compliance" {
+						return errUsage
+					}
+					sinceTime, err := drivesync.ParseSince(*since, time.Now())
+					if err != nil {
+						return err
+					}
+					return drivesync.NewService().WriteComplianceReport(ctx, os.Stdout, sinceTime, *format)
+				}
+			}},
+		{"support-bundle", "[file.zip]", "make a zip file to attach to a bug report",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				return func(ctx context.Context, args []string) error {
+					if len(args) > 1 {
+						return errUsage
+					}
+					fileName := "support-bundle.zip"
+					if len(args) > 0 {
+						fileName = args[0]
+					}
+					return drivesync.NewService().WriteSupportBundle(ctx, fileName)
+				}
+			}},
+		{"service", "install|uninstall", "start the sync at login on macOS, or stop doing that",
+			func(flags *flag.FlagSet) func(context.Context, []string) error {
+				finderSidebar := flags.Bool("finder-sidebar", false, "also add the synced folders to the Finder sidebar")
+				return func(ctx context.Context, args []string) error {
+					if len(args) != 1 {
+						return errUsage
+					}
+					switch args[0] {
+					case "install":
+						return installService(drivesync.NewService(), *finderSidebar)
+					case "uninstall":
+						return uninstallService()
+					}
+					return errUsage
+				}
+			}},
+	}
+}
 
-		// check if anything was modified on the remote shared drive
-		remoteModifiedFiles, err := service.getRemoteModifiedFiles()
-		if err != nil {
-			fmt.Println(err)
-			continue
-		}
-		if len(remoteModifiedFiles) > 0 {
-			// grab all the metadata for the files/folders that are currently on the remote shared drive
-			// because we need the ids of files/folders, timestamps, md5's, etc.
-			service.clearDownloadLookupMap()
-			err := service.fillDownloadLookupMap(remoteModifiedFiles, verified)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
+//*************************************************************************************************
+//*************************************************************************************************
 
-			// check if we need to download anything
-			service.checkForDownloads()
+// the flag package stops at the first arg that isn't a flag, this lets the flags go after the args too,
+// like open <path> --browser
+func parseFlags(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-		// do the download or re-download if it was not verified from the last loop
-		if len(service.filesToDownload) > 0 {
-			if debug {
-				fmt.Println("Preparing to download files")
-			}
-			service.handleDownloads()
-		}
+//*********************************************************
 
-		//***********************************************************
+// parses the flags of the command and runs it, returns the exit code
+// Ctrl-C stopping a command that runs until it's cancelled is the normal way out, anything else like a stuck
+// cycle is a failure, so the exit status tells the service manager to start the program again
+func stopped(err error) error {
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("stopped:", err)
+		return nil
+	}
+	return fmt.Errorf("stopped: %w", err)
+}
 
-		// verify section
+//*********************************************************
+
+func runCommand(ctx context.Context, cmd command, args []string) int {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	debug := flags.Bool("debug", false, "print what the sync is doing and why, the same as --log-level debug")
+	logLevel := flags.String("log-level", "info", "the least important messages that are printed: debug, info, warn or error")
+	logFormat := flags.String("log-format", "text", "text, or json for one JSON record per line")
+	configDir := flags.String("config", drivesync.DEFAULT_CONFIG_DIR, "the folder with the settings, credentials and saved state")
+	run := cmd.setup(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %v %v [flags] %v\n%v\n\nflags:\n", drivesync.APP_NAME, cmd.name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
 
-		if len(service.filesToUpload) > 0 {
-			if debug {
-				fmt.Println("Need to verify uploads. Grabbing remote metadata first.")
-			}
-			service.clearUploadLookupMap()
-			err := service.fillUploadLookupMap(service.getBaseFolderSlice())
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-		}
+	args = parseFlags(flags, args)
+	level, err := drivesync.ParseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	drivesync.SetLogLevel(level)
+	err = drivesync.SetLogFormat(*logFormat)
+	if err != nil {
+		fmt.Println(err)
+		return 2
+	}
+	if *debug {
+		drivesync.SetDebug(true)
+	}
+	drivesync.SetConfigDir(*configDir)
 
-		if len(service.filesToDownload) > 0 {
-			if debug {
-				fmt.Println("Need to verify downloads. Grabbing remote metadata first.")
-			}
-			// again grab all the metadata for the files/folders that are currently on the remote shared drive
-			service.clearDownloadLookupMap()
-			err := service.fillDownloadLookupMap(remoteModifiedFiles, verified)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-		}
+	err = run(ctx, args)
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	return 0
+}
 
-		// do a verify if we uploaded or downloaded anything
-		if len(service.filesToUpload) > 0 || len(service.filesToDownload) > 0 {
-			// verify local files were uploaded to the remote server
-			service.verifyUploads()
+//*********************************************************
 
-			// verify remote files were downloaded to the local side
-			service.verifyDownloads()
+func printCommands(commands []command) {
+	fmt.Printf("usage: %v <command> [flags] [args]\n\ncommands:\n", drivesync.APP_NAME)
+	for _, cmd := range commands {
+		fmt.Printf("  %-20v %v\n", cmd.name, cmd.summary)
+	}
+	fmt.Println("\nevery command takes --debug, --log-level <level>, --log-format <format> and --config <folder>, run help <command> to see the rest of its flags")
+}
 
-			if len(service.filesToUpload) == 0 && len(service.filesToDownload) == 0 {
-				fmt.Println("verified! new verified timestamp:", service.mostRecentTimestampSeen.Local(), "numApiCalls:", service.conn.numApiCalls)
-				service.setVerifiedTime()
-				service.clearUploadLookupMap()
-				service.clearDownloadLookupMap()
-				verified = true
-			} else {
-				fmt.Println("not verified, will try again next time")
-			}
-		}
+//*************************************************************************************************
+//*************************************************************************************************
 
-		//***********************************************************
+func main() {
+	drivesync.AppVersion = appVersion
+
+	// stop cleanly on Ctrl+C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	// without a command it syncs, and the flags of the sync command can still be given
+	name := "sync"
+	args := os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name = args[0]
+		args = args[1:]
+	}
 
-		// cleanup and re-verify section, if it's been more than 14 hours
+	all := commands()
+	if name == "help" {
+		if len(args) == 0 {
+			printCommands(all)
+			return
+		}
+		name = args[0]
+		args = []string{"-help"} // the flag package prints the usage and exits
+	}
 
-		now := time.Now()
-		if now.Hour() == 2 && service.hoursSinceLastClean() > 14 {
-			fmt.Println("cleaning up at", now)
-			service.setCleanTime(now)
-			removeDeletedFiles(&service, false)
-			verified = false
+	for _, cmd := range all {
+		if cmd.name == name {
+			os.Exit(runCommand(ctx, cmd, args))
 		}
 	}
+	fmt.Println("unknown command", name)
+	printCommands(all)
+	os.Exit(2)
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"JusticeProject/Google-Drive-For-Desktop-Lite/drivesync"
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// how many lines of the sync's own output the log panel keeps
+const MONITOR_LOG_LINES = 500
+
+// how many cells wide the progress bar of a transfer is
+const PROGRESS_BAR_WIDTH = 20
+
+// the panels of the monitor, each one is refreshed from a MonitorSnapshot
+type monitorView struct {
+	app       *tview.Application
+	header    *tview.TextView
+	active    *tview.Table
+	pending   *tview.TextView
+	errors    *tview.TextView
+	transfers *tview.TextView
+	log       *tview.TextView
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// runs the sync like normal but shows it in the terminal, what's queued, the progress of the files being
+// transferred, the recent errors and conflicts, and the sync's output, p pauses and resumes, s syncs now, q quits
+func runMonitor(ctx context.Context, service *drivesync.Service, fullRescan bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	view := newMonitorView()
+
+	// everything the sync prints goes to the log panel instead of over the top of the screen
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdout := os.Stdout
+	os.Stdout = writer
+	defer func() {
+		os.Stdout = stdout
+		writer.Close()
+	}()
+	go view.followLog(reader)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- service.Run(ctx, fullRescan)
+		view.app.Stop()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				snapshot := service.MonitorSnapshot()
+				view.app.QueueUpdateDraw(func() { view.refresh(snapshot) })
+			}
+		}
+	}()
+
+	view.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Rune() {
+		case 'p':
+			if service.MonitorSnapshot().Paused {
+				service.Resume()
+			} else {
+				service.Pause()
+			}
+		case 's':
+			service.SyncNow()
+		case 'q':
+			cancel()
+		default:
+			return event
+		}
+		return nil
+	})
+
+	err = view.app.Run()
+	cancel()
+	if err != nil {
+		return err
+	}
+	return <-stopped
+}
+
+//*********************************************************
+
+func newMonitorView() *monitorView {
+	view := monitorView{
+		app:       tview.NewApplication(),
+		header:    tview.NewTextView().SetDynamicColors(true),
+		active:    tview.NewTable(),
+		pending:   tview.NewTextView(),
+		errors:    tview.NewTextView(),
+		transfers: tview.NewTextView(),
+		log:       tview.NewTextView().SetMaxLines(MONITOR_LOG_LINES),
+	}
+	view.active.SetBorder(true).SetTitle(" Transferring ")
+	view.pending.SetBorder(true).SetTitle(" Queued ")
+	view.errors.SetBorder(true).SetTitle(" Errors and conflicts ")
+	view.transfers.SetBorder(true).SetTitle(" Recent transfers ")
+	view.log.SetBorder(true).SetTitle(" Log ")
+
+	panels := tview.NewFlex().
+		AddItem(view.pending, 0, 1, false).
+		AddItem(view.errors, 0, 1, false).
+		AddItem(view.transfers, 0, 1, false)
+	keys := tview.NewTextView().SetText("p pause/resume   s sync now   q quit")
+
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(view.header, 3, 0, false).
+		AddItem(view.active, 0, 1, false).
+		AddItem(panels, 0, 2, false).
+		AddItem(view.log, 0, 1, false).
+		AddItem(keys, 1, 0, false)
+	view.app.SetRoot(layout, true)
+	return &view
+}
+
+//*********************************************************
+
+// copies the sync's output to the log panel until the pipe is closed
+func (view *monitorView) followLog(reader *os.File) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := tview.Escape(scanner.Text())
+		view.app.QueueUpdateDraw(func() {
+			fmt.Fprintln(view.log, line)
+			view.log.ScrollToEnd()
+		})
+	}
+}
+
+//*********************************************************
+
+// called on the ui goroutine
+func (view *monitorView) refresh(snapshot drivesync.MonitorSnapshot) {
+	state := "[green]syncing[-]"
+	if snapshot.Paused {
+		state = "[yellow]paused[-]"
+	}
+	activity := snapshot.Activity
+	if activity == "" {
+		activity = "waiting for the next sync"
+	}
+	lastCycle := "not yet"
+	if !snapshot.LastCycleAt.IsZero() {
+		lastCycle = snapshot.LastCycleAt.Local().Format("15:04:05")
+	}
+	var speed float64
+	for _, transfer := range snapshot.Active {
+		speed += transfer.BytesPerSecond
+	}
+	view.header.SetText(fmt.Sprintf("%v   %v   last sync: %v\n%v transferring at %v/s, %v queued", state, tview.Escape(activity),
+		lastCycle, len(snapshot.Active), formatBytes(int64(speed)), snapshot.Queued))
+	view.refreshActive(snapshot.Active)
+
+	view.pending.SetText(strings.Join(snapshot.Pending, "\n"))
+
+	var problems []string
+	errorPaths := make([]string, 0, len(snapshot.Errors))
+	for localPath := range snapshot.Errors {
+		errorPaths = append(errorPaths, localPath)
+	}
+	sort.Strings(errorPaths)
+	for _, localPath := range errorPaths {
+		problems = append(problems, localPath+": "+snapshot.Errors[localPath])
+	}
+	for i := len(snapshot.Conflicts) - 1; i >= 0; i-- {
+		problems = append(problems, "conflict copy: "+snapshot.Conflicts[i])
+	}
+	for _, deletion := range snapshot.PendingDeletions {
+		problems = append(problems, "will be deleted: "+deletion.LocalPath)
+	}
+	view.errors.SetText(strings.Join(problems, "\n"))
+
+	// newest first so the latest ones are visible without scrolling
+	var transfers []string
+	for i := len(snapshot.Transfers) - 1; i >= 0; i-- {
+		transfers = append(transfers, snapshot.Transfers[i])
+	}
+	view.transfers.SetText(strings.Join(transfers, "\n"))
+}
+
+//*********************************************************
+
+// one row for each file being transferred, with a progress bar when the size is known
+func (view *monitorView) refreshActive(transfers []drivesync.TransferProgress) {
+	view.active.Clear()
+	for column, title := range []string{"", "file", "progress", "done", "size", "speed", "left"} {
+		view.active.SetCell(0, column, tview.NewTableCell(title).SetTextColor(tcell.ColorYellow).SetSelectable(false))
+	}
+
+	for i, transfer := range transfers {
+		direction := "↑"
+		if transfer.Direction == drivesync.TRANSFER_DOWNLOAD {
+			direction = "↓"
+		}
+		bar, size, left := "", "", ""
+		if transfer.Size > 0 {
+			bar = progressBar(transfer.Done, transfer.Size)
+			size = formatBytes(transfer.Size)
+		}
+		if transfer.SecondsLeft > 0 {
+			left = (time.Duration(transfer.SecondsLeft) * time.Second).String()
+		}
+
+		row := i + 1
+		view.active.SetCell(row, 0, tview.NewTableCell(direction))
+		view.active.SetCell(row, 1, tview.NewTableCell(tview.Escape(transfer.Path)).SetExpansion(1))
+		view.active.SetCell(row, 2, tview.NewTableCell(bar))
+		view.active.SetCell(row, 3, tview.NewTableCell(formatBytes(transfer.Done)).SetAlign(tview.AlignRight))
+		view.active.SetCell(row, 4, tview.NewTableCell(size).SetAlign(tview.AlignRight))
+		view.active.SetCell(row, 5, tview.NewTableCell(formatBytes(int64(transfer.BytesPerSecond))+"/s").SetAlign(tview.AlignRight))
+		view.active.SetCell(row, 6, tview.NewTableCell(left).SetAlign(tview.AlignRight))
+	}
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// like [########------------]  40%
+func progressBar(done int64, size int64) string {
+	if done > size {
+		done = size
+	}
+	filled := int(done * PROGRESS_BAR_WIDTH / size)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", PROGRESS_BAR_WIDTH-filled)
+	return fmt.Sprintf("[%v[] %3d%%", bar, done*100/size)
+}
+
+//*********************************************************
+
+// like 1.5 MB
+func formatBytes(n int64) string {
+	for _, unit := range []struct {
+		name string
+		size int64
+	}{{"GB", 1024 * 1024 * 1024}, {"MB", 1024 * 1024}, {"KB", 1024}} {
+		if n >= unit.size {
+			return fmt.Sprintf("%.1f %v", float64(n)/float64(unit.size), unit.name)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
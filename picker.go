@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"JusticeProject/Google-Drive-For-Desktop-Lite/drivesync"
+)
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+type folderChoice struct {
+	kind string // "Shared Drive" or "Shared Folder"
+	name string
+	id   string
+}
+
+//*************************************************************************************************
+//*************************************************************************************************
+
+// lists the Shared Drives and folders that the credential can access and lets the user pick which ones
+// to sync, the picked folders are added to config/folder-ids.txt so nobody has to hunt for folder ids
+func pickFolders(ctx context.Context, service *drivesync.Service) {
+	var choices []folderChoice
+
+	drives, err := service.Connection().GetSharedDrives(ctx)
+	if err != nil {
+		fmt.Println("failed to get the shared drives:", err)
+	}
+	for _, drive := range drives {
+		choices = append(choices, folderChoice{kind: "Shared Drive", name: drive.Name, id: drive.ID})
+	}
+
+	folders, err := service.Connection().GetSharedFolders(ctx)
+	if err != nil {
+		fmt.Println("failed to get the shared folders:", err)
+	}
+	for _, folder := range folders {
+		choices = append(choices, folderChoice{kind: "Shared Folder", name: folder.Name, id: folder.ID})
+	}
+
+	if len(choices) == 0 {
+		fmt.Println("No Shared Drives or folders are accessible, share a folder with the service account first.")
+		return
+	}
+
+	baseFolders := service.BaseFolders()
+	fmt.Println("\nThese Shared Drives and folders are accessible:")
+	for i, choice := range choices {
+		synced := ""
+		for _, baseId := range baseFolders {
+			if baseId == choice.id {
+				synced = " (already synced)"
+			}
+		}
+		fmt.Printf("  [%v] %v: %v  id=%v%v\n", i+1, choice.kind, choice.name, choice.id, synced)
+	}
+
+	fmt.Println("\nType the numbers of the folders you want to sync separated by spaces, then hit Enter.")
+	fmt.Println("Just hit Enter to exit without changing anything.")
+
+	scanner := bufio.NewScanner(os.Stdin)
+	if !scanner.Scan() {
+		return
+	}
+
+	for _, field := range strings.Fields(scanner.Text()) {
+		index, err := strconv.Atoi(field)
+		if err != nil || index < 1 || index > len(choices) {
+			fmt.Println("skipping invalid choice", field)
+			continue
+		}
+		choice := choices[index-1]
+
+		fmt.Printf("Local folder name for %v [%v]: ", choice.name, choice.name)
+		localName := choice.name
+		if scanner.Scan() && len(strings.TrimSpace(scanner.Text())) > 0 {
+			localName = strings.TrimSpace(scanner.Text())
+		}
+
+		_, alreadyUsed := baseFolders[localName]
+		if alreadyUsed {
+			fmt.Println("the local folder", localName, "is already in use, skipping")
+			continue
+		}
+
+		err = service.AddBaseFolder(localName, choice.id)
+		if err != nil {
+			fmt.Println("failed to save the folder:", err)
+			continue
+		}
+		baseFolders[localName] = choice.id
+		fmt.Println("added", localName, "=", choice.id)
+	}
+}